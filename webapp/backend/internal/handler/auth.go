@@ -2,19 +2,63 @@ package handler
 
 import (
 	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"github.com/go-chi/chi/v5"
 	"github.com/goccy/go-json"
 )
 
 type AuthHandler struct {
-	AuthSvc *service.AuthService
+	AuthSvc   *service.AuthService
+	cookieCfg CookieConfig
 }
 
-func NewAuthHandler(authSvc *service.AuthService) *AuthHandler {
-	return &AuthHandler{AuthSvc: authSvc}
+// セッション系 Cookie に共通で付ける属性
+type CookieConfig struct {
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+	Domain   string
+	// 0 ならセッションの有効期限を Expires に使う
+	MaxAge time.Duration
+}
+
+var DefaultCookieConfig = CookieConfig{
+	HttpOnly: true,
+}
+
+func NewAuthHandler(authSvc *service.AuthService, cookieCfg CookieConfig) *AuthHandler {
+	return &AuthHandler{AuthSvc: authSvc, cookieCfg: cookieCfg}
+}
+
+func (h *AuthHandler) newCookie(name, value, path string, expiresAt time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   h.cookieCfg.Domain,
+		Expires:  expiresAt,
+		Secure:   h.cookieCfg.Secure,
+		HttpOnly: h.cookieCfg.HttpOnly,
+		SameSite: h.cookieCfg.SameSite,
+	}
+	if h.cookieCfg.MaxAge > 0 {
+		cookie.MaxAge = int(h.cookieCfg.MaxAge.Seconds())
+	}
+	return cookie
+}
+
+func (h *AuthHandler) clearCookie(w http.ResponseWriter, name, path string) {
+	cookie := h.newCookie(name, "", path, time.Time{})
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
 }
 
 // ログイン時にセッションを発行し、Cookieにセットする
@@ -25,24 +69,679 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionID, expiresAt, err := h.AuthSvc.Login(r.Context(), req.UserName, req.Password)
+	req.IP = middleware.ClientIP(r)
+	req.UserAgent = r.UserAgent()
+
+	result, err := h.AuthSvc.Login(r.Context(), req)
 	if err != nil {
 		if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword) {
 			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
+		} else if errors.Is(err, service.ErrTooManyAttempts) {
+			http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+		} else if errors.Is(err, service.ErrAccountLocked) {
+			http.Error(w, "Account locked", http.StatusLocked)
+		} else if errors.Is(err, service.ErrTOTPRequired) {
+			// パスワードは正しいので、クライアントはコードを付けて再送する
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"message": "Two-factor code required", "totp_required": true})
+		} else if errors.Is(err, service.ErrInvalidTOTP) {
+			http.Error(w, "Unauthorized: Invalid two-factor code", http.StatusUnauthorized)
 		} else {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 		}
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		Expires:  expiresAt,
-		HttpOnly: true,
-		Path:     "/",
-	})
+	h.setSessionCookie(w, result.SessionID, result.ExpiresAt)
+	if result.RefreshToken != "" {
+		h.setRefreshTokenCookie(w, result.RefreshToken, result.RefreshTokenExpiresAt)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
+}
+
+// セッションを再発行・延長し、Cookieを更新する
+// remember-me のリフレッシュトークンがあれば新しいセッションを発行し、なければ現在のセッションを延長する
+func (h *AuthHandler) RefreshSession(w http.ResponseWriter, r *http.Request) {
+	if refreshCookie, err := r.Cookie(refreshTokenCookieName); err == nil && refreshCookie.Value != "" {
+		result, err := h.AuthSvc.RefreshWithToken(r.Context(), refreshCookie.Value)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidRefresh) {
+				h.clearRefreshTokenCookie(w)
+				http.Error(w, "Unauthorized: Invalid refresh token", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		h.setSessionCookie(w, result.SessionID, result.ExpiresAt)
+		h.setRefreshTokenCookie(w, result.RefreshToken, result.RefreshTokenExpiresAt)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]any{"message": "Session refreshed", "expires_at": result.ExpiresAt})
+		return
+	}
+
+	cookie, err := r.Cookie("session_id")
+	if err != nil {
+		http.Error(w, "Unauthorized: No session cookie", http.StatusUnauthorized)
+		return
+	}
+
+	expiresAt, err := h.AuthSvc.RefreshSession(r.Context(), cookie.Value)
+	if err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.setSessionCookie(w, cookie.Value, expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]any{"message": "Session refreshed", "expires_at": expiresAt})
+}
+
+// セッション Cookie と、それに紐づく CSRF トークン Cookie を発行する
+func (h *AuthHandler) setSessionCookie(w http.ResponseWriter, sessionID string, expiresAt time.Time) {
+	http.SetCookie(w, h.newCookie("session_id", sessionID, "/", expiresAt))
+	h.setCSRFCookie(w, h.newCookie(middleware.CSRFCookieName, "", "/", expiresAt))
+}
+
+func (h *AuthHandler) setCSRFCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	csrfToken, err := middleware.NewCSRFToken()
+	if err != nil {
+		log.Printf("Failed to generate CSRF token: %v", err)
+		return
+	}
+	cookie.Value = csrfToken
+	// JS から読めるよう HttpOnly は付けない
+	cookie.HttpOnly = false
+	cookie.SameSite = http.SameSiteStrictMode
+	http.SetCookie(w, cookie)
+}
+
+// リフレッシュ用エンドポイントにだけ送られるようにパスを絞る
+const refreshTokenCookieName = "refresh_token"
+
+// リフレッシュトークンはセッションより長く生きるので MaxAge は適用しない
+func (h *AuthHandler) setRefreshTokenCookie(w http.ResponseWriter, refreshToken string, expiresAt time.Time) {
+	cookie := h.newCookie(refreshTokenCookieName, refreshToken, "/api/session", expiresAt)
+	cookie.MaxAge = 0
+	cookie.HttpOnly = true
+	http.SetCookie(w, cookie)
+
+	// セッション切れの後に呼ぶリフレッシュも CSRF 検証の対象なので、CSRF Cookie もリフレッシュトークンと同じだけ生かす
+	csrfCookie := h.newCookie(middleware.CSRFCookieName, "", "/", expiresAt)
+	csrfCookie.MaxAge = 0
+	h.setCSRFCookie(w, csrfCookie)
+}
+
+func (h *AuthHandler) clearRefreshTokenCookie(w http.ResponseWriter) {
+	h.clearCookie(w, refreshTokenCookieName, "/api/session")
+}
+
+// 管理者によるアカウントロック解除
+func (h *AuthHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.UnlockUser(r.Context(), userID); err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to unlock user %d: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("User unlocked"))
+}
+
+// API トークンを発行する（管理者用）
+func (h *AuthHandler) IssueAPIToken(w http.ResponseWriter, r *http.Request) {
+	var req model.IssueAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rawToken, token, err := h.AuthSvc.IssueAPIToken(r.Context(), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRequest):
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		case errors.Is(err, service.ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to issue API token: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	resp := struct {
+		Token string          `json:"token"`
+		Info  *model.APIToken `json:"info"`
+	}{
+		Token: rawToken,
+		Info:  token,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// API トークンを失効させる（管理者用）
+func (h *AuthHandler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.RevokeAPIToken(r.Context(), tokenID); err != nil {
+		if errors.Is(err, service.ErrTokenNotFound) {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke API token %d: %v", tokenID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ユーザーのロールを変更する（管理者用）
+func (h *AuthHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.UpdateUserRole(r.Context(), userID, req.Role); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRequest):
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+		case errors.Is(err, service.ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to update role for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// パスワードを変更する
+// 既存セッションは全て失効するので、クライアントは再ログインが必要
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.ChangePassword(r.Context(), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRequest):
+			http.Error(w, "New password is required", http.StatusBadRequest)
+		case errors.Is(err, service.ErrInvalidPassword):
+			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
+		default:
+			log.Printf("Failed to change password for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password changed"})
+}
+
+// 自分の有効なセッション一覧を取得
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	currentSessionID, _ := middleware.GetSessionIDFromContext(r.Context())
+
+	sessions, err := h.AuthSvc.ListSessions(r.Context(), userID, currentSessionID)
+	if err != nil {
+		log.Printf("Failed to list sessions for user %d: %v", userID, err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Data []model.UserSession `json:"data"`
+	}{
+		Data: sessions,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 自分のセッションを 1 つ失効させる
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "sessionID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.RevokeSession(r.Context(), userID, id); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke session %d for user %d: %v", id, userID, err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 自分の全端末のセッションを失効させる
+func (h *AuthHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.AuthSvc.RevokeAllSessions(r.Context(), userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", userID, err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 自分のログイン履歴を取得
+func (h *AuthHandler) LoginHistory(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	events, total, err := h.AuthSvc.LoginHistory(r.Context(), userID, page, pageSize)
+	if err != nil {
+		log.Printf("Failed to fetch login history for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch login history", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Data  []model.LoginEvent `json:"data"`
+		Total int                `json:"total"`
+	}{
+		Data:  events,
+		Total: total,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// セッションキャッシュのヒット・ミス数（管理者用）
+func (h *AuthHandler) SessionCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.AuthSvc.SessionCacheStats())
+}
+
+// 自分のプロフィールを取得
+func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.AuthSvc.GetProfile(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get profile for user %d: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// 自分の表示名と設定値を更新
+func (h *AuthHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.AuthSvc.UpdateProfile(r.Context(), userID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidRequest):
+			http.Error(w, "Invalid profile", http.StatusBadRequest)
+		case errors.Is(err, service.ErrUserNotFound):
+			http.Error(w, "User not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to update profile for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// 二要素認証 (TOTP) の登録を開始する
+func (h *AuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	enrollment, err := h.AuthSvc.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, service.ErrTOTPAlreadyEnabled) {
+			http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		} else {
+			log.Printf("Failed to enroll TOTP for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// 認証アプリのコードを確認して二要素認証を有効化し、リカバリーコードを返す
+func (h *AuthHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := h.AuthSvc.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrTOTPAlreadyEnabled):
+			http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		case errors.Is(err, service.ErrTOTPNotEnrolled):
+			http.Error(w, "Two-factor enrollment not started", http.StatusBadRequest)
+		case errors.Is(err, service.ErrInvalidTOTP):
+			http.Error(w, "Invalid two-factor code", http.StatusBadRequest)
+		default:
+			log.Printf("Failed to confirm TOTP for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": recoveryCodes})
+}
+
+// 現在のコードを確認して二要素認証を無効化する
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.AuthSvc.DisableTOTP(r.Context(), userID, req.Code); err != nil {
+		switch {
+		case errors.Is(err, service.ErrTOTPNotEnrolled):
+			http.Error(w, "Two-factor authentication not enabled", http.StatusBadRequest)
+		case errors.Is(err, service.ErrInvalidTOTP):
+			http.Error(w, "Invalid two-factor code", http.StatusBadRequest)
+		default:
+			log.Printf("Failed to disable TOTP for user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OIDC の state・PKCE の code_verifier・nonce をコールバックまで保持する Cookie
+const oidcStateCookieName = "oidc_state"
+
+// OIDC ログインを開始し、IdP へリダイレクトする
+func (h *AuthHandler) OIDCStart(w http.ResponseWriter, r *http.Request) {
+	h.startOIDC(w, r, 0)
+}
+
+// ログイン中のユーザーに外部 ID を紐付けるため、IdP へリダイレクトする
+func (h *AuthHandler) OIDCLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+	h.startOIDC(w, r, userID)
+}
+
+// linkUserID が 0 ならログイン、それ以外はそのユーザーへの紐付け
+func (h *AuthHandler) startOIDC(w http.ResponseWriter, r *http.Request, linkUserID int) {
+	if !h.AuthSvc.OIDCEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	var params [3]string // state, code_verifier, nonce
+	for i := range params {
+		v, err := service.NewOIDCRandomString()
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		params[i] = v
+	}
+	state, codeVerifier, nonce := params[0], params[1], params[2]
+
+	var authURL string
+	var err error
+	if linkUserID != 0 {
+		authURL, err = h.AuthSvc.OIDCLinkURL(r.Context(), linkUserID, state, codeVerifier, nonce)
+	} else {
+		authURL, err = h.AuthSvc.OIDCAuthURL(r.Context(), state, codeVerifier, nonce)
+	}
+	if err != nil {
+		log.Printf("Failed to build OIDC auth URL: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// IdP からのリダイレクトで送られる必要があるので SameSite は Lax 固定
+	stateCookie := h.newCookie(oidcStateCookieName, state+"."+codeVerifier+"."+nonce, "/api/login/oidc", time.Time{})
+	stateCookie.MaxAge = 600
+	stateCookie.HttpOnly = true
+	stateCookie.SameSite = http.SameSiteLaxMode
+	http.SetCookie(w, stateCookie)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// IdP からのコールバックを受けてセッションを発行する (紐付けの場合は紐付けのみ)
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !h.AuthSvc.OIDCEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		http.Error(w, "Missing OIDC state", http.StatusBadRequest)
+		return
+	}
+	h.clearCookie(w, oidcStateCookieName, "/api/login/oidc")
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] == "" || r.URL.Query().Get("state") != parts[0] {
+		http.Error(w, "Invalid OIDC state", http.StatusBadRequest)
+		return
+	}
+	state, codeVerifier, nonce := parts[0], parts[1], parts[2]
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	linked, err := h.AuthSvc.CompleteOIDCLink(r.Context(), state, code, codeVerifier, nonce)
+	if linked {
+		switch {
+		case errors.Is(err, service.ErrOIDCIdentityInUse):
+			http.Error(w, "This identity is already linked to an account", http.StatusConflict)
+		case errors.Is(err, service.ErrInvalidPassword):
+			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
+		case err != nil:
+			log.Printf("Failed to link OIDC identity: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		default:
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+		return
+	}
+
+	meta := model.LoginRequest{IP: middleware.ClientIP(r), UserAgent: r.UserAgent()}
+	result, err := h.AuthSvc.LoginWithOIDC(r.Context(), code, codeVerifier, nonce, meta)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrInvalidPassword):
+			http.Error(w, "Unauthorized: Invalid credentials", http.StatusUnauthorized)
+		case errors.Is(err, service.ErrAccountLocked):
+			http.Error(w, "Account locked", http.StatusLocked)
+		case errors.Is(err, service.ErrTOTPRequired):
+			// チケットとコードを POST /api/login/oidc/totp に送ってログインを完了する
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{
+				"message":       "Two-factor code required",
+				"totp_required": true,
+				"mfa_ticket":    result.MFATicket,
+			})
+		default:
+			log.Printf("Failed to login with OIDC: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.setSessionCookie(w, result.SessionID, result.ExpiresAt)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// OIDC ログインの二要素認証を完了する
+func (h *AuthHandler) OIDCSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFATicket    string `json:"mfa_ticket"`
+		TOTPCode     string `json:"totp_code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFATicket == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	meta := model.LoginRequest{
+		TOTPCode:     req.TOTPCode,
+		RecoveryCode: req.RecoveryCode,
+		IP:           middleware.ClientIP(r),
+		UserAgent:    r.UserAgent(),
+	}
+	result, err := h.AuthSvc.CompleteOIDCSecondFactor(r.Context(), req.MFATicket, meta)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidTOTP):
+			http.Error(w, "Unauthorized: Invalid two-factor code", http.StatusUnauthorized)
+		case errors.Is(err, service.ErrAccountLocked):
+			http.Error(w, "Account locked", http.StatusLocked)
+		default:
+			log.Printf("Failed to complete OIDC second factor: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
 
+	h.setSessionCookie(w, result.SessionID, result.ExpiresAt)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Login successful"})
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/internal/middleware"
+)
+
+func responseCookies(w *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := map[string]*http.Cookie{}
+	for _, c := range w.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	return cookies
+}
+
+func TestSessionCookiesUseConfiguredAttributes(t *testing.T) {
+	h := NewAuthHandler(nil, CookieConfig{
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Domain:   "example.com",
+		MaxAge:   time.Hour,
+	})
+	w := httptest.NewRecorder()
+	expiresAt := time.Now().Add(24 * time.Hour)
+	h.setSessionCookie(w, "sid", expiresAt)
+	h.setRefreshTokenCookie(w, "rt", expiresAt.Add(24*time.Hour))
+
+	cookies := responseCookies(w)
+	session := cookies["session_id"]
+	if session == nil || !session.Secure || !session.HttpOnly || session.SameSite != http.SameSiteLaxMode ||
+		session.Domain != "example.com" || session.MaxAge != 3600 {
+		t.Fatalf("session cookie = %+v, want the configured attributes", session)
+	}
+
+	csrf := cookies[middleware.CSRFCookieName]
+	if csrf == nil || csrf.HttpOnly || csrf.SameSite != http.SameSiteStrictMode || !csrf.Secure {
+		t.Fatalf("csrf cookie = %+v, want it readable from JS, strict and secure", csrf)
+	}
+
+	refresh := cookies[refreshTokenCookieName]
+	if refresh == nil || refresh.MaxAge != 0 || !refresh.HttpOnly || refresh.Path != "/api/session" {
+		t.Fatalf("refresh cookie = %+v, want no MaxAge, HttpOnly and scoped to /api/session", refresh)
+	}
+}
+
+func TestDefaultCookieConfig(t *testing.T) {
+	h := NewAuthHandler(nil, DefaultCookieConfig)
+	w := httptest.NewRecorder()
+	h.setSessionCookie(w, "sid", time.Now().Add(time.Hour))
+
+	session := responseCookies(w)["session_id"]
+	if session == nil || !session.HttpOnly || session.Secure || session.MaxAge != 0 || session.Domain != "" {
+		t.Fatalf("session cookie = %+v, want HttpOnly only", session)
+	}
+}
+
+func TestClearCookieExpiresImmediately(t *testing.T) {
+	h := NewAuthHandler(nil, CookieConfig{MaxAge: time.Hour})
+	w := httptest.NewRecorder()
+	h.clearRefreshTokenCookie(w)
+
+	refresh := responseCookies(w)[refreshTokenCookieName]
+	if refresh == nil || refresh.MaxAge >= 0 || refresh.Value != "" {
+		t.Fatalf("refresh cookie = %+v, want it deleted", refresh)
+	}
+}
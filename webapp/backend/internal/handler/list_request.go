@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"github.com/goccy/go-json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 一覧 API のリクエストを読む
+// GET はクエリ文字列 (キーは JSON と同じ)、それ以外は JSON ボディから読む
+func bindListRequest(r *http.Request, req *model.ListRequest) error {
+	if r.Method == http.MethodGet {
+		return bindQuery(r.URL.Query(), req)
+	}
+	return json.NewDecoder(r.Body).Decode(req)
+}
+
+// 一覧レスポンスのページング情報 (レスポンスの構造体に埋め込む)
+type pagination struct {
+	Page       int  `json:"page,omitempty"` // キーセットページングでは省略
+	PageSize   int  `json:"page_size"`
+	TotalPages int  `json:"total_pages"`
+	HasNext    bool `json:"has_next"`
+}
+
+// n は返す件数。approximate なら total は打ち切った件数で、実際にはもっとある
+func newPagination(req model.ListRequest, total, n int, approximate bool) pagination {
+	p := pagination{PageSize: req.PageSize}
+	if req.PageSize > 0 {
+		p.TotalPages = (total + req.PageSize - 1) / req.PageSize
+	}
+	if req.AfterID > 0 || req.BeforeID > 0 {
+		// 位置が分からないので、ページが埋まっていれば次があるとみなす (next_cursor と同じ)
+		p.HasNext = n == req.PageSize
+		return p
+	}
+	p.Page = req.Page
+	p.HasNext = req.Offset+n < total || (approximate && n == req.PageSize)
+	return p
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// json タグと同じ名前のクエリパラメータを構造体のフィールドに入れる
+// スライスは繰り返し (statuses=a&statuses=b) とカンマ区切り (statuses=a,b) のどちらでもよい
+// 時刻は RFC3339、ポインタの数値は指定された場合だけ設定する
+func bindQuery(q url.Values, dst interface{}) error {
+	v := reflect.ValueOf(dst).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		values, ok := q[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if err := setQueryValue(v.Field(i), values); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setQueryValue(f reflect.Value, values []string) error {
+	if f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String {
+		var items []string
+		for _, v := range values {
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+		return nil
+	}
+
+	s := values[len(values)-1]
+	if f.Kind() == reflect.Pointer && f.Type().Elem() == timeType {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(&tm))
+		return nil
+	}
+	if f.Kind() == reflect.Pointer && f.Type().Elem().Kind() == reflect.Int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.Set(reflect.ValueOf(&n))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
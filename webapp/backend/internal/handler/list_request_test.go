@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestBindListRequestFromQuery(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet,
+		"/api/v1/orders?search=%E3%82%8A%E3%82%93%E3%81%94&page=2&page_size=50&sort_field=order_id&sort_order=asc"+
+			"&statuses=shipping,delivering&statuses=completed&created_from=2025-09-01T00:00:00%2B09:00"+
+			"&fields=order_id&approximate_total=true&after_id=120&offset=99", nil)
+
+	var req model.ListRequest
+	if err := bindListRequest(r, &req); err != nil {
+		t.Fatalf("bindListRequest: %v", err)
+	}
+	from := time.Date(2025, 9, 1, 0, 0, 0, 0, time.FixedZone("", 9*60*60))
+	if req.Search != "りんご" || req.Page != 2 || req.PageSize != 50 || req.SortField != "order_id" || req.SortOrder != "asc" ||
+		!req.ApproximateTotal || req.AfterID != 120 || req.CreatedFrom == nil || !req.CreatedFrom.Equal(from) || req.CreatedTo != nil {
+		t.Fatalf("req = %+v", req)
+	}
+	if want := []string{"shipping", "delivering", "completed"}; !reflect.DeepEqual(req.Statuses, want) {
+		t.Fatalf("statuses = %v, want %v", req.Statuses, want)
+	}
+	if want := []string{"order_id"}; !reflect.DeepEqual(req.Fields, want) {
+		t.Fatalf("fields = %v, want %v", req.Fields, want)
+	}
+	if req.Offset != 0 {
+		t.Fatalf("offset = %d, must not be bound from the query", req.Offset)
+	}
+}
+
+func TestBindListRequestRejectsInvalidQuery(t *testing.T) {
+	for _, query := range []string{"page=abc", "approximate_total=maybe", "created_to=yesterday", "min_value=cheap"} {
+		var req model.ListRequest
+		if err := bindListRequest(httptest.NewRequest(http.MethodGet, "/api/v1/product?"+query, nil), &req); err == nil {
+			t.Errorf("%s must be rejected", query)
+		}
+	}
+}
+
+func TestBindListRequestRangeFromQuery(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/product?min_value=0&max_weight=500", nil)
+	var req model.ListRequest
+	if err := bindListRequest(r, &req); err != nil {
+		t.Fatalf("bindListRequest: %v", err)
+	}
+	if req.MinValue == nil || *req.MinValue != 0 || req.MaxWeight == nil || *req.MaxWeight != 500 || req.MaxValue != nil || req.MinWeight != nil {
+		t.Fatalf("req = %+v, want only min_value and max_weight set", req)
+	}
+}
+
+func TestBindListRequestFromBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/product?page=9", strings.NewReader(`{"search":"りんご","page":2}`))
+	var req model.ListRequest
+	if err := bindListRequest(r, &req); err != nil {
+		t.Fatalf("bindListRequest: %v", err)
+	}
+	if req.Search != "りんご" || req.Page != 2 {
+		t.Fatalf("req = %+v, want the body to be used", req)
+	}
+}
+
+func TestNewPagination(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         model.ListRequest
+		total, n    int
+		approximate bool
+		want        pagination
+	}{
+		{"first page", model.ListRequest{Page: 1, PageSize: 20}, 45, 20, false, pagination{Page: 1, PageSize: 20, TotalPages: 3, HasNext: true}},
+		{"last page", model.ListRequest{Page: 3, PageSize: 20, Offset: 40}, 45, 5, false, pagination{Page: 3, PageSize: 20, TotalPages: 3}},
+		{"exactly full", model.ListRequest{Page: 2, PageSize: 20, Offset: 20}, 40, 20, false, pagination{Page: 2, PageSize: 20, TotalPages: 2}},
+		{"empty", model.ListRequest{Page: 1, PageSize: 20}, 0, 0, false, pagination{Page: 1, PageSize: 20}},
+		{"approximate beyond limit", model.ListRequest{Page: 501, PageSize: 20, Offset: 10000}, model.ApproximateTotalLimit + 1, 20, true, pagination{Page: 501, PageSize: 20, TotalPages: 501, HasNext: true}},
+		{"keyset", model.ListRequest{Page: 1, PageSize: 20, AfterID: 100}, 45, 20, false, pagination{PageSize: 20, TotalPages: 3, HasNext: true}},
+		{"keyset last", model.ListRequest{Page: 1, PageSize: 20, AfterID: 100}, 45, 7, false, pagination{PageSize: 20, TotalPages: 3}},
+	}
+	for _, tt := range tests {
+		if got := newPagination(tt.req, tt.total, tt.n, tt.approximate); got != tt.want {
+			t.Errorf("%s: newPagination = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPaginationIsFlattenedIntoResponse(t *testing.T) {
+	resp := struct {
+		Total int `json:"total"`
+		pagination
+	}{Total: 45, pagination: pagination{Page: 1, PageSize: 20, TotalPages: 3, HasNext: true}}
+	rec := httptest.NewRecorder()
+	writeJSONWithETag(rec, httptest.NewRequest(http.MethodGet, "/api/v1/product", nil), resp)
+	if want := "{\"total\":45,\"page\":1,\"page_size\":20,\"total_pages\":3,\"has_next\":true}\n"; rec.Body.String() != want {
+		t.Fatalf("body = %s, want %s", rec.Body.String(), want)
+	}
+}
@@ -0,0 +1,30 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"backend/internal/service"
+
+	"github.com/goccy/go-json"
+)
+
+// リポジトリのメソッドごとの所要時間ヒストグラムを返す（管理者用）
+func RepoMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(service.RepoMetrics())
+}
+
+// 配送計画のアルゴリズムごとの集計を返す（管理者用、format=prometheus なら Prometheus のテキスト形式）
+func PlannerMetrics(w http.ResponseWriter, r *http.Request) {
+	stats := service.PlannerMetrics()
+	if r.URL.Query().Get("format") == "prometheus" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := service.WritePlannerMetricsPrometheus(w, stats); err != nil {
+			log.Printf("Failed to write planner metrics: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -4,9 +4,15 @@ import (
 	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"encoding/base64"
+	"errors"
+	"github.com/go-chi/chi/v5"
 	"github.com/goccy/go-json"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type OrderHandler struct {
@@ -26,8 +32,8 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req model.ListRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := bindListRequest(r, &req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
@@ -49,21 +55,311 @@ func (h *OrderHandler) List(w http.ResponseWriter, r *http.Request) {
 	}
 	req.Offset = (req.Page - 1) * req.PageSize
 
+	if req.Cursor != "" {
+		if err := decodeOrderCursor(req.Cursor, &req); err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+	keyset := req.AfterID > 0 || req.BeforeID > 0
+	if keyset && req.SortField != "order_id" {
+		http.Error(w, "Cursor pagination requires sort_field=order_id", http.StatusBadRequest)
+		return
+	}
+
 	orders, total, err := h.OrderSvc.FetchOrders(r.Context(), userID, req)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "Invalid filter", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to fetch orders for user %d: %v", userID, err)
 		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
 		return
 	}
 
+	approximate := req.ApproximateTotal && total > model.ApproximateTotalLimit
 	resp := struct {
-		Data  []model.Order `json:"data"`
-		Total int           `json:"total"`
+		Data       any    `json:"data"`
+		Total      int    `json:"total"`
+		NextCursor string `json:"next_cursor,omitempty"`
+		PrevCursor string `json:"prev_cursor,omitempty"`
+		// total が概数 (ApproximateTotalLimit を超えていて打ち切った) か
+		TotalApproximate bool `json:"total_approximate,omitempty"`
+		pagination
 	}{
-		Data:  orders,
-		Total: total,
+		Data:             orders,
+		Total:            total,
+		TotalApproximate: approximate,
+		pagination:       newPagination(req, total, len(orders), approximate),
+	}
+	if len(req.Fields) > 0 {
+		resp.Data = selectOrderFields(orders, req.Fields)
+	}
+	if req.SortField == "order_id" && len(orders) > 0 {
+		if len(orders) == req.PageSize {
+			resp.NextCursor = encodeOrderCursor(orderCursorAfter, orders[len(orders)-1].OrderID)
+		}
+		if keyset || req.Offset > 0 {
+			resp.PrevCursor = encodeOrderCursor(orderCursorBefore, orders[0].OrderID)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// 注文詳細を取得
+func (h *OrderHandler) Get(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.OrderSvc.GetOrder(r.Context(), userID, orderID)
+	if err != nil {
+		if errors.Is(err, service.ErrOrderNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to get order %d for user %d: %v", orderID, userID, err)
+		http.Error(w, "Failed to get order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// 自分の注文のステータスを一括更新（受け取り確認など）
+func (h *OrderHandler) UpdateStatuses(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	var req model.UpdateOrderStatusesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.OrderSvc.UpdateStatuses(r.Context(), userID, req.OrderIDs, req.NewStatus)
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrInvalidStatusTransition):
+		http.Error(w, "Invalid status transition", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to update order statuses for user %d: %v", userID, err)
+		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 完了済みの注文を返品する (再配達する場合は代わりの注文 ID を返す)
+func (h *OrderHandler) Return(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	orderID, err := strconv.ParseInt(chi.URLParam(r, "orderID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var req model.ReturnOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.OrderSvc.ReturnOrder(r.Context(), userID, orderID, req)
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrOrderNotFound):
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrInvalidStatusTransition):
+		http.Error(w, "Only completed orders can be returned", http.StatusConflict)
+		return
+	case errors.Is(err, service.ErrOutOfStock):
+		writeOutOfStock(w, err)
+		return
+	case err != nil:
+		log.Printf("Failed to return order %d for user %d: %v", orderID, userID, err)
+		http.Error(w, "Failed to return order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 一括削除のデフォルトのバッチ件数と間隔
+const (
+	defaultPurgeBatchSize = 1000
+	defaultPurgePause     = 100 * time.Millisecond
+)
+
+// 完了済み注文を一括削除する（管理者用）
+// before (RFC3339) より前に完了した明細が対象で、mode=archive なら削除せずアーカイブする
+// バッチごとに進捗を 1 行の JSON で返す (最後の行に done: true)
+func (h *OrderHandler) PurgeCompleted(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	before, err := time.Parse(time.RFC3339, q.Get("before"))
+	if err != nil {
+		http.Error(w, "Invalid before", http.StatusBadRequest)
+		return
+	}
+	req := service.PurgeCompletedRequest{
+		Before:    before,
+		BatchSize: defaultPurgeBatchSize,
+		Pause:     defaultPurgePause,
+	}
+	switch mode := q.Get("mode"); mode {
+	case "", "delete":
+	case "archive":
+		req.Archive = true
+	default:
+		http.Error(w, "Invalid mode", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("batch_size"); v != "" {
+		if req.BatchSize, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid batch_size", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("pause"); v != "" {
+		if req.Pause, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "Invalid pause", http.StatusBadRequest)
+			return
+		}
+	}
+
+	type progress struct {
+		Purged int    `json:"purged"`
+		Done   bool   `json:"done,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+	started := false
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	report := func(p progress) {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		enc.Encode(p)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	total, err := h.OrderSvc.PurgeCompleted(r.Context(), req, func(total int) {
+		report(progress{Purged: total})
+	})
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to purge completed orders: %v", err)
+		if !started {
+			http.Error(w, "Failed to purge completed orders", http.StatusInternalServerError)
+			return
+		}
+		// 進捗を返し始めた後はステータスを変えられないので最後の行で伝える
+		report(progress{Purged: total, Error: "failed to purge completed orders"})
+		return
+	}
+	report(progress{Purged: total, Done: true})
+}
+
+// 注文統計を取得
+func (h *OrderHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return
+	}
+
+	stats, err := h.OrderSvc.GetOrderStats(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to get order stats for user %d: %v", userID, err)
+		http.Error(w, "Failed to get order stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// fields で指定されたフィールドだけを返す
+func selectOrderFields(orders []model.Order, fields []string) []map[string]any {
+	data := make([]map[string]any, len(orders))
+	for i := range orders {
+		row := make(map[string]any, len(fields))
+		for _, field := range fields {
+			row[field] = model.OrderListFields[field](&orders[i])
+		}
+		data[i] = row
+	}
+	return data
+}
+
+const (
+	orderCursorAfter  = "a"
+	orderCursorBefore = "b"
+)
+
+// 注文履歴のカーソルは "a:<order_id>" / "b:<order_id>" を base64 にしたもの
+func encodeOrderCursor(direction string, orderID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(direction + ":" + strconv.FormatInt(orderID, 10)))
+}
+
+func decodeOrderCursor(cursor string, req *model.ListRequest) error {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return err
+	}
+	direction, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return errors.New("malformed cursor")
+	}
+	orderID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || orderID <= 0 {
+		return errors.New("malformed cursor")
+	}
+	switch direction {
+	case orderCursorAfter:
+		req.AfterID, req.BeforeID = orderID, 0
+	case orderCursorBefore:
+		req.AfterID, req.BeforeID = 0, orderID
+	default:
+		return errors.New("malformed cursor")
+	}
+	return nil
+}
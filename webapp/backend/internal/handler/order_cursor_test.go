@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestOrderCursorRoundTrip(t *testing.T) {
+	var req model.ListRequest
+	if err := decodeOrderCursor(encodeOrderCursor(orderCursorAfter, 42), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.AfterID != 42 || req.BeforeID != 0 {
+		t.Fatalf("req = %+v, want after_id 42", req)
+	}
+
+	// カーソルの向きで after_id / before_id を上書きする
+	if err := decodeOrderCursor(encodeOrderCursor(orderCursorBefore, 7), &req); err != nil {
+		t.Fatal(err)
+	}
+	if req.AfterID != 0 || req.BeforeID != 7 {
+		t.Fatalf("req = %+v, want before_id 7", req)
+	}
+}
+
+func TestDecodeOrderCursorRejectsMalformed(t *testing.T) {
+	for _, cursor := range []string{
+		"not base64!",
+		encodeOrderCursor("x", 1),
+		encodeOrderCursor(orderCursorAfter, 0),
+		"YToxMg==", // パディング付き ("a:12")
+	} {
+		var req model.ListRequest
+		if err := decodeOrderCursor(cursor, &req); err == nil {
+			t.Errorf("decodeOrderCursor(%q) succeeded, want an error", cursor)
+		}
+	}
+}
@@ -4,13 +4,25 @@ import (
 	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/goccy/go-json"
+	"github.com/samber/lo"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -18,10 +30,14 @@ const (
 	PRODUCT_PAGE_SIZE_DEFAULT  = 20
 	PRODUCT_SORT_FIELD_DEFAULT = "product_id"
 	PRODUCT_SORT_ORDER_DEFAULT = "asc"
+
+	PRODUCT_RECOMMENDATION_LIMIT_DEFAULT = 10
 )
 
 type ProductHandler struct {
 	ProductSvc *service.ProductService
+	// true なら GetImage は X-Accel-Redirect を使わず画像ストアから直接返す (nginx を通さない開発環境・テスト用)
+	ServeImagesDirectly bool
 }
 
 func NewProductHandler(svc *service.ProductService) *ProductHandler {
@@ -37,8 +53,8 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req model.ListRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := bindListRequest(r, &req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
@@ -49,7 +65,8 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 		req.PageSize = PRODUCT_PAGE_SIZE_DEFAULT
 	}
 	if req.SortField == "" {
-		req.SortField = PRODUCT_SORT_FIELD_DEFAULT
+		// 検索時は関連度順 (FULLTEXT を使わない場合はリポジトリ側で商品 ID 順になる)
+		req.SortField = lo.Ternary(strings.TrimSpace(req.Search) != "", model.ProductSortRelevance, PRODUCT_SORT_FIELD_DEFAULT)
 	}
 	if req.SortOrder == "" {
 		req.SortOrder = PRODUCT_SORT_ORDER_DEFAULT
@@ -57,21 +74,137 @@ func (h *ProductHandler) List(w http.ResponseWriter, r *http.Request) {
 	req.Offset = (req.Page - 1) * req.PageSize
 
 	products, total, err := h.ProductSvc.FetchProducts(r.Context(), userID, req)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "Invalid fields or range", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to fetch products", http.StatusInternalServerError)
 		return
 	}
 
 	resp := struct {
-		Data  []model.Product `json:"data"`
-		Total int             `json:"total"`
+		Data  any `json:"data"`
+		Total int `json:"total"`
+		pagination
 	}{
-		Data:  products,
-		Total: total,
+		Data:       products,
+		Total:      total,
+		pagination: newPagination(req, total, len(products), false),
+	}
+	if len(req.Fields) > 0 {
+		resp.Data = selectProductFields(products, req.Fields)
+	}
+
+	writeJSONWithETag(w, r, resp)
+}
+
+// fields に指定されたフィールドだけを返す
+func selectProductFields(products []model.Product, fields []string) []map[string]any {
+	data := make([]map[string]any, len(products))
+	for i := range products {
+		row := make(map[string]any, len(fields))
+		for _, field := range fields {
+			row[field] = model.ProductListFields[field](&products[i])
+		}
+		data[i] = row
+	}
+	return data
+}
+
+// レスポンスの内容から ETag を付けて返し、If-None-Match が一致すれば 304 を返す
+// 在庫数は注文のたびに変わり、インスタンス間で共有する世代番号もないので、内容のハッシュを使う
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
 	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
 
+	w.Header().Set("ETag", etag)
+	// ユーザーごとの認証が必要なので共有キャッシュには置かせず、毎回再検証させる
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	w.Write(append(body, '\n'))
+}
+
+// If-None-Match のいずれかのタグ (弱い比較) が etag と一致するか
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// 画像ストアのファイルを返す (Content-Type は拡張子か中身から決め、Range と If-Modified-Since に対応する)
+func (h *ProductHandler) serveImage(w http.ResponseWriter, r *http.Request, imagePath string) {
+	f, err := h.ProductSvc.OpenProductImage(r.Context(), imagePath)
+	switch {
+	case errors.Is(err, service.ErrImageNotFound):
+		http.Error(w, "画像が見つかりません", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrImageStoreUnavailable):
+		http.Error(w, "Image store is not configured", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("Failed to open image %s: %v", imagePath, err)
+		http.Error(w, "Failed to get image", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	var modTime time.Time
+	if st, ok := f.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if info, err := st.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+	}
+	// Range に応えるにはシークできる必要がある (ローカル以外のストアはメモリに読む)
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			log.Printf("Failed to read image %s: %v", imagePath, err)
+			http.Error(w, "Failed to get image", http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	http.ServeContent(w, r, path.Base(imagePath), modTime, content)
+}
+
+// Accept で明示的に受け付けている (q=0 でない) 画像形式 (service.ProductImageFormats のうち)
+// */* や image/* では変換済みの画像を返さない (WebP / AVIF を表示できるとは限らない)
+func acceptedImageFormats(accept string) []string {
+	var formats []string
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		format, ok := strings.CutPrefix(strings.ToLower(strings.TrimSpace(mediaType)), "image/")
+		if !ok || !slices.Contains(service.ProductImageFormats, format) {
+			continue
+		}
+		rejected := false
+		for _, param := range strings.Split(params, ";") {
+			if k, v, _ := strings.Cut(strings.TrimSpace(param), "="); k == "q" {
+				q, err := strconv.ParseFloat(v, 64)
+				rejected = err != nil || q == 0
+			}
+		}
+		if !rejected {
+			formats = append(formats, format)
+		}
+	}
+	return formats
 }
 
 // 注文を作成
@@ -88,10 +221,30 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, req.Items)
+	// タイムアウト後の再送で二重に注文されないよう、クライアントは Idempotency-Key を付けられる
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
+	insertedOrderIDs, err := h.ProductSvc.CreateOrders(r.Context(), userID, req.Items, idempotencyKey)
 	if err != nil {
-		log.Printf("Failed to create orders: %v", err)
-		http.Error(w, "Failed to process order request", http.StatusInternalServerError)
+		switch {
+		case errors.Is(err, service.ErrInvalidRequest):
+			http.Error(w, "Invalid order priority", http.StatusBadRequest)
+		case errors.Is(err, service.ErrIdempotencyKeyReused):
+			http.Error(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+		case errors.Is(err, service.ErrIdempotencyInProgress):
+			http.Error(w, "A request with this Idempotency-Key is in progress", http.StatusConflict)
+		case errors.Is(err, service.ErrOutOfStock):
+			writeOutOfStock(w, err)
+		case errors.Is(err, service.ErrProductInactive):
+			writeInactiveProducts(w, err)
+		default:
+			log.Printf("Failed to create orders: %v", err)
+			http.Error(w, "Failed to process order request", http.StatusInternalServerError)
+		}
 		return
 	}
 
@@ -104,6 +257,255 @@ func (h *ProductHandler) CreateOrders(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// 在庫不足の商品ごとの詳細を 409 で返す
+func writeOutOfStock(w http.ResponseWriter, err error) {
+	var outOfStock *service.OutOfStockError
+	errors.As(err, &outOfStock)
+	resp := struct {
+		Message       string                 `json:"message"`
+		RejectedItems []model.StockRejection `json:"rejected_items"`
+	}{
+		Message: "Some items are out of stock",
+	}
+	if outOfStock != nil {
+		resp.RejectedItems = outOfStock.Items
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 新しい注文を受け付けない商品の ID を 409 で返す
+func writeInactiveProducts(w http.ResponseWriter, err error) {
+	var inactive *service.InactiveProductError
+	errors.As(err, &inactive)
+	resp := struct {
+		Message            string `json:"message"`
+		InactiveProductIDs []int  `json:"inactive_product_ids"`
+	}{
+		Message: "Some products are not available for order",
+	}
+	if inactive != nil {
+		resp.InactiveProductIDs = inactive.ProductIDs
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// よく一緒に注文される商品を取得
+func (h *ProductHandler) Recommendations(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productID"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	limit := PRODUCT_RECOMMENDATION_LIMIT_DEFAULT
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	recs, err := h.ProductSvc.Recommendations(r.Context(), productID, limit)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "Invalid limit", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get recommendations for product %d: %v", productID, err)
+		http.Error(w, "Failed to get recommendations", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": recs})
+}
+
+// 商品をお気に入りに追加
+func (h *ProductHandler) AddFavorite(w http.ResponseWriter, r *http.Request) {
+	h.updateFavorite(w, r, h.ProductSvc.AddFavorite)
+}
+
+// 商品をお気に入りから外す
+func (h *ProductHandler) RemoveFavorite(w http.ResponseWriter, r *http.Request) {
+	h.updateFavorite(w, r, h.ProductSvc.RemoveFavorite)
+}
+
+func (h *ProductHandler) updateFavorite(w http.ResponseWriter, r *http.Request, update func(ctx context.Context, userID, productID int) error) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	productID, err := strconv.Atoi(chi.URLParam(r, "productID"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	err = update(r.Context(), userID, productID)
+	if errors.Is(err, service.ErrProductNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update favorite %d for user %d: %v", productID, userID, err)
+		http.Error(w, "Failed to update favorites", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// お気に入りの商品一覧を取得 (page / page_size をクエリで指定する)
+func (h *ProductHandler) ListFavorites(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	var req model.ListRequest
+	if err := bindListRequest(r, &req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Page <= 0 {
+		req.Page = PRODUCT_PAGE_DEFAULT
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = PRODUCT_PAGE_SIZE_DEFAULT
+	}
+	req.Offset = (req.Page - 1) * req.PageSize
+
+	favorites, total, err := h.ProductSvc.ListFavorites(r.Context(), userID, req)
+	if err != nil {
+		log.Printf("Failed to list favorites for user %d: %v", userID, err)
+		http.Error(w, "Failed to list favorites", http.StatusInternalServerError)
+		return
+	}
+	resp := struct {
+		Data  []model.FavoriteProduct `json:"data"`
+		Total int                     `json:"total"`
+		pagination
+	}{
+		Data:       favorites,
+		Total:      total,
+		pagination: newPagination(req, total, len(favorites), false),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 商品の価格を変更する（管理者用）
+func (h *ProductHandler) Update(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusInternalServerError)
+		return
+	}
+	productID, err := strconv.Atoi(chi.URLParam(r, "productID"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	var req model.UpdateProductRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err = h.ProductSvc.UpdateProduct(r.Context(), adminID, productID, req)
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		http.Error(w, "Invalid value or is_active", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	case err != nil:
+		log.Printf("Failed to update product %d: %v", productID, err)
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 商品の価格の変更履歴を取得
+func (h *ProductHandler) PriceHistory(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productID"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	resp, err := h.ProductSvc.ProductValueHistory(r.Context(), productID)
+	if errors.Is(err, service.ErrProductNotFound) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get price history of product %d: %v", productID, err)
+		http.Error(w, "Failed to get price history", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 商品一覧のキャッシュの大きさと追い出した数（管理者用）
+func (h *ProductHandler) ListCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ProductSvc.ListCacheStats())
+}
+
+// 商品画像をアップロードする（管理者用）
+// multipart/form-data の image フィールドで受け取る
+func (h *ProductHandler) UploadImage(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "productID"))
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	// multipart のヘッダー分の余裕を持たせる
+	r.Body = http.MaxBytesReader(w, r.Body, service.MaxProductImageBytes+1<<20)
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		http.Error(w, "image file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, service.MaxProductImageBytes+1))
+	if err != nil {
+		http.Error(w, "Failed to read image", http.StatusBadRequest)
+		return
+	}
+
+	image, err := h.ProductSvc.UpdateProductImage(r.Context(), productID, data)
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		http.Error(w, "Image is empty or too large", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrUnsupportedImageType):
+		http.Error(w, "Unsupported image type", http.StatusUnsupportedMediaType)
+		return
+	case errors.Is(err, service.ErrProductNotFound):
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrImageStoreUnavailable):
+		http.Error(w, "Image upload is not available", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.Printf("Failed to upload image for product %d: %v", productID, err)
+		http.Error(w, "Failed to upload image", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"product_id": productID,
+		"image":      image,
+	})
+}
+
 func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	imagePath := r.URL.Query().Get("path")
 	if imagePath == "" {
@@ -119,6 +521,38 @@ func (h *ProductHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// size を指定すると長辺がそのピクセル数の縮小版を返す (original または省略で元の画像)
+	if size := r.URL.Query().Get("size"); size != "" && size != "original" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			http.Error(w, "無効なサイズです", http.StatusBadRequest)
+			return
+		}
+		variant, err := h.ProductSvc.ProductImageVariant(r.Context(), imagePath, n)
+		switch {
+		case errors.Is(err, service.ErrInvalidRequest):
+			http.Error(w, "無効なサイズです", http.StatusBadRequest)
+			return
+		case errors.Is(err, service.ErrImageNotFound):
+			http.Error(w, "画像が見つかりません", http.StatusNotFound)
+			return
+		case err != nil:
+			log.Printf("Failed to get image variant %s (%d): %v", imagePath, n, err)
+			http.Error(w, "Failed to get image", http.StatusInternalServerError)
+			return
+		}
+		imagePath = variant
+	}
+
+	// Accept で WebP / AVIF を受け付けるなら、変換済みの画像があればそちらを返す
+	w.Header().Set("Vary", "Accept")
+	imagePath = h.ProductSvc.ProductImageAlternate(r.Context(), imagePath, acceptedImageFormats(r.Header.Get("Accept")))
+
+	if h.ServeImagesDirectly {
+		h.serveImage(w, r, imagePath)
+		return
+	}
+
 	// nginx でキャッシュを無効化しており、画像の取得が毎回行われるので、レギュレーションに違反しない
 	accelURI := path.Join("/_protected/images", imagePath)
 	w.Header().Set("X-Accel-Redirect", accelURI)
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{``, false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz"`, false},
+		{`*`, true},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, etag); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestWriteJSONWithETag(t *testing.T) {
+	body := map[string]int{"total": 3}
+
+	first := httptest.NewRecorder()
+	writeJSONWithETag(first, httptest.NewRequest(http.MethodPost, "/api/v1/product", nil), body)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || first.Body.String() != "{\"total\":3}\n" {
+		t.Fatalf("first response = %d %q (etag %q)", first.Code, first.Body.String(), etag)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/product", nil)
+	req.Header.Set("If-None-Match", etag)
+	second := httptest.NewRecorder()
+	writeJSONWithETag(second, req, body)
+	if second.Code != http.StatusNotModified || second.Body.Len() != 0 {
+		t.Fatalf("second response = %d %q, want 304 without body", second.Code, second.Body.String())
+	}
+
+	changed := httptest.NewRecorder()
+	writeJSONWithETag(changed, req, map[string]int{"total": 4})
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Fatalf("changed response = %d (etag %q), want 200 with a new etag", changed.Code, changed.Header().Get("ETag"))
+	}
+}
+
+func TestSelectProductFields(t *testing.T) {
+	products := []model.Product{{ProductID: 1, Name: "りんご", Value: 100, Description: "長い説明"}}
+	got := selectProductFields(products, []string{"product_id", "name", "value"})
+	want := []map[string]any{{"product_id": 1, "name": "りんご", "value": 100}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("selectProductFields = %v, want %v", got, want)
+	}
+}
+
+func TestAcceptedImageFormats(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   []string
+	}{
+		{"", nil},
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", []string{"avif", "webp"}},
+		{"image/webp;q=0.9, image/png", []string{"webp"}},
+		{"image/avif;q=0, image/webp", []string{"webp"}},
+		{"IMAGE/WEBP", []string{"webp"}},
+		{"image/*, */*", nil},
+	}
+	for _, tt := range tests {
+		if got := acceptedImageFormats(tt.accept); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("acceptedImageFormats(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestGetImageServesDirectly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "apple.png"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	images, err := repository.NewLocalImageStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewProductHandler(service.NewProductService(repository.NewStore(nil, repository.WithImageStore(images))))
+	h.ServeImagesDirectly = true
+
+	get := func(path, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/image?path="+path, nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		h.GetImage(rec, req)
+		return rec
+	}
+
+	full := get("apple.png", "")
+	if full.Code != http.StatusOK || full.Body.String() != "0123456789" || full.Header().Get("X-Accel-Redirect") != "" {
+		t.Fatalf("full response = %d %q", full.Code, full.Body.String())
+	}
+	if ct, cl := full.Header().Get("Content-Type"), full.Header().Get("Content-Length"); ct != "image/png" || cl != "10" {
+		t.Fatalf("Content-Type = %q, Content-Length = %q", ct, cl)
+	}
+
+	partial := get("apple.png", "bytes=2-4")
+	if partial.Code != http.StatusPartialContent || partial.Body.String() != "234" || partial.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Fatalf("range response = %d %q (%q)", partial.Code, partial.Body.String(), partial.Header().Get("Content-Range"))
+	}
+
+	if missing := get("missing.png", ""); missing.Code != http.StatusNotFound {
+		t.Fatalf("missing image = %d, want 404", missing.Code)
+	}
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"backend/internal/middleware"
 	"backend/internal/model"
 	"backend/internal/service"
+	"errors"
+	"github.com/go-chi/chi/v5"
 	"github.com/goccy/go-json"
+	"github.com/google/uuid"
 	"log"
 	"net/http"
 	"strconv"
@@ -11,31 +15,95 @@ import (
 
 type RobotHandler struct {
 	RobotSvc *service.RobotService
+	PlanJobs *service.DeliveryPlanJobs
 }
 
-func NewRobotHandler(robotSvc *service.RobotService) *RobotHandler {
-	return &RobotHandler{RobotSvc: robotSvc}
+func NewRobotHandler(robotSvc *service.RobotService, planJobs *service.DeliveryPlanJobs) *RobotHandler {
+	return &RobotHandler{RobotSvc: robotSvc, PlanJobs: planJobs}
+}
+
+// 全ロボット共通のキーで認証したロボット
+const defaultRobotID = "robot-001"
+
+// ロボットごとのキーで認証した場合はキーのロボット
+// X-Robot-ID は認証されていないので使わない (共通のキーならすべて defaultRobotID として扱う)
+func requestRobotID(r *http.Request) string {
+	if id, ok := middleware.GetRobotFromContext(r.Context()); ok {
+		return id
+	}
+	return defaultRobotID
+}
+
+// 配送計画を作る条件のクエリパラメータ
+// capacity と volume_capacity は省略したら 0 (volume_capacity は商品に容積を持つ場合のみ使う)
+func deliveryPlanParams(w http.ResponseWriter, r *http.Request) (model.DeliveryPlanParams, bool) {
+	var params model.DeliveryPlanParams
+	query := r.URL.Query()
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"capacity", &params.Capacity}, {"volume_capacity", &params.VolumeCapacity}} {
+		str := query.Get(p.name)
+		if str == "" {
+			continue
+		}
+		v, err := strconv.Atoi(str)
+		if err != nil || v <= 0 {
+			http.Error(w, "Query parameter '"+p.name+"' must be a positive integer", http.StatusBadRequest)
+			return params, false
+		}
+		*p.value = v
+	}
+
+	// 応答を受け取れなかった計画を再要求するときの UUID
+	if params.PlanUUID = query.Get("plan_uuid"); params.PlanUUID != "" {
+		if _, err := uuid.Parse(params.PlanUUID); err != nil {
+			http.Error(w, "Query parameter 'plan_uuid' must be a UUID", http.StatusBadRequest)
+			return params, false
+		}
+	}
+	if params.Algorithm = query.Get("algorithm"); params.Algorithm != "" && !service.ValidDeliveryPlanAlgorithm(params.Algorithm) {
+		http.Error(w, "Query parameter 'algorithm' must be one of dp, greedy, fptas, branch-and-bound", http.StatusBadRequest)
+		return params, false
+	}
+	// エリアを担当するロボットはそのゾーンの注文だけを計画する
+	if params.Zone = query.Get("zone"); len(params.Zone) > service.MaxZoneLength {
+		http.Error(w, "Query parameter 'zone' is too long", http.StatusBadRequest)
+		return params, false
+	}
+	return params, true
 }
 
 // 配送計画を取得
+// 登録済みのロボットは capacity を省略でき、指定しても登録された積載量を超えない
 func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
-	robotID := "robot-001"
-
-	capacityStr := r.URL.Query().Get("capacity")
-	if capacityStr == "" {
-		http.Error(w, "Query parameter 'capacity' is required", http.StatusBadRequest)
+	robotID := requestRobotID(r)
+	params, ok := deliveryPlanParams(w, r)
+	if !ok {
 		return
 	}
-	capacity, err := strconv.Atoi(capacityStr)
+
+	plan, err := h.RobotSvc.GenerateDeliveryPlan(r.Context(), robotID, params)
 	if err != nil {
-		http.Error(w, "Query parameter 'capacity' must be an integer", http.StatusBadRequest)
+		writeDeliveryPlanError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// 注文を配送中にせずに、配送計画を確認する (パラメータは /delivery-plan と同じ、plan_uuid は使わない)
+func (h *RobotHandler) PreviewDeliveryPlan(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+	params, ok := deliveryPlanParams(w, r)
+	if !ok {
 		return
 	}
 
-	plan, err := h.RobotSvc.GenerateDeliveryPlan(r.Context(), robotID, capacity)
+	plan, err := h.RobotSvc.PreviewDeliveryPlan(r.Context(), robotID, params)
 	if err != nil {
-		log.Printf("Failed to generate delivery plan: %v", err)
-		http.Error(w, "Failed to create delivery plan", http.StatusInternalServerError)
+		writeDeliveryPlanError(w, err)
 		return
 	}
 
@@ -43,6 +111,118 @@ func (h *RobotHandler) GetDeliveryPlan(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(plan)
 }
 
+// 配送計画を作れなかった理由 (v1 と v2 で共通)
+func writeDeliveryPlanError(w http.ResponseWriter, err error) {
+	msg, code := deliveryPlanError(err)
+	if code == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	http.Error(w, msg, code)
+}
+
+// 配送計画を作れなかった理由の応答と HTTP ステータス (WebSocket とジョブでも使う)
+func deliveryPlanError(err error) (string, int) {
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		return "Query parameter 'capacity' is required for unregistered robots", http.StatusBadRequest
+	case errors.Is(err, service.ErrRobotOffline):
+		return "Robot is offline", http.StatusConflict
+	case errors.Is(err, service.ErrOrderConflict):
+		return "Orders were updated concurrently, retry", http.StatusConflict
+	case errors.Is(err, service.ErrDeliveryPlanNotFound):
+		return "Delivery plan not found", http.StatusNotFound
+	case errors.Is(err, service.ErrDeliveryPlanBusy):
+		return "Too many delivery plans in progress, retry later", http.StatusServiceUnavailable
+	default:
+		log.Printf("Failed to generate delivery plan: %v", err)
+		return "Failed to create delivery plan", http.StatusInternalServerError
+	}
+}
+
+// 複数の注文ステータスをまとめて更新 (order_ids の 1 件ごとに 1 個を進める)
+func (h *RobotHandler) UpdateOrderStatuses(w http.ResponseWriter, r *http.Request) {
+	var req model.UpdateOrderStatusesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.RobotSvc.UpdateOrderStatuses(r.Context(), req.OrderIDs, req.NewStatus)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "new_status must be delivering or completed and order_ids must have 1 to 1000 items", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrInvalidStatusTransition) {
+		http.Error(w, "Invalid status transition", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update order statuses for %d orders: %v", len(req.OrderIDs), err)
+		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 配送計画を引き受けたことを通知する (リース期限までに呼ばないと計画の注文は未配送に戻る)
+func (h *RobotHandler) AcknowledgeDeliveryPlan(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+
+	planID, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.RobotSvc.AcknowledgeDeliveryPlan(r.Context(), robotID, planID)
+	if errors.Is(err, service.ErrDeliveryPlanNotFound) {
+		http.Error(w, "Delivery plan not found or lease expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to acknowledge delivery plan %d: %v", planID, err)
+		http.Error(w, "Failed to acknowledge delivery plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 配送計画のうち order_ids の明細だけを引き受け、ほかの明細を未配送に戻す (確認も兼ねる)
+func (h *RobotHandler) AcceptDeliveryPlan(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+
+	planID, err := strconv.ParseInt(chi.URLParam(r, "planID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+	var req model.AcceptDeliveryPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.RobotSvc.AcceptDeliveryPlan(r.Context(), robotID, planID, req.OrderIDs)
+	if errors.Is(err, service.ErrDeliveryPlanNotFound) {
+		http.Error(w, "Delivery plan not found, already acknowledged, or lease expired", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "order_ids must be order items of the plan", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to accept delivery plan %d: %v", planID, err)
+		http.Error(w, "Failed to accept delivery plan", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
 // 配送完了時に注文ステータスを更新
 func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
 	var req model.UpdateOrderStatusRequest
@@ -51,7 +231,24 @@ func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err := h.RobotSvc.UpdateOrderStatus(r.Context(), req.OrderID, req.NewStatus)
+	if req.NewStatus == "failed" {
+		h.reportDeliveryFailure(w, r, req)
+		return
+	}
+
+	err := h.RobotSvc.UpdateOrderStatus(r.Context(), req.OrderID, req.NewStatus, req.Version)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "new_status must be delivering, completed or failed", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, service.ErrOrderConflict) {
+		http.Error(w, "Order was updated concurrently", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, service.ErrInvalidStatusTransition) {
+		http.Error(w, "Invalid status transition", http.StatusConflict)
+		return
+	}
 	if err != nil {
 		log.Printf("Failed to update order status for order %d: %v", req.OrderID, err)
 		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
@@ -61,3 +258,214 @@ func (h *RobotHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Order status updated"))
 }
+
+// 配送に失敗した明細の 1 個を報告 (再試行の上限までは未配送に戻す)
+func (h *RobotHandler) reportDeliveryFailure(w http.ResponseWriter, r *http.Request, req model.UpdateOrderStatusRequest) {
+	result, err := h.RobotSvc.ReportDeliveryFailure(r.Context(), requestRobotID(r), req.OrderID, req.Reason)
+	switch {
+	case errors.Is(err, service.ErrDeliveryFailureDisabled):
+		http.Error(w, "Delivery failure reporting is not enabled", http.StatusNotFound)
+		return
+	case errors.Is(err, service.ErrInvalidRequest):
+		http.Error(w, "reason must be one of recipient_absent, address_not_found, access_denied, damaged, other", http.StatusBadRequest)
+		return
+	case errors.Is(err, service.ErrInvalidStatusTransition):
+		http.Error(w, "Order has no units being delivered", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to report delivery failure for order %d: %v", req.OrderID, err)
+		http.Error(w, "Failed to update order status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// 配送ロボットを登録 (登録済みなら積載量と状態を更新)
+func (h *RobotHandler) RegisterRobot(w http.ResponseWriter, r *http.Request) {
+	var req model.RegisterRobotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	robot, err := h.RobotSvc.RegisterRobot(r.Context(), chi.URLParam(r, "robotID"), req)
+	if err != nil {
+		h.writeRobotError(w, err, "Failed to register robot")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(robot)
+}
+
+func (h *RobotHandler) GetRobot(w http.ResponseWriter, r *http.Request) {
+	robot, err := h.RobotSvc.GetRobot(r.Context(), chi.URLParam(r, "robotID"))
+	if err != nil {
+		h.writeRobotError(w, err, "Failed to get robot")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(robot)
+}
+
+// ロボットの状態 (idle / delivering / offline) を更新
+func (h *RobotHandler) UpdateRobotState(w http.ResponseWriter, r *http.Request) {
+	var req model.UpdateRobotStateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.RobotSvc.UpdateRobotState(r.Context(), chi.URLParam(r, "robotID"), req.State); err != nil {
+		h.writeRobotError(w, err, "Failed to update robot state")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RobotHandler) writeRobotError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidRequest):
+		http.Error(w, "capacity must be positive and state must be idle, delivering or offline", http.StatusBadRequest)
+	case errors.Is(err, service.ErrRobotNotFound):
+		http.Error(w, "Robot not found", http.StatusNotFound)
+	case errors.Is(err, service.ErrRobotRegistryDisabled):
+		http.Error(w, "Robot registry is not enabled", http.StatusNotFound)
+	default:
+		log.Printf("%s: %v", message, err)
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
+
+// ロボットのハートビート (バッテリー残量、現在地、積んでいる重さ)
+func (h *RobotHandler) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+
+	var req model.RobotHeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	err := h.RobotSvc.Heartbeat(r.Context(), robotID, req)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "battery_percent, latitude, longitude or load_weight is out of range", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record heartbeat of robot %s: %v", robotID, err)
+		http.Error(w, "Failed to record heartbeat", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 管理者向けに各ロボットの最新の状態を一覧する
+func (h *RobotHandler) ListRobots(w http.ResponseWriter, r *http.Request) {
+	telemetry, err := h.RobotSvc.ListRobotTelemetry(r.Context())
+	if err != nil {
+		log.Printf("Failed to list robot telemetry: %v", err)
+		http.Error(w, "Failed to list robots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(telemetry)
+}
+
+// ロボットの API キーを発行する（管理者用、生キーはこの応答でしか返さない）
+func (h *RobotHandler) IssueRobotAPIKey(w http.ResponseWriter, r *http.Request) {
+	robotID := chi.URLParam(r, "robotID")
+	var req model.IssueRobotAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rawKey, key, err := h.RobotSvc.IssueRobotAPIKey(r.Context(), robotID, req)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "rotate_grace must be a non-negative duration", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue API key for robot %s: %v", robotID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Key  string             `json:"key"`
+		Info *model.RobotAPIKey `json:"info"`
+	}{
+		Key:  rawKey,
+		Info: key,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 配送計画のアルゴリズムを同じ注文で比べる（管理者用）
+func (h *RobotHandler) BenchmarkPlanners(w http.ResponseWriter, r *http.Request) {
+	var req model.PlannerBenchmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.RobotSvc.BenchmarkPlanners(r.Context(), req)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "Invalid benchmark parameters", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to benchmark delivery planners: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ロボットの API キーの一覧（管理者用）
+func (h *RobotHandler) ListRobotAPIKeys(w http.ResponseWriter, r *http.Request) {
+	robotID := chi.URLParam(r, "robotID")
+	keys, err := h.RobotSvc.ListRobotAPIKeys(r.Context(), robotID)
+	if err != nil {
+		log.Printf("Failed to list API keys for robot %s: %v", robotID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// ロボットの API キーを失効させる（管理者用）
+func (h *RobotHandler) RevokeRobotAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.ParseInt(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid key ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.RobotSvc.RevokeRobotAPIKey(r.Context(), keyID); err != nil {
+		if errors.Is(err, service.ErrRobotAPIKeyNotFound) {
+			http.Error(w, "API key not found or already revoked", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to revoke robot API key %d: %v", keyID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
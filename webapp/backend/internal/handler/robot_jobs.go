@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"backend/internal/service"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+	"net/http"
+)
+
+// 配送計画を作るジョブを受け付ける (パラメータは /delivery-plan と同じ)
+// 注文が多く計画に時間がかかる場合に、リクエストを待たせずに解く
+func (h *RobotHandler) SubmitDeliveryPlanJob(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+	params, ok := deliveryPlanParams(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.PlanJobs.Submit(robotID, params)
+	if errors.Is(err, service.ErrDeliveryPlanJobQueueFull) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many delivery plan jobs, retry later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to submit delivery plan job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", r.URL.Path+"/"+job.JobID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// ジョブの状態を取得 (done なら plan に計画を含む)
+func (h *RobotHandler) GetDeliveryPlanJob(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+
+	job, err := h.PlanJobs.Get(robotID, chi.URLParam(r, "jobID"))
+	if errors.Is(err, service.ErrDeliveryPlanJobNotFound) {
+		http.Error(w, "Delivery plan job not found or expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get delivery plan job", http.StatusInternalServerError)
+		return
+	}
+	if job.Status == model.DeliveryPlanJobFailed {
+		job.Error, _ = deliveryPlanError(job.Err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
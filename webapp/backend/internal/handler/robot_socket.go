@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"context"
+	"github.com/goccy/go-json"
+	"golang.org/x/net/websocket"
+	"log"
+	"net/http"
+)
+
+// ロボットから受け取るメッセージの大きさの上限 (ready しか受け取らない)
+const robotSocketMaxPayload = 4096
+
+// 配送計画の WebSocket
+// ロボットが ready を送るたびに、空でない計画が作れ次第その計画を配送中にして送る (ポーリングの代わり)
+// パラメータは /delivery-plan と同じ (plan_uuid は使わない)
+func (h *RobotHandler) DeliveryPlanSocket(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+	params, ok := deliveryPlanParams(w, r)
+	if !ok {
+		return
+	}
+	params.PlanUUID = ""
+	websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.MaxPayloadBytes = robotSocketMaxPayload
+		h.serveDeliveryPlanSocket(ws, robotID, params)
+	}}.ServeHTTP(w, r)
+}
+
+func (h *RobotHandler) serveDeliveryPlanSocket(ws *websocket.Conn, robotID string, params model.DeliveryPlanParams) {
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	// 受信は別に読み、切断されたら計画を待つのをやめる
+	ready := make(chan struct{}, 1)
+	go func() {
+		defer cancel()
+		for {
+			var data []byte
+			if err := websocket.Message.Receive(ws, &data); err != nil {
+				return
+			}
+			var msg model.RobotSocketMessage
+			if err := json.Unmarshal(data, &msg); err != nil || msg.Type != model.RobotSocketReady {
+				continue
+			}
+			select {
+			case ready <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ready:
+		}
+		plan, err := h.RobotSvc.WaitDeliveryPlan(ctx, robotID, params)
+		if ctx.Err() != nil {
+			// 計画を送れなかった場合も、リースを記録していれば期限切れで未配送に戻る
+			return
+		}
+		if err != nil {
+			msg, _ := deliveryPlanError(err)
+			sendRobotSocketMessage(ws, model.RobotSocketMessage{Type: model.RobotSocketError, Error: msg})
+			return
+		}
+		if err := sendRobotSocketMessage(ws, model.RobotSocketMessage{Type: model.RobotSocketPlan, Plan: plan}); err != nil {
+			log.Printf("Failed to push delivery plan to %s: %v", robotID, err)
+			return
+		}
+	}
+}
+
+func sendRobotSocketMessage(ws *websocket.Conn, msg model.RobotSocketMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return websocket.Message.Send(ws, string(data))
+}
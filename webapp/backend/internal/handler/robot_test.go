@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/service"
+)
+
+func TestDeliveryPlanParams(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/robot/delivery-plan?capacity=50&volume_capacity=20&algorithm=fptas&zone=north", nil)
+	params, ok := deliveryPlanParams(w, r)
+	want := model.DeliveryPlanParams{Capacity: 50, VolumeCapacity: 20, Algorithm: "fptas", Zone: "north"}
+	if !ok || params != want {
+		t.Fatalf("params = %+v, %v; want %+v", params, ok, want)
+	}
+
+	for _, query := range []string{"capacity=0", "volume_capacity=big", "plan_uuid=abc", "algorithm=simplex"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/robot/delivery-plan?"+query, nil)
+		if _, ok := deliveryPlanParams(w, r); ok || w.Code != http.StatusBadRequest {
+			t.Errorf("%s must be rejected (status %d)", query, w.Code)
+		}
+	}
+}
+
+func TestWriteDeliveryPlanErrorBusy(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeDeliveryPlanError(w, service.ErrDeliveryPlanBusy)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q; want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRequestRobotIDIgnoresHeader(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/robot/delivery-plan", nil)
+	r.Header.Set("X-Robot-ID", "robot-008")
+	if got := requestRobotID(r); got != defaultRobotID {
+		t.Fatalf("robot = %q, want %q for the shared key", got, defaultRobotID)
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"backend/internal/model"
+	"backend/internal/service"
+	"github.com/goccy/go-json"
+	"net/http"
+	"time"
+)
+
+// 配送計画 v2 (計画の作り方とパラメータは v1 と同じで、明細ごとにまとめて商品名、配送先、取り扱いフラグを付ける)
+func (h *RobotHandler) GetDeliveryPlanV2(w http.ResponseWriter, r *http.Request) {
+	robotID := requestRobotID(r)
+	params, ok := deliveryPlanParams(w, r)
+	if !ok {
+		return
+	}
+
+	plan, details, err := h.RobotSvc.GenerateDeliveryPlanWithDetails(r.Context(), robotID, params)
+	if err != nil {
+		writeDeliveryPlanError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveryPlanV2(plan, details, time.Now()))
+}
+
+// 計画の単位を明細ごとにまとめる (計画で最初に現れた順)
+func deliveryPlanV2(plan *model.DeliveryPlan, details map[int64]model.Order, now time.Time) model.DeliveryPlanV2 {
+	items := []model.DeliveryPlanItemV2{}
+	index := make(map[int64]int, len(plan.Orders))
+	for _, o := range plan.Orders {
+		if i, ok := index[o.OrderID]; ok {
+			items[i].Units++
+			continue
+		}
+		detail := details[o.OrderID]
+		// 取り扱いフラグは明細の metadata と配達期限から決める
+		detail.DeliverBefore = o.DeliverBefore
+		item := model.DeliveryPlanItemV2{
+			OrderID:       o.OrderID,
+			Version:       o.Version,
+			ProductID:     detail.ProductID,
+			ProductName:   detail.ProductName,
+			Units:         1,
+			UnitWeight:    o.Weight,
+			UnitVolume:    o.Volume,
+			UnitValue:     o.Value,
+			Priority:      o.Priority,
+			DeliverAfter:  o.DeliverAfter,
+			DeliverBefore: o.DeliverBefore,
+			Handling:      service.DeliveryHandlingFlags(detail, now),
+		}
+		if item.Handling == nil {
+			item.Handling = []string{}
+		}
+		if o.DestLatitude != nil && o.DestLongitude != nil {
+			item.Destination = &model.Destination{Latitude: *o.DestLatitude, Longitude: *o.DestLongitude}
+		}
+		index[o.OrderID] = len(items)
+		items = append(items, item)
+	}
+	return model.DeliveryPlanV2{
+		RobotID:        plan.RobotID,
+		TotalWeight:    plan.TotalWeight,
+		TotalVolume:    plan.TotalVolume,
+		TotalValue:     plan.TotalValue,
+		Items:          items,
+		Approximate:    plan.Approximate,
+		Route:          plan.Route,
+		PlanID:         plan.PlanID,
+		LeaseExpiresAt: plan.LeaseExpiresAt,
+		PlanUUID:       plan.PlanUUID,
+		Replayed:       plan.Replayed,
+	}
+}
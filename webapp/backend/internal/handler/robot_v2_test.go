@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestDeliveryPlanV2GroupsUnitsByOrderItem(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(time.Minute)
+	lat, lng := 35.68, 139.76
+	plan := &model.DeliveryPlan{
+		RobotID:     "robot",
+		TotalWeight: 7,
+		TotalValue:  30,
+		Orders: []model.Order{
+			{OrderID: 2, Version: 4, Weight: 3, Value: 10, DeliverBefore: &soon, DestLatitude: &lat, DestLongitude: &lng},
+			{OrderID: 1, Version: 1, Weight: 1, Value: 10},
+			{OrderID: 2, Version: 4, Weight: 3, Value: 10, DeliverBefore: &soon, DestLatitude: &lat, DestLongitude: &lng},
+		},
+	}
+	details := map[int64]model.Order{
+		1: {OrderID: 1, ProductID: 10, ProductName: "りんご"},
+		2: {OrderID: 2, ProductID: 20, ProductName: "グラス", Metadata: model.OrderMetadata{"handling": []any{"fragile", 1, "fragile"}}},
+	}
+
+	got := deliveryPlanV2(plan, details, now)
+	if len(got.Items) != 2 || got.TotalWeight != 7 || got.RobotID != "robot" {
+		t.Fatalf("plan = %+v", got)
+	}
+	glass, apple := got.Items[0], got.Items[1]
+	if glass.OrderID != 2 || glass.Units != 2 || glass.ProductName != "グラス" || glass.Destination == nil || glass.Destination.Latitude != lat {
+		t.Fatalf("glass = %+v", glass)
+	}
+	if want := []string{"fragile", model.HandlingUrgent}; !reflect.DeepEqual(glass.Handling, want) {
+		t.Fatalf("handling = %v, want %v", glass.Handling, want)
+	}
+	if apple.OrderID != 1 || apple.Units != 1 || apple.ProductID != 10 || apple.Destination != nil || apple.Handling == nil || len(apple.Handling) != 0 {
+		t.Fatalf("apple = %+v", apple)
+	}
+}
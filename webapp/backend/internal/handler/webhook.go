@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"backend/internal/middleware"
+	"backend/internal/service"
+	"database/sql"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type WebhookHandler struct {
+	WebhookSvc *service.WebhookService
+}
+
+func NewWebhookHandler(svc *service.WebhookService) *WebhookHandler {
+	return &WebhookHandler{WebhookSvc: svc}
+}
+
+// ログインユーザー自身の注文を対象にする
+func (h *WebhookHandler) userScope(w http.ResponseWriter, r *http.Request) (sql.NullInt64, bool) {
+	userID, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found", http.StatusInternalServerError)
+		return sql.NullInt64{}, false
+	}
+	return sql.NullInt64{Int64: int64(userID), Valid: true}, true
+}
+
+func (h *WebhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.userScope(w, r)
+	if !ok {
+		return
+	}
+	h.create(w, r, scope)
+}
+
+func (h *WebhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.userScope(w, r)
+	if !ok {
+		return
+	}
+	h.list(w, r, scope)
+}
+
+func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	scope, ok := h.userScope(w, r)
+	if !ok {
+		return
+	}
+	h.delete(w, r, scope)
+}
+
+// 全ユーザーの注文を対象にする Webhook（管理者用）
+func (h *WebhookHandler) CreateGlobal(w http.ResponseWriter, r *http.Request) {
+	h.create(w, r, sql.NullInt64{})
+}
+
+func (h *WebhookHandler) ListGlobal(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, sql.NullInt64{})
+}
+
+func (h *WebhookHandler) DeleteGlobal(w http.ResponseWriter, r *http.Request) {
+	h.delete(w, r, sql.NullInt64{})
+}
+
+func (h *WebhookHandler) create(w http.ResponseWriter, r *http.Request, scope sql.NullInt64) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := h.WebhookSvc.Register(r.Context(), scope, req.URL)
+	if errors.Is(err, service.ErrInvalidRequest) {
+		http.Error(w, "Invalid webhook URL", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to register webhook: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// シークレットは登録時にのみ返す
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ID     int64  `json:"id"`
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+	}{
+		ID:     webhook.ID,
+		URL:    webhook.URL,
+		Secret: webhook.Secret,
+	})
+}
+
+func (h *WebhookHandler) list(w http.ResponseWriter, r *http.Request, scope sql.NullInt64) {
+	webhooks, err := h.WebhookSvc.List(r.Context(), scope)
+	if err != nil {
+		log.Printf("Failed to list webhooks: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhooks)
+}
+
+func (h *WebhookHandler) delete(w http.ResponseWriter, r *http.Request, scope sql.NullInt64) {
+	webhookID, err := strconv.ParseInt(chi.URLParam(r, "webhookID"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.WebhookSvc.Delete(r.Context(), scope, webhookID); err != nil {
+		if errors.Is(err, service.ErrWebhookNotFound) {
+			http.Error(w, "Webhook not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to delete webhook %d: %v", webhookID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"backend/internal/model"
+)
+
+type roleFinderFunc func(userID int) (string, error)
+
+func (f roleFinderFunc) FindRoleByID(_ context.Context, userID int) (string, error) {
+	return f(userID)
+}
+
+func TestAdminOnly(t *testing.T) {
+	roles := roleFinderFunc(func(userID int) (string, error) {
+		switch userID {
+		case 1:
+			return model.RoleAdmin, nil
+		case 2:
+			return model.RoleUser, nil
+		}
+		return "", errors.New("not found")
+	})
+	h := AdminOnly(roles)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(userID int) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/users/3/role", nil)
+		if userID != 0 {
+			req = req.WithContext(context.WithValue(req.Context(), userContextKey, userID))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, tt := range []struct {
+		userID int
+		want   int
+	}{
+		{0, http.StatusUnauthorized},
+		{1, http.StatusOK},
+		{2, http.StatusForbidden},
+		{3, http.StatusForbidden},
+	} {
+		if got := call(tt.userID); got != tt.want {
+			t.Errorf("user %d: status = %d, want %d", tt.userID, got, tt.want)
+		}
+	}
+}
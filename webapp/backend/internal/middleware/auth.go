@@ -2,57 +2,229 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"backend/internal/model"
 	"backend/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 )
 
 type contextKey string
 
-const userContextKey contextKey = "user"
+const (
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session"
+	robotContextKey   contextKey = "robot"
+)
+
+// API トークンのスコープ
+const (
+	ScopeAll            = model.ScopeAll
+	ScopeProductsRead   = model.ScopeProductsRead
+	ScopeOrdersRead     = model.ScopeOrdersRead
+	ScopeOrdersWrite    = model.ScopeOrdersWrite
+	ScopeFavoritesWrite = model.ScopeFavoritesWrite
+	ScopeAdmin          = model.ScopeAdmin
+)
+
+// セッション Cookie もしくは Authorization: Bearer トークンでユーザーを認証するミドルウェアを作る
+// Bearer トークンは scopes をすべて持つ場合のみ受け付ける
+// scopes を指定しないルートはセッション認証のみ (トークンは拒否する)
+func UserAuthMiddleware(sessionRepo *repository.SessionRepository, tokenRepo *repository.TokenRepository) func(scopes ...string) func(http.Handler) http.Handler {
+	return func(scopes ...string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if rawToken, ok := bearerToken(r); ok {
+					if len(scopes) == 0 {
+						http.Error(w, "Forbidden: API tokens are not accepted for this endpoint", http.StatusForbidden)
+						return
+					}
+					token, err := tokenRepo.FindActiveByHash(r.Context(), repository.HashToken(rawToken))
+					if err != nil {
+						log.Printf("Error finding API token: %v", err)
+						http.Error(w, "Unauthorized: Invalid token", http.StatusUnauthorized)
+						return
+					}
+					if !hasScopes(strings.Split(token.Scopes, ","), scopes) {
+						http.Error(w, "Forbidden: Insufficient token scope", http.StatusForbidden)
+						return
+					}
+					ctx := context.WithValue(r.Context(), userContextKey, token.UserID)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+
+				cookie, err := r.Cookie("session_id")
+				if err != nil {
+					log.Printf("Error retrieving session cookie: %v", err)
+					http.Error(w, "Unauthorized: No session cookie", http.StatusUnauthorized)
+					return
+				}
+				sessionID := cookie.Value
+
+				userID, err := sessionRepo.FindUserBySessionID(r.Context(), sessionID)
+				if err != nil {
+					log.Printf("Error finding user by session ID: %v", err)
+					http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+					return
+				}
 
-func UserAuthMiddleware(sessionRepo *repository.SessionRepository) func(http.Handler) http.Handler {
+				ctx := context.WithValue(r.Context(), userContextKey, userID)
+				ctx = context.WithValue(ctx, sessionContextKey, sessionID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		}
+	}
+}
+
+func hasScopes(granted, required []string) bool {
+	if slices.Contains(granted, ScopeAll) {
+		return true
+	}
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+type UserRoleFinder interface {
+	FindRoleByID(ctx context.Context, userID int) (string, error)
+}
+
+// 管理者ロールのユーザーのみ通す
+// UserAuthMiddleware の後ろで使う
+func AdminOnly(userRepo UserRoleFinder) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			cookie, err := r.Cookie("session_id")
-			if err != nil {
-				log.Printf("Error retrieving session cookie: %v", err)
-				http.Error(w, "Unauthorized: No session cookie", http.StatusUnauthorized)
+			userID, ok := GetUserFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			sessionID := cookie.Value
-
-			userID, err := sessionRepo.FindUserBySessionID(r.Context(), sessionID)
+			role, err := userRepo.FindRoleByID(r.Context(), userID)
 			if err != nil {
-				log.Printf("Error finding user by session ID: %v", err)
-				http.Error(w, "Unauthorized: Invalid session", http.StatusUnauthorized)
+				log.Printf("Error finding user role: %v", err)
+				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
-
-			ctx := context.WithValue(r.Context(), userContextKey, userID)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			if role != model.RoleAdmin {
+				http.Error(w, "Forbidden: Admin only", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func RobotAuthMiddleware(validAPIKey string) func(http.Handler) http.Handler {
+// X-API-KEY からロボットを引く (robot_api_keys)
+type RobotAPIKeyFinder interface {
+	// 有効なキーがなければ sql.ErrNoRows
+	FindActiveRobotID(ctx context.Context, keyHash string) (string, error)
+}
+
+// X-API-KEY をロボットごとのキーで認証し、キーのロボット ID をコンテキストに入れる
+// X-Robot-ID を指定する場合はキーのロボットと一致しなければならない
+// sharedAPIKey が空でなければ、全ロボット共通のキーとしても受け付ける (どのロボットかは分からないので、コンテキストにロボット ID を入れない)
+// 認証できたキーは cacheTTL の間キャッシュする (0 ならキャッシュしない、失効の反映もその分遅れる)
+func RobotAuthMiddleware(keys RobotAPIKeyFinder, sharedAPIKey string, cacheTTL time.Duration) func(http.Handler) http.Handler {
+	var cache *expirable.LRU[string, string]
+	if cacheTTL > 0 {
+		cache = expirable.NewLRU[string, string](robotAPIKeyCacheSize, nil, cacheTTL)
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			apiKey := r.Header.Get("X-API-KEY")
-
-			if apiKey == "" || apiKey != validAPIKey {
+			if apiKey == "" {
 				http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
 				return
 			}
+			if sharedAPIKey != "" && subtle.ConstantTimeCompare([]byte(apiKey), []byte(sharedAPIKey)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			keyHash := repository.HashToken(apiKey)
+			robotID, ok := "", false
+			if cache != nil {
+				robotID, ok = cache.Get(keyHash)
+			}
+			if !ok {
+				var err error
+				robotID, err = keys.FindActiveRobotID(r.Context(), keyHash)
+				if err != nil {
+					if !errors.Is(err, sql.ErrNoRows) {
+						log.Printf("Error finding robot API key: %v", err)
+					}
+					http.Error(w, "Forbidden: Invalid or missing API key", http.StatusForbidden)
+					return
+				}
+				if cache != nil {
+					cache.Add(keyHash, robotID)
+				}
+			}
+			if id := r.Header.Get("X-Robot-ID"); id != "" && id != robotID {
+				http.Error(w, "Forbidden: API key does not belong to this robot", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), robotContextKey, robotID)))
+		})
+	}
+}
+
+// 認証済みのキーを覚えておく数
+const robotAPIKeyCacheSize = 1024
+
+// URL パラメータ param のロボットが、ロボットごとのキーで認証したロボットと一致する場合だけ通す
+// 共通のキーではどのロボットか分からないので拒否する (管理者用のルートから操作する)
+func RobotSelfOnly(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			robotID, ok := GetRobotFromContext(r.Context())
+			if !ok || robotID != chi.URLParam(r, param) {
+				http.Error(w, "Forbidden: API key does not belong to this robot", http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// ロボットごとのキーで認証したロボット ID (共通のキーの場合はない)
+func GetRobotFromContext(ctx context.Context) (string, bool) {
+	robotID, ok := ctx.Value(robotContextKey).(string)
+	return robotID, ok
+}
+
 // コンテキストからユーザー情報を取得
 // ユーザ情報はUserAuthMiddleware
 func GetUserFromContext(ctx context.Context) (int, bool) {
 	userID, ok := ctx.Value(userContextKey).(int)
 	return userID, ok
 }
+
+// コンテキストからセッションIDを取得
+// トークン認証の場合は存在しない
+func GetSessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionContextKey).(string)
+	return sessionID, ok
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserAuthRejectsTokenWithoutDeclaredScope(t *testing.T) {
+	userAuth := UserAuthMiddleware(nil, nil)
+	called := false
+	h := userAuth()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer sometoken")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if called {
+		t.Fatal("handler must not be called")
+	}
+}
+
+func TestUserAuthRequiresSessionCookie(t *testing.T) {
+	userAuth := UserAuthMiddleware(nil, nil)
+	h := userAuth(ScopeOrdersRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not be called")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/orders", nil))
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHasScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required []string
+		want     bool
+	}{
+		{"exact", []string{ScopeOrdersRead}, []string{ScopeOrdersRead}, true},
+		{"wildcard", []string{ScopeAll}, []string{ScopeAdmin}, true},
+		{"missing", []string{ScopeOrdersRead}, []string{ScopeOrdersWrite}, false},
+		{"partial", []string{ScopeOrdersRead}, []string{ScopeOrdersRead, ScopeProductsRead}, false},
+		{"read does not imply admin", []string{ScopeProductsRead, ScopeOrdersRead, ScopeOrdersWrite}, []string{ScopeAdmin}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasScopes(tt.granted, tt.required); got != tt.want {
+				t.Errorf("hasScopes(%v, %v) = %v, want %v", tt.granted, tt.required, got, tt.want)
+			}
+		})
+	}
+}
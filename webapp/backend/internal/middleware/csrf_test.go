@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	h := CSRFMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		bearer bool
+		want   int
+	}{
+		{"safe method", http.MethodGet, "", "", false, http.StatusNoContent},
+		{"matching token", http.MethodPost, "abc", "abc", false, http.StatusNoContent},
+		{"missing header", http.MethodPost, "abc", "", false, http.StatusForbidden},
+		{"missing cookie", http.MethodPost, "", "abc", false, http.StatusForbidden},
+		{"mismatch", http.MethodDelete, "abc", "abd", false, http.StatusForbidden},
+		{"bearer token", http.MethodPost, "", "", true, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/session/refresh", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeaderName, tt.header)
+			}
+			if tt.bearer {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrossSiteRequestMiddleware(t *testing.T) {
+	h := CrossSiteRequestMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name      string
+		method    string
+		fetchSite string
+		want      int
+	}{
+		{"header-less client", http.MethodPost, "", http.StatusNoContent},
+		{"same origin", http.MethodPost, "same-origin", http.StatusNoContent},
+		{"cross-site post", http.MethodPost, "cross-site", http.StatusForbidden},
+		{"cross-site get", http.MethodGet, "cross-site", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/v1/product/post", nil)
+			if tt.fetchSite != "" {
+				req.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
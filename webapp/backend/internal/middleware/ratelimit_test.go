@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitByIPMiddleware(t *testing.T) {
+	limiter := NewTokenBucketLimiter(0.001, 2)
+	h := RateLimitByIPMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+		req.Header.Set("X-Real-IP", ip)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := range 2 {
+		if w := call("192.0.2.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200 within the burst", i, w.Code)
+		}
+	}
+	w := call("192.0.2.1")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q; want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := call("192.0.2.2"); w.Code != http.StatusOK {
+		t.Fatalf("another IP: status = %d, want its own bucket", w.Code)
+	}
+}
+
+func TestClientIPPrefersProxyHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if got := ClientIP(req); got != "10.0.0.1" {
+		t.Fatalf("ClientIP = %q, want the remote address", got)
+	}
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.1")
+	if got := ClientIP(req); got != "198.51.100.7" {
+		t.Fatalf("ClientIP = %q, want the first X-Forwarded-For entry", got)
+	}
+	req.Header.Set("X-Real-IP", "203.0.113.5")
+	if got := ClientIP(req); got != "203.0.113.5" {
+		t.Fatalf("ClientIP = %q, want X-Real-IP", got)
+	}
+}
+
+func TestRateLimitByAPIKeyMiddleware(t *testing.T) {
+	limiter := NewTokenBucketLimiter(0.001, 2)
+	h := RateLimitByAPIKeyMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/robot/delivery-plan", nil)
+		req.Header.Set("X-API-KEY", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := range 2 {
+		if w := call("robot-a"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200 within the burst", i, w.Code)
+		}
+	}
+	w := call("robot-a")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, Retry-After = %q; want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := call("robot-b"); w.Code != http.StatusOK {
+		t.Fatalf("another key: status = %d, want its own bucket", w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/internal/repository"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeRobotKeys struct {
+	keys    map[string]string // hash -> robot ID
+	lookups int
+}
+
+func (f *fakeRobotKeys) FindActiveRobotID(_ context.Context, keyHash string) (string, error) {
+	f.lookups++
+	if robotID, ok := f.keys[keyHash]; ok {
+		return robotID, nil
+	}
+	return "", sql.ErrNoRows
+}
+
+func TestRobotAuthMiddleware(t *testing.T) {
+	keys := &fakeRobotKeys{keys: map[string]string{repository.HashToken("robot-key"): "robot-007"}}
+	var gotRobot string
+	var gotOK bool
+	h := RobotAuthMiddleware(keys, "shared-key", time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRobot, gotOK = GetRobotFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name      string
+		key       string
+		robotID   string
+		want      int
+		wantRobot string
+	}{
+		{"per-robot key", "robot-key", "", http.StatusOK, "robot-007"},
+		{"matching X-Robot-ID", "robot-key", "robot-007", http.StatusOK, "robot-007"},
+		{"other robot", "robot-key", "robot-008", http.StatusForbidden, ""},
+		{"shared key", "shared-key", "robot-008", http.StatusOK, ""},
+		{"unknown key", "nope", "", http.StatusForbidden, ""},
+		{"missing key", "", "", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRobot, gotOK = "", false
+			req := httptest.NewRequest(http.MethodGet, "/api/robot/delivery-plan", nil)
+			if tt.key != "" {
+				req.Header.Set("X-API-KEY", tt.key)
+			}
+			if tt.robotID != "" {
+				req.Header.Set("X-Robot-ID", tt.robotID)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if gotRobot != tt.wantRobot || gotOK != (tt.wantRobot != "") {
+				t.Fatalf("robot = %q (%v), want %q", gotRobot, gotOK, tt.wantRobot)
+			}
+		})
+	}
+	// 認証できたキーはキャッシュから引く
+	if keys.lookups != 2 {
+		t.Fatalf("lookups = %d, want the per-robot key looked up once plus the unknown key", keys.lookups)
+	}
+}
+
+func TestRobotSelfOnly(t *testing.T) {
+	keys := &fakeRobotKeys{keys: map[string]string{repository.HashToken("robot-key"): "robot-007"}}
+	router := chi.NewRouter()
+	router.Use(RobotAuthMiddleware(keys, "shared-key", 0))
+	router.With(RobotSelfOnly("robotID")).Put("/robots/{robotID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name    string
+		key     string
+		robotID string
+		want    int
+	}{
+		{"own robot", "robot-key", "robot-007", http.StatusNoContent},
+		{"other robot", "robot-key", "robot-008", http.StatusForbidden},
+		// 共通のキーではどのロボットか分からない
+		{"shared key", "shared-key", "robot-001", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/robots/"+tt.robotID, nil)
+			req.Header.Set("X-API-KEY", tt.key)
+			req.Header.Set("X-Robot-ID", tt.robotID)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := bearerToken(req); ok {
+		t.Fatal("request without Authorization has a token")
+	}
+	req.Header.Set("Authorization", "bearer  abc ")
+	if token, ok := bearerToken(req); !ok || token != "abc" {
+		t.Fatalf("bearerToken = %q, %v; want abc", token, ok)
+	}
+	req.Header.Set("Authorization", "Basic abc")
+	if _, ok := bearerToken(req); ok {
+		t.Fatal("Basic credentials taken as a bearer token")
+	}
+}
@@ -2,13 +2,105 @@ package model
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
 	"time"
+
+	"github.com/goccy/go-json"
 )
 
 type User struct {
-	UserID       int    `db:"user_id"`
-	PasswordHash string `db:"password_hash"`
-	UserName     string `db:"user_name"`
+	UserID           int            `db:"user_id"            json:"user_id"`
+	PasswordHash     string         `db:"password_hash"      json:"-"`
+	UserName         string         `db:"user_name"          json:"user_name"`
+	FailedLoginCount int            `db:"failed_login_count" json:"-"`
+	LockedUntil      sql.NullTime   `db:"locked_until"       json:"-"`
+	Role             string         `db:"role"               json:"role"`
+	TOTPSecret       sql.NullString `db:"totp_secret"        json:"-"`
+	TOTPEnabled      bool           `db:"totp_enabled"       json:"totp_enabled"`
+	DisplayName      string         `db:"display_name"       json:"display_name"`
+	Settings         UserSettings   `db:"settings"           json:"settings"`
+	// 注文明細でゾーンを省略した場合の配送エリア (注文明細にゾーンを持つ場合のみ)
+	Zone *string `db:"zone" json:"zone,omitempty"`
+}
+
+// ユーザーごとの任意の設定値 (users.settings に JSON で保存する)
+type UserSettings map[string]any
+
+func (s *UserSettings) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*s = UserSettings{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for UserSettings: %T", src)
+	}
+	settings := UserSettings{}
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return err
+	}
+	*s = settings
+	return nil
+}
+
+func (s UserSettings) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	// 指定したキーだけを上書きする (null を指定したキーは削除)
+	Settings map[string]any `json:"settings"`
+	// 空文字列ならゾーンをなくす
+	Zone *string `json:"zone"`
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// API トークンのスコープ
+const (
+	ScopeAll            = "*"
+	ScopeProductsRead   = "products:read"
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+	ScopeFavoritesWrite = "favorites:write"
+	ScopeAdmin          = "admin"
+)
+
+func ValidTokenScope(scope string) bool {
+	switch scope {
+	case ScopeAll, ScopeProductsRead, ScopeOrdersRead, ScopeOrdersWrite, ScopeFavoritesWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+type UserSession struct {
+	ID          int64     `db:"id"           json:"id"`
+	SessionUUID string    `db:"session_uuid" json:"-"`
+	ExpiresAt   time.Time `db:"expires_at"   json:"expires_at"`
+	Current     bool      `db:"-"            json:"current"`
+}
+
+type RefreshToken struct {
+	ID        int64     `db:"id"`
+	UserID    int       `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
 }
 
 type Product struct {
@@ -16,12 +108,83 @@ type Product struct {
 	Name        string `db:"name"         json:"name"`
 	Value       int    `db:"value"        json:"value"`
 	Weight      int    `db:"weight"       json:"weight"`
+	Volume      int    `db:"volume"       json:"volume,omitempty"` // 商品に容積を持つ場合のみ
 	Image       string `db:"image"        json:"image"`
 	Description string `db:"description"  json:"description"`
+	Stock       *int   `db:"stock"        json:"stock,omitempty"` // nil なら在庫を管理しない
+}
+
+// 商品の価格の変更 (product_value_history の 1 行)
+type ProductValueChange struct {
+	ID        int64         `db:"id"         json:"id"`
+	ProductID int           `db:"product_id" json:"product_id"`
+	OldValue  int           `db:"old_value"  json:"old_value"`
+	NewValue  int           `db:"new_value"  json:"new_value"`
+	ChangedBy sql.NullInt64 `db:"changed_by" json:"-"`
+	ChangedAt time.Time     `db:"changed_at" json:"changed_at"`
+}
+
+type ProductValueHistoryResponse struct {
+	ProductID    int                  `json:"product_id"`
+	CurrentValue int                  `json:"current_value"`
+	History      []ProductValueChange `json:"history"` // 古い順
+}
+
+type UpdateProductRequest struct {
+	Value *int `json:"value"`
+	// false にすると一覧に出さず、新しい注文を受け付けない (注文済みの明細は配送する)
+	IsActive *bool `json:"is_active"`
+}
+
+// 商品一覧で fields に指定できるフィールドと、その値の取り出し方 (キーは products の列名と同じ)
+var ProductListFields = map[string]func(p *Product) any{
+	"product_id":  func(p *Product) any { return p.ProductID },
+	"name":        func(p *Product) any { return p.Name },
+	"value":       func(p *Product) any { return p.Value },
+	"weight":      func(p *Product) any { return p.Weight },
+	"image":       func(p *Product) any { return p.Image },
+	"description": func(p *Product) any { return p.Description },
+	"stock":       func(p *Product) any { return p.Stock },
+}
+
+// お気に入りの商品
+type FavoriteProduct struct {
+	Product
+	FavoritedAt time.Time `db:"favorited_at" json:"favorited_at"`
+}
+
+// 同じ注文で一緒に注文された商品の組と、その注文数
+type ProductCoOccurrence struct {
+	ProductID        int `db:"product_id"`
+	RelatedProductID int `db:"related_product_id"`
+	Orders           int `db:"orders"`
+}
+
+// よく一緒に注文される商品
+type ProductRecommendation struct {
+	Product
+	CoOrderCount int `json:"co_order_count"`
+}
+
+// 在庫不足で受け付けられなかった商品
+type StockRejection struct {
+	ProductID int `json:"product_id"`
+	Requested int `json:"requested"`
+	Available int `json:"available"`
+}
+
+// 注文で在庫数がしきい値を下回った商品
+type LowStockAlert struct {
+	ProductID int `json:"product_id"`
+	Stock     int `json:"stock"`
+	Threshold int `json:"threshold"`
 }
 
+// 注文明細 (order_items の 1 行、商品ごとの数量つき)
+// 配送計画では未配送の数量分に展開した 1 個ずつを同じ OrderID で表す (数量系のフィールドは 0)
 type Order struct {
 	OrderID       int64        `db:"order_id"        json:"order_id"`
+	OrderHeaderID int64        `db:"order_header_id" json:"order_header_id,omitempty"`
 	UserID        int          `db:"user_id"         json:"user_id"`
 	ProductID     int          `db:"product_id"      json:"product_id"`
 	ProductName   string       `db:"product_name"    json:"product_name"`
@@ -30,34 +193,477 @@ type Order struct {
 	Value         int          `db:"value"           json:"value"`
 	CreatedAt     time.Time    `db:"created_at"      json:"created_at"`
 	ArrivedAt     sql.NullTime `db:"arrived_at"      json:"arrived_at"`
+	Priority      int          `db:"priority"        json:"priority"`
+	Version       int64        `db:"version"         json:"version"`
+	Image         string       `db:"image"           json:"image,omitempty"` // 注文詳細でのみ設定する
+
+	// 数量と配送の進捗 (配送中 = Dispatched - Completed)
+	Quantity           int `db:"quantity"            json:"quantity,omitempty"`
+	DispatchedQuantity int `db:"dispatched_quantity" json:"dispatched_quantity,omitempty"`
+	CompletedQuantity  int `db:"completed_quantity"  json:"completed_quantity,omitempty"`
+
+	Metadata OrderMetadata `db:"metadata" json:"metadata,omitempty"`
+
+	// 配達希望期間 (nil なら指定なし)
+	DeliverAfter  *time.Time `db:"deliver_after"  json:"deliver_after,omitempty"`
+	DeliverBefore *time.Time `db:"deliver_before" json:"deliver_before,omitempty"`
+
+	// 1 個あたりの容積 (商品に容積を持つ場合のみ)
+	Volume int `db:"volume" json:"volume,omitempty"`
+
+	// 配送先の座標 (nil なら指定なし、注文明細に配送先を持つ場合のみ)
+	DestLatitude  *float64 `db:"dest_latitude"  json:"dest_latitude,omitempty"`
+	DestLongitude *float64 `db:"dest_longitude" json:"dest_longitude,omitempty"`
+
+	// 配送エリア (nil なら指定なし、注文明細にゾーンを持つ場合のみ)
+	Zone *string `db:"zone" json:"zone,omitempty"`
+
+	// 配送計画で見積もった到着予定日時 (注文一覧と詳細でのみ設定する)
+	EstimatedArrivalAt *time.Time `db:"estimated_arrival_at" json:"estimated_arrival_at,omitempty"`
+
+	// 返品 (注文詳細でのみ設定する)
+	ReturnedAt         *time.Time `db:"returned_at"          json:"returned_at,omitempty"`
+	ReturnReason       *string    `db:"return_reason"        json:"return_reason,omitempty"`
+	ReplacementOrderID *int64     `db:"replacement_order_id" json:"replacement_order_id,omitempty"`
+}
+
+// 配達希望期間の開始前か
+func (o *Order) BeforeDeliveryWindow(now time.Time) bool {
+	return o.DeliverAfter != nil && now.Before(*o.DeliverAfter)
+}
+
+// 注文明細ごとの任意のメタデータ (order_items.metadata に JSON で保存する、未指定なら NULL)
+type OrderMetadata map[string]any
+
+func (m *OrderMetadata) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("unsupported type for OrderMetadata: %T", src)
+	}
+	var metadata OrderMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return err
+	}
+	*m = metadata
+	return nil
+}
+
+func (m OrderMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// 注文履歴一覧で fields に指定できるフィールドと、その値の取り出し方
+var OrderListFields = map[string]func(o *Order) any{
+	"order_id":             func(o *Order) any { return o.OrderID },
+	"product_id":           func(o *Order) any { return o.ProductID },
+	"product_name":         func(o *Order) any { return o.ProductName },
+	"shipped_status":       func(o *Order) any { return o.ShippedStatus },
+	"quantity":             func(o *Order) any { return o.Quantity },
+	"dispatched_quantity":  func(o *Order) any { return o.DispatchedQuantity },
+	"completed_quantity":   func(o *Order) any { return o.CompletedQuantity },
+	"metadata":             func(o *Order) any { return o.Metadata },
+	"created_at":           func(o *Order) any { return o.CreatedAt },
+	"arrived_at":           func(o *Order) any { return o.ArrivedAt },
+	"deliver_after":        func(o *Order) any { return o.DeliverAfter },
+	"deliver_before":       func(o *Order) any { return o.DeliverBefore },
+	"estimated_arrival_at": func(o *Order) any { return o.EstimatedArrivalAt },
+}
+
+type OrderStats struct {
+	ByStatus []OrderStatusStat `json:"by_status"`
+	Daily    []DailyOrderCount `json:"daily"`
+}
+
+type OrderStatusStat struct {
+	ShippedStatus string `json:"shipped_status"`
+	Count         int    `json:"count"`
+	TotalValue    int    `json:"total_value"`
+}
+
+type DailyOrderCount struct {
+	Date  string `json:"date"` // YYYY-MM-DD
+	Count int    `json:"count"`
+}
+
+type Webhook struct {
+	ID        int64         `db:"id"         json:"id"`
+	UserID    sql.NullInt64 `db:"user_id"    json:"-"`
+	URL       string        `db:"url"        json:"url"`
+	Secret    string        `db:"secret"     json:"-"`
+	CreatedAt time.Time     `db:"created_at" json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID        int64  `db:"id"`
+	WebhookID int64  `db:"webhook_id"`
+	URL       string `db:"url"`
+	Secret    string `db:"secret"`
+	Payload   []byte `db:"payload"`
+	Attempts  int    `db:"attempts"`
 }
 
 type DeliveryPlan struct {
 	RobotID     string  `json:"robot_id"`
 	TotalWeight int     `json:"total_weight"`
+	TotalVolume int     `json:"total_volume,omitempty"`
 	TotalValue  int     `json:"total_value"`
 	Orders      []Order `json:"orders"`
+	// 最適とは限らない計画 (計算量か時間の上限に達して一部を貪欲法で選んだか、他の計画と重なった単位を外した)
+	Approximate bool `json:"approximate,omitempty"`
+	// 配送先を回る順番 (注文明細に配送先を持つ場合のみ)
+	Route []RouteStop `json:"route,omitempty"`
+	// リースを記録した場合の計画 ID と期限 (期限までに確認しないと注文は未配送に戻る)
+	PlanID         int64      `json:"plan_id,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// 計画を記録した場合の UUID (再要求するときに指定する)
+	PlanUUID string `json:"plan_uuid,omitempty"`
+	// 新しく作らずに記録した計画を返した
+	Replayed bool `json:"replayed,omitempty"`
+	// 注文を更新せずに作った計画 (/delivery-plan/preview)
+	Preview bool `json:"preview,omitempty"`
+}
+
+// 配送計画のジョブの状態
+const (
+	DeliveryPlanJobQueued  = "queued"
+	DeliveryPlanJobRunning = "running"
+	DeliveryPlanJobDone    = "done"
+	DeliveryPlanJobFailed  = "failed"
+)
+
+// 非同期で作る配送計画 (/delivery-plan/jobs)
+type DeliveryPlanJob struct {
+	JobID      string        `json:"job_id"`
+	RobotID    string        `json:"robot_id"`
+	Status     string        `json:"status"`
+	Plan       *DeliveryPlan `json:"plan,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	// 失敗した理由 (Error は handler が応答用に詰める)
+	Err error `json:"-"`
+}
+
+// 配送計画を作る条件 (/delivery-plan などのクエリパラメータ)
+type DeliveryPlanParams struct {
+	Capacity       int    // 0 なら登録された積載量
+	VolumeCapacity int    // 0 なら登録された容積 (登録もなければ容積を考えない)
+	PlanUUID       string // 応答を受け取れなかった計画を再要求する場合の UUID
+	Algorithm      string // 空なら設定のアルゴリズム
+	Zone           string // 空ならすべてのゾーン (ゾーンのない注文も含む)
+}
+
+// 配送計画のアルゴリズムを同じ注文で比べる (/api/admin/planner-benchmark)
+// 注文は更新せず、配送計画の集計 (planner-metrics) にも含めない
+type PlannerBenchmarkRequest struct {
+	// 空ならすべてのアルゴリズム
+	Algorithms []string `json:"algorithms"`
+	// "synthetic" (既定) なら Seed から作った注文、"snapshot" なら現在の配送中の注文 (Zone で絞れる)
+	Source string `json:"source"`
+	Zone   string `json:"zone"`
+	// synthetic の注文数と、重さ・価値・容積の上限 (1 から上限までの一様分布、容積の上限が 0 なら容積なし)
+	Orders    int   `json:"orders"`
+	MaxWeight int   `json:"max_weight"`
+	MaxValue  int   `json:"max_value"`
+	MaxVolume int   `json:"max_volume"`
+	Seed      int64 `json:"seed"`
+
+	Capacity       int `json:"capacity"`
+	VolumeCapacity int `json:"volume_capacity"`
+	// アルゴリズムごとに解く回数 (所要時間の最小・平均・最大を返す)
+	Runs int `json:"runs"`
+	// 1 回あたりの時間の上限 (ミリ秒、0 なら DeliveryPlanTimeBudget)
+	TimeBudgetMs int `json:"time_budget_ms"`
+}
+
+type PlannerBenchmarkResponse struct {
+	Source         string                   `json:"source"`
+	InputOrders    int                      `json:"input_orders"`
+	Capacity       int                      `json:"capacity"`
+	VolumeCapacity int                      `json:"volume_capacity,omitempty"`
+	Results        []PlannerBenchmarkResult `json:"results"`
+}
+
+type PlannerBenchmarkResult struct {
+	Algorithm    string `json:"algorithm"`
+	PickedOrders int    `json:"picked_orders"`
+	TotalWeight  int    `json:"total_weight"`
+	TotalVolume  int    `json:"total_volume,omitempty"`
+	TotalValue   int    `json:"total_value"`
+	Approximate  bool   `json:"approximate,omitempty"`
+	// 比べたアルゴリズムのうち最も大きい価値の合計に対する比
+	ValueRatio float64 `json:"value_ratio"`
+	MinMs      float64 `json:"min_ms"`
+	AvgMs      float64 `json:"avg_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+// 配送計画の一部だけを引き受ける (order_ids にない明細は未配送に戻す)
+type AcceptDeliveryPlanRequest struct {
+	OrderIDs []int64 `json:"order_ids"`
+}
+
+type AcceptDeliveryPlanResponse struct {
+	PlanID   int64   `json:"plan_id"`
+	Accepted []int64 `json:"accepted"`
+	Reverted []int64 `json:"reverted"`
+}
+
+// 配送計画 v2 (/api/robot/v2/delivery-plan)
+// 単位ごとではなく明細ごとにまとめ、商品名、配送先、取り扱いフラグを付ける
+type DeliveryPlanV2 struct {
+	RobotID        string               `json:"robot_id"`
+	TotalWeight    int                  `json:"total_weight"`
+	TotalVolume    int                  `json:"total_volume,omitempty"`
+	TotalValue     int                  `json:"total_value"`
+	Items          []DeliveryPlanItemV2 `json:"items"`
+	Approximate    bool                 `json:"approximate,omitempty"`
+	Route          []RouteStop          `json:"route,omitempty"`
+	PlanID         int64                `json:"plan_id,omitempty"`
+	LeaseExpiresAt *time.Time           `json:"lease_expires_at,omitempty"`
+	PlanUUID       string               `json:"plan_uuid,omitempty"`
+	Replayed       bool                 `json:"replayed,omitempty"`
+}
+
+// 取り扱いフラグのうちサーバーが付けるもの (ほかは注文明細の metadata の handling をそのまま返す)
+const HandlingUrgent = "urgent"
+
+type DeliveryPlanItemV2 struct {
+	OrderID       int64        `json:"order_id"`
+	Version       int64        `json:"version"`
+	ProductID     int          `json:"product_id"`
+	ProductName   string       `json:"product_name"`
+	Units         int          `json:"units"`
+	UnitWeight    int          `json:"unit_weight"`
+	UnitVolume    int          `json:"unit_volume,omitempty"`
+	UnitValue     int          `json:"unit_value"`
+	Priority      int          `json:"priority"`
+	DeliverAfter  *time.Time   `json:"deliver_after,omitempty"`
+	DeliverBefore *time.Time   `json:"deliver_before,omitempty"`
+	Destination   *Destination `json:"destination,omitempty"`
+	Handling      []string     `json:"handling"`
+}
+
+type Destination struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// 配送ルートで立ち寄る 1 か所 (同じ明細の単位はまとめて届ける)
+// 座標のない明細はルートの最後に計画の順で並べる
+type RouteStop struct {
+	OrderID   int64    `json:"order_id"`
+	Units     int      `json:"units"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
 }
 
 type LoginRequest struct {
-	UserName string `json:"user_name"`
-	Password string `json:"password"`
+	UserName   string `json:"user_name"`
+	Password   string `json:"password"`
+	RememberMe bool   `json:"remember_me"`
+
+	// 二要素認証が有効なユーザーはどちらかが必要
+	TOTPCode     string `json:"totp_code"`
+	RecoveryCode string `json:"recovery_code"`
+
+	// 監査ログ用にハンドラーで設定する
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type LoginEvent struct {
+	ID            int64          `db:"id"             json:"id"`
+	UserID        sql.NullInt64  `db:"user_id"        json:"-"`
+	UserName      string         `db:"user_name"      json:"user_name"`
+	IP            string         `db:"ip"             json:"ip"`
+	UserAgent     string         `db:"user_agent"     json:"user_agent"`
+	Success       bool           `db:"success"        json:"success"`
+	FailureReason sql.NullString `db:"failure_reason" json:"failure_reason"`
+	CreatedAt     time.Time      `db:"created_at"     json:"created_at"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type TOTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURL string `json:"otpauth_url"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
 }
 
 type CreateOrderRequest struct {
 	Items []RequestItem `json:"items"`
 }
 
+type IdempotencyRecord struct {
+	RequestHash string
+	OrderIDs    []string
+	Completed   bool
+}
+
 type RequestItem struct {
 	ProductID int `json:"product_id"`
 	Quantity  int `json:"quantity"`
+	Priority  int `json:"priority,omitempty"`
+
+	Metadata OrderMetadata `json:"metadata,omitempty"`
+
+	// 配達希望期間 (省略可)
+	DeliverAfter  *time.Time `json:"deliver_after,omitempty"`
+	DeliverBefore *time.Time `json:"deliver_before,omitempty"`
+
+	// 配送先の座標 (省略可、指定する場合は両方)
+	DestLatitude  *float64 `json:"dest_latitude,omitempty"`
+	DestLongitude *float64 `json:"dest_longitude,omitempty"`
+
+	// 配送エリア (省略したらユーザーのゾーン、空文字列ならゾーンなし)
+	Zone *string `json:"zone,omitempty"`
+}
+
+type ReturnOrderRequest struct {
+	Reason string `json:"reason"`
+	// 代わりの明細を作って再配達するか
+	Redeliver bool `json:"redeliver"`
+}
+
+type ReturnOrderResponse struct {
+	OrderID            int64  `json:"order_id"`
+	ReplacementOrderID *int64 `json:"replacement_order_id,omitempty"`
+}
+
+// 配送ロボットの状態
+const (
+	RobotStateIdle       = "idle"
+	RobotStateDelivering = "delivering"
+	RobotStateOffline    = "offline"
+)
+
+// 登録済みの配送ロボット
+type Robot struct {
+	RobotID   string    `db:"robot_id" json:"robot_id"`
+	Capacity  int       `db:"capacity" json:"capacity"`
+	State     string    `db:"state" json:"state"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	// 積める容積 (nil なら容積を考えない、商品に容積を持つ場合のみ)
+	VolumeCapacity *int `db:"volume_capacity" json:"volume_capacity,omitempty"`
+}
+
+// 配送ロボットを登録 (登録済みなら積載量と状態を更新) する
+// State を省略した場合は idle (更新時は今の状態のまま)
+type RegisterRobotRequest struct {
+	Capacity       int    `json:"capacity"`
+	VolumeCapacity *int   `json:"volume_capacity,omitempty"`
+	State          string `json:"state,omitempty"`
+}
+
+type UpdateRobotStateRequest struct {
+	State string `json:"state"`
+}
+
+// 配送計画の WebSocket (/api/robot/ws) でやりとりするメッセージ
+const (
+	RobotSocketReady = "ready" // ロボット → サーバー: 次の計画を受け取れる
+	RobotSocketPlan  = "plan"  // サーバー → ロボット: 配送中にした計画
+	RobotSocketError = "error" // サーバー → ロボット: 計画を作れなかった (接続は閉じる)
+)
+
+type RobotSocketMessage struct {
+	Type  string        `json:"type"`
+	Plan  *DeliveryPlan `json:"plan,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+// 配送ロボットのハートビート (バッテリー残量、現在地、積んでいる重さ)
+type RobotHeartbeatRequest struct {
+	BatteryPercent int     `json:"battery_percent"`
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	LoadWeight     int     `json:"load_weight"`
+}
+
+// 配送ロボットが最後に報告した状態
+// Offline は最後の報告から RobotHeartbeatTimeout 以上経っているか
+type RobotTelemetry struct {
+	RobotID        string    `db:"robot_id" json:"robot_id"`
+	BatteryPercent int       `db:"battery_percent" json:"battery_percent"`
+	Latitude       float64   `db:"latitude" json:"latitude"`
+	Longitude      float64   `db:"longitude" json:"longitude"`
+	LoadWeight     int       `db:"load_weight" json:"load_weight"`
+	ReportedAt     time.Time `db:"reported_at" json:"reported_at"`
+	Offline        bool      `db:"-" json:"offline"`
 }
 
 type UpdateOrderStatusRequest struct {
 	OrderID   int64  `json:"order_id"`
 	NewStatus string `json:"new_status"`
+	Version   *int64 `json:"version,omitempty"` // 指定した場合はこのバージョンのときだけ更新する
+	Reason    string `json:"reason,omitempty"`  // new_status が failed の場合の理由 (DeliveryFailure*)
+}
+
+// ロボットが報告する配送の失敗の理由
+const (
+	DeliveryFailureRecipientAbsent = "recipient_absent"
+	DeliveryFailureAddressNotFound = "address_not_found"
+	DeliveryFailureAccessDenied    = "access_denied"
+	DeliveryFailureDamaged         = "damaged"
+	DeliveryFailureOther           = "other"
+)
+
+// 配送の失敗の記録 (delivery_failures)
+type DeliveryFailure struct {
+	FailureID int64     `db:"failure_id"    json:"failure_id"`
+	OrderID   int64     `db:"order_id"      json:"order_id"`
+	RobotID   string    `db:"robot_id"      json:"robot_id"`
+	Reason    string    `db:"reason"        json:"reason"`
+	Requeued  bool      `db:"requeued"      json:"requeued"`
+	CreatedAt time.Time `db:"created_at"    json:"created_at"`
+}
+
+// 配送の失敗を報告した結果 (未配送に戻したか、手動での確認が必要になったか)
+type DeliveryFailureResult struct {
+	OrderID        int64 `json:"order_id"`
+	Attempts       int   `json:"attempts"`
+	Requeued       bool  `json:"requeued"`
+	ReviewRequired bool  `json:"review_required"`
 }
 
+// ステータス更新の対象と、読み取り時点のバージョン
+// Quantity 個を遷移元のステータスから newStatus に進める
+type OrderVersion struct {
+	OrderID  int64 `db:"order_id"`
+	Version  int64 `db:"version"`
+	Quantity int   `db:"quantity"`
+}
+
+type UpdateOrderStatusesRequest struct {
+	OrderIDs  []int64 `json:"order_ids"`
+	NewStatus string  `json:"new_status"`
+}
+
+// 商品一覧を検索の関連度順に並べるときの sort_field (商品一覧の FULLTEXT 検索が有効な場合のみ)
+const ProductSortRelevance = "relevance"
+
 type ListRequest struct {
 	Search    string `json:"search"`
 	Type      string `json:"type"`
@@ -66,4 +672,67 @@ type ListRequest struct {
 	SortField string `json:"sort_field"`
 	SortOrder string `json:"sort_order"`
 	Offset    int    `json:"-"`
+
+	// 注文履歴の絞り込み (空なら絞り込まない)
+	Statuses    []string   `json:"statuses"`
+	CreatedFrom *time.Time `json:"created_from"`
+	CreatedTo   *time.Time `json:"created_to"`
+
+	// 一覧で返すフィールド (OrderListFields / ProductListFields のキー、空ならすべて)
+	Fields []string `json:"fields"`
+
+	// 商品一覧をお気に入りの商品だけに絞る
+	FavoritesOnly bool `json:"favorites_only"`
+	// 商品一覧の検索で、綴りが少し違っても (n-gram が十分に重なれば) 一致とみなす
+	// FULLTEXT を使う場合 (PRODUCT_SEARCH_FULLTEXT) のみ有効
+	Fuzzy bool `json:"fuzzy"`
+	// 商品一覧を価格・重さの範囲で絞る (両端を含む、nil なら絞り込まない)
+	MinValue  *int `json:"min_value"`
+	MaxValue  *int `json:"max_value"`
+	MinWeight *int `json:"min_weight"`
+	MaxWeight *int `json:"max_weight"`
+
+	// 件数を ApproximateTotalLimit 件までで打ち切って数える (超えた場合は ApproximateTotalLimit + 1 を返す)
+	ApproximateTotal bool `json:"approximate_total"`
+
+	// キーセットページング (sort_field が order_id のときのみ)
+	// cursor はレスポンスの next_cursor / prev_cursor をそのまま渡す
+	Cursor   string `json:"cursor"`
+	AfterID  int64  `json:"after_id"`
+	BeforeID int64  `json:"before_id"`
+}
+
+// approximate_total を指定した場合に件数を数える上限
+const ApproximateTotalLimit = 10000
+
+type APIToken struct {
+	TokenID   int64        `db:"token_id"   json:"token_id"`
+	TokenHash string       `db:"token_hash" json:"-"`
+	UserID    int          `db:"user_id"    json:"user_id"`
+	Name      string       `db:"name"       json:"name"`
+	Scopes    string       `db:"scopes"     json:"scopes"`
+	CreatedAt time.Time    `db:"created_at" json:"created_at"`
+	ExpiresAt sql.NullTime `db:"expires_at" json:"expires_at"`
+	RevokedAt sql.NullTime `db:"revoked_at" json:"revoked_at"`
+}
+
+type IssueAPITokenRequest struct {
+	UserID    int      `json:"user_id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"` // time.ParseDuration 形式、空なら無期限
+}
+
+// ロボットごとの API キー (生キーは発行時にのみ返す)
+type RobotAPIKey struct {
+	KeyID     int64        `db:"key_id"     json:"key_id"`
+	KeyHash   string       `db:"key_hash"   json:"-"`
+	RobotID   string       `db:"robot_id"   json:"robot_id"`
+	CreatedAt time.Time    `db:"created_at" json:"created_at"`
+	RevokedAt sql.NullTime `db:"revoked_at" json:"revoked_at"`
+}
+
+type IssueRobotAPIKeyRequest struct {
+	// 指定するとロボットの既存のキーをこの時間の後に失効させる (time.ParseDuration 形式、"0s" なら即時)
+	RotateGrace string `json:"rotate_grace"`
 }
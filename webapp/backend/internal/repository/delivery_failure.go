@@ -0,0 +1,30 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"backend/internal/model"
+)
+
+// ロボットが報告した配送の失敗 (35_delivery_failures.sql)
+type DeliveryFailureRepository struct {
+	db DBTX
+}
+
+func NewDeliveryFailureRepository(db DBTX) *DeliveryFailureRepository {
+	return &DeliveryFailureRepository{db: db}
+}
+
+func (r *DeliveryFailureRepository) Create(ctx context.Context, failure *model.DeliveryFailure) (err error) {
+	defer observeRepoCall("DeliveryFailureRepository.Create", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO delivery_failures (order_item_id, robot_id, reason, requeued, created_at) VALUES (?, ?, ?, ?, ?)",
+		failure.OrderID, failure.RobotID, failure.Reason, failure.Requeued, failure.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	failure.FailureID, err = result.LastInsertId()
+	return err
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"backend/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 配送計画のリース (26_delivery_plans.sql)
+// ロボットが期限までに確認しなかった計画は、ReleaseExpired の対象になる
+type DeliveryPlanRepository struct {
+	db DBTX
+}
+
+func NewDeliveryPlanRepository(db DBTX) *DeliveryPlanRepository {
+	return &DeliveryPlanRepository{db: db}
+}
+
+// 計画と、計画で配送中にした明細ごとの個数を記録し、計画 ID を返す
+func (r *DeliveryPlanRepository) Create(ctx context.Context, robotID string, leaseExpiresAt time.Time, items []model.OrderVersion) (_ int64, err error) {
+	defer observeRepoCall("DeliveryPlanRepository.Create", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO delivery_plans (robot_id, created_at, lease_expires_at) VALUES (?, NOW(), ?)",
+		robotID, leaseExpiresAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	planID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	// 計画は 1 個ずつなので明細ごとにまとめる (同じ計画の明細はバージョンも同じ)
+	merged := mergeOrderTargets(items)
+	if len(merged) == 0 {
+		return planID, nil
+	}
+	var b strings.Builder
+	args := make([]any, 0, 3*len(merged))
+	b.WriteString("INSERT INTO delivery_plan_items (plan_id, order_item_id, quantity) VALUES ")
+	for i, item := range merged {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(?, ?, ?)")
+		args = append(args, planID, item.OrderID, item.Quantity)
+	}
+	if _, err := r.db.ExecContext(ctx, b.String(), args...); err != nil {
+		return 0, err
+	}
+	return planID, nil
+}
+
+// 計画に UUID と、作った計画そのもの (JSON) を記録する (31_delivery_plans_idempotency.sql)
+func (r *DeliveryPlanRepository) SavePayload(ctx context.Context, planID int64, planUUID string, payload []byte) (err error) {
+	defer observeRepoCall("DeliveryPlanRepository.SavePayload", time.Now(), &err)
+	_, err = r.db.ExecContext(ctx, "UPDATE delivery_plans SET plan_uuid = ?, payload = ? WHERE plan_id = ?", planUUID, payload, planID)
+	return err
+}
+
+// 計画 ID で記録した計画を返す (記録していなければ nil)
+func (r *DeliveryPlanRepository) GetPayload(ctx context.Context, planID int64) (_ []byte, err error) {
+	defer observeRepoCall("DeliveryPlanRepository.GetPayload", time.Now(), &err)
+	var payload []byte
+	if err := r.db.GetContext(ctx, &payload, "SELECT payload FROM delivery_plans WHERE plan_id = ?", planID); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// 記録した計画を返す (31_delivery_plans_idempotency.sql)
+// planUUID を指定すればその計画 (確認済みでもよい)、空ならまだ確認しておらずリースも切れていない最新の計画
+// 解放済みの計画と他のロボットの計画は返さず、なければ sql.ErrNoRows を返す
+func (r *DeliveryPlanRepository) FindPayload(ctx context.Context, robotID, planUUID string) (_ []byte, err error) {
+	defer observeRepoCall("DeliveryPlanRepository.FindPayload", time.Now(), &err)
+	var payload []byte
+	if planUUID != "" {
+		err = r.db.GetContext(ctx, &payload, `
+			SELECT payload FROM delivery_plans
+			WHERE plan_uuid = ? AND robot_id = ? AND released_at IS NULL AND payload IS NOT NULL`,
+			planUUID, robotID,
+		)
+	} else {
+		err = r.db.GetContext(ctx, &payload, `
+			SELECT payload FROM delivery_plans
+			WHERE robot_id = ? AND acknowledged_at IS NULL AND released_at IS NULL AND lease_expires_at > NOW() AND payload IS NOT NULL
+			ORDER BY plan_id DESC
+			LIMIT 1`,
+			robotID,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// ロボットが計画を引き受けたことを記録する (確認済みの計画は ReleaseExpired の対象にならない)
+// 計画がない、他のロボットのもの、またはリースが切れていれば sql.ErrNoRows を返す
+// 確認済みの計画をもう一度確認しても成功する
+func (r *DeliveryPlanRepository) Acknowledge(ctx context.Context, planID int64, robotID string) (err error) {
+	defer observeRepoCall("DeliveryPlanRepository.Acknowledge", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE delivery_plans SET acknowledged_at = NOW()
+		WHERE plan_id = ? AND robot_id = ? AND acknowledged_at IS NULL AND released_at IS NULL AND lease_expires_at > NOW()`,
+		planID, robotID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	var acknowledged bool
+	if err := r.db.GetContext(ctx, &acknowledged,
+		"SELECT EXISTS(SELECT 1 FROM delivery_plans WHERE plan_id = ? AND robot_id = ? AND acknowledged_at IS NOT NULL)",
+		planID, robotID,
+	); err != nil {
+		return err
+	}
+	if !acknowledged {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// まだ確認しておらずリースも切れていない計画を行ロックする (トランザクション内で呼ぶこと)
+// 計画がない、他のロボットのもの、確認済み、解放済み、またはリースが切れていれば sql.ErrNoRows を返す
+func (r *DeliveryPlanRepository) LockOpen(ctx context.Context, planID int64, robotID string) (err error) {
+	defer observeRepoCall("DeliveryPlanRepository.LockOpen", time.Now(), &err)
+	var id int64
+	return r.db.GetContext(ctx, &id, `
+		SELECT plan_id FROM delivery_plans
+		WHERE plan_id = ? AND robot_id = ? AND acknowledged_at IS NULL AND released_at IS NULL AND lease_expires_at > NOW()
+		FOR UPDATE`,
+		planID, robotID,
+	)
+}
+
+// 計画から明細を外す (ロボットが引き受けなかった明細)
+func (r *DeliveryPlanRepository) RemoveItems(ctx context.Context, planID int64, orderIDs []int64) (err error) {
+	defer observeRepoCall("DeliveryPlanRepository.RemoveItems", time.Now(), &err)
+	if len(orderIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("DELETE FROM delivery_plan_items WHERE plan_id = ? AND order_item_id IN (?)", planID, orderIDs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+	return err
+}
+
+// before までにリースが切れ、確認されていない計画を limit 件まで行ロック付きで取得する (トランザクション内で呼ぶこと)
+func (r *DeliveryPlanRepository) LockExpired(ctx context.Context, before time.Time, limit int) (_ []int64, err error) {
+	defer observeRepoCall("DeliveryPlanRepository.LockExpired", time.Now(), &err)
+	var planIDs []int64
+	err = r.db.SelectContext(ctx, &planIDs, `
+		SELECT plan_id FROM delivery_plans
+		WHERE acknowledged_at IS NULL AND released_at IS NULL AND lease_expires_at <= ?
+		ORDER BY lease_expires_at, plan_id
+		LIMIT ?
+		FOR UPDATE`,
+		before, limit,
+	)
+	return planIDs, err
+}
+
+// 計画で配送中にした明細ごとの個数の合計 (Version は使わない)
+func (r *DeliveryPlanRepository) GetItems(ctx context.Context, planIDs []int64) (_ []model.OrderVersion, err error) {
+	defer observeRepoCall("DeliveryPlanRepository.GetItems", time.Now(), &err)
+	if len(planIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT order_item_id AS order_id, SUM(quantity) AS quantity
+		FROM delivery_plan_items
+		WHERE plan_id IN (?)
+		GROUP BY order_item_id
+		ORDER BY order_item_id`, planIDs)
+	if err != nil {
+		return nil, err
+	}
+	var items []model.OrderVersion
+	if err := r.db.SelectContext(ctx, &items, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// 注文を未配送に戻した計画を解放済みにする
+func (r *DeliveryPlanRepository) MarkReleased(ctx context.Context, planIDs []int64) (err error) {
+	defer observeRepoCall("DeliveryPlanRepository.MarkReleased", time.Now(), &err)
+	if len(planIDs) == 0 {
+		return nil
+	}
+	query, args, err := sqlx.In("UPDATE delivery_plans SET released_at = NOW() WHERE plan_id IN (?)", planIDs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+	return err
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestDeliveryPlanCreateMergesUnits(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(string, []any) int64 { return 1 })}
+	repo := NewDeliveryPlanRepository(db)
+	// 同じ明細から 2 個と、別の明細から 1 個
+	items := []model.OrderVersion{{OrderID: 7, Version: 2, Quantity: 1}, {OrderID: 8, Version: 1, Quantity: 1}, {OrderID: 7, Version: 2, Quantity: 1}}
+	if _, err := repo.Create(context.Background(), "robot", time.Now().Add(time.Minute), items); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if len(db.calls) != 2 {
+		t.Fatalf("exec calls = %d, want plan and items", len(db.calls))
+	}
+	if want := []any{int64(0), int64(7), 2, int64(0), int64(8), 1}; !reflect.DeepEqual(db.calls[1].args, want) {
+		t.Fatalf("item args = %v, want %v", db.calls[1].args, want)
+	}
+}
+
+func TestDeliveryPlanAcknowledgeExpired(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(string, []any) int64 { return 0 })}
+	if err := NewDeliveryPlanRepository(db).Acknowledge(context.Background(), 1, "robot"); err == nil {
+		t.Fatal("Acknowledge of an expired plan must fail")
+	}
+}
+
+func TestRevertDispatchedQuery(t *testing.T) {
+	query, args := revertDispatchedQuery([]model.OrderVersion{{OrderID: 3, Quantity: 2}})
+	if !strings.Contains(query, "o.completed_quantity < o.dispatched_quantity") ||
+		!strings.Contains(query, "o.dispatched_quantity = o.dispatched_quantity - LEAST(v.quantity, o.dispatched_quantity - o.completed_quantity)") {
+		t.Fatalf("query = %q, want revert bounded by delivering units", query)
+	}
+	if want := []any{int64(3), int64(0), 2}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("args = %v, want %v", args, want)
+	}
+}
+
+func TestRevertDispatchedInvalidatesShippingOrders(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(string, []any) int64 { return 1 })}
+	repo := newTestOrderRepository(db)
+	repo.state.shippingOrdersCache = []model.Order{{OrderID: 1}}
+	n, err := repo.RevertDispatched(context.Background(), []model.OrderVersion{{OrderID: 1, Quantity: 1}})
+	if err != nil || n != 1 {
+		t.Fatalf("RevertDispatched = %d, %v", n, err)
+	}
+	if repo.state.shippingOrdersCache != nil || repo.state.shippingOrdersVersion != 1 {
+		t.Fatal("shipping orders cache must be invalidated")
+	}
+}
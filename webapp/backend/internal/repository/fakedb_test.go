@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// テスト用の DBTX
+// 設定した関数だけを呼び、設定していないメソッドは何もせずに成功する
+type fakeDB struct {
+	get  func(ctx context.Context, dest any, query string, args ...any) error
+	sel  func(ctx context.Context, dest any, query string, args ...any) error
+	exec func(ctx context.Context, query string, args ...any) (sql.Result, error)
+	// *sqlx.Rows は作れないので、未設定の場合はエラーを返す
+	queryx func(ctx context.Context, query string, args ...any) (*sqlx.Rows, error)
+
+	// 実行した ExecContext (exec の設定に関わらず記録する)
+	mu    sync.Mutex
+	calls []execCall
+}
+
+type execCall struct {
+	query string
+	args  []any
+}
+
+// query と args から更新件数を決める exec
+func affectedRows(f func(query string, args []any) int64) func(context.Context, string, ...any) (sql.Result, error) {
+	return func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		return fakeResult{rowsAffected: f(query, args)}, nil
+	}
+}
+
+func (db *fakeDB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	if db.get == nil {
+		return nil
+	}
+	return db.get(ctx, dest, query, args...)
+}
+
+func (db *fakeDB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	if db.sel == nil {
+		return nil
+	}
+	return db.sel(ctx, dest, query, args...)
+}
+
+func (db *fakeDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	db.mu.Lock()
+	db.calls = append(db.calls, execCall{query: query, args: args})
+	db.mu.Unlock()
+	if db.exec == nil {
+		return driver.RowsAffected(0), nil
+	}
+	return db.exec(ctx, query, args...)
+}
+
+func (db *fakeDB) QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error) {
+	if db.queryx == nil {
+		return nil, errors.New("fakeDB: QueryxContext is not configured")
+	}
+	return db.queryx(ctx, query, args...)
+}
+
+// プリペアドステートメントは *sqlx.DB / *sqlx.Tx でしか使わないので呼ばれない
+func (db *fakeDB) PreparexContext(context.Context, string) (*sqlx.Stmt, error) {
+	return nil, errors.New("fakeDB: PreparexContext is not supported")
+}
+
+func (db *fakeDB) Rebind(query string) string { return query }
+
+// ExecContext の結果
+type fakeResult struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rowsAffected, nil }
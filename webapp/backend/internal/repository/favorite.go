@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"backend/internal/model"
+)
+
+type FavoriteRepository struct {
+	db DBTX
+}
+
+func NewFavoriteRepository(db DBTX) *FavoriteRepository {
+	return &FavoriteRepository{db: db}
+}
+
+// お気に入りに追加する (追加済みなら何もしない)
+// 商品が存在しなければ sql.ErrNoRows を返す
+func (r *FavoriteRepository) Add(ctx context.Context, userID, productID int) (err error) {
+	defer observeRepoCall("FavoriteRepository.Add", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx, `
+		INSERT IGNORE INTO favorites (user_id, product_id, created_at)
+		SELECT ?, product_id, NOW() FROM products WHERE product_id = ?`,
+		userID, productID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// 追加済みか、商品が存在しないか
+	var exists bool
+	if err := r.db.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM products WHERE product_id = ?)", productID); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// お気に入りから外す (登録されていなければ何もしない)
+func (r *FavoriteRepository) Remove(ctx context.Context, userID, productID int) (err error) {
+	defer observeRepoCall("FavoriteRepository.Remove", time.Now(), &err)
+	_, err = r.db.ExecContext(ctx, "DELETE FROM favorites WHERE user_id = ? AND product_id = ?", userID, productID)
+	return err
+}
+
+// お気に入りの商品を追加した新しい順に取得
+func (r *FavoriteRepository) List(ctx context.Context, userID, limit, offset int) (_ []model.FavoriteProduct, _ int, err error) {
+	defer observeRepoCall("FavoriteRepository.List", time.Now(), &err)
+	var total int
+	if err := r.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM favorites WHERE user_id = ?", userID); err != nil {
+		return nil, 0, err
+	}
+	favorites := []model.FavoriteProduct{}
+	if total == 0 {
+		return favorites, 0, nil
+	}
+
+	query := `
+		SELECT p.product_id, p.name, p.value, p.weight, p.image, p.description, p.stock, f.created_at AS favorited_at
+		FROM favorites f
+		JOIN products p ON p.product_id = f.product_id
+		WHERE f.user_id = ?
+		ORDER BY f.created_at DESC, f.product_id DESC
+		LIMIT ? OFFSET ?`
+	if err := r.db.SelectContext(ctx, &favorites, query, userID, limit, offset); err != nil {
+		return nil, 0, err
+	}
+	return favorites, total, nil
+}
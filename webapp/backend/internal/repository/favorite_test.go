@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestFavoriteAdd(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		exists   bool
+		wantErr  error
+	}{
+		{"added", 1, true, nil},
+		{"already added", 0, true, nil},
+		{"product not found", 0, false, sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 商品の存在確認に exists を返す
+			db := &fakeDB{
+				get: func(_ context.Context, dest any, _ string, _ ...any) error {
+					*dest.(*bool) = tt.exists
+					return nil
+				},
+				exec: affectedRows(func(string, []any) int64 { return tt.affected }),
+			}
+			if err := NewFavoriteRepository(db).Add(context.Background(), 1, 7); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
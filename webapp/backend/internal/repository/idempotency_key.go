@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/internal/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/goccy/go-json"
+)
+
+const mysqlErrDuplicateEntry = 1062
+
+type IdempotencyKeyRepository struct {
+	db DBTX
+}
+
+func NewIdempotencyKeyRepository(db DBTX) *IdempotencyKeyRepository {
+	return &IdempotencyKeyRepository{db: db}
+}
+
+// キーを確保する
+// 既に同じキーが使われていれば false を返す
+// トランザクション内で呼ぶと、同じキーの並行リクエストは先行のトランザクションが終わるまで待たされる
+func (r *IdempotencyKeyRepository) Claim(ctx context.Context, userID int, key, requestHash string) (bool, error) {
+	query := "INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at) VALUES (?, ?, ?, ?)"
+	_, err := r.db.ExecContext(ctx, query, userID, key, requestHash, time.Now())
+	if err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// 確保したキーに処理結果を保存する
+func (r *IdempotencyKeyRepository) Complete(ctx context.Context, userID int, key string, orderIDs []string) error {
+	encoded, err := json.Marshal(orderIDs)
+	if err != nil {
+		return err
+	}
+	query := "UPDATE idempotency_keys SET order_ids = ? WHERE user_id = ? AND idempotency_key = ?"
+	_, err = r.db.ExecContext(ctx, query, string(encoded), userID, key)
+	return err
+}
+
+func (r *IdempotencyKeyRepository) Find(ctx context.Context, userID int, key string) (*model.IdempotencyRecord, error) {
+	var row struct {
+		RequestHash string `db:"request_hash"`
+		OrderIDs    []byte `db:"order_ids"`
+	}
+	query := "SELECT request_hash, order_ids FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?"
+	if err := r.db.GetContext(ctx, &row, query, userID, key); err != nil {
+		return nil, err
+	}
+
+	record := &model.IdempotencyRecord{RequestHash: row.RequestHash}
+	if row.OrderIDs != nil {
+		if err := json.Unmarshal(row.OrderIDs, &record.OrderIDs); err != nil {
+			return nil, err
+		}
+		record.Completed = true
+	}
+	return record, nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImageStore は商品画像の保存先
+// name は products.image に入れる相対パスで、GetImage (nginx の /_protected/images) から配信される
+type ImageStore interface {
+	// 画像がなければ os.ErrNotExist を返す
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Save(ctx context.Context, name string, r io.Reader) error
+	Delete(ctx context.Context, name string) error
+}
+
+// ローカルディレクトリによる実装 (nginx の /_protected/images と同じディレクトリを指定する)
+type localImageStore struct {
+	dir string
+}
+
+func NewLocalImageStore(dir string) (ImageStore, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("image store path is not a directory: %s", dir)
+	}
+	return &localImageStore{dir: dir}, nil
+}
+
+func (s *localImageStore) path(name string) (string, error) {
+	name = filepath.Clean(name)
+	if filepath.IsAbs(name) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("invalid image name: %s", name)
+	}
+	return filepath.Join(s.dir, name), nil
+}
+
+func (s *localImageStore) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// 書き込み途中のファイルを配信しないよう、一時ファイルに書いてから rename する
+func (s *localImageStore) Save(_ context.Context, name string, r io.Reader) error {
+	dst, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// nginx のワーカーから読めるようにする (CreateTemp は 0600 で作る)
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+func (s *localImageStore) Delete(_ context.Context, name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
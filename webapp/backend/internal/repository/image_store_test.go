@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalImageStoreSaveAndDelete(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalImageStore(dir)
+	if err != nil {
+		t.Fatalf("NewLocalImageStore: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Save(ctx, "products/1/a.png", strings.NewReader("png")); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "products/1/a.png"))
+	if err != nil || string(got) != "png" {
+		t.Fatalf("saved file = %q, %v", got, err)
+	}
+	r, err := store.Open(ctx, "products/1/a.png")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	r.Close()
+	entries, _ := os.ReadDir(filepath.Join(dir, "products/1"))
+	if len(entries) != 1 {
+		t.Fatalf("entries = %d, want no temporary files left", len(entries))
+	}
+
+	if err := store.Delete(ctx, "products/1/a.png"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "products/1/a.png")); !os.IsNotExist(err) {
+		t.Fatalf("file still exists: %v", err)
+	}
+	if err := store.Delete(ctx, "products/1/a.png"); err != nil {
+		t.Fatalf("Delete of a missing file: %v", err)
+	}
+	if _, err := store.Open(ctx, "products/1/a.png"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Open of a missing file: err = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLocalImageStoreRejectsEscapingNames(t *testing.T) {
+	store, err := NewLocalImageStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalImageStore: %v", err)
+	}
+	for _, name := range []string{"../a.png", "/etc/a.png", "products/../../a.png"} {
+		if err := store.Save(context.Background(), name, strings.NewReader("x")); err == nil {
+			t.Errorf("Save(%q) must fail", name)
+		}
+	}
+}
+
+func TestNewLocalImageStoreRequiresDirectory(t *testing.T) {
+	if _, err := NewLocalImageStore(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("missing directory must be rejected")
+	}
+}
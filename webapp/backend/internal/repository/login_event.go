@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+
+	"backend/internal/model"
+)
+
+type LoginEventRepository struct {
+	db DBTX
+}
+
+func NewLoginEventRepository(db DBTX) *LoginEventRepository {
+	return &LoginEventRepository{db: db}
+}
+
+func (r *LoginEventRepository) Create(ctx context.Context, event *model.LoginEvent) error {
+	query := `
+		INSERT INTO login_events (user_id, user_name, ip, user_agent, success, failure_reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := r.db.ExecContext(ctx, query,
+		event.UserID, event.UserName, event.IP, event.UserAgent, event.Success, event.FailureReason, event.CreatedAt,
+	)
+	return err
+}
+
+// ユーザーのログイン履歴を新しい順に取得
+func (r *LoginEventRepository) ListByUserID(ctx context.Context, userID int, limit, offset int) ([]model.LoginEvent, int, error) {
+	var total int
+	if err := r.db.GetContext(ctx, &total, "SELECT COUNT(*) FROM login_events WHERE user_id = ?", userID); err != nil {
+		return nil, 0, err
+	}
+	events := []model.LoginEvent{}
+	if total == 0 {
+		return events, 0, nil
+	}
+
+	query := `
+		SELECT id, user_id, user_name, ip, user_agent, success, failure_reason, created_at
+		FROM login_events
+		WHERE user_id = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?`
+	if err := r.db.SelectContext(ctx, &events, query, userID, limit, offset); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// リポジトリのメソッドごとの所要時間ヒストグラムの上限 (ミリ秒、最後のバケットは上限なし)
+var repoLatencyBucketsMs = []int64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000}
+
+// メソッドごとの呼び出し回数・エラー数・所要時間
+type RepoMethodStats struct {
+	Method  string  `json:"method"`
+	Calls   int64   `json:"calls"`
+	Errors  int64   `json:"errors"`
+	TotalMs float64 `json:"total_ms"`
+	// Buckets[i] は所要時間が BucketsMs[i] ミリ秒以下だった回数 (累積ではない)
+	// 最後の要素は最大のバケットを超えた回数
+	BucketsMs []int64 `json:"buckets_ms"`
+	Buckets   []int64 `json:"buckets"`
+}
+
+type repoMethodMetrics struct {
+	calls, errors, totalNanos atomic.Int64
+	buckets                   []atomic.Int64
+}
+
+var repoMetrics sync.Map // method -> *repoMethodMetrics
+
+// defer observeRepoCall("OrderRepository.ListOrders", time.Now(), &err) のように使う
+func observeRepoCall(method string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	m, ok := repoMetrics.Load(method)
+	if !ok {
+		m, _ = repoMetrics.LoadOrStore(method, &repoMethodMetrics{buckets: make([]atomic.Int64, len(repoLatencyBucketsMs)+1)})
+	}
+	metrics := m.(*repoMethodMetrics)
+	metrics.calls.Add(1)
+	if err != nil && *err != nil {
+		metrics.errors.Add(1)
+	}
+	metrics.totalNanos.Add(int64(elapsed))
+	ms := elapsed.Milliseconds()
+	i, _ := slices.BinarySearch(repoLatencyBucketsMs, ms)
+	metrics.buckets[i].Add(1)
+}
+
+// メソッドごとの集計を合計所要時間の長い順に返す
+func RepoMetricsSnapshot() []RepoMethodStats {
+	var stats []RepoMethodStats
+	repoMetrics.Range(func(key, value any) bool {
+		m := value.(*repoMethodMetrics)
+		s := RepoMethodStats{
+			Method:    key.(string),
+			Calls:     m.calls.Load(),
+			Errors:    m.errors.Load(),
+			TotalMs:   float64(m.totalNanos.Load()) / float64(time.Millisecond),
+			BucketsMs: repoLatencyBucketsMs,
+			Buckets:   make([]int64, len(m.buckets)),
+		}
+		for i := range m.buckets {
+			s.Buckets[i] = m.buckets[i].Load()
+		}
+		stats = append(stats, s)
+		return true
+	})
+	slices.SortFunc(stats, func(a, b RepoMethodStats) int {
+		switch {
+		case a.TotalMs > b.TotalMs:
+			return -1
+		case a.TotalMs < b.TotalMs:
+			return 1
+		}
+		return 0
+	})
+	return stats
+}
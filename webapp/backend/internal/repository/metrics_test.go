@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestObserveRepoCall(t *testing.T) {
+	const method = "TestRepository.Method"
+	ok, failed := error(nil), errors.New("failed")
+	observeRepoCall(method, time.Now(), &ok)
+	observeRepoCall(method, time.Now().Add(-3*time.Millisecond), &failed)
+	observeRepoCall(method, time.Now().Add(-2*time.Second), &ok)
+
+	for _, s := range RepoMetricsSnapshot() {
+		if s.Method != method {
+			continue
+		}
+		if s.Calls != 3 || s.Errors != 1 {
+			t.Fatalf("calls = %d, errors = %d; want 3 and 1", s.Calls, s.Errors)
+		}
+		// 0ms は 1ms 以下、3ms は 5ms 以下、2s は上限超え
+		want := map[int]int64{0: 1, 2: 1, len(s.BucketsMs): 1}
+		for i, n := range s.Buckets {
+			if n != want[i] {
+				t.Fatalf("buckets = %v", s.Buckets)
+			}
+		}
+		return
+	}
+	t.Fatal("method not recorded")
+}
@@ -4,12 +4,21 @@ import (
 	"backend/internal/model"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/samber/lo"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/jmoiron/sqlx"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
@@ -17,35 +26,157 @@ const (
 	shippedStatusEnumShipping   = 2
 	shippedStatusEnumDelivering = 1
 	shippedStatusEnumCompleted  = 0
+	shippedStatusEnumReturned   = 3
 )
 
+var OrderSearchCountCacheSize = 1024
+
+var OrderStatsCacheSize = 1024
+
+// order_items_archive もあわせて参照するか (注文のアーカイブを有効にしている場合のみ)
+var OrderArchiveEnabled = false
+
+// 注文明細に配送先の座標を持つか
+// 29_order_items_destination.sql を適用している場合のみ有効にする
+var OrderDestinationEnabled = false
+
+// 商品と配送ロボットに容積を持つか
+// 30_products_volume.sql を適用している場合のみ有効にする
+var ProductVolumeEnabled = false
+
+// 注文明細とユーザーに配送エリア (ゾーン) を持つか
+// 33_order_zones.sql を適用している場合のみ有効にする
+var OrderZoneEnabled = false
+
+// 注文明細に到着予定日時を持つか
+// 34_order_items_eta.sql を適用している場合のみ有効にする
+var OrderETAEnabled = false
+
+// 注文統計の日別件数を返す日数
+const orderStatsDays = 30
+
+type orderStatsCacheEntry struct {
+	day   string // 計算した日 (日付が変わったら日別件数がずれるので作り直す)
+	stats *model.OrderStats
+}
+
+// 楽観ロックの競合 (読み取った後に他の更新で注文が変わっていた)
+var ErrVersionConflict = errors.New("order version conflict")
+
+// 遷移元のステータスにある数量が足りない (未配送でない単位を配送に出す、配送中でない単位を完了にするなど)
+var ErrInsufficientQuantity = errors.New("not enough order units in the source status")
+
+// UpdateStatuses でバージョンを確認しない場合に指定する
+const AnyVersion int64 = -1
+
+// 商品名の部分一致検索に FULLTEXT (ngram) インデックスを使うか
+// 14_products_name_fulltext.sql を適用している場合のみ有効にする
+var OrderSearchFullText = false
+
+// ngram_token_size (MySQL のデフォルトは 2) 未満の検索語は FULLTEXT で引けない
+// 商品一覧の FULLTEXT 検索 (ProductSearchFullText) でも使う
+var OrderSearchNgramSize = 2
+
+// 注文履歴一覧で件数とページを COUNT(*) OVER() により 1 クエリで取得するか
+// ウィンドウ関数に対応したバックエンド (MySQL 8.0 以降) でのみ有効にする
+var OrderListWindowCount = false
+
+// BatchCreate で 1 回の INSERT に含める最大行数 (max_allowed_packet 対策)
+var OrderBatchInsertChunkSize = 1000
+
+// UpdateStatuses で 1 回の UPDATE に含める最大件数
+var OrderStatusUpdateChunkSize = 500
+
+// 配送中一覧のキャッシュを無効化してからこの時間内は、読み直している間も直前の一覧を返す (0 なら常に読み直しを待つ)
+// 古い一覧で計画しても、UpdateStatuses のバージョン確認で競合として弾かれる
+var ShippingOrdersMaxStaleness time.Duration = 0
+
+// 配達希望期間の開始がこの時間内に迫っている注文も配送計画に含める (配送計画では後回しにする)
+// 開始がそれより先の注文は配送中一覧から除く
+var DeliveryWindowLookahead time.Duration = 0
+
+// 配送中一覧の読み直しのタイムアウト (呼び出し元のキャンセルとは切り離す)
+const shippingOrdersRefreshTimeout = 10 * time.Second
+
+// コミット後に共有バージョンを進めるときのタイムアウト
+const shippingOrdersBumpTimeout = time.Second
+
+// 検索付き COUNT(*) キャッシュのキー
+type orderSearchCountKey struct {
+	userID     int
+	search     string // 正規化済み
+	searchType string
+}
+
 type orderRepoState struct {
+	// 常に非トランザクションの DB
+	// 注文IDの採番 (即コミットさせるため) と、配送中一覧の読み直し (呼び出し元のトランザクションに依存させないため) に使う
+	baseDB DBTX
+
 	// 更新のたびにインクリメントされるバージョン（配送中一覧キャッシュ用）
 	shippingOrdersVersion int64
+	// バージョンが進んだら close して作り直す (ShippingOrdersChanged)
+	shippingOrdersChanged chan struct{}
 
 	// GetShippingOrders の結果キャッシュ（参照返却前提）
 	shippingOrdersCache []model.Order
 
+	// 無効化された直前のキャッシュと無効化した時刻 (ShippingOrdersMaxStaleness 以内なら読み直し中に返す)
+	shippingOrdersStale   []model.Order
+	shippingOrdersStaleAt time.Time
+
+	// 注文履歴一覧・件数のプリペアドステートメント (PreparedStatementCacheSize が 0 なら nil)
+	stmts *stmtCache
+
+	// 配送中一覧の読み直しを 1 つにまとめる
+	shippingOrdersGroup singleflight.Group
+
+	// インスタンス間で共有するバージョン (nil ならプロセス内だけで管理する)
+	sharedVersion ShippingOrdersVersionStore
+	// shippingOrdersCache を読み込んだ時点の共有バージョン
+	shippingOrdersSharedVersion int64
+
 	// user_id のみの COUNT(*) キャッシュ
 	countByUser map[int]int
 
+	// 商品名検索付きの COUNT(*) キャッシュ
+	searchCountByUser *lru.Cache[orderSearchCountKey, int]
+
+	// ユーザーごとの注文統計キャッシュ
+	// ステータス更新では、更新した明細を持つユーザーの分だけ消す
+	statsByUser *lru.Cache[int, orderStatsCacheEntry]
+
 	mu sync.RWMutex
 }
 
 type OrderRepository struct {
 	db    DBTX
 	state *orderRepoState
+	hooks *commitHooks
 }
 
-func newOrderRepository(db DBTX, state *orderRepoState) *OrderRepository {
+func newOrderRepository(db DBTX, state *orderRepoState, hooks *commitHooks) *OrderRepository {
 	state.mu.Lock()
+	if state.baseDB == nil {
+		state.baseDB = db
+	}
+	if state.shippingOrdersChanged == nil {
+		state.shippingOrdersChanged = make(chan struct{})
+	}
 	if state.countByUser == nil {
 		state.countByUser = make(map[int]int)
 	}
+	if state.searchCountByUser == nil {
+		state.searchCountByUser = lo.Must(lru.New[orderSearchCountKey, int](OrderSearchCountCacheSize))
+	}
+	if state.statsByUser == nil {
+		state.statsByUser = lo.Must(lru.New[int, orderStatsCacheEntry](OrderStatsCacheSize))
+	}
 	state.mu.Unlock()
 	return &OrderRepository{
 		db:    db,
 		state: state,
+		hooks: hooks,
 	}
 }
 
@@ -55,31 +186,114 @@ func (r *OrderRepository) GetShippingOrdersVersion(ctx context.Context) (int64,
 	return r.state.shippingOrdersVersion, nil
 }
 
-func (r *OrderRepository) onUpdateShippingOnly() {
+// 配送中一覧のバージョンが次に進んだら close されるチャネル
+// 他インスタンスでの更新は、このインスタンスが共有バージョンを確認するまで通知されない
+func (r *OrderRepository) ShippingOrdersChanged() <-chan struct{} {
+	r.state.mu.RLock()
+	defer r.state.mu.RUnlock()
+	return r.state.shippingOrdersChanged
+}
+
+// キャッシュの無効化はトランザクションのコミット後に行う
+func (r *OrderRepository) onUpdateOrders(userIDs ...int) {
+	r.hooks.add(func() { r.invalidateOrders(userIDs...) })
+}
+
+// 注文の進捗だけが変わった (件数は変わらない) 場合は、配送中一覧と対象ユーザーの統計キャッシュだけ捨てる
+func (r *OrderRepository) onUpdateProgress(userIDs []int) {
+	r.hooks.add(func() { r.invalidateProgress(userIDs) })
+}
+
+func (r *OrderRepository) invalidateProgress(userIDs []int) {
+	r.bumpSharedShippingOrdersVersion()
 	r.state.mu.Lock()
 	defer r.state.mu.Unlock()
+	r.invalidateShippingOrdersLocked()
+	for _, uid := range userIDs {
+		r.state.statsByUser.Remove(uid)
+	}
+}
+
+// 他インスタンスのキャッシュを捨てさせる
+// ローカルのキャッシュより先に進めておき、その間に読み直した一覧が古い共有バージョンで残らないようにする
+func (r *OrderRepository) bumpSharedShippingOrdersVersion() {
+	shared := r.state.sharedVersion
+	if shared == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shippingOrdersBumpTimeout)
+	defer cancel()
+	if err := shared.Bump(ctx); err != nil {
+		log.Printf("[OrderRepository] 配送中一覧の共有バージョン更新失敗: %v", err)
+	}
+}
+
+// 共有バージョンが進んでいたら (他インスタンスで更新されていたら) キャッシュを捨て、現在の共有バージョンを返す
+// 共有バージョンが読めなければキャッシュを信用せずに捨て、-1 を返す (読み直した一覧は次回また捨てられる)
+func (r *OrderRepository) syncSharedShippingOrdersVersion(ctx context.Context) int64 {
+	shared := r.state.sharedVersion
+	if shared == nil {
+		return 0
+	}
+	v, err := shared.Current(ctx)
+	if err != nil {
+		log.Printf("[OrderRepository] 配送中一覧の共有バージョン取得失敗: %v", err)
+		v = -1
+	}
+
+	r.state.mu.RLock()
+	outdated := r.state.shippingOrdersCache != nil && (v < 0 || v != r.state.shippingOrdersSharedVersion)
+	r.state.mu.RUnlock()
+	if outdated {
+		r.state.mu.Lock()
+		if r.state.shippingOrdersCache != nil && (v < 0 || v != r.state.shippingOrdersSharedVersion) {
+			r.invalidateShippingOrdersLocked()
+		}
+		r.state.mu.Unlock()
+	}
+	return v
+}
+
+func (r *OrderRepository) invalidateShippingOrdersLocked() {
 	r.state.shippingOrdersVersion++
-	r.state.shippingOrdersCache = nil
+	close(r.state.shippingOrdersChanged)
+	r.state.shippingOrdersChanged = make(chan struct{})
+	if r.state.shippingOrdersCache != nil {
+		r.state.shippingOrdersStale = r.state.shippingOrdersCache
+		r.state.shippingOrdersStaleAt = time.Now()
+		r.state.shippingOrdersCache = nil
+	}
 }
 
-func (r *OrderRepository) onUpdateOrders(userIDs ...int) {
+func (r *OrderRepository) invalidateOrders(userIDs ...int) {
+	r.bumpSharedShippingOrdersVersion()
 	r.state.mu.Lock()
 	defer r.state.mu.Unlock()
 
-	r.state.shippingOrdersVersion++
-	r.state.shippingOrdersCache = nil
+	r.invalidateShippingOrdersLocked()
 
 	if len(userIDs) == 0 {
 		r.state.countByUser = make(map[int]int)
+		r.state.searchCountByUser.Purge()
+		r.state.statsByUser.Purge()
 		return
 	}
 
-	for _, uid := range lo.Uniq(userIDs) {
+	uids := lo.Uniq(userIDs)
+	for _, uid := range uids {
 		delete(r.state.countByUser, uid)
+		r.state.statsByUser.Remove(uid)
+	}
+	for _, key := range r.state.searchCountByUser.Keys() {
+		if lo.Contains(uids, key.userID) {
+			r.state.searchCountByUser.Remove(key)
+		}
 	}
 }
 
-func (r *OrderRepository) BatchCreate(ctx context.Context, orders []*model.Order) ([]string, error) {
+// 注文ヘッダーと明細 (商品ごとに 1 行) を作成し、作成した明細の ID (注文 ID) を返す
+func (r *OrderRepository) BatchCreate(ctx context.Context, userID int, orders []*model.Order) (_ []string, err error) {
+	defer observeRepoCall("OrderRepository.BatchCreate", time.Now(), &err)
 	if len(orders) == 0 {
 		return []string{}, nil
 	}
@@ -89,92 +303,746 @@ func (r *OrderRepository) BatchCreate(ctx context.Context, orders []*model.Order
 		return nil, fmt.Errorf("BatchCreate must be called within a transaction")
 	}
 
-	query := `INSERT INTO orders (user_id, product_id, shipped_status, created_at) VALUES (:user_id, :product_id, 'shipping', NOW())`
-	result, err := txx.NamedExecContext(ctx, query, orders)
+	result, err := txx.ExecContext(ctx, "INSERT INTO order_headers (user_id, created_at) VALUES (?, NOW())", userID)
 	if err != nil {
 		return nil, err
 	}
-
-	userIDs := lo.Map(orders, func(o *model.Order, _ int) int {
-		return o.UserID
-	})
-	// 本当はキャッシュの更新をしたい
-	r.onUpdateOrders(userIDs...)
-
-	// このロジック大丈夫?
-	var insertedIDs []string
-	lastID, err := result.LastInsertId()
+	headerID, err := result.LastInsertId()
 	if err != nil {
 		return nil, err
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	firstID, err := r.allocateOrderIDs(ctx, len(orders))
 	if err != nil {
 		return nil, err
 	}
-	for i := int64(0); i < rowsAffected; i++ {
-		insertedIDs = append(insertedIDs, fmt.Sprintf("%d", lastID+i))
+	insertedIDs := make([]string, len(orders))
+	for i, o := range orders {
+		o.OrderID = firstID + int64(i)
+		o.OrderHeaderID = headerID
+		o.UserID = userID
+		insertedIDs[i] = strconv.FormatInt(o.OrderID, 10)
+	}
+
+	chunkSize := OrderBatchInsertChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(orders)
+	}
+	columns := "order_item_id, order_header_id, user_id, product_id, quantity, priority, metadata, deliver_after, deliver_before"
+	values := ":order_id, :order_header_id, :user_id, :product_id, :quantity, :priority, :metadata, :deliver_after, :deliver_before"
+	if OrderDestinationEnabled {
+		columns += ", dest_latitude, dest_longitude"
+		values += ", :dest_latitude, :dest_longitude"
+	}
+	if OrderZoneEnabled {
+		columns += ", zone"
+		values += ", :zone"
+	}
+	query := "INSERT INTO order_items (" + columns + ", created_at) VALUES (" + values + ", NOW())"
+	for _, chunk := range lo.Chunk(orders, chunkSize) {
+		if _, err := txx.NamedExecContext(ctx, query, chunk); err != nil {
+			return nil, err
+		}
 	}
 
+	r.onUpdateOrders(userID)
+
 	return insertedIDs, nil
 }
 
-// 複数の注文IDのステータスを一括で更新
+// 連続した注文IDを n 個確保し、先頭の ID を返す
+// LAST_INSERT_ID(expr) で更新後の値を返させるので、同時実行されても範囲は重ならない
+// 行ロックをすぐ離すため、呼び出し元のトランザクションとは別に即コミットする (ロールバック時は欠番になる)
+func (r *OrderRepository) allocateOrderIDs(ctx context.Context, n int) (int64, error) {
+	result, err := r.state.baseDB.ExecContext(ctx,
+		"UPDATE order_id_sequence SET next_id = LAST_INSERT_ID(next_id + ?) WHERE id = 1", n)
+	if err != nil {
+		return 0, err
+	}
+	nextID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return 0, fmt.Errorf("order_id_sequence is not initialized")
+	}
+	return nextID - int64(n), nil
+}
+
+// 複数の注文明細の数量を newStatus (delivering / completed) に進める
+// delivering は未配送から、completed は配送中から、それぞれ Quantity 個を移す
 // 主に配送ロボットが注文を引き受けた際に一括更新をするために使用
-func (r *OrderRepository) UpdateStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
-	if len(orderIDs) == 0 {
+// 読み取り時点からバージョンが変わっている明細があれば ErrVersionConflict を、
+// バージョンを確認しない明細で遷移元の数量が足りなければ ErrInsufficientQuantity を返す (トランザクションごとロールバックすること)
+// Version に AnyVersion を指定した明細はバージョンを確認しない
+func (r *OrderRepository) UpdateStatuses(ctx context.Context, targets []model.OrderVersion, newStatus string) (err error) {
+	defer observeRepoCall("OrderRepository.UpdateStatuses", time.Now(), &err)
+	if len(targets) == 0 {
 		return nil
 	}
-	query, args, err := sqlx.In("UPDATE orders SET shipped_status = ? WHERE order_id IN (?)", newStatus, orderIDs)
+	if newStatus != "delivering" && newStatus != "completed" {
+		return fmt.Errorf("unsupported shipped status: %s", newStatus)
+	}
+	chunkSize := OrderStatusUpdateChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(targets)
+	}
+
+	// 複数テーブルの UPDATE は同じ行を 1 回しか更新しないので、同じ明細への指定はまとめておく
+	var anyVersion, versioned []model.OrderVersion
+	for _, t := range mergeOrderTargets(targets) {
+		if t.Version == AnyVersion {
+			anyVersion = append(anyVersion, t)
+		} else {
+			versioned = append(versioned, t)
+		}
+	}
+
+	// (order_item_id, version, quantity) の導出表と JOIN して更新し、件数で失敗を検出する
+	update := func(chunk []model.OrderVersion, checkVersion bool) (bool, error) {
+		query, args := statusUpdateQuery(chunk, newStatus, checkVersion)
+		result, err := r.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return false, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		return affected == int64(len(chunk)), nil
+	}
+	for _, chunk := range lo.Chunk(anyVersion, chunkSize) {
+		ok, err := update(chunk, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInsufficientQuantity
+		}
+	}
+	for _, chunk := range lo.Chunk(versioned, chunkSize) {
+		ok, err := update(chunk, true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrVersionConflict
+		}
+	}
+
+	// すべての単位が完了した明細は到着日時を記録する (アーカイブの判定に使う)
+	if newStatus == "completed" {
+		ids := lo.Uniq(lo.Map(targets, func(t model.OrderVersion, _ int) int64 { return t.OrderID }))
+		for _, chunk := range lo.Chunk(ids, chunkSize) {
+			query, args, err := sqlx.In("UPDATE order_items SET arrived_at = NOW() WHERE order_item_id IN (?) AND completed_quantity = quantity AND arrived_at IS NULL", chunk)
+			if err != nil {
+				return err
+			}
+			if _, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
+				return err
+			}
+		}
+	}
+
+	// 件数キャッシュはステータスで絞り込まない場合しか使わないので、統計キャッシュだけ対象ユーザーの分を消す
+	userIDs, err := r.orderUserIDs(ctx, lo.Map(targets, func(t model.OrderVersion, _ int) int64 { return t.OrderID }))
 	if err != nil {
 		return err
 	}
-	query = r.db.Rebind(query)
-	_, err = r.db.ExecContext(ctx, query, args...)
+	r.onUpdateProgress(userIDs)
+
+	return nil
+}
+
+// 明細を持つユーザーの ID を重複なしで返す
+func (r *OrderRepository) orderUserIDs(ctx context.Context, orderIDs []int64) ([]int, error) {
+	ids := lo.Uniq(orderIDs)
+	chunkSize := OrderStatusUpdateChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(ids)
+	}
+	var userIDs []int
+	for _, chunk := range lo.Chunk(ids, chunkSize) {
+		query, args, err := sqlx.In("SELECT DISTINCT user_id FROM order_items WHERE order_item_id IN (?)", chunk)
+		if err != nil {
+			return nil, err
+		}
+		var uids []int
+		if err := r.db.SelectContext(ctx, &uids, r.db.Rebind(query), args...); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, uids...)
+	}
+	return lo.Uniq(userIDs), nil
+}
+
+// 同じ (明細, バージョン) への指定を数量の合計にまとめる (順序は最初に現れた順)
+func mergeOrderTargets(targets []model.OrderVersion) []model.OrderVersion {
+	type key struct{ orderID, version int64 }
+	merged := make([]model.OrderVersion, 0, len(targets))
+	index := make(map[key]int, len(targets))
+	for _, t := range targets {
+		k := key{t.OrderID, t.Version}
+		if i, ok := index[k]; ok {
+			merged[i].Quantity += t.Quantity
+			continue
+		}
+		index[k] = len(merged)
+		merged = append(merged, t)
+	}
+	return merged
+}
+
+// (order_item_id, version, quantity) の導出表 v と JOIN する UPDATE を書き始める
+func writeOrderTargetsJoin(b *strings.Builder, targets []model.OrderVersion) []any {
+	args := make([]any, 0, 3*len(targets))
+	b.WriteString("UPDATE order_items o JOIN (")
+	for i, t := range targets {
+		if i == 0 {
+			b.WriteString("SELECT ? AS order_item_id, ? AS version, ? AS quantity")
+		} else {
+			b.WriteString(" UNION ALL SELECT ?, ?, ?")
+		}
+		args = append(args, t.OrderID, t.Version, t.Quantity)
+	}
+	b.WriteString(") v ON o.order_item_id = v.order_item_id")
+	return args
+}
+
+func statusUpdateQuery(targets []model.OrderVersion, newStatus string, checkVersion bool) (string, []any) {
+	var b strings.Builder
+	args := writeOrderTargetsJoin(&b, targets)
+	if checkVersion {
+		b.WriteString(" AND o.version = v.version")
+	}
+	if newStatus == "completed" {
+		b.WriteString(" AND o.completed_quantity + v.quantity <= o.dispatched_quantity SET o.completed_quantity = o.completed_quantity + v.quantity")
+	} else {
+		b.WriteString(" AND o.dispatched_quantity + v.quantity <= o.quantity SET o.dispatched_quantity = o.dispatched_quantity + v.quantity")
+	}
+	b.WriteString(", o.version = o.version + 1")
+	return b.String(), args
+}
+
+// 配送計画で見積もった明細ごとの到着予定日時を記録する
+// 同じ明細の単位が複数の計画に分かれた場合は、最後に記録した計画のもの
+func (r *OrderRepository) SetEstimatedArrivals(ctx context.Context, etas map[int64]time.Time) (err error) {
+	defer observeRepoCall("OrderRepository.SetEstimatedArrivals", time.Now(), &err)
+	if len(etas) == 0 {
+		return nil
+	}
+	chunkSize := OrderStatusUpdateChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(etas)
+	}
+	orderIDs := slices.Sorted(maps.Keys(etas))
+	for _, chunk := range lo.Chunk(orderIDs, chunkSize) {
+		query, args := estimatedArrivalsQuery(chunk, etas)
+		if _, err := r.db.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func estimatedArrivalsQuery(orderIDs []int64, etas map[int64]time.Time) (string, []any) {
+	var b strings.Builder
+	args := make([]any, 0, 2*len(orderIDs))
+	b.WriteString("UPDATE order_items o JOIN (")
+	for i, id := range orderIDs {
+		if i == 0 {
+			b.WriteString("SELECT ? AS order_item_id, ? AS eta")
+		} else {
+			b.WriteString(" UNION ALL SELECT ?, ?")
+		}
+		args = append(args, id, etas[id])
+	}
+	b.WriteString(") v ON o.order_item_id = v.order_item_id SET o.estimated_arrival_at = v.eta")
+	return b.String(), args
+}
+
+// 配送中の単位がある明細の配送の失敗回数を 1 増やし、増やした後の回数を返す (35_delivery_failures.sql)
+// 配送中の単位がなければ ErrInsufficientQuantity を返す
+func (r *OrderRepository) IncrementFailedAttempts(ctx context.Context, orderID int64) (_ int, err error) {
+	defer observeRepoCall("OrderRepository.IncrementFailedAttempts", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE order_items SET failed_attempts = failed_attempts + 1 WHERE order_item_id = ? AND returned_at IS NULL AND completed_quantity < dispatched_quantity",
+		orderID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, ErrInsufficientQuantity
+	}
+	var attempts int
+	if err := r.db.GetContext(ctx, &attempts, "SELECT failed_attempts FROM order_items WHERE order_item_id = ?", orderID); err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// 配送に失敗し続けた明細を、手動で確認する明細として記録する (最初に記録した時刻を残す)
+func (r *OrderRepository) MarkReviewRequired(ctx context.Context, orderID int64) (err error) {
+	defer observeRepoCall("OrderRepository.MarkReviewRequired", time.Now(), &err)
+	_, err = r.db.ExecContext(ctx, "UPDATE order_items SET review_required_at = NOW() WHERE order_item_id = ? AND review_required_at IS NULL", orderID)
+	return err
+}
+
+// 配送中の数量を最大 Quantity 個ずつ未配送に戻し、戻した明細の数を返す (リースの切れた配送計画の解放に使う)
+// 計画の後に完了した単位は戻さない (配送中の個数を上限にする)
+func (r *OrderRepository) RevertDispatched(ctx context.Context, targets []model.OrderVersion) (_ int, err error) {
+	defer observeRepoCall("OrderRepository.RevertDispatched", time.Now(), &err)
+	if len(targets) == 0 {
+		return 0, nil
+	}
+	chunkSize := OrderStatusUpdateChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(targets)
+	}
+	total := 0
+	for _, chunk := range lo.Chunk(mergeOrderTargets(targets), chunkSize) {
+		query, args := revertDispatchedQuery(chunk)
+		result, err := r.db.ExecContext(ctx, query, args...)
+		if err != nil {
+			return total, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += int(affected)
+	}
+	if total > 0 {
+		userIDs, err := r.orderUserIDs(ctx, lo.Map(targets, func(t model.OrderVersion, _ int) int64 { return t.OrderID }))
+		if err != nil {
+			return total, err
+		}
+		r.onUpdateProgress(userIDs)
 	}
+	return total, nil
+}
+
+func revertDispatchedQuery(targets []model.OrderVersion) (string, []any) {
+	var b strings.Builder
+	args := writeOrderTargetsJoin(&b, targets)
+	b.WriteString(" AND o.returned_at IS NULL AND o.completed_quantity < o.dispatched_quantity")
+	b.WriteString(" SET o.dispatched_quantity = o.dispatched_quantity - LEAST(v.quantity, o.dispatched_quantity - o.completed_quantity), o.version = o.version + 1")
+	return b.String(), args
+}
 
-	r.onUpdateShippingOnly()
+// 配送計画で選んだ明細を行ロックし、ロックできた明細の現在のバージョンと未配送の数量を返す (トランザクション内で呼ぶこと)
+// 他のトランザクション (並行して計画を作っているロボット) がロックしている明細は待たずに除く (MySQL 8.0 以降)
+func (r *OrderRepository) LockForDispatch(ctx context.Context, orderIDs []int64) (_ map[int64]model.OrderVersion, err error) {
+	defer observeRepoCall("OrderRepository.LockForDispatch", time.Now(), &err)
+	locked := make(map[int64]model.OrderVersion, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return locked, nil
+	}
+	query, args, err := sqlx.In(`
+        SELECT order_item_id AS order_id, version, quantity - dispatched_quantity AS quantity
+        FROM order_items
+        WHERE order_item_id IN (?) AND returned_at IS NULL
+        FOR UPDATE SKIP LOCKED`, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.OrderVersion
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		locked[row.OrderID] = row
+	}
+	return locked, nil
+}
+
+// 配送計画で選んだ明細の商品と metadata (配送中の明細はアーカイブされないので order_items だけを読む)
+func (r *OrderRepository) GetDeliveryDetails(ctx context.Context, orderIDs []int64) (_ map[int64]model.Order, err error) {
+	defer observeRepoCall("OrderRepository.GetDeliveryDetails", time.Now(), &err)
+	details := make(map[int64]model.Order, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return details, nil
+	}
+	query, args, err := sqlx.In(`
+        SELECT o.order_item_id AS order_id, o.user_id, o.product_id, p.name AS product_name, o.metadata
+        FROM order_items o
+        JOIN products p ON p.product_id = o.product_id
+        WHERE o.order_item_id IN (?)`, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.Order
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		details[row.OrderID] = row
+	}
+	return details, nil
+}
 
+// ユーザーが所有する注文明細の数量と進捗を行ロック付きで取得（トランザクション内で呼ぶこと）
+func (r *OrderRepository) GetProgressForUpdate(ctx context.Context, userID int, orderIDs []int64) (_ map[int64]model.Order, err error) {
+	defer observeRepoCall("OrderRepository.GetProgressForUpdate", time.Now(), &err)
+	progress := make(map[int64]model.Order, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return progress, nil
+	}
+	columns := "order_item_id AS order_id, user_id, product_id, quantity, priority, dispatched_quantity, completed_quantity, shipped_status, version, metadata"
+	if OrderDestinationEnabled {
+		columns += ", dest_latitude, dest_longitude"
+	}
+	if OrderZoneEnabled {
+		columns += ", zone"
+	}
+	query, args, err := sqlx.In(`
+        SELECT `+columns+`
+        FROM order_items
+        WHERE user_id = ? AND order_item_id IN (?)
+        FOR UPDATE`, userID, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.Order
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		progress[row.OrderID] = row
+	}
+	return progress, nil
+}
+
+// すべての単位が完了した明細を返品済みにする (トランザクション内で呼ぶこと)
+// 完了していない、またはすでに返品済みの明細なら ErrInsufficientQuantity を返す
+func (r *OrderRepository) MarkReturned(ctx context.Context, orderID int64, reason string, replacementID sql.NullInt64) (err error) {
+	defer observeRepoCall("OrderRepository.MarkReturned", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx, `
+        UPDATE order_items
+        SET returned_at = NOW(), return_reason = ?, replacement_order_item_id = ?, version = version + 1
+        WHERE order_item_id = ? AND completed_quantity = quantity AND returned_at IS NULL`,
+		reason, replacementID, orderID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInsufficientQuantity
+	}
+	userIDs, err := r.orderUserIDs(ctx, []int64{orderID})
+	if err != nil {
+		return err
+	}
+	r.onUpdateProgress(userIDs)
 	return nil
 }
 
-// 配送中(shipped_status_code: shipping)の注文一覧を取得（参照返却・バージョン連動キャッシュ）
-func (r *OrderRepository) GetShippingOrders(ctx context.Context) ([]model.Order, error) {
+// 未配送の数量を 1 個ずつに展開した互換ビュー (17_order_items.sql)
+const shippingOrdersColumns = "order_id, priority, version, weight, value, deliver_after, deliver_before"
+
+// 配送先の座標 (29_order_items_destination.sql)、商品の容積 (30_products_volume.sql)、ゾーン (33_order_zones.sql) は、有効な場合のみ読む
+func shippingOrdersSelect() string {
+	columns := shippingOrdersColumns
+	if OrderDestinationEnabled {
+		columns += ", dest_latitude, dest_longitude"
+	}
+	if ProductVolumeEnabled {
+		columns += ", volume"
+	}
+	if OrderZoneEnabled {
+		columns += ", zone"
+	}
+	return "SELECT " + columns + " FROM shipping_order_units"
+}
+
+// 配送中の注文を 1 件ずつ fn に渡す (zone が空でなければそのゾーンの注文のみ)
+// キャッシュがあればそれを使い、なければ DB から逐次読み込む (一覧を組み立てないのでキャッシュはしない)
+// fn がエラーを返したら中断してそのエラーを返す
+func (r *OrderRepository) ForEachShippingOrder(ctx context.Context, zone string, fn func(model.Order) error) (err error) {
+	defer observeRepoCall("OrderRepository.ForEachShippingOrder", time.Now(), &err)
+	r.syncSharedShippingOrdersVersion(ctx)
 	r.state.mu.RLock()
-	if cache := r.state.shippingOrdersCache; cache != nil {
-		out := cache
-		r.state.mu.RUnlock()
-		return out, nil
+	cache := r.state.shippingOrdersCache
+	r.state.mu.RUnlock()
+	horizon := time.Now().Add(DeliveryWindowLookahead)
+	if cache != nil {
+		for _, o := range cache {
+			if o.BeforeDeliveryWindow(horizon) || !inZone(o, zone) {
+				continue
+			}
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	rows, err := r.db.QueryxContext(ctx, shippingOrdersSelect())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o model.Order
+		if err := rows.StructScan(&o); err != nil {
+			return err
+		}
+		if o.BeforeDeliveryWindow(horizon) || !inZone(o, zone) {
+			continue
+		}
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// 未配送 (shipping) の注文を 1 個ずつに展開した一覧を取得（参照返却・バージョン連動キャッシュ）
+// 配達希望期間の開始が DeliveryWindowLookahead より先の注文は除く
+// zone が空でなければそのゾーンの注文のみ (キャッシュはゾーンによらず 1 つなので、絞り込んだ一覧は新しく作る)
+func (r *OrderRepository) GetShippingOrders(ctx context.Context, zone string) (_ []model.Order, err error) {
+	defer observeRepoCall("OrderRepository.GetShippingOrders", time.Now(), &err)
+	orders, err := r.allShippingOrders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	orders = excludeBeforeDeliveryWindow(orders, time.Now().Add(DeliveryWindowLookahead))
+	if zone != "" {
+		orders = lo.Filter(orders, func(o model.Order, _ int) bool { return inZone(o, zone) })
+	}
+	return orders, nil
+}
+
+// zone が空ならすべての注文
+func inZone(o model.Order, zone string) bool {
+	return zone == "" || (o.Zone != nil && *o.Zone == zone)
+}
+
+// horizon の時点で配達希望期間が始まっていない注文を除く
+// 除くものがなければ (期間指定がほとんどない場合) 受け取った一覧をそのまま返す
+func excludeBeforeDeliveryWindow(orders []model.Order, horizon time.Time) []model.Order {
+	first := slices.IndexFunc(orders, func(o model.Order) bool { return o.BeforeDeliveryWindow(horizon) })
+	if first < 0 {
+		return orders
+	}
+	eligible := slices.Clone(orders[:first])
+	for _, o := range orders[first+1:] {
+		if !o.BeforeDeliveryWindow(horizon) {
+			eligible = append(eligible, o)
+		}
 	}
+	return eligible
+}
+
+// 期間を考慮しない配送中一覧 (キャッシュはこちらを持つ)
+// キャッシュがなければ読み直しを 1 つにまとめて待つ
+// ShippingOrdersMaxStaleness 以内に無効化された一覧があれば、読み直しを待たずにそれを返す
+func (r *OrderRepository) allShippingOrders(ctx context.Context) ([]model.Order, error) {
+	sharedVer := r.syncSharedShippingOrdersVersion(ctx)
+	r.state.mu.RLock()
+	cache := r.state.shippingOrdersCache
+	stale, staleAt := r.state.shippingOrdersStale, r.state.shippingOrdersStaleAt
+	r.state.mu.RUnlock()
+	if cache != nil {
+		return cache, nil
+	}
+
+	result := r.state.shippingOrdersGroup.DoChan("shipping", func() (any, error) {
+		return r.refreshShippingOrders(ctx, sharedVer)
+	})
+	if stale != nil && ShippingOrdersMaxStaleness > 0 && time.Since(staleAt) <= ShippingOrdersMaxStaleness {
+		return stale, nil
+	}
+
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.([]model.Order), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 配送中の一覧を読み直してキャッシュする
+// 待っている他の呼び出しを巻き込まないよう、呼び出し元のキャンセルやトランザクションとは切り離して読む
+// sharedVer は読み直す前に確認した共有バージョン
+func (r *OrderRepository) refreshShippingOrders(ctx context.Context, sharedVer int64) ([]model.Order, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shippingOrdersRefreshTimeout)
+	defer cancel()
+
+	r.state.mu.RLock()
 	localVer := r.state.shippingOrdersVersion
 	r.state.mu.RUnlock()
 
 	var orders []model.Order
-	const query = `
-        SELECT
-            o.order_id,
-            p.weight,
-            p.value
-        FROM orders o
-        JOIN products p ON o.product_id = p.product_id
-        WHERE o.shipped_status_code = ?
-    `
-	if err := r.db.SelectContext(ctx, &orders, query, shippedStatusEnumShipping); err != nil {
+	if err := r.state.baseDB.SelectContext(ctx, &orders, shippingOrdersSelect()); err != nil {
 		return nil, err
 	}
 
 	r.state.mu.Lock()
 	if r.state.shippingOrdersVersion == localVer && r.state.shippingOrdersCache == nil {
 		r.state.shippingOrdersCache = orders
+		r.state.shippingOrdersSharedVersion = sharedVer
+		r.state.shippingOrdersStale = nil
 	}
 	r.state.mu.Unlock()
 
 	return orders, nil
 }
 
-// 注文履歴一覧を取得
-func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
+// ユーザーの注文明細を参照する FROM 句
+// アーカイブが有効な場合は order_items_archive と UNION ALL する (user_id で先に絞ってインデックスを効かせる)
+// includeArchive が false の場合 (完了済みを含まない絞り込みなど) は order_items だけを見る
+func userOrdersFrom(userID int, includeArchive bool) (string, []any) {
+	if !OrderArchiveEnabled || !includeArchive {
+		return "order_items o", nil
+	}
+	columns := "order_item_id, order_header_id, user_id, product_id, quantity, priority, dispatched_quantity, completed_quantity, version, shipped_status, shipped_status_code, metadata, deliver_after, deliver_before, returned_at, return_reason, replacement_order_item_id, created_at, arrived_at"
+	if OrderETAEnabled {
+		columns += ", estimated_arrival_at"
+	}
+	from := fmt.Sprintf(`(
+            SELECT %[1]s FROM order_items WHERE user_id = ?
+            UNION ALL
+            SELECT %[1]s FROM order_items_archive WHERE user_id = ?
+        ) o`, columns)
+	return from, []any{userID, userID}
+}
+
+// ステータスごとの個数・金額と、直近 orderStatsDays 日の日別個数を取得
+// 明細の数量を進捗ごとに分けて数え、日付で GROUP BY した 1 クエリの結果から両方を集計する
+func (r *OrderRepository) GetOrderStats(ctx context.Context, userID int) (_ *model.OrderStats, err error) {
+	defer observeRepoCall("OrderRepository.GetOrderStats", time.Now(), &err)
+	now := time.Now()
+	today := now.Format(time.DateOnly)
+
+	if entry, ok := r.state.statsByUser.Get(userID); ok && entry.day == today {
+		return entry.stats, nil
+	}
+	// 集計中に注文が更新されていたら、消された後の古い結果を残さないようキャッシュしない
+	r.state.mu.RLock()
+	version := r.state.shippingOrdersVersion
+	r.state.mu.RUnlock()
+
+	var rows []struct {
+		Day             string `db:"day"`
+		Shipping        int    `db:"shipping"`
+		Delivering      int    `db:"delivering"`
+		Completed       int    `db:"completed"`
+		Returned        int    `db:"returned"`
+		ShippingValue   int    `db:"shipping_value"`
+		DeliveringValue int    `db:"delivering_value"`
+		CompletedValue  int    `db:"completed_value"`
+		ReturnedValue   int    `db:"returned_value"`
+	}
+	from, fromArgs := userOrdersFrom(userID, true)
+	query := `
+        SELECT
+            DATE_FORMAT(o.created_at, '%Y-%m-%d')                                    AS day,
+            SUM(o.quantity - o.dispatched_quantity)                                  AS shipping,
+            SUM(o.dispatched_quantity - o.completed_quantity)                        AS delivering,
+            SUM(IF(o.returned_at IS NULL, o.completed_quantity, 0))                  AS completed,
+            SUM(IF(o.returned_at IS NULL, 0, o.quantity))                            AS returned,
+            SUM((o.quantity - o.dispatched_quantity) * p.value)                      AS shipping_value,
+            SUM((o.dispatched_quantity - o.completed_quantity) * p.value)            AS delivering_value,
+            SUM(IF(o.returned_at IS NULL, o.completed_quantity, 0) * p.value)        AS completed_value,
+            SUM(IF(o.returned_at IS NULL, 0, o.quantity) * p.value)                  AS returned_value
+        FROM ` + from + `
+        JOIN products p ON p.product_id = o.product_id
+        WHERE o.user_id = ?
+        GROUP BY day
+    `
+	if err := r.db.SelectContext(ctx, &rows, query, append(fromArgs, userID)...); err != nil {
+		return nil, err
+	}
+
+	stats := &model.OrderStats{
+		ByStatus: []model.OrderStatusStat{
+			{ShippedStatus: "shipping"},
+			{ShippedStatus: "delivering"},
+			{ShippedStatus: "completed"},
+			{ShippedStatus: "returned"},
+		},
+		Daily: make([]model.DailyOrderCount, 0, orderStatsDays),
+	}
+	daily := map[string]int{}
+	for _, row := range rows {
+		stats.ByStatus[0].Count += row.Shipping
+		stats.ByStatus[0].TotalValue += row.ShippingValue
+		stats.ByStatus[1].Count += row.Delivering
+		stats.ByStatus[1].TotalValue += row.DeliveringValue
+		stats.ByStatus[2].Count += row.Completed
+		stats.ByStatus[2].TotalValue += row.CompletedValue
+		stats.ByStatus[3].Count += row.Returned
+		stats.ByStatus[3].TotalValue += row.ReturnedValue
+		daily[row.Day] += row.Shipping + row.Delivering + row.Completed + row.Returned
+	}
+	for i := orderStatsDays - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i).Format(time.DateOnly)
+		stats.Daily = append(stats.Daily, model.DailyOrderCount{Date: day, Count: daily[day]})
+	}
+
+	r.state.mu.Lock()
+	if r.state.shippingOrdersVersion == version {
+		r.state.statsByUser.Add(userID, orderStatsCacheEntry{day: today, stats: stats})
+	}
+	r.state.mu.Unlock()
+	return stats, nil
+}
+
+// 注文明細の詳細を商品情報付きで取得
+// 他ユーザーの注文であれば sql.ErrNoRows を返す
+func (r *OrderRepository) GetOrderByID(ctx context.Context, userID int, orderID int64) (_ *model.Order, err error) {
+	defer observeRepoCall("OrderRepository.GetOrderByID", time.Now(), &err)
+	var order model.Order
+	from, fromArgs := userOrdersFrom(userID, true)
+	query := `
+        SELECT
+            o.order_item_id AS order_id,
+            o.order_header_id,
+            o.user_id,
+            o.product_id,
+            p.name          AS product_name,
+            o.shipped_status,
+            p.weight,
+            p.value,
+            p.image,
+            o.quantity,
+            o.dispatched_quantity,
+            o.completed_quantity,
+            o.priority,
+            o.version,
+            o.metadata,
+            o.deliver_after,
+            o.deliver_before,
+            o.returned_at,
+            o.return_reason,
+            o.replacement_order_item_id AS replacement_order_id,
+            o.created_at,
+            o.arrived_at` + lo.Ternary(OrderETAEnabled, ",\n            o.estimated_arrival_at", "") + `
+        FROM ` + from + `
+        JOIN products p ON p.product_id = o.product_id
+        WHERE o.order_item_id = ? AND o.user_id = ?
+    `
+	if err := r.db.GetContext(ctx, &order, query, append(fromArgs, orderID, userID)...); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// 注文履歴 (明細単位) の一覧を取得
+func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.ListRequest) (_ []model.Order, _ int, err error) {
+	defer observeRepoCall("OrderRepository.ListOrders", time.Now(), &err)
 	// WHERE 句の構築
 	conds := []string{"o.user_id = ?"}
 	args := []any{userID}
@@ -182,6 +1050,7 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 	var (
 		searchApplied bool
 		searchPattern string
+		searchKey     orderSearchCountKey
 	)
 
 	if s := strings.TrimSpace(req.Search); s != "" {
@@ -195,93 +1064,350 @@ func (r *OrderRepository) ListOrders(ctx context.Context, userID int, req model.
 			searchType = "partial"
 			searchPattern = "%" + s + "%"
 		}
-		conds = append(conds, "p.name LIKE ?")
-		args = append(args, searchPattern)
+		if phrase, ok := fullTextPhrase(s); ok && searchType == "partial" {
+			// FULLTEXT で候補を絞り、LIKE で従来どおりの部分一致に揃える
+			conds = append(conds, "MATCH(p.name) AGAINST (? IN BOOLEAN MODE)", "p.name LIKE ?")
+			args = append(args, phrase, searchPattern)
+		} else {
+			conds = append(conds, "p.name LIKE ?")
+			args = append(args, searchPattern)
+		}
+		// LIKE は大文字小文字を区別しない照合順序なので小文字で正規化する
+		searchKey = orderSearchCountKey{userID: userID, search: strings.ToLower(s), searchType: searchType}
+	}
+
+	filtered := searchApplied
+	if len(req.Statuses) > 0 {
+		codes := make([]int, 0, len(req.Statuses))
+		for _, status := range lo.Uniq(req.Statuses) {
+			code, ok := shippedStatusCode(status)
+			if !ok {
+				return nil, 0, fmt.Errorf("unknown shipped status: %s", status)
+			}
+			codes = append(codes, code)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(codes)), ",")
+		conds = append(conds, "o.shipped_status_code IN ("+placeholders+")")
+		for _, code := range codes {
+			args = append(args, code)
+		}
+		filtered = true
 	}
+	if req.CreatedFrom != nil {
+		conds = append(conds, "o.created_at >= ?")
+		args = append(args, *req.CreatedFrom)
+		filtered = true
+	}
+	if req.CreatedTo != nil {
+		conds = append(conds, "o.created_at < ?")
+		args = append(args, *req.CreatedTo)
+		filtered = true
+	}
+
+	// 商品名検索のみの場合は COUNT(*) をキャッシュする
+	searchOnly := searchApplied && len(req.Statuses) == 0 && req.CreatedFrom == nil && req.CreatedTo == nil
 
-	var total int
-	if !searchApplied {
+	// 完了済みを含まない絞り込みならアーカイブは見なくてよい
+	from, fromArgs := userOrdersFrom(userID, len(req.Statuses) == 0 || lo.Contains(req.Statuses, "completed"))
+
+	// 件数のキャッシュ
+	total, totalKnown := 0, false
+	if searchOnly {
+		total, totalKnown = r.state.searchCountByUser.Get(searchKey)
+	} else if !filtered {
 		r.state.mu.RLock()
-		cached, ok := r.state.countByUser[userID]
+		total, totalKnown = r.state.countByUser[userID]
 		r.state.mu.RUnlock()
-		if ok {
-			total = cached
-		} else {
-			const countQuery = "SELECT COUNT(*) FROM orders o WHERE o.user_id = ?"
-			if err := r.db.GetContext(ctx, &total, countQuery, userID); err != nil {
-				return nil, 0, err
-			}
+	}
+	storeTotal := func(total int) {
+		if searchOnly {
+			r.state.searchCountByUser.Add(searchKey, total)
+		} else if !filtered {
 			r.state.mu.Lock()
 			r.state.countByUser[userID] = total
 			r.state.mu.Unlock()
 		}
-	} else {
+	}
+	// キーセット条件を足す前の conds で数えること
+	countConds := strings.Join(conds, " AND ")
+	countArgs := append(append([]any{}, fromArgs...), args...)
+	countTotal := func() (int, error) {
+		// 商品名で絞り込まない場合は JOIN 不要
+		join := ""
+		if searchApplied {
+			join = "JOIN products p ON p.product_id = o.product_id"
+		}
 		countQuery := fmt.Sprintf(`
             SELECT COUNT(*)
-            FROM orders o
-            JOIN products p ON p.product_id = o.product_id
-            WHERE %s`, strings.Join(conds, " AND "),
+            FROM %s
+            %s
+            WHERE %s`, from, join, countConds,
 		)
-		if err := r.db.GetContext(ctx, &total, countQuery, userID, searchPattern); err != nil {
-			return nil, 0, err
+		if req.ApproximateTotal {
+			// 上限 + 1 件まで数えたら打ち切る (上限を超えたことだけ分かればよい)
+			countQuery = fmt.Sprintf(`
+            SELECT COUNT(*) FROM (
+                SELECT 1
+                FROM %s
+                %s
+                WHERE %s
+                LIMIT %d
+            ) t`, from, join, countConds, model.ApproximateTotalLimit+1,
+			)
 		}
+		var count int
+		if err := r.state.stmts.getContext(ctx, r.db, &count, countQuery, countArgs...); err != nil {
+			return 0, err
+		}
+		return count, nil
 	}
 
-	if total == 0 {
+	keyset := req.SortField == "order_id" && (req.AfterID > 0 || req.BeforeID > 0)
+	// キーセット条件は件数に含めないので COUNT(*) OVER() では数えられない
+	// 概数でよい場合は全件を数える COUNT(*) OVER() は使わない
+	windowCount := !totalKnown && OrderListWindowCount && !keyset && !req.ApproximateTotal
+	if !totalKnown && !windowCount {
+		count, err := countTotal()
+		if err != nil {
+			return nil, 0, err
+		}
+		total = count
+		// 打ち切った件数は正確ではないのでキャッシュしない
+		if !req.ApproximateTotal || total <= model.ApproximateTotalLimit {
+			storeTotal(total)
+		}
+	}
+	if !windowCount && total == 0 {
 		return []model.Order{}, 0, nil
 	}
 
-	orderBy := buildOrderBy(req.SortField, req.SortOrder)
+	// キーセットページング
+	// before_id の場合は逆順で取得して最後に並べ直す
+	sortOrder := req.SortOrder
+	reverse := false
+	offset := req.Offset
+	if keyset {
+		desc := strings.ToUpper(req.SortOrder) == "DESC"
+		offset = 0
+		if req.AfterID > 0 {
+			conds = append(conds, lo.Ternary(desc, "o.order_item_id < ?", "o.order_item_id > ?"))
+			args = append(args, req.AfterID)
+		} else {
+			conds = append(conds, lo.Ternary(desc, "o.order_item_id > ?", "o.order_item_id < ?"))
+			args = append(args, req.BeforeID)
+			reverse = true
+			sortOrder = lo.Ternary(desc, "ASC", "DESC")
+		}
+	}
+
+	orderBy := buildOrderBy(req.SortField, sortOrder)
+
+	// 商品名を返さず、商品名で検索・並び替えもしない場合は商品を JOIN しない
+	joinProduct := searchApplied || req.SortField == "product_name" || len(req.Fields) == 0 || lo.Contains(req.Fields, "product_name")
 
 	query := fmt.Sprintf(`
         SELECT
-            o.order_id,
+            o.order_item_id AS order_id,
             o.product_id,
-            p.name          AS product_name,
+            %s AS product_name,
             o.shipped_status,
+            o.quantity,
+            o.dispatched_quantity,
+            o.completed_quantity,
+            o.metadata,
+            o.deliver_after,
+            o.deliver_before,
             o.created_at,
-            o.arrived_at
-        FROM orders o
-        JOIN products p ON p.product_id = o.product_id
+            o.arrived_at%s%s
+        FROM %s
+        %s
         WHERE %s
         %s
         LIMIT ? OFFSET ?`,
+		lo.Ternary(joinProduct, "p.name", "''"),
+		lo.Ternary(OrderETAEnabled, ",\n            o.estimated_arrival_at", ""),
+		lo.Ternary(windowCount, ",\n            COUNT(*) OVER() AS total_count", ""),
+		from,
+		lo.Ternary(joinProduct, "JOIN products p ON p.product_id = o.product_id", ""),
 		strings.Join(conds, " AND "),
 		orderBy,
 	)
 
 	// ページング引数
-	argsWithPage := append(append([]any{}, args...), req.PageSize, req.Offset)
+	argsWithPage := append(append(append([]any{}, fromArgs...), args...), req.PageSize, offset)
 
 	type row struct {
-		OrderID       int64        `db:"order_id"`
-		ProductID     int          `db:"product_id"`
-		ProductName   string       `db:"product_name"`
-		ShippedStatus string       `db:"shipped_status"`
-		CreatedAt     sql.NullTime `db:"created_at"`
-		ArrivedAt     sql.NullTime `db:"arrived_at"`
+		OrderID            int64               `db:"order_id"`
+		ProductID          int                 `db:"product_id"`
+		ProductName        string              `db:"product_name"`
+		ShippedStatus      string              `db:"shipped_status"`
+		Quantity           int                 `db:"quantity"`
+		DispatchedQuantity int                 `db:"dispatched_quantity"`
+		CompletedQuantity  int                 `db:"completed_quantity"`
+		Metadata           model.OrderMetadata `db:"metadata"`
+		DeliverAfter       *time.Time          `db:"deliver_after"`
+		DeliverBefore      *time.Time          `db:"deliver_before"`
+		CreatedAt          sql.NullTime        `db:"created_at"`
+		ArrivedAt          sql.NullTime        `db:"arrived_at"`
+		EstimatedArrivalAt *time.Time          `db:"estimated_arrival_at"`
+		TotalCount         int                 `db:"total_count"`
 	}
 
 	var rows []row
-	if err := r.db.SelectContext(ctx, &rows, query, argsWithPage...); err != nil {
+	if err := r.state.stmts.selectContext(ctx, r.db, &rows, query, argsWithPage...); err != nil {
 		return nil, 0, err
 	}
 
+	if windowCount {
+		switch {
+		case len(rows) > 0:
+			total = rows[0].TotalCount
+		case offset > 0:
+			// 範囲外のページでは件数が取れないので数え直す
+			count, err := countTotal()
+			if err != nil {
+				return nil, 0, err
+			}
+			total = count
+		}
+		storeTotal(total)
+	}
+
 	orders := make([]model.Order, 0, len(rows))
 	for _, r := range rows {
 		orders = append(orders, model.Order{
-			OrderID:       r.OrderID,
-			ProductID:     r.ProductID,
-			ProductName:   r.ProductName,
-			ShippedStatus: r.ShippedStatus,
-			CreatedAt:     r.CreatedAt.Time,
-			ArrivedAt:     r.ArrivedAt,
+			OrderID:            r.OrderID,
+			ProductID:          r.ProductID,
+			ProductName:        r.ProductName,
+			ShippedStatus:      r.ShippedStatus,
+			Quantity:           r.Quantity,
+			DispatchedQuantity: r.DispatchedQuantity,
+			CompletedQuantity:  r.CompletedQuantity,
+			Metadata:           r.Metadata,
+			DeliverAfter:       r.DeliverAfter,
+			DeliverBefore:      r.DeliverBefore,
+			CreatedAt:          r.CreatedAt.Time,
+			ArrivedAt:          r.ArrivedAt,
+			EstimatedArrivalAt: r.EstimatedArrivalAt,
 		})
 	}
+	if reverse {
+		slices.Reverse(orders)
+	}
 
 	return orders, total, nil
 }
 
+// arrived_at が before より前の完了済み明細を最大 limit 件 order_items_archive に移し、移した件数を返す
+// トランザクション内で呼ぶこと
+func (r *OrderRepository) ArchiveCompleted(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	defer observeRepoCall("OrderRepository.ArchiveCompleted", time.Now(), &err)
+	if _, ok := r.db.(*sqlx.Tx); !ok {
+		return 0, fmt.Errorf("ArchiveCompleted must be called within a transaction")
+	}
+
+	var orderIDs []int64
+	const selectQuery = `
+        SELECT order_item_id
+        FROM order_items
+        WHERE shipped_status_code = ? AND arrived_at < ?
+        ORDER BY arrived_at
+        LIMIT ?
+        FOR UPDATE
+    `
+	if err := r.db.SelectContext(ctx, &orderIDs, selectQuery, shippedStatusEnumCompleted, before, limit); err != nil {
+		return 0, err
+	}
+	if len(orderIDs) == 0 {
+		return 0, nil
+	}
+
+	columns := "order_item_id, order_header_id, user_id, product_id, quantity, priority, dispatched_quantity, completed_quantity, version, metadata, deliver_after, deliver_before, created_at, arrived_at"
+	if OrderDestinationEnabled {
+		columns += ", dest_latitude, dest_longitude"
+	}
+	if OrderZoneEnabled {
+		columns += ", zone"
+	}
+	if OrderETAEnabled {
+		columns += ", estimated_arrival_at"
+	}
+	insertQuery, args, err := sqlx.In(`
+        INSERT INTO order_items_archive (`+columns+`)
+        SELECT `+columns+`
+        FROM order_items
+        WHERE order_item_id IN (?)`, orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(insertQuery), args...); err != nil {
+		return 0, err
+	}
+
+	deleteQuery, args, err := sqlx.In("DELETE FROM order_items WHERE order_item_id IN (?)", orderIDs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(deleteQuery), args...); err != nil {
+		return 0, err
+	}
+
+	// 参照側は order_items_archive も UNION するので件数系のキャッシュは無効化しなくてよい
+	return len(orderIDs), nil
+}
+
+// arrived_at が before より前の完了済み明細を最大 limit 件削除し、削除した件数を返す
+// 注文ヘッダーは残す
+func (r *OrderRepository) DeleteCompleted(ctx context.Context, before time.Time, limit int) (_ int, err error) {
+	defer observeRepoCall("OrderRepository.DeleteCompleted", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx, `
+        DELETE FROM order_items
+        WHERE shipped_status_code = ? AND arrived_at < ?
+        ORDER BY arrived_at
+        LIMIT ?`, shippedStatusEnumCompleted, before, limit)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected > 0 {
+		// 誰の注文が消えたか分からないので件数系のキャッシュはすべて捨てる
+		r.onUpdateOrders()
+	}
+	return int(affected), nil
+}
+
+// 部分一致検索語を BOOLEAN MODE のフレーズ検索に変換する
+// FULLTEXT を使えない場合は false を返す
+func fullTextPhrase(search string) (string, bool) {
+	if !OrderSearchFullText {
+		return "", false
+	}
+	// フレーズ内で " は区切りとして扱われるので取り除く
+	search = strings.TrimSpace(strings.ReplaceAll(search, `"`, ""))
+	if utf8.RuneCountInString(search) < OrderSearchNgramSize {
+		return "", false
+	}
+	return `"` + search + `"`, true
+}
+
+func shippedStatusCode(status string) (int, bool) {
+	switch status {
+	case "shipping":
+		return shippedStatusEnumShipping, true
+	case "delivering":
+		return shippedStatusEnumDelivering, true
+	case "completed":
+		return shippedStatusEnumCompleted, true
+	case "returned":
+		return shippedStatusEnumReturned, true
+	default:
+		return 0, false
+	}
+}
+
 func buildOrderBy(field, order string) string {
 	dir := "ASC"
 	if strings.ToUpper(order) == "DESC" {
@@ -303,6 +1429,6 @@ func buildOrderBy(field, order string) string {
 	case "order_id":
 		fallthrough
 	default:
-		return "ORDER BY o.order_id " + dir
+		return "ORDER BY o.order_item_id " + dir
 	}
 }
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestArchiveCompletedMovesRowsInTransaction(t *testing.T) {
+	db, rec := newRecordingDB()
+	rec.query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"order_id"}, [][]driver.Value{{int64(1)}, {int64(2)}}, nil
+	}
+	store := NewStore(db)
+
+	var n int
+	err := store.ExecTx(context.Background(), func(txStore *Store) error {
+		var err error
+		n, err = txStore.OrderRepo.ArchiveCompleted(context.Background(), time.Now(), 10)
+		return err
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("ArchiveCompleted = %d, %v; want 2", n, err)
+	}
+
+	log := rec.entries()
+	if len(log) != 4 ||
+		!strings.Contains(log[0], "FOR UPDATE") ||
+		!strings.Contains(log[1], "INSERT INTO order_items_archive") || !strings.Contains(log[1], "IN (?, ?)") ||
+		!strings.HasPrefix(log[2], "DELETE FROM order_items ") ||
+		log[3] != "COMMIT" {
+		t.Fatalf("log = %q, want select, copy, delete and commit", log)
+	}
+}
+
+func TestArchiveCompletedRequiresTransaction(t *testing.T) {
+	if _, err := NewStore(&fakeDB{}).OrderRepo.ArchiveCompleted(context.Background(), time.Now(), 10); err == nil {
+		t.Fatal("want an error outside a transaction")
+	}
+}
+
+func TestListOrdersReadsArchive(t *testing.T) {
+	OrderArchiveEnabled = true
+	t.Cleanup(func() { OrderArchiveEnabled = false })
+
+	var countQuery string
+	var countArgs []any
+	db := &fakeDB{get: func(_ context.Context, _ any, q string, a ...any) error {
+		countQuery, countArgs = q, a
+		return nil
+	}}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+
+	if _, _, err := repo.ListOrders(ctx, 1, model.ListRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(countQuery, "order_items_archive") || len(countArgs) != 3 {
+		t.Fatalf("count query = %s %v, want order_items and order_items_archive filtered by user", countQuery, countArgs)
+	}
+
+	// 完了済みを含まない絞り込みではアーカイブを見ない
+	if _, _, err := repo.ListOrders(ctx, 1, model.ListRequest{Statuses: []string{"shipping"}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(countQuery, "order_items_archive") {
+		t.Fatalf("count query = %s, want order_items only", countQuery)
+	}
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestBatchCreateInsertsInChunks(t *testing.T) {
+	chunkSize := OrderBatchInsertChunkSize
+	OrderBatchInsertChunkSize = 2
+	t.Cleanup(func() { OrderBatchInsertChunkSize = chunkSize })
+
+	db, rec := newRecordingDB()
+	rec.exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if strings.HasPrefix(query, "UPDATE order_id_sequence") {
+			return fakeResult{lastInsertID: 100 + args[0].Value.(int64), rowsAffected: 1}, nil
+		}
+		if strings.HasPrefix(query, "INSERT INTO order_headers") {
+			return fakeResult{lastInsertID: 7, rowsAffected: 1}, nil
+		}
+		return driver.RowsAffected(1), nil
+	}
+	store := NewStore(db)
+
+	orders := make([]*model.Order, 5)
+	for i := range orders {
+		orders[i] = &model.Order{ProductID: i + 1, Quantity: 1}
+	}
+	var ids []string
+	err := store.ExecTx(context.Background(), func(txStore *Store) error {
+		var err error
+		ids, err = txStore.OrderRepo.BatchCreate(context.Background(), 1, orders)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"100", "101", "102", "103", "104"}; strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for _, o := range orders {
+		if o.OrderHeaderID != 7 || o.UserID != 1 {
+			t.Fatalf("order = %+v, want header 7 of user 1", o)
+		}
+	}
+	headers, inserts := 0, 0
+	for _, q := range rec.entries() {
+		switch {
+		case strings.HasPrefix(q, "INSERT INTO order_headers"):
+			headers++
+		case strings.HasPrefix(q, "INSERT INTO order_items"):
+			inserts++
+		}
+	}
+	if headers != 1 || inserts != 3 {
+		t.Fatalf("headers = %d, inserts = %d; want one header and 5 items split into chunks of 2", headers, inserts)
+	}
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestListOrdersFilters(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	var countQuery string
+	var countArgs []any
+	db := &fakeDB{get: func(_ context.Context, dest any, q string, a ...any) error {
+		countQuery, countArgs = q, a
+		*dest.(*int) = 3
+		return nil
+	}}
+	repo := NewStore(db).OrderRepo
+	// 絞り込み無しの件数キャッシュを汚しておく
+	repo.state.countByUser[1] = 100
+
+	_, total, err := repo.ListOrders(context.Background(), 1, model.ListRequest{
+		Statuses:    []string{"shipping", "completed", "shipping"},
+		CreatedFrom: &from,
+		CreatedTo:   &to,
+		PageSize:    20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 {
+		t.Fatalf("total = %d, want the filtered count instead of the cached one", total)
+	}
+	for _, want := range []string{"o.shipped_status_code IN (?,?)", "o.created_at >= ?", "o.created_at < ?"} {
+		if !strings.Contains(countQuery, want) {
+			t.Errorf("count query = %s, want %q", countQuery, want)
+		}
+	}
+	if strings.Contains(countQuery, "JOIN products") {
+		t.Errorf("count query = %s, want no JOIN without a name search", countQuery)
+	}
+	want := []any{1, shippedStatusEnumShipping, shippedStatusEnumCompleted, from, to}
+	if len(countArgs) != len(want) {
+		t.Fatalf("count args = %v, want %v", countArgs, want)
+	}
+	for i := range want {
+		if countArgs[i] != want[i] {
+			t.Fatalf("count args = %v, want %v", countArgs, want)
+		}
+	}
+}
+
+func TestListOrdersRejectsUnknownStatus(t *testing.T) {
+	_, _, err := NewStore(&fakeDB{}).OrderRepo.ListOrders(context.Background(), 1, model.ListRequest{Statuses: []string{"lost"}})
+	if err == nil {
+		t.Fatal("unknown status was accepted")
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestFullTextPhrase(t *testing.T) {
+	OrderSearchFullText = true
+	t.Cleanup(func() { OrderSearchFullText = false })
+
+	tests := []struct {
+		search string
+		want   string
+		ok     bool
+	}{
+		{"ロボット", `"ロボット"`, true},
+		{`say "hi"`, `"say hi"`, true},
+		{"a", "", false},   // ngram_token_size 未満
+		{`"a"`, "", false}, // " を除くと 1 文字
+	}
+	for _, tt := range tests {
+		if got, ok := fullTextPhrase(tt.search); got != tt.want || ok != tt.ok {
+			t.Errorf("fullTextPhrase(%q) = %q, %v; want %q, %v", tt.search, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	OrderSearchFullText = false
+	if _, ok := fullTextPhrase("ロボット"); ok {
+		t.Error("FULLTEXT must not be used when disabled")
+	}
+}
+
+func TestListOrdersUsesFullTextForPartialSearch(t *testing.T) {
+	OrderSearchFullText = true
+	t.Cleanup(func() { OrderSearchFullText = false })
+
+	var countQuery string
+	var countArgs []any
+	db := &fakeDB{get: func(_ context.Context, _ any, q string, a ...any) error {
+		countQuery, countArgs = q, a
+		return nil
+	}}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+
+	if _, _, err := repo.ListOrders(ctx, 1, model.ListRequest{Search: "robot"}); err != nil {
+		t.Fatal(err)
+	}
+	// LIKE も残して従来と同じ結果にする
+	if !strings.Contains(countQuery, "MATCH(p.name) AGAINST (? IN BOOLEAN MODE)") || !strings.Contains(countQuery, "p.name LIKE ?") {
+		t.Fatalf("count query = %s, want MATCH and LIKE", countQuery)
+	}
+	if len(countArgs) != 3 || countArgs[1] != `"robot"` || countArgs[2] != "%robot%" {
+		t.Fatalf("count args = %v", countArgs)
+	}
+
+	// 前方一致は LIKE のままでインデックスを使える
+	if _, _, err := repo.ListOrders(ctx, 1, model.ListRequest{Search: "robot", Type: "prefix"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(countQuery, "MATCH") {
+		t.Fatalf("count query = %s, want LIKE only for prefix search", countQuery)
+	}
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestAllocateOrderIDs(t *testing.T) {
+	// LAST_INSERT_ID(next_id + n) は更新後の next_id を返す
+	next := int64(100)
+	db := &fakeDB{exec: func(_ context.Context, _ string, args ...any) (sql.Result, error) {
+		next += int64(args[0].(int))
+		return fakeResult{lastInsertID: next, rowsAffected: 1}, nil
+	}}
+	repo := NewStore(db).OrderRepo
+
+	first, err := repo.allocateOrderIDs(context.Background(), 3)
+	if err != nil || first != 100 {
+		t.Fatalf("allocateOrderIDs(3) = %d, %v; want 100", first, err)
+	}
+	second, err := repo.allocateOrderIDs(context.Background(), 2)
+	if err != nil || second != 103 {
+		t.Fatalf("allocateOrderIDs(2) = %d, %v; want 103 right after the first range", second, err)
+	}
+}
+
+func TestAllocateOrderIDsWithoutSequenceRow(t *testing.T) {
+	db := &fakeDB{exec: func(context.Context, string, ...any) (sql.Result, error) {
+		return fakeResult{}, nil
+	}}
+	if _, err := NewStore(db).OrderRepo.allocateOrderIDs(context.Background(), 1); err == nil {
+		t.Fatal("want an error when order_id_sequence has no row")
+	}
+}
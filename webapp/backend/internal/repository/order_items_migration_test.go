@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+const orderItemsMigration = "../../../mysql/migration/17_order_items.sql"
+
+func TestOrderItemsMigrationBackfillsBeforeDrop(t *testing.T) {
+	raw, err := os.ReadFile(orderItemsMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql := string(raw)
+	pos := func(stmt string) int {
+		i := strings.Index(sql, stmt)
+		if i < 0 {
+			t.Fatalf("migration does not contain %q", stmt)
+		}
+		return i
+	}
+
+	copyFromOrders := pos("FROM orders\n")
+	copyFromArchive := pos("FROM orders_archive;")
+	insertItems := pos("INSERT INTO order_items ")
+	insertHeaders := pos("INSERT INTO order_headers ")
+	dropOrders := pos("DROP TABLE orders;")
+	dropArchive := pos("DROP TABLE orders_archive;")
+	for _, backfill := range []int{copyFromOrders, copyFromArchive, insertItems, insertHeaders} {
+		if backfill > dropOrders || backfill > dropArchive {
+			t.Fatal("existing orders must be copied before the old tables are dropped")
+		}
+	}
+}
+
+// MIGRATION_TEST_DATABASE_URL (DATABASE_URL と同じ形式) に空のデータベースを指定した場合のみ実行する
+func TestOrderItemsMigrationBackfill(t *testing.T) {
+	dbURL := os.Getenv("MIGRATION_TEST_DATABASE_URL")
+	if dbURL == "" {
+		t.Skip("MIGRATION_TEST_DATABASE_URL is not set")
+	}
+	raw, err := os.ReadFile(orderItemsMigration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Open("mysql", dbURL+"?parseTime=True&multiStatements=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	// 16_order_version.sql まで適用した時点の注文関連のテーブル
+	setup := `
+        DROP VIEW IF EXISTS shipping_order_units;
+        DROP TABLE IF EXISTS order_unit_numbers, order_items_archive, order_items, order_headers, orders_archive, orders, products;
+        CREATE TABLE products (
+            product_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+            value INT UNSIGNED NOT NULL,
+            weight INT UNSIGNED NOT NULL
+        );
+        CREATE TABLE orders (
+            order_id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+            user_id INT UNSIGNED NOT NULL,
+            product_id INT UNSIGNED NOT NULL,
+            shipped_status VARCHAR(50) NOT NULL,
+            created_at DATETIME NOT NULL,
+            arrived_at DATETIME,
+            priority TINYINT UNSIGNED NOT NULL DEFAULT 0,
+            version BIGINT UNSIGNED NOT NULL DEFAULT 0
+        );
+        CREATE TABLE orders_archive LIKE orders;
+        INSERT INTO products (product_id, value, weight) VALUES (1, 100, 3), (2, 50, 1);
+        INSERT INTO orders (order_id, user_id, product_id, shipped_status, created_at, arrived_at, priority, version) VALUES
+            (1, 10, 1, 'shipping', '2025-01-01 00:00:00', NULL, 2, 0),
+            (2, 10, 2, 'delivering', '2025-01-02 00:00:00', NULL, 0, 1),
+            (3, 11, 1, 'completed', '2025-01-03 00:00:00', '2025-01-04 00:00:00', 0, 2);
+        INSERT INTO orders_archive (order_id, user_id, product_id, shipped_status, created_at, arrived_at, priority, version) VALUES
+            (4, 11, 2, 'completed', '2024-01-01 00:00:00', '2024-01-02 00:00:00', 0, 2);`
+	if _, err := db.ExecContext(ctx, setup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, string(raw)); err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+
+	var items []struct {
+		OrderItemID   int64  `db:"order_item_id"`
+		OrderHeaderID int64  `db:"order_header_id"`
+		UserID        int    `db:"user_id"`
+		Quantity      int    `db:"quantity"`
+		Priority      int    `db:"priority"`
+		Version       int64  `db:"version"`
+		ShippedStatus string `db:"shipped_status"`
+		Arrived       bool   `db:"arrived"`
+	}
+	if err := db.SelectContext(ctx, &items, `
+        SELECT order_item_id, order_header_id, user_id, quantity, priority, version, shipped_status, arrived_at IS NOT NULL AS arrived
+        FROM order_items ORDER BY order_item_id`); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		status  string
+		arrived bool
+	}{{"shipping", false}, {"delivering", false}, {"completed", true}, {"completed", true}}
+	if len(items) != len(want) {
+		t.Fatalf("order_items = %+v, want one item per existing order", items)
+	}
+	for i, item := range items {
+		id := int64(i + 1)
+		if item.OrderItemID != id || item.OrderHeaderID != id || item.Quantity != 1 ||
+			item.ShippedStatus != want[i].status || item.Arrived != want[i].arrived {
+			t.Errorf("item %d = %+v, want id kept, quantity 1, status %s", id, item, want[i].status)
+		}
+	}
+	if items[0].Priority != 2 || items[2].Version != 2 {
+		t.Errorf("items = %+v, want priority and version carried over", items)
+	}
+
+	var headers int
+	if err := db.GetContext(ctx, &headers, "SELECT COUNT(*) FROM order_headers"); err != nil || headers != 4 {
+		t.Fatalf("order_headers = %d, %v; want 4", headers, err)
+	}
+	var units []int64
+	if err := db.SelectContext(ctx, &units, "SELECT order_id FROM shipping_order_units"); err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0] != 1 {
+		t.Fatalf("shipping_order_units = %v, want only order 1", units)
+	}
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+// SELECT の結果として order_id だけを持つ行を dest に詰める
+func fillOrderIDs(dest any, ids ...int64) {
+	rows := reflect.ValueOf(dest).Elem()
+	for _, id := range ids {
+		row := reflect.New(rows.Type().Elem()).Elem()
+		row.FieldByName("OrderID").SetInt(id)
+		rows.Set(reflect.Append(rows, row))
+	}
+}
+
+func TestListOrdersKeyset(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       model.ListRequest
+		wantCond  string
+		wantOrder string
+		rows      []int64
+		want      []int64
+	}{
+		{
+			name:      "after asc",
+			req:       model.ListRequest{SortField: "order_id", SortOrder: "asc", AfterID: 10},
+			wantCond:  "o.order_item_id > ?",
+			wantOrder: "ASC",
+			rows:      []int64{11, 12},
+			want:      []int64{11, 12},
+		},
+		{
+			name:      "after desc",
+			req:       model.ListRequest{SortField: "order_id", SortOrder: "desc", AfterID: 10},
+			wantCond:  "o.order_item_id < ?",
+			wantOrder: "DESC",
+			rows:      []int64{9, 8},
+			want:      []int64{9, 8},
+		},
+		{
+			// 逆順で取得して並べ直す
+			name:      "before asc",
+			req:       model.ListRequest{SortField: "order_id", SortOrder: "asc", BeforeID: 10},
+			wantCond:  "o.order_item_id < ?",
+			wantOrder: "DESC",
+			rows:      []int64{9, 8},
+			want:      []int64{8, 9},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.PageSize = 2
+			tt.req.Offset = 20
+			var query string
+			var args []any
+			db := &fakeDB{
+				get: func(_ context.Context, dest any, _ string, _ ...any) error {
+					*dest.(*int) = 100
+					return nil
+				},
+				sel: func(_ context.Context, dest any, q string, a ...any) error {
+					query, args = q, a
+					fillOrderIDs(dest, tt.rows...)
+					return nil
+				},
+			}
+			orders, _, err := NewStore(db).OrderRepo.ListOrders(context.Background(), 1, tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(query, tt.wantCond) || !strings.Contains(query, "o.order_item_id "+tt.wantOrder) {
+				t.Errorf("query = %s, want %q ordered %s", query, tt.wantCond, tt.wantOrder)
+			}
+			// キーセットでは OFFSET を使わない
+			if args[len(args)-1] != 0 {
+				t.Errorf("offset = %v, want 0", args[len(args)-1])
+			}
+			got := make([]int64, len(orders))
+			for i, o := range orders {
+				got[i] = o.OrderID
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("order ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
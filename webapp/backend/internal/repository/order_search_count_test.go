@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestSearchCountIsCachedPerUser(t *testing.T) {
+	counts := 0
+	db := &fakeDB{get: func(_ context.Context, dest any, q string, _ ...any) error {
+		if strings.Contains(q, "LIKE") {
+			counts++
+		}
+		*dest.(*int) = 0
+		return nil
+	}}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+	list := func(userID int, req model.ListRequest) {
+		t.Helper()
+		if _, _, err := repo.ListOrders(ctx, userID, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list(1, model.ListRequest{Search: "Robot"})
+	list(1, model.ListRequest{Search: " robot "})
+	if counts != 1 {
+		t.Fatalf("counts = %d, want the normalized search to hit the cache", counts)
+	}
+	list(1, model.ListRequest{Search: "robot", Type: "prefix"})
+	if counts != 2 {
+		t.Fatalf("counts = %d, want prefix search cached separately", counts)
+	}
+	// 他の絞り込みと組み合わせた場合はキャッシュしない
+	list(1, model.ListRequest{Search: "robot", Statuses: []string{"shipping"}})
+	list(1, model.ListRequest{Search: "robot", Statuses: []string{"shipping"}})
+	if counts != 4 {
+		t.Fatalf("counts = %d, want combined filters to bypass the cache", counts)
+	}
+
+	list(2, model.ListRequest{Search: "robot"})
+	repo.onUpdateOrders(1)
+	list(1, model.ListRequest{Search: "robot"})
+	list(2, model.ListRequest{Search: "robot"})
+	if counts != 6 {
+		t.Fatalf("counts = %d, want only user 1's entries invalidated", counts)
+	}
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 日付で GROUP BY した結果行 (日付, 進捗ごとの個数 4 つ, 進捗ごとの金額 4 つ) を dest に詰める
+func fillStatsRows(dest any, rows ...[9]any) {
+	fields := []string{"Day", "Shipping", "Delivering", "Completed", "Returned", "ShippingValue", "DeliveringValue", "CompletedValue", "ReturnedValue"}
+	v := reflect.ValueOf(dest).Elem()
+	for _, r := range rows {
+		row := reflect.New(v.Type().Elem()).Elem()
+		row.FieldByName(fields[0]).SetString(r[0].(string))
+		for i, name := range fields[1:] {
+			row.FieldByName(name).SetInt(int64(r[i+1].(int)))
+		}
+		v.Set(reflect.Append(v, row))
+	}
+}
+
+func TestGetOrderStats(t *testing.T) {
+	today := time.Now().Format(time.DateOnly)
+	yesterday := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	queries := 0
+	db := &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		queries++
+		fillStatsRows(dest,
+			[9]any{today, 2, 1, 0, 0, 300, 50, 0, 0},
+			[9]any{yesterday, 1, 0, 0, 0, 100, 0, 0, 0},
+			[9]any{"2000-01-01", 0, 0, 5, 1, 0, 0, 500, 20},
+		)
+		return nil
+	}}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+
+	stats, err := repo.GetOrderStats(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]int{"shipping": {3, 400}, "delivering": {1, 50}, "completed": {5, 500}, "returned": {1, 20}}
+	if len(stats.ByStatus) != 4 {
+		t.Fatalf("by_status = %+v, want all four statuses", stats.ByStatus)
+	}
+	for _, s := range stats.ByStatus {
+		if w := want[s.ShippedStatus]; s.Count != w[0] || s.TotalValue != w[1] {
+			t.Errorf("%s = %d, %d; want %v", s.ShippedStatus, s.Count, s.TotalValue, w)
+		}
+	}
+	if len(stats.Daily) != orderStatsDays {
+		t.Fatalf("daily has %d days, want %d", len(stats.Daily), orderStatsDays)
+	}
+	last, prev := stats.Daily[orderStatsDays-1], stats.Daily[orderStatsDays-2]
+	if last.Date != today || last.Count != 3 || prev.Date != yesterday || prev.Count != 1 {
+		t.Fatalf("daily tail = %+v, %+v; want today 3, yesterday 1", prev, last)
+	}
+
+	if _, err := repo.GetOrderStats(ctx, 1); err != nil || queries != 1 {
+		t.Fatalf("queries = %d, %v; want the second call served from cache", queries, err)
+	}
+	repo.onUpdateOrders(1)
+	if _, err := repo.GetOrderStats(ctx, 1); err != nil || queries != 2 {
+		t.Fatalf("queries = %d, %v; want the cache rebuilt after the user's orders change", queries, err)
+	}
+}
+
+func TestUpdateStatusesEvictsStatsOfAffectedUsersAfterCommit(t *testing.T) {
+	var userQueries []string
+	db := &fakeDB{
+		sel: func(_ context.Context, dest any, query string, _ ...any) error {
+			if uids, ok := dest.(*[]int); ok {
+				userQueries = append(userQueries, query)
+				*uids = []int{1}
+				return nil
+			}
+			fillStatsRows(dest, [9]any{time.Now().Format(time.DateOnly), 1, 0, 0, 0, 100, 0, 0, 0})
+			return nil
+		},
+		exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) }),
+	}
+	hooks := &commitHooks{}
+	repo := newOrderRepository(db, &orderRepoState{}, hooks)
+	ctx := context.Background()
+	for _, uid := range []int{1, 2} {
+		if _, err := repo.GetOrderStats(ctx, uid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	targets := []model.OrderVersion{{OrderID: 10, Version: AnyVersion, Quantity: 1}}
+	if err := repo.UpdateStatuses(ctx, targets, "delivering"); err != nil {
+		t.Fatal(err)
+	}
+	if len(userQueries) != 1 || !strings.Contains(userQueries[0], "user_id") {
+		t.Fatalf("queries = %q, want the owners of the updated items looked up", userQueries)
+	}
+	if !repo.state.statsByUser.Contains(1) {
+		t.Fatal("stats evicted before commit")
+	}
+	hooks.run()
+	if repo.state.statsByUser.Contains(1) {
+		t.Fatal("stats of the updated user are still cached after commit")
+	}
+	if !repo.state.statsByUser.Contains(2) {
+		t.Fatal("stats of an unaffected user were evicted")
+	}
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestForEachShippingOrderStreamsRows(t *testing.T) {
+	db, rec := newRecordingDB()
+	rec.query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"order_id", "priority", "weight", "value"}, [][]driver.Value{
+			{int64(1), int64(0), int64(3), int64(10)},
+			{int64(2), int64(1), int64(5), int64(20)},
+			{int64(3), int64(0), int64(1), int64(5)},
+		}, nil
+	}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+
+	var got []model.Order
+	if err := repo.ForEachShippingOrder(ctx, "", func(o model.Order) error {
+		got = append(got, o)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := []model.Order{
+		{OrderID: 1, Weight: 3, Value: 10},
+		{OrderID: 2, Priority: 1, Weight: 5, Value: 20},
+		{OrderID: 3, Weight: 1, Value: 5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("streamed %+v, want %+v", got, want)
+	}
+
+	// fn のエラーで打ち切る
+	stop := errors.New("stop")
+	calls := 0
+	err := repo.ForEachShippingOrder(ctx, "", func(model.Order) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatalf("err = %v after %d calls, want stop after 1", err, calls)
+	}
+
+	// 逐次読み込みではキャッシュを作らない
+	repo.state.mu.RLock()
+	cached := repo.state.shippingOrdersCache
+	repo.state.mu.RUnlock()
+	if cached != nil {
+		t.Fatalf("streaming populated the cache: %+v", cached)
+	}
+}
+
+func TestForEachShippingOrderUsesCache(t *testing.T) {
+	cached := []model.Order{{OrderID: 1, Weight: 1, Value: 1}, {OrderID: 2, Weight: 2, Value: 2}}
+	db := &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		*dest.(*[]model.Order) = cached
+		return nil
+	}}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+	if _, err := repo.GetShippingOrders(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// キャッシュがあれば QueryxContext (未設定ならエラー) を呼ばない
+	var ids []int64
+	if err := repo.ForEachShippingOrder(ctx, "", func(o model.Order) error {
+		ids = append(ids, o.OrderID)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Fatalf("ids = %v, want [1 2]", ids)
+	}
+}
@@ -0,0 +1,541 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func newTestOrderRepository(db DBTX) *OrderRepository {
+	return newOrderRepository(db, &orderRepoState{}, nil)
+}
+
+func TestStatusUpdateQuery(t *testing.T) {
+	targets := []model.OrderVersion{{OrderID: 1, Version: 3, Quantity: 2}, {OrderID: 2, Version: 0, Quantity: 1}}
+
+	query, args := statusUpdateQuery(targets, "delivering", true)
+	want := "UPDATE order_items o JOIN (SELECT ? AS order_item_id, ? AS version, ? AS quantity UNION ALL SELECT ?, ?, ?) v ON o.order_item_id = v.order_item_id AND o.version = v.version AND o.dispatched_quantity + v.quantity <= o.quantity SET o.dispatched_quantity = o.dispatched_quantity + v.quantity, o.version = o.version + 1"
+	if query != want {
+		t.Fatalf("query = %q", query)
+	}
+	wantArgs := []any{int64(1), int64(3), 2, int64(2), int64(0), 1}
+	if len(args) != len(wantArgs) {
+		t.Fatalf("args = %v, want %v", args, wantArgs)
+	}
+	for i := range wantArgs {
+		if args[i] != wantArgs[i] {
+			t.Fatalf("args[%d] = %v, want %v", i, args[i], wantArgs[i])
+		}
+	}
+
+	query, _ = statusUpdateQuery(targets, "completed", false)
+	if strings.Contains(query, "o.version = v.version") {
+		t.Errorf("unversioned update must not check version: %q", query)
+	}
+	if !strings.Contains(query, "o.completed_quantity + v.quantity <= o.dispatched_quantity") {
+		t.Errorf("completed update must be bounded by dispatched units: %q", query)
+	}
+}
+
+func TestMergeOrderTargets(t *testing.T) {
+	got := mergeOrderTargets([]model.OrderVersion{
+		{OrderID: 1, Version: 2, Quantity: 1},
+		{OrderID: 2, Version: 5, Quantity: 1},
+		{OrderID: 1, Version: 2, Quantity: 1},
+		{OrderID: 1, Version: 2, Quantity: 3},
+	})
+	want := []model.OrderVersion{{OrderID: 1, Version: 2, Quantity: 5}, {OrderID: 2, Version: 5, Quantity: 1}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+// 導出表の行数 (= 明細数) を返す
+func derivedRows(args []any) int64 { return int64(len(args) / 3) }
+
+func TestUpdateStatusesChunksVersionedTargets(t *testing.T) {
+	defer func(n int) { OrderStatusUpdateChunkSize = n }(OrderStatusUpdateChunkSize)
+	OrderStatusUpdateChunkSize = 2
+
+	db := &fakeDB{exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) })}
+	repo := newTestOrderRepository(db)
+	targets := []model.OrderVersion{{OrderID: 1, Version: 1, Quantity: 1}, {OrderID: 2, Version: 1, Quantity: 1}, {OrderID: 3, Version: 1, Quantity: 1}}
+	if err := repo.UpdateStatuses(context.Background(), targets, "delivering"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	if len(db.calls) != 2 {
+		t.Fatalf("exec calls = %d, want 2", len(db.calls))
+	}
+}
+
+func TestUpdateStatusesMergesUnitsOfSameItem(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) })}
+	repo := newTestOrderRepository(db)
+	// 配送計画で同じ明細から 3 個選んだ場合
+	targets := []model.OrderVersion{{OrderID: 7, Version: 4, Quantity: 1}, {OrderID: 7, Version: 4, Quantity: 1}, {OrderID: 7, Version: 4, Quantity: 1}}
+	if err := repo.UpdateStatuses(context.Background(), targets, "delivering"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	if len(db.calls) != 1 {
+		t.Fatalf("exec calls = %d, want 1", len(db.calls))
+	}
+	if args := db.calls[0].args; len(args) != 3 || args[2] != 3 {
+		t.Fatalf("args = %v, want a single row with quantity 3", args)
+	}
+}
+
+func TestUpdateStatusesConflictCountsOnlyVersionedTargets(t *testing.T) {
+	// AnyVersion の明細が何件あっても、バージョン指定の件数だけで競合を判定する
+	db := &fakeDB{exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) })}
+	repo := newTestOrderRepository(db)
+	targets := []model.OrderVersion{{OrderID: 1, Version: AnyVersion, Quantity: 1}, {OrderID: 2, Version: AnyVersion, Quantity: 1}, {OrderID: 3, Version: 5, Quantity: 1}}
+	if err := repo.UpdateStatuses(context.Background(), targets, "delivering"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	if len(db.calls) != 2 {
+		t.Fatalf("exec calls = %d, want 2", len(db.calls))
+	}
+}
+
+func TestUpdateStatusesVersionConflict(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(string, []any) int64 { return 1 })}
+	repo := newTestOrderRepository(db)
+	targets := []model.OrderVersion{{OrderID: 1, Version: 2, Quantity: 1}, {OrderID: 2, Version: 7, Quantity: 1}}
+	err := repo.UpdateStatuses(context.Background(), targets, "delivering")
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("err = %v, want ErrVersionConflict", err)
+	}
+}
+
+func TestUpdateStatusesInsufficientQuantity(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(string, []any) int64 { return 0 })}
+	repo := newTestOrderRepository(db)
+	targets := []model.OrderVersion{{OrderID: 1, Version: AnyVersion, Quantity: 1}}
+	err := repo.UpdateStatuses(context.Background(), targets, "completed")
+	if !errors.Is(err, ErrInsufficientQuantity) {
+		t.Fatalf("err = %v, want ErrInsufficientQuantity", err)
+	}
+}
+
+func TestUpdateStatusesSetsArrivedAtOnCompletion(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) })}
+	repo := newTestOrderRepository(db)
+	targets := []model.OrderVersion{{OrderID: 1, Version: AnyVersion, Quantity: 1}}
+
+	if err := repo.UpdateStatuses(context.Background(), targets, "completed"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	if len(db.calls) != 2 || !strings.Contains(db.calls[1].query, "arrived_at = NOW()") {
+		t.Fatalf("completed update must record arrived_at: %v", db.calls)
+	}
+
+	db.calls = nil
+	if err := repo.UpdateStatuses(context.Background(), targets, "delivering"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	for _, call := range db.calls {
+		if strings.Contains(call.query, "arrived_at") {
+			t.Errorf("delivering update must not touch arrived_at: %q", call.query)
+		}
+	}
+}
+
+func TestUpdateStatusesInvalidatesCacheAfterCommit(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) })}
+	hooks := &commitHooks{}
+	repo := newOrderRepository(db, &orderRepoState{}, hooks)
+	targets := []model.OrderVersion{{OrderID: 1, Version: AnyVersion, Quantity: 1}}
+
+	if err := repo.UpdateStatuses(context.Background(), targets, "delivering"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	if v, _ := repo.GetShippingOrdersVersion(context.Background()); v != 0 {
+		t.Fatalf("version = %d before commit, want 0", v)
+	}
+	hooks.run()
+	if v, _ := repo.GetShippingOrdersVersion(context.Background()); v != 1 {
+		t.Fatalf("version = %d after commit, want 1", v)
+	}
+}
+
+func TestUpdateStatusesOutsideTxInvalidatesImmediately(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(_ string, args []any) int64 { return derivedRows(args) })}
+	repo := newTestOrderRepository(db)
+	targets := []model.OrderVersion{{OrderID: 1, Version: AnyVersion, Quantity: 1}}
+
+	if err := repo.UpdateStatuses(context.Background(), targets, "delivering"); err != nil {
+		t.Fatalf("UpdateStatuses: %v", err)
+	}
+	if v, _ := repo.GetShippingOrdersVersion(context.Background()); v != 1 {
+		t.Fatalf("version = %d, want 1", v)
+	}
+}
+
+// 件数は count (0 なら 1 件) として、件数と一覧のクエリを記録する DBTX
+type listOrdersDB struct {
+	*fakeDB
+	count   int
+	gets    []string
+	selects []string
+}
+
+func newListOrdersDB(count int) *listOrdersDB {
+	db := &listOrdersDB{count: count}
+	db.fakeDB = &fakeDB{
+		get: func(_ context.Context, dest any, query string, _ ...any) error {
+			db.gets = append(db.gets, query)
+			if n, ok := dest.(*int); ok {
+				*n = max(db.count, 1)
+			}
+			return nil
+		},
+		sel: func(_ context.Context, _ any, query string, _ ...any) error {
+			db.selects = append(db.selects, query)
+			return nil
+		},
+	}
+	return db
+}
+
+func TestListOrdersSkipsProductJoinForSparseFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      model.ListRequest
+		wantJoin bool
+	}{
+		{"all fields", model.ListRequest{}, true},
+		{"status board", model.ListRequest{Fields: []string{"order_id", "shipped_status"}}, false},
+		{"product name requested", model.ListRequest{Fields: []string{"order_id", "product_name"}}, true},
+		{"sorted by product name", model.ListRequest{Fields: []string{"order_id"}, SortField: "product_name"}, true},
+		{"searched by product name", model.ListRequest{Fields: []string{"order_id"}, Search: "りんご"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newListOrdersDB(0)
+			repo := newTestOrderRepository(db)
+			tt.req.PageSize = 20
+			if _, _, err := repo.ListOrders(context.Background(), 1, tt.req); err != nil {
+				t.Fatalf("ListOrders: %v", err)
+			}
+			if len(db.selects) != 1 {
+				t.Fatalf("select calls = %d, want 1", len(db.selects))
+			}
+			if got := strings.Contains(db.selects[0], "JOIN products"); got != tt.wantJoin {
+				t.Fatalf("join products = %v, want %v: %s", got, tt.wantJoin, db.selects[0])
+			}
+		})
+	}
+}
+
+// 配送中一覧の読み込みを数え、release が閉じられるまで待たせる DBTX
+type shippingOrdersDB struct {
+	*fakeDB
+	mu      sync.Mutex
+	selects int
+	started chan struct{}
+	release chan struct{}
+}
+
+func newShippingOrdersDB() *shippingOrdersDB {
+	db := &shippingOrdersDB{started: make(chan struct{}, 8), release: make(chan struct{})}
+	db.fakeDB = &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		db.mu.Lock()
+		db.selects++
+		db.mu.Unlock()
+		db.started <- struct{}{}
+		<-db.release
+		*dest.(*[]model.Order) = []model.Order{{OrderID: 2}}
+		return nil
+	}}
+	return db
+}
+
+func (f *shippingOrdersDB) selectCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.selects
+}
+
+func TestGetShippingOrdersCoalescesRefresh(t *testing.T) {
+	db := newShippingOrdersDB()
+	repo := newTestOrderRepository(db)
+
+	const callers = 5
+	var wg sync.WaitGroup
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repo.GetShippingOrders(context.Background(), ""); err != nil {
+				t.Errorf("GetShippingOrders: %v", err)
+			}
+		}()
+	}
+	<-db.started
+	close(db.release)
+	wg.Wait()
+
+	if n := db.selectCount(); n != 1 {
+		t.Fatalf("selects = %d, want 1", n)
+	}
+}
+
+func TestGetShippingOrdersServesStaleWhileRefreshing(t *testing.T) {
+	defer func(d time.Duration) { ShippingOrdersMaxStaleness = d }(ShippingOrdersMaxStaleness)
+	ShippingOrdersMaxStaleness = time.Minute
+
+	db := newShippingOrdersDB()
+	repo := newTestOrderRepository(db)
+	repo.state.shippingOrdersCache = []model.Order{{OrderID: 1}}
+	repo.invalidateProgress(nil)
+
+	orders, err := repo.GetShippingOrders(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].OrderID != 1 {
+		t.Fatalf("orders = %v, want the stale snapshot", orders)
+	}
+
+	<-db.started
+	close(db.release)
+	// 裏で読み直しが終わるのを待つ
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		repo.state.mu.RLock()
+		refreshed := repo.state.shippingOrdersCache != nil
+		repo.state.mu.RUnlock()
+		if refreshed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("refresh did not finish")
+		}
+	}
+	orders, err = repo.GetShippingOrders(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].OrderID != 2 {
+		t.Fatalf("orders = %v, want the refreshed list", orders)
+	}
+}
+
+func TestGetShippingOrdersWaitsWhenStaleTooOld(t *testing.T) {
+	defer func(d time.Duration) { ShippingOrdersMaxStaleness = d }(ShippingOrdersMaxStaleness)
+	ShippingOrdersMaxStaleness = time.Minute
+
+	db := newShippingOrdersDB()
+	repo := newTestOrderRepository(db)
+	repo.state.shippingOrdersStale = []model.Order{{OrderID: 1}}
+	repo.state.shippingOrdersStaleAt = time.Now().Add(-time.Hour)
+	close(db.release)
+
+	orders, err := repo.GetShippingOrders(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].OrderID != 2 {
+		t.Fatalf("orders = %v, want the refreshed list", orders)
+	}
+}
+
+type memoryShippingOrdersVersionStore struct {
+	mu  sync.Mutex
+	v   int64
+	err error
+}
+
+func (s *memoryShippingOrdersVersionStore) Current(context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.v, s.err
+}
+
+func (s *memoryShippingOrdersVersionStore) Bump(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.v++
+	return s.err
+}
+
+func TestGetShippingOrdersFollowsSharedVersion(t *testing.T) {
+	shared := &memoryShippingOrdersVersionStore{}
+	newInstance := func() (*OrderRepository, *shippingOrdersDB) {
+		db := newShippingOrdersDB()
+		close(db.release)
+		return newOrderRepository(db, &orderRepoState{sharedVersion: shared}, nil), db
+	}
+	a, _ := newInstance()
+	b, bDB := newInstance()
+
+	if _, err := b.GetShippingOrders(context.Background(), ""); err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if _, err := b.GetShippingOrders(context.Background(), ""); err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if n := bDB.selectCount(); n != 1 {
+		t.Fatalf("selects = %d, want 1 (cached)", n)
+	}
+
+	// 別インスタンスでの更新
+	a.invalidateProgress(nil)
+	if _, err := b.GetShippingOrders(context.Background(), ""); err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if n := bDB.selectCount(); n != 2 {
+		t.Fatalf("selects = %d, want 2 (reloaded after shared bump)", n)
+	}
+}
+
+func TestGetShippingOrdersDoesNotTrustCacheWithoutSharedVersion(t *testing.T) {
+	shared := &memoryShippingOrdersVersionStore{}
+	db := newShippingOrdersDB()
+	close(db.release)
+	repo := newOrderRepository(db, &orderRepoState{sharedVersion: shared}, nil)
+
+	if _, err := repo.GetShippingOrders(context.Background(), ""); err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	shared.mu.Lock()
+	shared.err = errors.New("redis down")
+	shared.mu.Unlock()
+	if _, err := repo.GetShippingOrders(context.Background(), ""); err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if n := db.selectCount(); n != 2 {
+		t.Fatalf("selects = %d, want 2", n)
+	}
+}
+
+func TestListOrdersApproximateTotal(t *testing.T) {
+	db := newListOrdersDB(model.ApproximateTotalLimit + 1)
+	repo := newTestOrderRepository(db)
+	req := model.ListRequest{PageSize: 20, ApproximateTotal: true}
+
+	_, total, err := repo.ListOrders(context.Background(), 1, req)
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if total != model.ApproximateTotalLimit+1 {
+		t.Fatalf("total = %d, want %d", total, model.ApproximateTotalLimit+1)
+	}
+	if len(db.gets) != 1 || !strings.Contains(db.gets[0], "LIMIT 10001") {
+		t.Fatalf("count query must be capped: %v", db.gets)
+	}
+
+	// 打ち切った件数はキャッシュしないので、正確な件数を求めたら数え直す
+	req.ApproximateTotal = false
+	if _, _, err := repo.ListOrders(context.Background(), 1, req); err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if len(db.gets) != 2 || strings.Contains(db.gets[1], "LIMIT") {
+		t.Fatalf("exact count must be queried without a cap: %v", db.gets)
+	}
+}
+
+func TestExcludeBeforeDeliveryWindow(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	open := []model.Order{{OrderID: 1}, {OrderID: 2, DeliverAfter: &earlier}}
+	if got := excludeBeforeDeliveryWindow(open, now); &got[0] != &open[0] {
+		t.Fatal("list without excluded orders must be returned as is")
+	}
+
+	orders := []model.Order{{OrderID: 1}, {OrderID: 2, DeliverAfter: &later}, {OrderID: 3, DeliverAfter: &earlier}}
+	got := excludeBeforeDeliveryWindow(orders, now)
+	if len(got) != 2 || got[0].OrderID != 1 || got[1].OrderID != 3 {
+		t.Fatalf("orders = %v, want 1 and 3", got)
+	}
+	if len(excludeBeforeDeliveryWindow(orders, now.Add(2*time.Hour))) != 3 {
+		t.Fatal("orders whose window opens within the horizon must be kept")
+	}
+}
+
+func TestShippingOrdersSelectIncludesDestination(t *testing.T) {
+	defer func(enabled bool) { OrderDestinationEnabled = enabled }(OrderDestinationEnabled)
+	OrderDestinationEnabled = false
+	if strings.Contains(shippingOrdersSelect(), "dest_latitude") {
+		t.Fatal("destination columns must not be read unless enabled")
+	}
+	OrderDestinationEnabled = true
+	if q := shippingOrdersSelect(); !strings.Contains(q, "dest_latitude") || !strings.Contains(q, "dest_longitude") {
+		t.Fatalf("query = %q, want destination columns", q)
+	}
+}
+
+func TestShippingOrdersSelectIncludesVolume(t *testing.T) {
+	defer func(enabled bool) { ProductVolumeEnabled = enabled }(ProductVolumeEnabled)
+	ProductVolumeEnabled = false
+	if strings.Contains(shippingOrdersSelect(), "volume") || strings.Contains(productColumns(), "volume") {
+		t.Fatal("volume must not be read unless enabled")
+	}
+	ProductVolumeEnabled = true
+	if !strings.Contains(shippingOrdersSelect(), ", volume") || !strings.Contains(productColumns(), "volume") {
+		t.Fatalf("query = %q, want volume", shippingOrdersSelect())
+	}
+}
+
+func TestGetShippingOrdersFiltersZone(t *testing.T) {
+	north, south := "north", "south"
+	repo := newTestOrderRepository(&fakeDB{})
+	repo.state.shippingOrdersCache = []model.Order{{OrderID: 1, Zone: &north}, {OrderID: 2, Zone: &south}, {OrderID: 3}}
+
+	orders, err := repo.GetShippingOrders(context.Background(), "north")
+	if err != nil {
+		t.Fatalf("GetShippingOrders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].OrderID != 1 {
+		t.Fatalf("orders = %+v, want only the north order", orders)
+	}
+	if orders, _ := repo.GetShippingOrders(context.Background(), ""); len(orders) != 3 {
+		t.Fatalf("got %d orders without a zone, want all 3", len(orders))
+	}
+	if len(repo.state.shippingOrdersCache) != 3 {
+		t.Fatal("filtering by zone must not modify the cache")
+	}
+}
+
+func TestEstimatedArrivalsQuery(t *testing.T) {
+	at := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+	etas := map[int64]time.Time{1: at, 2: at.Add(time.Minute)}
+	query, args := estimatedArrivalsQuery([]int64{1, 2}, etas)
+	want := "UPDATE order_items o JOIN (SELECT ? AS order_item_id, ? AS eta UNION ALL SELECT ?, ?) v ON o.order_item_id = v.order_item_id SET o.estimated_arrival_at = v.eta"
+	if query != want {
+		t.Fatalf("query = %q", query)
+	}
+	if len(args) != 4 || args[0] != int64(1) || args[3] != at.Add(time.Minute) {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestShippingOrdersChangedClosesOnInvalidate(t *testing.T) {
+	repo := newTestOrderRepository(&fakeDB{})
+	changed := repo.ShippingOrdersChanged()
+	select {
+	case <-changed:
+		t.Fatal("closed before any update")
+	default:
+	}
+	repo.invalidateProgress(nil)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not closed after the version was bumped")
+	}
+	if repo.ShippingOrdersChanged() == changed {
+		t.Fatal("a new channel must be returned for the next update")
+	}
+}
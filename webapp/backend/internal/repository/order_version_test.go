@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestUpdateStatusesChecksVersion(t *testing.T) {
+	var queries []string
+	affected := int64(2)
+	db := &fakeDB{exec: func(_ context.Context, query string, _ ...any) (sql.Result, error) {
+		queries = append(queries, query)
+		return driver.RowsAffected(affected), nil
+	}}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+
+	targets := []model.OrderVersion{{OrderID: 1, Version: 3, Quantity: 1}, {OrderID: 2, Version: 4, Quantity: 2}}
+	if err := repo.UpdateStatuses(ctx, targets, "delivering"); err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 || !strings.Contains(queries[0], "version") {
+		t.Fatalf("queries = %q, want one UPDATE that checks and bumps version", queries)
+	}
+
+	// 更新できなかった注文があれば競合
+	affected = 1
+	if err := repo.UpdateStatuses(ctx, targets, "delivering"); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("err = %v, want ErrVersionConflict", err)
+	}
+
+	// バージョンを確認しない場合は、遷移元の数量が足りずに更新できなかったことになる
+	affected = 0
+	anyVersion := []model.OrderVersion{{OrderID: 1, Version: AnyVersion, Quantity: 1}}
+	if err := repo.UpdateStatuses(ctx, anyVersion, "completed"); !errors.Is(err, ErrInsufficientQuantity) {
+		t.Fatalf("err = %v, want ErrInsufficientQuantity without a version check", err)
+	}
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestListOrdersWindowCount(t *testing.T) {
+	OrderListWindowCount = true
+	t.Cleanup(func() { OrderListWindowCount = false })
+
+	counts := 0
+	var listQuery string
+	rowsToReturn := 2
+	db := &fakeDB{
+		get: func(_ context.Context, dest any, _ string, _ ...any) error {
+			counts++
+			*dest.(*int) = 42
+			return nil
+		},
+		sel: func(_ context.Context, dest any, q string, _ ...any) error {
+			listQuery = q
+			v := reflect.ValueOf(dest).Elem()
+			for i := range rowsToReturn {
+				row := reflect.New(v.Type().Elem()).Elem()
+				row.FieldByName("OrderID").SetInt(int64(i + 1))
+				row.FieldByName("TotalCount").SetInt(42)
+				v.Set(reflect.Append(v, row))
+			}
+			return nil
+		},
+	}
+	repo := NewStore(db).OrderRepo
+	ctx := context.Background()
+
+	_, total, err := repo.ListOrders(ctx, 1, model.ListRequest{Search: "robot", PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 42 || counts != 0 || !strings.Contains(listQuery, "COUNT(*) OVER()") {
+		t.Fatalf("total = %d, counts = %d; want the total from the window function without a COUNT query", total, counts)
+	}
+	// 数えた件数はキャッシュされる
+	if _, total, _ := repo.ListOrders(ctx, 1, model.ListRequest{Search: "robot", PageSize: 2}); total != 42 || strings.Contains(listQuery, "OVER()") {
+		t.Fatalf("total = %d, query = %s; want the cached total", total, listQuery)
+	}
+
+	// 範囲外のページでは数え直す
+	rowsToReturn = 0
+	if _, total, _ := repo.ListOrders(ctx, 1, model.ListRequest{Search: "other", PageSize: 2, Offset: 100}); total != 42 || counts != 1 {
+		t.Fatalf("total = %d, counts = %d; want a COUNT query for an empty page", total, counts)
+	}
+
+	// キーセットでは COUNT(*) OVER() が件数にならない
+	rowsToReturn = 2
+	if _, _, err := repo.ListOrders(ctx, 2, model.ListRequest{SortField: "order_id", AfterID: 5, PageSize: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if counts != 2 || strings.Contains(listQuery, "OVER()") {
+		t.Fatalf("counts = %d, query = %s; want a separate COUNT for keyset pages", counts, listQuery)
+	}
+}
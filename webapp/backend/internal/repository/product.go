@@ -3,35 +3,216 @@ package repository
 import (
 	"backend/internal/model"
 	"context"
+	"database/sql"
 	"fmt"
 	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/jmoiron/sqlx"
 	"github.com/samber/lo"
 	"log"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
+// 商品一覧はプロセス内にキャッシュせず毎回 DB から読む (説明文・画像パスをヒープに持たない)
+// プロセス内に持つのは絞り込みごとの件数と、よく読まれるページの商品 ID の並びだけで、
+// どちらも件数と ProductListCacheMaxBytes で上限を決める
 var ProductListCountCacheSize = 64
 
+// 件数とページのキャッシュを合わせた大きさ (キーと値の見積もり) の上限 (0 なら件数だけで上限を決める)
+// 超えたらページ、次に件数の古いものから追い出す
+var ProductListCacheMaxBytes = 1 << 20
+
+// 商品一覧のページ (絞り込み・並び順・ページ位置) ごとに商品 ID の並びを覚えておく件数
+// 商品の行は毎回主キーで読むので、キャッシュするのは絞り込みと並べ替えの結果だけ
+// 複数台で動かす場合、他のインスタンスでの価格・is_active の変更は反映されない (0 ならキャッシュしない)
+var ProductListPageCacheSize = 256
+
+// 商品一覧の検索に FULLTEXT (ngram) インデックスを使うか
+// 22_products_search_fulltext.sql を適用している場合のみ有効にする
+// 有効なら空白区切りの各語をすべて含む商品に絞り、sort_field に relevance を指定すると関連度順に並べる
+var ProductSearchFullText = false
+
+// 商品名で並べるときのロケール (PRODUCT_NAME_SORT_LOCALE)
+// 空なら列の照合順序 (utf8mb4_0900_ai_ci) でインデックス順に読む。指定するとインデックスを使えずソートするので遅くなる
+var ProductNameSortLocale = ""
+
+// 対応するロケールと MySQL の照合順序 (列名と同じく SQL に埋め込むので、この表にあるものだけを使う)
+var productNameCollations = map[string]string{
+	"ja": "utf8mb4_ja_0900_as_cs", // ひらがなとカタカナ、清音と濁音を区別して五十音順
+	"zh": "utf8mb4_zh_0900_as_cs", // ピンイン順
+}
+
+// ProductNameSortLocale に対応しているか (空は列の照合順序)
+func ValidProductNameSortLocale(locale string) bool {
+	_, ok := productNameCollations[locale]
+	return locale == "" || ok
+}
+
+// あいまい検索 (ListRequest.Fuzzy) で一致とみなす関連度の下限 (MATCH ... IN NATURAL LANGUAGE MODE の値)
+// 関連度は語の出現頻度で変わるので、データに合わせて調整する
+var ProductSearchFuzzyMinScore = 1.0
+
 type productRepoState struct {
 	once           sync.Once
-	listCountCache *lru.Cache[string, int]
+	listCountCache *lru.Cache[string, productListCount]
+	listPageCache  *lru.Cache[productListPageKey, productListPage] // ProductListPageCacheSize が 0 なら nil
+
+	// キャッシュへの追加と上限による追い出しをまとめる
+	listCacheMu sync.Mutex
+	// 件数とページのキャッシュの大きさの見積もりと、上限で追い出した数 (無効化で消したものは含まない)
+	listCacheBytes, listCacheEvictions atomic.Int64
+
+	images ImageStore
 }
 
-func (s *productRepoState) initListCountCache() *lru.Cache[string, int] {
+func (s *productRepoState) init() {
 	s.once.Do(func() {
-		s.listCountCache = lo.Must(lru.New[string, int](ProductListCountCacheSize))
+		s.listCountCache = lo.Must(lru.NewWithEvict(ProductListCountCacheSize, func(key string, v productListCount) {
+			s.listCacheBytes.Add(-productListCountSize(key, v))
+		}))
+		if ProductListPageCacheSize > 0 {
+			s.listPageCache = lo.Must(lru.NewWithEvict(ProductListPageCacheSize, func(key productListPageKey, v productListPage) {
+				s.listCacheBytes.Add(-productListPageSize(key, v))
+			}))
+		}
 	})
-	return s.listCountCache
+}
+
+// 商品一覧のキャッシュの大きさと追い出した数
+type ProductListCacheStats struct {
+	CountEntries int   `json:"count_entries"`
+	PageEntries  int   `json:"page_entries"`
+	Bytes        int64 `json:"bytes"` // キーと値の見積もり
+	MaxBytes     int64 `json:"max_bytes"`
+	Evictions    int64 `json:"evictions"` // 件数・大きさの上限で追い出した数
+}
+
+// エントリごとの管理用の領域 (LRU のリストとマップの要素) の見積もり
+const productListCacheEntryOverhead = 96
+
+func (f productListFilter) size() int64 {
+	return int64(len(f.search)) + 4*8
+}
+
+func productListCountSize(key string, v productListCount) int64 {
+	return productListCacheEntryOverhead + int64(len(key)) + v.filter.size() + 8
+}
+
+func productListPageSize(key productListPageKey, v productListPage) int64 {
+	return productListCacheEntryOverhead + int64(len(key.filter)+len(key.orderBy)) + 2*8 + v.filter.size() + int64(8*len(v.ids))
+}
+
+// キャッシュに追加し、大きさの上限を超えたら古いものから追い出す
+// 同じキーを置き換える場合は、前の値を除いてから数え直す
+func (s *productRepoState) addListCount(key string, v productListCount) {
+	s.listCacheMu.Lock()
+	defer s.listCacheMu.Unlock()
+	s.listCountCache.Remove(key)
+	if s.listCountCache.Add(key, v) {
+		s.listCacheEvictions.Add(1)
+	}
+	s.listCacheBytes.Add(productListCountSize(key, v))
+	s.evictOverBudgetLocked()
+}
+
+func (s *productRepoState) addListPage(key productListPageKey, v productListPage) {
+	s.listCacheMu.Lock()
+	defer s.listCacheMu.Unlock()
+	s.listPageCache.Remove(key)
+	if s.listPageCache.Add(key, v) {
+		s.listCacheEvictions.Add(1)
+	}
+	s.listCacheBytes.Add(productListPageSize(key, v))
+	s.evictOverBudgetLocked()
+}
+
+func (s *productRepoState) evictOverBudgetLocked() {
+	if ProductListCacheMaxBytes <= 0 {
+		return
+	}
+	for s.listCacheBytes.Load() > int64(ProductListCacheMaxBytes) {
+		switch {
+		case s.listPageCache != nil && s.listPageCache.Len() > 0:
+			s.listPageCache.RemoveOldest()
+		case s.listCountCache.Len() > 0:
+			s.listCountCache.RemoveOldest()
+		default:
+			return
+		}
+		s.listCacheEvictions.Add(1)
+	}
+}
+
+// 絞り込みと並び順は組み立てた SQL で正規化する (sort_order の大文字小文字、未知の sort_field などを同じキーにする)
+type productListPageKey struct {
+	filter  string
+	orderBy string
+	limit   int
+	offset  int
+}
+
+// 一覧の絞り込み (検索語と範囲)
+// 商品 1 件の変更をキャッシュに反映するときに、その商品が含まれるかを判定する
+type productListFilter struct {
+	search                                   string
+	minValue, maxValue, minWeight, maxWeight *int
+}
+
+// 商品が絞り込みに含まれるかと、判定できたかを返す
+// 検索語の一致は照合順序や FULLTEXT の結果を再現できないので判定しない
+func (f productListFilter) contains(p model.Product) (in, known bool) {
+	if f.search != "" {
+		return false, false
+	}
+	within := func(v int, lower, upper *int) bool {
+		return (lower == nil || v >= *lower) && (upper == nil || v <= *upper)
+	}
+	return within(p.Value, f.minValue, f.maxValue) && within(p.Weight, f.minWeight, f.maxWeight), true
+}
+
+type productListCount struct {
+	filter productListFilter
+	total  int
+}
+
+type productListPage struct {
+	filter productListFilter
+	ids    []int
 }
 
 type ProductRepository struct {
-	db             DBTX
-	listCountCache *lru.Cache[string, int] // listCountCache key: search -> total_count
+	db DBTX
+	// listCountCache key: 絞り込み (検索語・範囲) -> total_count
+	// listPageCache key: 絞り込み・並び順・ページ位置 -> そのページの商品 ID の並び
+	// 価格 (範囲での絞り込みと価格順) と一覧に出すか (UpdateValue, UpdateActive) の変更は、
+	// コミット後に UpsertProductInCache / RemoveProductFromCache で変わった商品の分だけ反映する
+	// 在庫数・画像の更新は絞り込みにも並び順にも影響しない
+	listCountCache *lru.Cache[string, productListCount]
+	listPageCache  *lru.Cache[productListPageKey, productListPage]
+	state          *productRepoState
+	hooks          *commitHooks
+}
+
+func newProductRepository(db DBTX, state *productRepoState, hooks *commitHooks) *ProductRepository {
+	state.init()
+	return &ProductRepository{db: db, listCountCache: state.listCountCache, listPageCache: state.listPageCache, state: state, hooks: hooks}
 }
 
-func newProductRepository(db DBTX, state *productRepoState) *ProductRepository {
-	return &ProductRepository{db: db, listCountCache: state.initListCountCache()}
+func (r *ProductRepository) ListCacheStats() ProductListCacheStats {
+	stats := ProductListCacheStats{
+		CountEntries: r.listCountCache.Len(),
+		Bytes:        r.state.listCacheBytes.Load(),
+		MaxBytes:     int64(ProductListCacheMaxBytes),
+		Evictions:    r.state.listCacheEvictions.Load(),
+	}
+	if r.listPageCache != nil {
+		stats.PageEntries = r.listPageCache.Len()
+	}
+	return stats
 }
 
 // 商品一覧を全件取得し、アプリケーション側でページング処理を行う
@@ -40,47 +221,494 @@ func (r *ProductRepository) ListProducts(
 	ctx context.Context,
 	userID int,
 	req model.ListRequest,
-) ([]model.Product, int, error) {
-	where := ""
+) (_ []model.Product, _ int, err error) {
+	defer observeRepoCall("ProductRepository.ListProducts", time.Now(), &err)
+	// 一覧に出さない商品を除く
+	conds := []string{"is_active = TRUE"}
 	args := make([]interface{}, 0, 1)
+	orderBy := buildProductOrderBy(req.SortField, req.SortOrder)
+	var orderArgs []interface{}
 
 	if s := strings.TrimSpace(req.Search); s != "" {
-		where = "WHERE name LIKE ? OR description LIKE ?"
-		pattern := "%" + s + "%"
-		args = append(args, pattern, pattern)
+		if against, ok := productFuzzyQuery(s, req.Fuzzy); ok {
+			// 検索語の n-gram を含む商品に関連度をつけ、下限以上のものだけを返す
+			conds = append(conds, "MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) >= ?")
+			args = append(args, against, ProductSearchFuzzyMinScore)
+			if req.SortField == model.ProductSortRelevance {
+				orderBy = "MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) DESC, product_id ASC"
+				orderArgs = append(orderArgs, against)
+			}
+		} else if against, ok := productFullTextQuery(s); ok {
+			conds = append(conds, "MATCH(name, description) AGAINST (? IN BOOLEAN MODE)")
+			args = append(args, against)
+			if req.SortField == model.ProductSortRelevance {
+				orderBy = "MATCH(name, description) AGAINST (? IN BOOLEAN MODE) DESC, product_id ASC"
+				orderArgs = append(orderArgs, against)
+			}
+		} else {
+			conds = append(conds, "(name LIKE ? OR description LIKE ?)")
+			pattern := "%" + s + "%"
+			args = append(args, pattern, pattern)
+		}
+	}
+	for _, r := range []struct {
+		cond  string
+		bound *int
+	}{
+		{"value >= ?", req.MinValue},
+		{"value <= ?", req.MaxValue},
+		{"weight >= ?", req.MinWeight},
+		{"weight <= ?", req.MaxWeight},
+	} {
+		if r.bound != nil {
+			conds = append(conds, r.cond)
+			args = append(args, *r.bound)
+		}
+	}
+	// 検索語と範囲で決まる絞り込み (件数とページのキャッシュのキー)
+	filterKey := strings.Join(conds, " AND ") + "\x00" + fmt.Sprint(args...)
+	filter := productListFilter{
+		search:    strings.TrimSpace(req.Search),
+		minValue:  copyBound(req.MinValue),
+		maxValue:  copyBound(req.MaxValue),
+		minWeight: copyBound(req.MinWeight),
+		maxWeight: copyBound(req.MaxWeight),
+	}
+	if req.FavoritesOnly {
+		conds = append(conds, "product_id IN (SELECT product_id FROM favorites WHERE user_id = ?)")
+		args = append(args, userID)
+	}
+	where := "WHERE " + strings.Join(conds, " AND ")
+	if req.SortField == model.ProductSortRelevance && orderArgs == nil {
+		// 関連度を計算できない (FULLTEXT を使わない) 場合は商品 ID 順
+		orderBy = "product_id ASC"
 	}
 
-	// 総件数
+	// 総件数 (お気に入りで絞る場合はユーザーごとに変わるのでキャッシュしない)
 	var total int
-	totalCacheKey := req.Search
-	if v, ok := r.listCountCache.Get(totalCacheKey); ok {
-		total = v
+	if v, ok := r.listCountCache.Get(filterKey); ok && !req.FavoritesOnly {
+		total = v.total
 	} else {
 		// キャッシュにない場合はDBから取得してキャッシュに保存
 		countSQL := "SELECT COUNT(1) FROM products " + where
 		if err := r.db.GetContext(ctx, &total, countSQL, args...); err != nil {
 			return nil, 0, err
 		}
-		r.listCountCache.Add(totalCacheKey, total)
-		log.Printf("ListProducts: listCountCache len=%d\n", r.listCountCache.Len())
+		if !req.FavoritesOnly {
+			r.state.addListCount(filterKey, productListCount{filter: filter, total: total})
+			log.Printf("ListProducts: listCountCache len=%d\n", r.listCountCache.Len())
+		}
+	}
+
+	// お気に入りで絞る場合はユーザーごとに変わるのでキャッシュしない
+	var pageKey productListPageKey
+	cachePage := r.listPageCache != nil && !req.FavoritesOnly
+	if cachePage {
+		pageKey = productListPageKey{filter: filterKey, orderBy: orderBy, limit: req.PageSize, offset: req.Offset}
+		if page, ok := r.listPageCache.Get(pageKey); ok {
+			products, err := r.getPageByIDs(ctx, page.ids, req.Fields)
+			if err != nil {
+				return nil, 0, err
+			}
+			return products, total, nil
+		}
 	}
 
-	// データ取得（ORDER BY の列名・並び順をそのまま埋め込む）
+	// データ取得
 	query := fmt.Sprintf(`
-		SELECT product_id, name, value, weight, image, description
+		SELECT %s
 		FROM products
 		%s
-		ORDER BY %s %s, product_id ASC
+		ORDER BY %s
 		LIMIT ? OFFSET ?`,
-		where, req.SortField, req.SortOrder,
+		productListColumns(req.Fields), where, orderBy,
 	)
 
-	dataArgs := append(args, req.PageSize, req.Offset)
+	dataArgs := append(append(args, orderArgs...), req.PageSize, req.Offset)
 
 	var products []model.Product
 	if err := r.db.SelectContext(ctx, &products, query, dataArgs...); err != nil {
 		return nil, 0, err
 	}
+	if cachePage {
+		r.state.addListPage(pageKey, productListPage{filter: filter, ids: lo.Map(products, func(p model.Product, _ int) int { return p.ProductID })})
+	}
 
 	return products, total, nil
 }
+
+// キャッシュしたページの商品を主キーで読み、ids の順に並べる
+func (r *ProductRepository) getPageByIDs(ctx context.Context, ids []int, fields []string) ([]model.Product, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In("SELECT "+productListColumns(fields)+" FROM products WHERE product_id IN (?)", ids)
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.Product
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	byID := lo.KeyBy(rows, func(p model.Product) int { return p.ProductID })
+	products := make([]model.Product, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+func copyBound(v *int) *int {
+	if v == nil {
+		return nil
+	}
+	return lo.ToPtr(*v)
+}
+
+// 一覧に出る商品の追加・変更を、コミット後に件数とページのキャッシュへ反映する
+// old は変更前の商品 (一覧に出ていなかった場合は nil)
+func (r *ProductRepository) UpsertProductInCache(old *model.Product, p model.Product) {
+	r.hooks.add(func() { r.applyListedChange(old, &p) })
+}
+
+// 一覧に出なくなった商品を、コミット後に件数とページのキャッシュから除く
+func (r *ProductRepository) RemoveProductFromCache(old model.Product) {
+	r.hooks.add(func() { r.applyListedChange(&old, nil) })
+}
+
+// 変更前後で絞り込みに含まれるかを見て、件数は増減し、ページは並びが変わりうるものだけ消す
+// 含まれるか判定できない絞り込み (検索語あり) の件数とページは消す
+func (r *ProductRepository) applyListedChange(old, cur *model.Product) {
+	membership := func(f productListFilter) (before, after, known bool) {
+		if old != nil {
+			in, ok := f.contains(*old)
+			if !ok {
+				return false, false, false
+			}
+			before = in
+		}
+		if cur != nil {
+			in, ok := f.contains(*cur)
+			if !ok {
+				return false, false, false
+			}
+			after = in
+		}
+		return before, after, true
+	}
+
+	for _, key := range r.listCountCache.Keys() {
+		entry, ok := r.listCountCache.Peek(key)
+		if !ok {
+			continue
+		}
+		before, after, known := membership(entry.filter)
+		switch {
+		case !known:
+			r.listCountCache.Remove(key)
+		case before != after:
+			entry.total += lo.Ternary(after, 1, -1)
+			r.state.addListCount(key, entry)
+		}
+	}
+
+	if r.listPageCache == nil {
+		return
+	}
+	valueChanged := old != nil && cur != nil && old.Value != cur.Value
+	for _, key := range r.listPageCache.Keys() {
+		page, ok := r.listPageCache.Peek(key)
+		if !ok {
+			continue
+		}
+		before, after, known := membership(page.filter)
+		switch {
+		case !known, before != after:
+			// 商品が増減するとそれ以降のページがずれる
+			r.listPageCache.Remove(key)
+		case before && valueChanged && strings.HasPrefix(key.orderBy, "value "):
+			r.listPageCache.Remove(key)
+		}
+	}
+}
+
+// 指定されたフィールドの列だけを読む (description などの大きい列を読まずに済ませる)
+// fields は model.ProductListFields のキーであること (サービス層で検証済み)
+func productListColumns(fields []string) string {
+	if len(fields) == 0 {
+		return productColumns()
+	}
+	columns := []string{"product_id"}
+	for _, field := range fields {
+		if field != "product_id" && !lo.Contains(columns, field) {
+			columns = append(columns, field)
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// 商品のすべての列 (容積は ProductVolumeEnabled の場合のみ)
+func productColumns() string {
+	if ProductVolumeEnabled {
+		return "product_id, name, value, weight, volume, image, description, stock"
+	}
+	return "product_id, name, value, weight, image, description, stock"
+}
+
+// 並び順は 0_index.sql の (列 [DESC], product_id) インデックスの順に揃え、インデックス順に LIMIT まで読むだけにする
+// 商品名は ProductNameSortLocale を指定した場合だけ、そのロケールの照合順序で並べる
+// 未知の列は商品 ID 順 (列名をそのまま埋め込まない)
+func buildProductOrderBy(field, order string) string {
+	dir := "ASC"
+	if strings.ToUpper(order) == "DESC" {
+		dir = "DESC"
+	}
+	switch field {
+	case "name":
+		if collation, ok := productNameCollations[ProductNameSortLocale]; ok {
+			return "name COLLATE " + collation + " " + dir + ", product_id ASC"
+		}
+		return "name " + dir + ", product_id ASC"
+	case "value", "weight":
+		return field + " " + dir + ", product_id ASC"
+	case "product_id":
+		fallthrough
+	default:
+		return "product_id " + dir
+	}
+}
+
+// 検索語を空白で区切り、すべての語をフレーズとして必須にした BOOLEAN MODE の検索式にする
+// FULLTEXT を使えない場合 (無効、または ngram より短い語がある) は false を返す
+func productFullTextQuery(search string) (string, bool) {
+	if !ProductSearchFullText {
+		return "", false
+	}
+	// フレーズ内で " は区切りとして扱われるので取り除く
+	terms := strings.Fields(strings.ReplaceAll(search, `"`, ""))
+	if len(terms) == 0 {
+		return "", false
+	}
+	for i, term := range terms {
+		if utf8.RuneCountInString(term) < OrderSearchNgramSize {
+			return "", false
+		}
+		terms[i] = `+"` + term + `"`
+	}
+	return strings.Join(terms, " "), true
+}
+
+// あいまい検索の検索式 (NATURAL LANGUAGE MODE では ngram パーサが検索語を n-gram に分け、いずれかを含めば候補になる)
+// FULLTEXT を使えない場合 (無効、または検索語が ngram より短い) は false を返す
+func productFuzzyQuery(search string, fuzzy bool) (string, bool) {
+	if !fuzzy || !ProductSearchFullText {
+		return "", false
+	}
+	search = strings.Join(strings.Fields(strings.ReplaceAll(search, `"`, "")), " ")
+	if utf8.RuneCountInString(search) < OrderSearchNgramSize {
+		return "", false
+	}
+	return search, true
+}
+
+// 商品の在庫数をロックして取得する (トランザクション内で呼ぶこと)
+// 在庫を管理しない商品 (stock が NULL) と存在しない商品は結果に含まない
+// デッドロックを避けるため product_id の昇順でロックする
+func (r *ProductRepository) GetStocksForUpdate(ctx context.Context, productIDs []int) (_ map[int]int, err error) {
+	defer observeRepoCall("ProductRepository.GetStocksForUpdate", time.Now(), &err)
+	stocks := make(map[int]int, len(productIDs))
+	if len(productIDs) == 0 {
+		return stocks, nil
+	}
+	query, args, err := sqlx.In(`
+        SELECT product_id, stock
+        FROM products
+        WHERE product_id IN (?) AND stock IS NOT NULL
+        ORDER BY product_id
+        FOR UPDATE`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		ProductID int           `db:"product_id"`
+		Stock     sql.NullInt64 `db:"stock"`
+	}
+	if err := r.db.SelectContext(ctx, &rows, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		stocks[row.ProductID] = int(row.Stock.Int64)
+	}
+	return stocks, nil
+}
+
+// 指定された商品のうち、一覧に出さない (新しい注文を受け付けない) 商品の ID を昇順で返す
+// 存在しない商品は含まない。在庫を管理しない商品の行までロックしないよう、ロックせずに読む
+func (r *ProductRepository) GetInactiveIDs(ctx context.Context, productIDs []int) (_ []int, err error) {
+	defer observeRepoCall("ProductRepository.GetInactiveIDs", time.Now(), &err)
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(`
+		SELECT product_id
+		FROM products
+		WHERE product_id IN (?) AND is_active = FALSE
+		ORDER BY product_id`, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	if err := r.db.SelectContext(ctx, &ids, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// 一覧の絞り込みと並びに関わる列
+type productListedRow struct {
+	Value    int  `db:"value"`
+	Weight   int  `db:"weight"`
+	IsActive bool `db:"is_active"`
+}
+
+func (row productListedRow) product(productID int) model.Product {
+	return model.Product{ProductID: productID, Value: row.Value, Weight: row.Weight}
+}
+
+// 商品の行をロックし、一覧の絞り込みと並びに関わる列を読む
+func (r *ProductRepository) getListedForUpdate(ctx context.Context, productID int) (productListedRow, error) {
+	var row productListedRow
+	err := r.db.GetContext(ctx, &row, "SELECT value, weight, is_active FROM products WHERE product_id = ? FOR UPDATE", productID)
+	return row, err
+}
+
+// 商品を一覧に出すか (新しい注文を受け付けるか) を変更する (トランザクション内で呼ぶこと)
+// 商品が存在しなければ sql.ErrNoRows を返す。変わらなければ何もせず false を返す
+func (r *ProductRepository) UpdateActive(ctx context.Context, productID int, active bool) (_ bool, err error) {
+	defer observeRepoCall("ProductRepository.UpdateActive", time.Now(), &err)
+	old, err := r.getListedForUpdate(ctx, productID)
+	if err != nil {
+		return false, err
+	}
+	if old.IsActive == active {
+		return false, nil
+	}
+	if _, err := r.db.ExecContext(ctx, "UPDATE products SET is_active = ? WHERE product_id = ?", active, productID); err != nil {
+		return false, err
+	}
+	if active {
+		r.UpsertProductInCache(nil, old.product(productID))
+	} else {
+		r.RemoveProductFromCache(old.product(productID))
+	}
+	return true, nil
+}
+
+// 在庫数を delta だけ増減する (在庫を管理しない商品は何もしない)
+// 減らす場合は GetStocksForUpdate で足りることを確認してから呼ぶこと
+func (r *ProductRepository) AdjustStock(ctx context.Context, productID, delta int) (err error) {
+	defer observeRepoCall("ProductRepository.AdjustStock", time.Now(), &err)
+	_, err = r.db.ExecContext(ctx, "UPDATE products SET stock = stock + ? WHERE product_id = ? AND stock IS NOT NULL", delta, productID)
+	return err
+}
+
+// 商品画像のパスを差し替え、差し替え前のパスを返す (トランザクション内で呼ぶこと)
+// 商品が存在しなければ sql.ErrNoRows を返す
+func (r *ProductRepository) UpdateImage(ctx context.Context, productID int, image string) (_ string, err error) {
+	defer observeRepoCall("ProductRepository.UpdateImage", time.Now(), &err)
+	var old string
+	if err := r.db.GetContext(ctx, &old, "SELECT image FROM products WHERE product_id = ? FOR UPDATE", productID); err != nil {
+		return "", err
+	}
+	if _, err := r.db.ExecContext(ctx, "UPDATE products SET image = ? WHERE product_id = ?", image, productID); err != nil {
+		return "", err
+	}
+	return old, nil
+}
+
+// 商品の価格を変更し、変更履歴を残す (トランザクション内で呼ぶこと)
+// 商品が存在しなければ sql.ErrNoRows を返す。価格が同じなら何もせず false を返す
+func (r *ProductRepository) UpdateValue(ctx context.Context, productID, value, changedBy int) (_ bool, err error) {
+	defer observeRepoCall("ProductRepository.UpdateValue", time.Now(), &err)
+	old, err := r.getListedForUpdate(ctx, productID)
+	if err != nil {
+		return false, err
+	}
+	if old.Value == value {
+		return false, nil
+	}
+	if _, err := r.db.ExecContext(ctx, "UPDATE products SET value = ? WHERE product_id = ?", value, productID); err != nil {
+		return false, err
+	}
+	// 一覧に出ていない商品は件数にもページにも含まれない
+	if old.IsActive {
+		before := old.product(productID)
+		after := before
+		after.Value = value
+		r.UpsertProductInCache(&before, after)
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO product_value_history (product_id, old_value, new_value, changed_by, changed_at)
+		VALUES (?, ?, ?, ?, NOW())`,
+		productID, old.Value, value, changedBy,
+	)
+	return err == nil, err
+}
+
+// 商品の現在の価格と、直近 limit 件の価格の変更履歴 (古い順) を返す
+// 商品が存在しなければ sql.ErrNoRows を返す
+func (r *ProductRepository) GetValueHistory(ctx context.Context, productID, limit int) (_ int, _ []model.ProductValueChange, err error) {
+	defer observeRepoCall("ProductRepository.GetValueHistory", time.Now(), &err)
+	var current int
+	if err := r.db.GetContext(ctx, &current, "SELECT value FROM products WHERE product_id = ?", productID); err != nil {
+		return 0, nil, err
+	}
+	history := []model.ProductValueChange{}
+	query := `
+		SELECT id, product_id, old_value, new_value, changed_by, changed_at
+		FROM product_value_history
+		WHERE product_id = ?
+		ORDER BY id DESC
+		LIMIT ?`
+	if err := r.db.SelectContext(ctx, &history, query, productID, limit); err != nil {
+		return 0, nil, err
+	}
+	slices.Reverse(history)
+	return current, history, nil
+}
+
+// since 以降の注文で、同じ注文に含まれた商品の組ごとの注文数を返す (両方向の組を返す)
+func (r *ProductRepository) ListCoOccurrences(ctx context.Context, since time.Time) (_ []model.ProductCoOccurrence, err error) {
+	defer observeRepoCall("ProductRepository.ListCoOccurrences", time.Now(), &err)
+	query := `
+		SELECT a.product_id AS product_id, b.product_id AS related_product_id, COUNT(DISTINCT h.order_header_id) AS orders
+		FROM order_headers h
+		JOIN order_items a ON a.order_header_id = h.order_header_id
+		JOIN order_items b ON b.order_header_id = h.order_header_id AND b.product_id <> a.product_id
+		WHERE h.created_at >= ?
+		GROUP BY a.product_id, b.product_id`
+	var rows []model.ProductCoOccurrence
+	if err := r.db.SelectContext(ctx, &rows, query, since); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// 商品 ID を指定して取得する (存在しない商品は含まない、順番は不定)
+func (r *ProductRepository) GetByIDs(ctx context.Context, productIDs []int) (_ []model.Product, err error) {
+	defer observeRepoCall("ProductRepository.GetByIDs", time.Now(), &err)
+	products := []model.Product{}
+	if len(productIDs) == 0 {
+		return products, nil
+	}
+	query, args, err := sqlx.In("SELECT "+productColumns()+" FROM products WHERE product_id IN (?)", productIDs)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.SelectContext(ctx, &products, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
@@ -0,0 +1,535 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"github.com/samber/lo"
+)
+
+// 商品一覧の SELECT を記録する DBTX
+type listProductsDB struct {
+	*fakeDB
+	query string
+	args  []any
+}
+
+func newListProductsDB() *listProductsDB {
+	db := &listProductsDB{}
+	db.fakeDB = &fakeDB{sel: func(_ context.Context, _ any, query string, args ...any) error {
+		db.query, db.args = query, args
+		return nil
+	}}
+	return db
+}
+
+func TestProductFullTextQuery(t *testing.T) {
+	defer func(enabled bool) { ProductSearchFullText = enabled }(ProductSearchFullText)
+	ProductSearchFullText = true
+
+	tests := []struct {
+		search string
+		want   string
+		wantOK bool
+	}{
+		{"りんご", `+"りんご"`, true},
+		{" 青森 りんご ", `+"青森" +"りんご"`, true},
+		{`"ジュース"`, `+"ジュース"`, true},
+		{"りんご 赤", "", false}, // ngram より短い語がある
+		{`"`, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := productFullTextQuery(tt.search)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("productFullTextQuery(%q) = %q, %v; want %q, %v", tt.search, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	ProductSearchFullText = false
+	if _, ok := productFullTextQuery("りんご"); ok {
+		t.Error("full text query must be disabled")
+	}
+}
+
+func TestProductFuzzyQuery(t *testing.T) {
+	defer func(enabled bool) { ProductSearchFullText = enabled }(ProductSearchFullText)
+	ProductSearchFullText = true
+
+	tests := []struct {
+		search string
+		fuzzy  bool
+		want   string
+		wantOK bool
+	}{
+		{"banan", true, "banan", true},
+		{` "青森"  りんご `, true, "青森 りんご", true},
+		{"a", true, "", false}, // ngram より短い
+		{"banan", false, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := productFuzzyQuery(tt.search, tt.fuzzy)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("productFuzzyQuery(%q, %v) = %q, %v; want %q, %v", tt.search, tt.fuzzy, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestListProductsOrdersByRelevance(t *testing.T) {
+	defer func(enabled bool) { ProductSearchFullText = enabled }(ProductSearchFullText)
+
+	tests := []struct {
+		name      string
+		fullText  bool
+		req       model.ListRequest
+		wantWhere string
+		wantOrder string
+		wantArgs  []any
+	}{
+		{
+			"relevance",
+			true,
+			model.ListRequest{Search: "青森 りんご", SortField: model.ProductSortRelevance, PageSize: 20},
+			"MATCH(name, description) AGAINST (? IN BOOLEAN MODE)",
+			"ORDER BY MATCH(name, description) AGAINST (? IN BOOLEAN MODE) DESC, product_id ASC",
+			[]any{`+"青森" +"りんご"`, `+"青森" +"りんご"`, 20, 0},
+		},
+		{
+			"full text with explicit sort",
+			true,
+			model.ListRequest{Search: "りんご", SortField: "value", SortOrder: "desc", PageSize: 20},
+			"MATCH(name, description) AGAINST (? IN BOOLEAN MODE)",
+			"ORDER BY value DESC, product_id ASC",
+			[]any{`+"りんご"`, 20, 0},
+		},
+		{
+			"fuzzy relevance",
+			true,
+			model.ListRequest{Search: "banan", Fuzzy: true, SortField: model.ProductSortRelevance, PageSize: 20},
+			"MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) >= ?",
+			"ORDER BY MATCH(name, description) AGAINST (? IN NATURAL LANGUAGE MODE) DESC, product_id ASC",
+			[]any{"banan", ProductSearchFuzzyMinScore, "banan", 20, 0},
+		},
+		{
+			"fuzzy without full text",
+			false,
+			model.ListRequest{Search: "banan", Fuzzy: true, PageSize: 20},
+			"(name LIKE ? OR description LIKE ?)",
+			"ORDER BY product_id ASC",
+			[]any{"%banan%", "%banan%", 20, 0},
+		},
+		{
+			"relevance without full text",
+			false,
+			model.ListRequest{Search: "りんご", SortField: model.ProductSortRelevance, PageSize: 20},
+			"(name LIKE ? OR description LIKE ?)",
+			"ORDER BY product_id ASC",
+			[]any{"%りんご%", "%りんご%", 20, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ProductSearchFullText = tt.fullText
+			db := newListProductsDB()
+			repo := newProductRepository(db, &productRepoState{}, nil)
+			if _, _, err := repo.ListProducts(context.Background(), 1, tt.req); err != nil {
+				t.Fatalf("ListProducts: %v", err)
+			}
+			if !strings.Contains(db.query, "WHERE is_active = TRUE AND "+tt.wantWhere) || !strings.Contains(db.query, tt.wantOrder) {
+				t.Fatalf("query = %s", db.query)
+			}
+			if !reflect.DeepEqual(db.args, tt.wantArgs) {
+				t.Fatalf("args = %v, want %v", db.args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBuildProductOrderByNameLocale(t *testing.T) {
+	defer func(locale string) { ProductNameSortLocale = locale }(ProductNameSortLocale)
+
+	tests := []struct {
+		locale, want string
+	}{
+		{"", "name DESC, product_id ASC"},
+		{"ja", "name COLLATE utf8mb4_ja_0900_as_cs DESC, product_id ASC"},
+		{"utf8mb4_bin", "name DESC, product_id ASC"}, // 表にない値は埋め込まない
+	}
+	for _, tt := range tests {
+		ProductNameSortLocale = tt.locale
+		if got := buildProductOrderBy("name", "desc"); got != tt.want {
+			t.Errorf("locale %q: buildProductOrderBy = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+	if ValidProductNameSortLocale("utf8mb4_bin") || !ValidProductNameSortLocale("ja") || !ValidProductNameSortLocale("") {
+		t.Fatal("ValidProductNameSortLocale must accept only the supported locales")
+	}
+}
+
+func TestBuildProductOrderBy(t *testing.T) {
+	tests := []struct {
+		field, order, want string
+	}{
+		{"name", "asc", "name ASC, product_id ASC"},
+		{"value", "DESC", "value DESC, product_id ASC"},
+		{"weight", "desc", "weight DESC, product_id ASC"},
+		{"product_id", "desc", "product_id DESC"},
+		{"", "", "product_id ASC"},
+		{"value; DROP TABLE products", "asc", "product_id ASC"},
+		{"name", "desc, (SELECT 1)", "name ASC, product_id ASC"},
+	}
+	for _, tt := range tests {
+		if got := buildProductOrderBy(tt.field, tt.order); got != tt.want {
+			t.Errorf("buildProductOrderBy(%q, %q) = %q, want %q", tt.field, tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestGetValueHistoryReturnsOldestFirst(t *testing.T) {
+	// 価格の変更履歴を新しい順に返す
+	var limit any
+	db := &fakeDB{
+		get: func(_ context.Context, dest any, _ string, _ ...any) error {
+			*dest.(*int) = 130
+			return nil
+		},
+		sel: func(_ context.Context, dest any, _ string, args ...any) error {
+			limit = args[1]
+			*dest.(*[]model.ProductValueChange) = []model.ProductValueChange{
+				{ID: 3, OldValue: 120, NewValue: 130},
+				{ID: 1, OldValue: 100, NewValue: 120},
+			}
+			return nil
+		},
+	}
+	repo := newProductRepository(db, &productRepoState{}, nil)
+	current, history, err := repo.GetValueHistory(context.Background(), 7, 50)
+	if err != nil {
+		t.Fatalf("GetValueHistory: %v", err)
+	}
+	if current != 130 || len(history) != 2 || history[0].ID != 1 || history[1].ID != 3 || limit != 50 {
+		t.Fatalf("current = %d, history = %+v, limit = %v", current, history, limit)
+	}
+}
+
+func TestProductListColumns(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		{nil, "product_id, name, value, weight, image, description, stock"},
+		{[]string{"name", "value", "weight"}, "product_id, name, value, weight"},
+		{[]string{"value", "product_id", "value"}, "product_id, value"},
+	}
+	for _, tt := range tests {
+		if got := productListColumns(tt.fields); got != tt.want {
+			t.Errorf("productListColumns(%v) = %q, want %q", tt.fields, got, tt.want)
+		}
+	}
+}
+
+// COUNT の呼び出しも記録する商品一覧用 DBTX
+type countingProductsDB struct {
+	*listProductsDB
+	counts []string
+}
+
+func newCountingProductsDB() *countingProductsDB {
+	db := &countingProductsDB{listProductsDB: newListProductsDB()}
+	db.get = func(_ context.Context, _ any, query string, _ ...any) error {
+		db.counts = append(db.counts, query)
+		return nil
+	}
+	return db
+}
+
+func TestListProductsFavoritesOnly(t *testing.T) {
+	db := newCountingProductsDB()
+	repo := newProductRepository(db, &productRepoState{}, nil)
+	req := model.ListRequest{Search: "りんご", FavoritesOnly: true, PageSize: 20}
+	for i := 0; i < 2; i++ {
+		if _, _, err := repo.ListProducts(context.Background(), 42, req); err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+	}
+	if !strings.Contains(db.query, "WHERE is_active = TRUE AND (name LIKE ? OR description LIKE ?) AND product_id IN (SELECT product_id FROM favorites WHERE user_id = ?)") {
+		t.Fatalf("query = %s", db.query)
+	}
+	if want := []any{"%りんご%", "%りんご%", 42, 20, 0}; !reflect.DeepEqual(db.args, want) {
+		t.Fatalf("args = %v, want %v", db.args, want)
+	}
+	if len(db.counts) != 2 {
+		t.Fatalf("count queries = %d, want the per-user count not to be cached", len(db.counts))
+	}
+}
+
+func TestListProductsCachesPageIDs(t *testing.T) {
+	// 商品一覧のページを返す (主キーでの読み直しには逆順で返す)
+	var queries []string
+	db := &fakeDB{
+		sel: func(_ context.Context, dest any, query string, _ ...any) error {
+			queries = append(queries, query)
+			rows := []model.Product{{ProductID: 3}, {ProductID: 1}, {ProductID: 2}}
+			if strings.Contains(query, "WHERE product_id IN") {
+				slices.Reverse(rows)
+			}
+			*dest.(*[]model.Product) = rows
+			return nil
+		},
+		get: func(_ context.Context, dest any, _ string, _ ...any) error {
+			if row, ok := dest.(*productListedRow); ok {
+				*row = productListedRow{Value: 50, Weight: 1, IsActive: true}
+			}
+			return nil
+		},
+		exec: affectedRows(func(string, []any) int64 { return 1 }),
+	}
+	state := &productRepoState{}
+	hooks := &commitHooks{}
+	repo := newProductRepository(db, state, nil)
+	txRepo := newProductRepository(db, state, hooks)
+
+	list := func(order string) []int {
+		t.Helper()
+		req := model.ListRequest{Search: "りんご ", SortField: "value", SortOrder: order, PageSize: 3}
+		products, _, err := repo.ListProducts(context.Background(), 1, req)
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		return lo.Map(products, func(p model.Product, _ int) int { return p.ProductID })
+	}
+	lastQuery := func() string { return queries[len(queries)-1] }
+
+	list("desc")
+	if strings.Contains(lastQuery(), "WHERE product_id IN") {
+		t.Fatalf("first query must filter and sort: %s", lastQuery())
+	}
+	// sort_order の大文字小文字は同じページとして扱う
+	if got := list("DESC"); !reflect.DeepEqual(got, []int{3, 1, 2}) || !strings.Contains(lastQuery(), "WHERE product_id IN") {
+		t.Fatalf("ids = %v, query = %s", got, lastQuery())
+	}
+
+	if _, err := txRepo.UpdateValue(context.Background(), 1, 100, 9); err != nil {
+		t.Fatalf("UpdateValue: %v", err)
+	}
+	list("desc")
+	if !strings.Contains(lastQuery(), "WHERE product_id IN") {
+		t.Fatal("cache must be kept until commit")
+	}
+	hooks.run()
+	list("desc")
+	if strings.Contains(lastQuery(), "WHERE product_id IN") {
+		t.Fatalf("pages of a search filter must be evicted after a value update: %s", lastQuery())
+	}
+}
+
+// 件数と商品 1 件の行を返し、COUNT と一覧の SELECT を記録する DBTX
+type listedProductDB struct {
+	*fakeDB
+	total   int
+	row     productListedRow
+	counts  []string
+	selects []string
+}
+
+func newListedProductDB(total int, row productListedRow) *listedProductDB {
+	db := &listedProductDB{total: total, row: row}
+	db.fakeDB = &fakeDB{
+		get: func(_ context.Context, dest any, query string, _ ...any) error {
+			switch dest := dest.(type) {
+			case *int:
+				db.counts = append(db.counts, query)
+				*dest = db.total
+			case *productListedRow:
+				*dest = db.row
+			}
+			return nil
+		},
+		sel: func(_ context.Context, dest any, query string, _ ...any) error {
+			db.selects = append(db.selects, query)
+			*dest.(*[]model.Product) = []model.Product{{ProductID: 1}}
+			return nil
+		},
+		exec: affectedRows(func(string, []any) int64 { return 1 }),
+	}
+	return db
+}
+
+func TestUpdateActiveUpdatesListCachesAfterCommit(t *testing.T) {
+	db := newListedProductDB(10, productListedRow{Value: 150, Weight: 1})
+	state := &productRepoState{}
+	hooks := &commitHooks{}
+	repo := newProductRepository(db, state, nil)
+	txRepo := newProductRepository(db, state, hooks)
+	minValue := 200
+	all := model.ListRequest{PageSize: 20}
+	expensive := model.ListRequest{MinValue: &minValue, PageSize: 20}
+	search := model.ListRequest{Search: "りんご", PageSize: 20}
+	list := func(req model.ListRequest) int {
+		t.Helper()
+		_, total, err := repo.ListProducts(context.Background(), 1, req)
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		return total
+	}
+	for _, req := range []model.ListRequest{all, expensive, search} {
+		list(req)
+	}
+
+	if changed, err := txRepo.UpdateActive(context.Background(), 7, true); err != nil || !changed {
+		t.Fatalf("UpdateActive = %v, %v", changed, err)
+	}
+	if total := list(all); total != 10 {
+		t.Fatalf("total = %d before commit, want 10", total)
+	}
+	hooks.run()
+	counts, selects := len(db.counts), len(db.selects)
+
+	// 範囲に含まれる絞り込みの件数は読み直さずに増やし、ページはずれるので読み直す
+	if total := list(all); total != 11 || len(db.counts) != counts || len(db.selects) != selects+1 || strings.Contains(db.selects[selects], "WHERE product_id IN") {
+		t.Fatalf("total = %d, queries = %v / %v; want 11 from cache and the page reloaded", total, db.counts, db.selects)
+	}
+	// 範囲外の絞り込みは件数もページもそのまま
+	if total := list(expensive); total != 10 || len(db.counts) != counts || !strings.Contains(db.selects[len(db.selects)-1], "WHERE product_id IN") {
+		t.Fatalf("total = %d, queries = %v / %v; want the unaffected filter kept", total, db.counts, db.selects)
+	}
+	// 検索語の一致は判定できないので読み直す
+	list(search)
+	if len(db.counts) != counts+1 {
+		t.Fatalf("count queries = %v, want the search count reloaded", db.counts)
+	}
+
+	db.row.IsActive = true
+	if changed, err := txRepo.UpdateActive(context.Background(), 7, false); err != nil || !changed {
+		t.Fatalf("UpdateActive = %v, %v", changed, err)
+	}
+	hooks.run()
+	if total := list(all); total != 10 {
+		t.Fatalf("total = %d, want the product removed from the count", total)
+	}
+}
+
+func TestUpdateValueEvictsOnlyPagesOrderedByValue(t *testing.T) {
+	db := newListedProductDB(10, productListedRow{Value: 150, Weight: 1, IsActive: true})
+	state := &productRepoState{}
+	hooks := &commitHooks{}
+	repo := newProductRepository(db, state, nil)
+	txRepo := newProductRepository(db, state, hooks)
+	maxValue := 200
+	byValue := model.ListRequest{SortField: "value", PageSize: 20}
+	byName := model.ListRequest{SortField: "name", PageSize: 20}
+	cheap := model.ListRequest{MaxValue: &maxValue, PageSize: 20}
+	cached := func(req model.ListRequest) (int, bool) {
+		t.Helper()
+		_, total, err := repo.ListProducts(context.Background(), 1, req)
+		if err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+		return total, strings.Contains(db.selects[len(db.selects)-1], "WHERE product_id IN")
+	}
+	for _, req := range []model.ListRequest{byValue, byName, cheap} {
+		cached(req)
+	}
+
+	if _, err := txRepo.UpdateValue(context.Background(), 7, 300, 9); err != nil {
+		t.Fatal(err)
+	}
+	hooks.run()
+	if total, hit := cached(byValue); total != 10 || hit {
+		t.Fatalf("total = %d, cached = %t; want the value-ordered page reloaded", total, hit)
+	}
+	if total, hit := cached(byName); total != 10 || !hit {
+		t.Fatalf("total = %d, cached = %t; want the name-ordered page kept", total, hit)
+	}
+	// 上限を超えたので範囲から外れる
+	if total, hit := cached(cheap); total != 9 || hit {
+		t.Fatalf("total = %d, cached = %t; want the product removed from the range", total, hit)
+	}
+	if n := len(db.counts); n != 2 {
+		t.Fatalf("count queries = %v, want no count reloaded", db.counts)
+	}
+
+	// 一覧に出ていない商品の価格はキャッシュに影響しない
+	db.row.IsActive = false
+	if _, err := txRepo.UpdateValue(context.Background(), 7, 100, 9); err != nil {
+		t.Fatal(err)
+	}
+	hooks.run()
+	if _, hit := cached(byValue); !hit {
+		t.Fatal("pages must be kept when an unlisted product changes")
+	}
+}
+
+func TestListProductsRangeFilters(t *testing.T) {
+	db := newCountingProductsDB()
+	repo := newProductRepository(db, &productRepoState{}, nil)
+	minValue, maxValue, maxWeight := 100, 500, 300
+	list := func(req model.ListRequest) {
+		t.Helper()
+		req.PageSize = 20
+		if _, _, err := repo.ListProducts(context.Background(), 1, req); err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+	}
+
+	list(model.ListRequest{Search: "りんご", MinValue: &minValue, MaxValue: &maxValue, MaxWeight: &maxWeight})
+	if !strings.Contains(db.query, "WHERE is_active = TRUE AND (name LIKE ? OR description LIKE ?) AND value >= ? AND value <= ? AND weight <= ?") {
+		t.Fatalf("query = %s", db.query)
+	}
+	if want := []any{"%りんご%", "%りんご%", 100, 500, 300, 20, 0}; !reflect.DeepEqual(db.args, want) {
+		t.Fatalf("args = %v, want %v", db.args, want)
+	}
+
+	// 範囲が違えば件数は別にキャッシュする
+	list(model.ListRequest{Search: "りんご", MinValue: &minValue})
+	list(model.ListRequest{Search: "りんご", MinValue: &minValue})
+	list(model.ListRequest{Search: "りんご"})
+	if len(db.counts) != 3 {
+		t.Fatalf("count queries = %d, want one per distinct filter", len(db.counts))
+	}
+}
+
+func TestListCachesEvictOverMaxBytes(t *testing.T) {
+	defer func(n int) { ProductListCacheMaxBytes = n }(ProductListCacheMaxBytes)
+	db := newListedProductDB(10, productListedRow{})
+	repo := newProductRepository(db, &productRepoState{}, nil)
+	list := func(offset int) {
+		t.Helper()
+		if _, _, err := repo.ListProducts(context.Background(), 1, model.ListRequest{PageSize: 20, Offset: offset}); err != nil {
+			t.Fatalf("ListProducts: %v", err)
+		}
+	}
+
+	list(0)
+	one := repo.ListCacheStats()
+	if one.CountEntries != 1 || one.PageEntries != 1 || one.Bytes <= 0 || one.Evictions != 0 {
+		t.Fatalf("stats = %+v, want one count and one page", one)
+	}
+	// 同じページを置き換えても二重に数えない
+	repo.state.addListPage(lo.Must(lo.Last(repo.listPageCache.Keys())), productListPage{ids: []int{1}})
+	if got := repo.ListCacheStats().Bytes; got != one.Bytes {
+		t.Fatalf("bytes = %d after replacing a page, want %d", got, one.Bytes)
+	}
+
+	// 2 ページ目を入れると上限を超えるので、古いページを追い出す
+	ProductListCacheMaxBytes = int(one.Bytes) + 1
+	list(20)
+	stats := repo.ListCacheStats()
+	if stats.CountEntries != 1 || stats.PageEntries != 1 || stats.Evictions != 1 || stats.Bytes > int64(ProductListCacheMaxBytes) {
+		t.Fatalf("stats = %+v, want the oldest page evicted within %d bytes", stats, ProductListCacheMaxBytes)
+	}
+	list(20)
+	if !strings.Contains(db.selects[len(db.selects)-1], "WHERE product_id IN") {
+		t.Fatal("the newest page must be kept")
+	}
+
+	// 無効化で消したものは追い出しに数えない
+	repo.applyListedChange(nil, &model.Product{ProductID: 2, Value: 1, Weight: 1})
+	if after := repo.ListCacheStats(); after.PageEntries != 0 || after.Evictions != 1 {
+		t.Fatalf("stats = %+v, want the page invalidated without counting an eviction", after)
+	}
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// database/sql 経由で実行された SQL とトランザクションの終了を記録するドライバ
+// *sqlx.Tx が必要な処理 (BatchCreate, ExecTx など) のテスト用
+type recordingDriver struct {
+	mu  sync.Mutex
+	log []string // 実行した SQL と "COMMIT" / "ROLLBACK"
+
+	// 設定すると ExecContext の結果を差し替える (未設定なら 1 行更新)
+	exec func(query string, args []driver.NamedValue) (driver.Result, error)
+	// 設定すると SELECT の結果を返す (未設定なら 0 行)
+	query func(query string, args []driver.NamedValue) (columns []string, rows [][]driver.Value, err error)
+}
+
+func newRecordingDB() (*sqlx.DB, *recordingDriver) {
+	d := &recordingDriver{}
+	return sqlx.NewDb(sql.OpenDB(d), "mysql"), d
+}
+
+func (d *recordingDriver) record(entry string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, entry)
+}
+
+func (d *recordingDriver) entries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+func (d *recordingDriver) Connect(context.Context) (driver.Conn, error) { return recordingConn{d}, nil }
+func (d *recordingDriver) Driver() driver.Driver                        { return nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("recordingDriver: prepared statements are not supported")
+}
+func (c recordingConn) Close() error              { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) { return recordingTx(c), nil }
+
+func (c recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.d.record(query)
+	if c.d.exec != nil {
+		return c.d.exec(query, args)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.record(query)
+	if c.d.query == nil {
+		return &recordingRows{}, nil
+	}
+	columns, rows, err := c.d.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingRows{columns: columns, rows: rows}, nil
+}
+
+type recordingRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string { return r.columns }
+func (r *recordingRows) Close() error      { return nil }
+
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type recordingTx struct{ d *recordingDriver }
+
+func (tx recordingTx) Commit() error   { tx.d.record("COMMIT"); return nil }
+func (tx recordingTx) Rollback() error { tx.d.record("ROLLBACK"); return nil }
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+type RecoveryCodeRepository struct {
+	db DBTX
+}
+
+func NewRecoveryCodeRepository(db DBTX) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{db: db}
+}
+
+// ユーザーのリカバリーコードを全て差し替える
+func (r *RecoveryCodeRepository) ReplaceByUserID(ctx context.Context, userID int, codeHashes []string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		query := "INSERT INTO totp_recovery_codes (user_id, code_hash) VALUES (?, ?)"
+		if _, err := r.db.ExecContext(ctx, query, userID, codeHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 未使用のリカバリーコードを使用済みにする
+// 該当するコードが無ければ false を返す
+func (r *RecoveryCodeRepository) Consume(ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := "UPDATE totp_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1"
+	result, err := r.db.ExecContext(ctx, query, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *RecoveryCodeRepository) DeleteByUserID(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = ?", userID)
+	return err
+}
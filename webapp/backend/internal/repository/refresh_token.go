@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"backend/internal/model"
+)
+
+type RefreshTokenRepository struct {
+	db DBTX
+}
+
+func NewRefreshTokenRepository(db DBTX) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+func (r *RefreshTokenRepository) Create(ctx context.Context, userID int, tokenHash string, expiresAt time.Time) error {
+	query := "INSERT INTO refresh_tokens (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)"
+	_, err := r.db.ExecContext(ctx, query, tokenHash, userID, time.Now(), expiresAt)
+	return err
+}
+
+// 有効（未失効・期限内）なリフレッシュトークンをハッシュから取得
+func (r *RefreshTokenRepository) FindActiveByHash(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	var token model.RefreshToken
+	query := `
+		SELECT id, user_id, expires_at
+		FROM refresh_tokens
+		WHERE token_hash = ? AND revoked_at IS NULL AND expires_at > ?`
+	if err := r.db.GetContext(ctx, &token, query, tokenHash, time.Now()); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// 失効させる
+// 既に失効済みの場合は false を返す（並行リクエストでの二重使用検出用）
+func (r *RefreshTokenRepository) Revoke(ctx context.Context, id int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (r *RefreshTokenRepository) RevokeByUserID(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now(), userID)
+	return err
+}
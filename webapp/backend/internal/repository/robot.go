@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 配送ロボットの登録 (27_robots.sql)
+type RobotRepository struct {
+	db DBTX
+}
+
+func NewRobotRepository(db DBTX) *RobotRepository {
+	return &RobotRepository{db: db}
+}
+
+// 登録されていなければ sql.ErrNoRows を返す
+func (r *RobotRepository) Get(ctx context.Context, robotID string) (_ *model.Robot, err error) {
+	defer observeRepoCall("RobotRepository.Get", time.Now(), &err)
+	columns := "robot_id, capacity, state, updated_at"
+	if ProductVolumeEnabled {
+		columns += ", volume_capacity"
+	}
+	var robot model.Robot
+	if err := r.db.GetContext(ctx, &robot, "SELECT "+columns+" FROM robots WHERE robot_id = ?", robotID); err != nil {
+		return nil, err
+	}
+	return &robot, nil
+}
+
+// 登録する (登録済みなら積載量と、state が空でなければ状態を更新する)
+// 積める容積は ProductVolumeEnabled の場合のみ記録する
+func (r *RobotRepository) Upsert(ctx context.Context, robotID string, capacity int, volumeCapacity *int, state string) (err error) {
+	defer observeRepoCall("RobotRepository.Upsert", time.Now(), &err)
+	initial := state
+	if initial == "" {
+		initial = model.RobotStateIdle
+	}
+	if ProductVolumeEnabled {
+		_, err = r.db.ExecContext(ctx, `
+		INSERT INTO robots (robot_id, capacity, volume_capacity, state, created_at, updated_at) VALUES (?, ?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE
+			capacity = VALUES(capacity), volume_capacity = VALUES(volume_capacity),
+			state = IF(? = '', state, VALUES(state)), updated_at = NOW()`,
+			robotID, capacity, volumeCapacity, initial, state,
+		)
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO robots (robot_id, capacity, state, created_at, updated_at) VALUES (?, ?, ?, NOW(), NOW())
+		ON DUPLICATE KEY UPDATE capacity = VALUES(capacity), state = IF(? = '', state, VALUES(state)), updated_at = NOW()`,
+		robotID, capacity, initial, state,
+	)
+	return err
+}
+
+// 状態を更新する (登録されていなければ sql.ErrNoRows を返す)
+func (r *RobotRepository) UpdateState(ctx context.Context, robotID, state string) (err error) {
+	defer observeRepoCall("RobotRepository.UpdateState", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx, "UPDATE robots SET state = ?, updated_at = NOW() WHERE robot_id = ?", state, robotID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	// 同じ秒に同じ状態へ更新した場合も 0 件になる
+	var exists bool
+	if err := r.db.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM robots WHERE robot_id = ?)", robotID); err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ロボットが報告した状態を最新のものとして記録する (28_robot_telemetry.sql)
+func (r *RobotRepository) SaveTelemetry(ctx context.Context, robotID string, hb model.RobotHeartbeatRequest) (err error) {
+	defer observeRepoCall("RobotRepository.SaveTelemetry", time.Now(), &err)
+	_, err = r.db.ExecContext(ctx, `
+		INSERT INTO robot_telemetry (robot_id, battery_percent, latitude, longitude, load_weight, reported_at) VALUES (?, ?, ?, ?, ?, NOW())
+		ON DUPLICATE KEY UPDATE
+			battery_percent = VALUES(battery_percent), latitude = VALUES(latitude), longitude = VALUES(longitude),
+			load_weight = VALUES(load_weight), reported_at = VALUES(reported_at)`,
+		robotID, hb.BatteryPercent, hb.Latitude, hb.Longitude, hb.LoadWeight,
+	)
+	return err
+}
+
+// ロボットが最後に報告した状態 (報告がなければ sql.ErrNoRows を返す)
+func (r *RobotRepository) GetTelemetry(ctx context.Context, robotID string) (_ *model.RobotTelemetry, err error) {
+	defer observeRepoCall("RobotRepository.GetTelemetry", time.Now(), &err)
+	var telemetry model.RobotTelemetry
+	if err := r.db.GetContext(ctx, &telemetry, `
+		SELECT robot_id, battery_percent, latitude, longitude, load_weight, reported_at
+		FROM robot_telemetry WHERE robot_id = ?`, robotID); err != nil {
+		return nil, err
+	}
+	return &telemetry, nil
+}
+
+// 報告のあったすべてのロボットの最新の状態
+func (r *RobotRepository) ListTelemetry(ctx context.Context) (_ []model.RobotTelemetry, err error) {
+	defer observeRepoCall("RobotRepository.ListTelemetry", time.Now(), &err)
+	telemetry := []model.RobotTelemetry{}
+	err = r.db.SelectContext(ctx, &telemetry, `
+		SELECT robot_id, battery_percent, latitude, longitude, load_weight, reported_at
+		FROM robot_telemetry
+		ORDER BY robot_id`)
+	return telemetry, err
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"backend/internal/model"
+)
+
+// ロボットごとの API キー (32_robot_api_keys.sql)
+// 生キーは保存せず HashToken のみ保持する
+type RobotAPIKeyRepository struct {
+	db DBTX
+}
+
+func NewRobotAPIKeyRepository(db DBTX) *RobotAPIKeyRepository {
+	return &RobotAPIKeyRepository{db: db}
+}
+
+func (r *RobotAPIKeyRepository) Create(ctx context.Context, key *model.RobotAPIKey) (_ int64, err error) {
+	defer observeRepoCall("RobotAPIKeyRepository.Create", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx,
+		"INSERT INTO robot_api_keys (key_hash, robot_id, created_at) VALUES (?, ?, ?)",
+		key.KeyHash, key.RobotID, key.CreatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// 有効な (失効していないか、失効の時刻がまだ来ていない) キーのロボット ID (なければ sql.ErrNoRows)
+func (r *RobotAPIKeyRepository) FindActiveRobotID(ctx context.Context, keyHash string) (_ string, err error) {
+	defer observeRepoCall("RobotAPIKeyRepository.FindActiveRobotID", time.Now(), &err)
+	var robotID string
+	err = r.db.GetContext(ctx, &robotID,
+		"SELECT robot_id FROM robot_api_keys WHERE key_hash = ? AND (revoked_at IS NULL OR revoked_at > ?)",
+		keyHash, time.Now(),
+	)
+	return robotID, err
+}
+
+// ロボットのキーを発行の新しい順に返す (失効したものも含む)
+func (r *RobotAPIKeyRepository) ListByRobot(ctx context.Context, robotID string) (_ []model.RobotAPIKey, err error) {
+	defer observeRepoCall("RobotAPIKeyRepository.ListByRobot", time.Now(), &err)
+	keys := []model.RobotAPIKey{}
+	err = r.db.SelectContext(ctx, &keys,
+		"SELECT key_id, key_hash, robot_id, created_at, revoked_at FROM robot_api_keys WHERE robot_id = ? ORDER BY key_id DESC",
+		robotID,
+	)
+	return keys, err
+}
+
+// at に失効させる (すでに失効していれば sql.ErrNoRows)
+// 失効の時刻が at より後のキーは at に早める
+func (r *RobotAPIKeyRepository) Revoke(ctx context.Context, keyID int64, at time.Time) (err error) {
+	defer observeRepoCall("RobotAPIKeyRepository.Revoke", time.Now(), &err)
+	result, err := r.db.ExecContext(ctx,
+		"UPDATE robot_api_keys SET revoked_at = ? WHERE key_id = ? AND (revoked_at IS NULL OR revoked_at > ?)",
+		at, keyID, at,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ロボットの keyID 以外の有効なキーを at に失効させる (ローテーション)
+func (r *RobotAPIKeyRepository) RevokeOthers(ctx context.Context, robotID string, keyID int64, at time.Time) (err error) {
+	defer observeRepoCall("RobotAPIKeyRepository.RevokeOthers", time.Now(), &err)
+	_, err = r.db.ExecContext(ctx,
+		"UPDATE robot_api_keys SET revoked_at = ? WHERE robot_id = ? AND key_id <> ? AND (revoked_at IS NULL OR revoked_at > ?)",
+		at, robotID, keyID, at,
+	)
+	return err
+}
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestRobotUpdateStateNotFound(t *testing.T) {
+	db := &fakeDB{exec: affectedRows(func(string, []any) int64 { return 0 })}
+	if err := NewRobotRepository(db).UpdateState(context.Background(), "robot", "idle"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("err = %v, want sql.ErrNoRows", err)
+	}
+}
@@ -2,56 +2,106 @@ package repository
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/samber/lo"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"backend/internal/model"
+
 	"github.com/google/uuid"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/jmoiron/sqlx"
 )
 
-const sessionCacheSize = 512
+type SessionCacheConfig struct {
+	// プロセス内 LRU のエントリ数と TTL
+	// DB を直接更新して失効させたセッションも TTL 経過後には反映されるよう、TTL が 0 以下ならデフォルトの TTL を使う
+	Size int
+	TTL  time.Duration
+	// 存在しないセッションIDのネガティブキャッシュ (NegativeTTL 0 で無効)
+	NegativeSize int
+	NegativeTTL  time.Duration
+}
 
-type sessionCacheEntry struct {
-	userID    int
-	expiresAt time.Time
+var DefaultSessionCacheConfig = SessionCacheConfig{
+	Size:         512,
+	TTL:          time.Minute,
+	NegativeSize: 1024,
+	NegativeTTL:  5 * time.Second,
+}
+
+// セッションキャッシュのヒット率
+type SessionCacheStats struct {
+	Hits         int64 `json:"hits"`
+	Misses       int64 `json:"misses"`
+	NegativeHits int64 `json:"negative_hits"`
 }
 
 type sessionRepoState struct {
-	once         sync.Once
-	sessionCache *lru.Cache[string, sessionCacheEntry]
+	once          sync.Once
+	cacheConfig   SessionCacheConfig
+	sessionStore  SessionStore
+	negativeCache *expirable.LRU[string, struct{}] // nil ならネガティブキャッシュ無効
+	bus           SessionInvalidationBus           // nil なら他インスタンスへの通知なし
+
+	hits, misses, negativeHits atomic.Int64
 }
 
-func (s *sessionRepoState) initSessionCache() *lru.Cache[string, sessionCacheEntry] {
+func (s *sessionRepoState) init() *sessionRepoState {
 	s.once.Do(func() {
-		s.sessionCache = lo.Must(lru.New[string, sessionCacheEntry](sessionCacheSize))
+		if s.cacheConfig.Size <= 0 {
+			s.cacheConfig = DefaultSessionCacheConfig
+		}
+		if s.cacheConfig.TTL <= 0 {
+			s.cacheConfig.TTL = DefaultSessionCacheConfig.TTL
+		}
+		if s.sessionStore == nil {
+			s.sessionStore = lo.Must(NewLRUSessionStore(s.cacheConfig.Size, s.cacheConfig.TTL))
+		}
+		if s.cacheConfig.NegativeTTL > 0 && s.cacheConfig.NegativeSize > 0 {
+			s.negativeCache = expirable.NewLRU[string, struct{}](s.cacheConfig.NegativeSize, nil, s.cacheConfig.NegativeTTL)
+		}
+		if s.bus != nil {
+			sessionStore := s.sessionStore
+			s.bus.Subscribe(func(sessionIDs []string) {
+				sessionStore.Delete(context.Background(), sessionIDs...)
+			})
+		}
 	})
-	return s.sessionCache
+	return s
 }
 
 type SessionRepository struct {
 	db           DBTX
-	sessionCache *lru.Cache[string, sessionCacheEntry] // sessionID -> {userID, expiresAt}
+	state        *sessionRepoState
+	sessionStore SessionStore // sessionID -> {userID, expiresAt}
+	hooks        *commitHooks
 }
 
 func NewSessionRepository(db DBTX) *SessionRepository {
-	cache, err := lru.New[string, sessionCacheEntry](sessionCacheSize)
-	if err != nil {
-		panic(err)
-	}
-	return &SessionRepository{
-		db:           db,
-		sessionCache: cache,
-	}
+	return newSessionRepository(db, &sessionRepoState{}, nil)
 }
 
-func newSessionRepository(db DBTX, state *sessionRepoState) *SessionRepository {
-	return &SessionRepository{db: db, sessionCache: state.initSessionCache()}
+func newSessionRepository(db DBTX, state *sessionRepoState, hooks *commitHooks) *SessionRepository {
+	state.init()
+	return &SessionRepository{db: db, state: state, sessionStore: state.sessionStore, hooks: hooks}
+}
+
+func (r *SessionRepository) CacheStats() SessionCacheStats {
+	return SessionCacheStats{
+		Hits:         r.state.hits.Load(),
+		Misses:       r.state.misses.Load(),
+		NegativeHits: r.state.negativeHits.Load(),
+	}
 }
 
 // セッションを作成し、セッションIDと有効期限を返す
-func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, duration time.Duration) (string, time.Time, error) {
+func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, duration time.Duration) (_ string, _ time.Time, err error) {
+	defer observeRepoCall("SessionRepository.Create", time.Now(), &err)
 	sessionUUID, err := uuid.NewRandom()
 	if err != nil {
 		return "", time.Time{}, err
@@ -65,33 +115,174 @@ func (r *SessionRepository) Create(ctx context.Context, userBusinessID int, dura
 		return "", time.Time{}, err
 	}
 
-	// キャッシュへ保存
-	r.sessionCache.Add(sessionIDStr, sessionCacheEntry{userID: userBusinessID, expiresAt: expiresAt})
+	// キャッシュへ保存 (トランザクション中はコミット後。ロールバックされたセッションを有効として残さない)
+	r.hooks.add(func() {
+		r.sessionStore.Set(ctx, sessionIDStr, userBusinessID, expiresAt)
+	})
 
 	return sessionIDStr, expiresAt, nil
 }
 
 // セッションIDからユーザーIDを取得
-func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID string) (int, error) {
+func (r *SessionRepository) FindUserBySessionID(ctx context.Context, sessionID string) (_ int, err error) {
+	defer observeRepoCall("SessionRepository.FindUserBySessionID", time.Now(), &err)
 	now := time.Now()
 
 	// 先にキャッシュを確認 (あるはず)
-	if v, ok := r.sessionCache.Get(sessionID); ok {
-		if now.Before(v.expiresAt) {
-			return v.userID, nil
+	if userID, expiresAt, ok := r.sessionStore.Get(ctx, sessionID); ok {
+		r.state.hits.Add(1)
+		if now.Before(expiresAt) {
+			return userID, nil
 		}
-		r.sessionCache.Remove(sessionID)
+		r.sessionStore.Delete(ctx, sessionID)
 		return 0, errors.New("session expired")
 	}
+	if neg := r.state.negativeCache; neg != nil {
+		if _, ok := neg.Get(sessionID); ok {
+			r.state.negativeHits.Add(1)
+			return 0, sql.ErrNoRows
+		}
+	}
+	r.state.misses.Add(1)
 
-	var userID int
+	var row struct {
+		UserID    int       `db:"user_id"`
+		ExpiresAt time.Time `db:"expires_at"`
+	}
 	query := `
-		SELECT 
-			s.user_id
+		SELECT
+			s.user_id,
+			s.expires_at
 		FROM user_sessions s
 		WHERE s.session_uuid = ? AND s.expires_at > ?`
-	if err := r.db.GetContext(ctx, &userID, query, sessionID, now); err != nil {
+	if err := r.db.GetContext(ctx, &row, query, sessionID, now); err != nil {
+		if errors.Is(err, sql.ErrNoRows) && r.state.negativeCache != nil {
+			r.state.negativeCache.Add(sessionID, struct{}{})
+		}
+		return 0, err
+	}
+	r.sessionStore.Set(ctx, sessionID, row.UserID, row.ExpiresAt)
+	return row.UserID, nil
+}
+
+// キャッシュから削除し、他インスタンスにも失効を通知する
+// トランザクション中はコミット後に行う (コミット前の読み取りで削除前の行をキャッシュに詰め直されないように)
+func (r *SessionRepository) invalidate(ctx context.Context, sessionIDs ...string) {
+	r.hooks.add(func() {
+		r.sessionStore.Delete(ctx, sessionIDs...)
+		if r.state.bus != nil {
+			if err := r.state.bus.Publish(ctx, sessionIDs...); err != nil {
+				log.Printf("[SessionRepository] 失効通知失敗: %v", err)
+			}
+		}
+	})
+}
+
+// セッションを失効させる
+// キャッシュからも削除し、失効通知で他インスタンスのキャッシュにも即時反映される
+func (r *SessionRepository) Delete(ctx context.Context, sessionID string) (err error) {
+	defer observeRepoCall("SessionRepository.Delete", time.Now(), &err)
+	query := "DELETE FROM user_sessions WHERE session_uuid = ?"
+	if _, err := r.db.ExecContext(ctx, query, sessionID); err != nil {
+		return err
+	}
+	r.invalidate(ctx, sessionID)
+	return nil
+}
+
+// 有効なセッションの有効期限を now+duration に延長する
+// 既に失効している場合は sql.ErrNoRows を返す
+func (r *SessionRepository) Refresh(ctx context.Context, sessionID string, duration time.Duration) (_ time.Time, err error) {
+	defer observeRepoCall("SessionRepository.Refresh", time.Now(), &err)
+	now := time.Now()
+	expiresAt := now.Add(duration)
+
+	query := "UPDATE user_sessions SET expires_at = ? WHERE session_uuid = ? AND expires_at > ?"
+	result, err := r.db.ExecContext(ctx, query, expiresAt, sessionID, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if affected == 0 {
+		r.sessionStore.Delete(ctx, sessionID)
+		return time.Time{}, sql.ErrNoRows
+	}
+
+	var userID int
+	if err := r.db.GetContext(ctx, &userID, "SELECT user_id FROM user_sessions WHERE session_uuid = ?", sessionID); err != nil {
+		return time.Time{}, err
+	}
+	r.sessionStore.Set(ctx, sessionID, userID, expiresAt)
+
+	return expiresAt, nil
+}
+
+// ユーザーの全セッションを失効させる
+func (r *SessionRepository) DeleteByUserID(ctx context.Context, userID int) (err error) {
+	defer observeRepoCall("SessionRepository.DeleteByUserID", time.Now(), &err)
+	var sessionIDs []string
+	if err := r.db.SelectContext(ctx, &sessionIDs, "SELECT session_uuid FROM user_sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM user_sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	r.invalidate(ctx, sessionIDs...)
+	return nil
+}
+
+// ユーザーの有効なセッション一覧を取得
+func (r *SessionRepository) ListByUserID(ctx context.Context, userID int) (_ []model.UserSession, err error) {
+	defer observeRepoCall("SessionRepository.ListByUserID", time.Now(), &err)
+	sessions := []model.UserSession{}
+	query := `
+		SELECT id, session_uuid, expires_at
+		FROM user_sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY id DESC`
+	if err := r.db.SelectContext(ctx, &sessions, query, userID, time.Now()); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// ユーザーのセッションを ID 指定で失効させる
+// 他ユーザーのセッションであれば sql.ErrNoRows を返す
+func (r *SessionRepository) DeleteByID(ctx context.Context, userID int, id int64) (err error) {
+	defer observeRepoCall("SessionRepository.DeleteByID", time.Now(), &err)
+	var sessionID string
+	if err := r.db.GetContext(ctx, &sessionID, "SELECT session_uuid FROM user_sessions WHERE id = ? AND user_id = ?", id, userID); err != nil {
+		return err
+	}
+	return r.Delete(ctx, sessionID)
+}
+
+// 期限切れのセッションを古い順に最大 limit 件削除し、削除件数を返す
+// キャッシュからも同じセッションIDを取り除く
+func (r *SessionRepository) DeleteExpired(ctx context.Context, limit int) (_ int, err error) {
+	defer observeRepoCall("SessionRepository.DeleteExpired", time.Now(), &err)
+	var sessionIDs []string
+	query := "SELECT session_uuid FROM user_sessions WHERE expires_at <= ? ORDER BY expires_at LIMIT ?"
+	if err := r.db.SelectContext(ctx, &sessionIDs, query, time.Now(), limit); err != nil {
+		return 0, err
+	}
+	if len(sessionIDs) == 0 {
+		return 0, nil
+	}
+
+	query, args, err := sqlx.In("DELETE FROM user_sessions WHERE session_uuid IN (?)", sessionIDs)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(query), args...); err != nil {
 		return 0, err
 	}
-	return userID, nil
+	r.invalidate(ctx, sessionIDs...)
+	return len(sessionIDs), nil
 }
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLRUSessionStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	store, _ := NewLRUSessionStore(16, 20*time.Millisecond)
+	store.Set(ctx, "s", 1, time.Now().Add(time.Hour))
+	if _, _, ok := store.Get(ctx, "s"); !ok {
+		t.Fatal("session missing right after Set")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, _, ok := store.Get(ctx, "s"); ok {
+		t.Fatal("session still cached after the TTL")
+	}
+}
+
+func TestSessionNegativeCache(t *testing.T) {
+	ctx := context.Background()
+	lookups := 0
+	db := &fakeDB{get: func(context.Context, any, string, ...any) error {
+		lookups++
+		return sql.ErrNoRows
+	}}
+	repo := NewStore(db, WithSessionCacheConfig(SessionCacheConfig{Size: 16, NegativeSize: 16, NegativeTTL: time.Minute})).SessionRepo
+
+	for range 3 {
+		if _, err := repo.FindUserBySessionID(ctx, "unknown"); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("err = %v, want sql.ErrNoRows", err)
+		}
+	}
+	if lookups != 1 {
+		t.Fatalf("DB lookups = %d, want 1 with negative caching", lookups)
+	}
+	if stats := repo.CacheStats(); stats.Misses != 1 || stats.NegativeHits != 2 || stats.Hits != 0 {
+		t.Fatalf("stats = %+v, want 1 miss and 2 negative hits", stats)
+	}
+}
+
+func TestSessionCacheStatsCountHits(t *testing.T) {
+	ctx := context.Background()
+	repo := NewStore(&fakeDB{}).SessionRepo
+	sessionID, _, err := repo.Create(ctx, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := repo.FindUserBySessionID(ctx, sessionID); err != nil || userID != 1 {
+		t.Fatalf("FindUserBySessionID = %d, %v; want 1", userID, err)
+	}
+	if stats := repo.CacheStats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Fatalf("stats = %+v, want a single hit", stats)
+	}
+}
+
+func TestSessionCacheTTLIsBounded(t *testing.T) {
+	for _, cfg := range []SessionCacheConfig{{}, {Size: 16}} {
+		repo := NewStore(&fakeDB{}, WithSessionCacheConfig(cfg)).SessionRepo
+		if ttl := repo.state.cacheConfig.TTL; ttl <= 0 {
+			t.Fatalf("config %+v: TTL = %v, want a bounded default so DB-side revocations expire", cfg, ttl)
+		}
+	}
+}
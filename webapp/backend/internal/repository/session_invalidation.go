@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionInvalidationBus はセッションの失効をインスタンス間に伝える
+// プロセス内 LRU を使っている場合、他インスタンスで失効したセッションを各インスタンスのキャッシュから追い出すのに使う
+type SessionInvalidationBus interface {
+	Publish(ctx context.Context, sessionIDs ...string) error
+	Subscribe(handler func(sessionIDs []string))
+}
+
+// DB を直接更新して失効させた場合は
+//
+//	redis-cli PUBLISH session:invalidate <session_uuid>[,<session_uuid>...]
+//
+// で全インスタンスのキャッシュから追い出せる
+const redisSessionInvalidationChannel = "session:invalidate"
+
+// Redis pub/sub による実装（複数インスタンス用）
+type redisSessionInvalidationBus struct {
+	client *redis.Client
+}
+
+func NewRedisSessionInvalidationBus(ctx context.Context, addr, password string, db int) (SessionInvalidationBus, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return &redisSessionInvalidationBus{client: client}, nil
+}
+
+func (b *redisSessionInvalidationBus) Publish(ctx context.Context, sessionIDs ...string) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+	return b.client.Publish(ctx, redisSessionInvalidationChannel, strings.Join(sessionIDs, ",")).Err()
+}
+
+// 購読はプロセスの寿命いっぱい続ける (切断時は go-redis が再接続する)
+func (b *redisSessionInvalidationBus) Subscribe(handler func(sessionIDs []string)) {
+	sub := b.client.Subscribe(context.Background(), redisSessionInvalidationChannel)
+	go func() {
+		for msg := range sub.Channel() {
+			sessionIDs := strings.Split(msg.Payload, ",")
+			handler(sessionIDs)
+		}
+		log.Printf("[SessionInvalidationBus] 購読が終了しました")
+	}()
+}
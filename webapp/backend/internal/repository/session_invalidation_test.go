@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 同期的に配送するだけの SessionInvalidationBus
+type testSessionBus struct {
+	mu        sync.Mutex
+	handlers  []func(sessionIDs []string)
+	published [][]string
+}
+
+func (b *testSessionBus) Publish(_ context.Context, sessionIDs ...string) error {
+	b.mu.Lock()
+	b.published = append(b.published, sessionIDs)
+	handlers := append([]func([]string){}, b.handlers...)
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(sessionIDs)
+	}
+	return nil
+}
+
+func (b *testSessionBus) Subscribe(handler func(sessionIDs []string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+func TestSessionRevocationIsBroadcast(t *testing.T) {
+	ctx := context.Background()
+	bus := &testSessionBus{}
+	// インスタンスごとにプロセス内 LRU を持つ構成
+	cacheA, _ := NewLRUSessionStore(16, 0)
+	cacheB, _ := NewLRUSessionStore(16, 0)
+	a := NewStore(&fakeDB{}, WithSessionStore(cacheA), WithSessionInvalidationBus(bus))
+	b := NewStore(&fakeDB{get: func(context.Context, any, string, ...any) error { return sql.ErrNoRows }},
+		WithSessionStore(cacheB), WithSessionInvalidationBus(bus))
+
+	cacheB.Set(ctx, "s", 7, time.Now().Add(time.Hour))
+	if err := a.SessionRepo.Delete(ctx, "s"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cacheB.Get(ctx, "s"); ok {
+		t.Fatal("session revoked on instance a is still cached on instance b")
+	}
+	if _, err := b.SessionRepo.FindUserBySessionID(ctx, "s"); err == nil {
+		t.Fatal("revoked session is still valid on instance b")
+	}
+}
+
+func TestDeleteByUserIDPublishesAllSessions(t *testing.T) {
+	ctx := context.Background()
+	bus := &testSessionBus{}
+	db := &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		*dest.(*[]string) = []string{"s1", "s2"}
+		return nil
+	}}
+	store := NewStore(db, WithSessionInvalidationBus(bus))
+
+	if err := store.SessionRepo.DeleteByUserID(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(bus.published) != 1 || len(bus.published[0]) != 2 {
+		t.Fatalf("published = %v, want both sessions in one message", bus.published)
+	}
+}
+
+func TestDeleteByUserIDInvalidatesAfterCommit(t *testing.T) {
+	ctx := context.Background()
+	db, rec := newRecordingDB()
+	rec.query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"session_uuid"}, [][]driver.Value{{"s1"}}, nil
+	}
+	bus := &testSessionBus{}
+	cache, _ := NewLRUSessionStore(16, 0)
+	store := NewStore(db, WithSessionStore(cache), WithSessionInvalidationBus(bus))
+
+	cache.Set(ctx, "s1", 7, time.Now().Add(time.Hour))
+	err := store.ExecTx(ctx, func(txStore *Store) error {
+		if err := txStore.SessionRepo.DeleteByUserID(ctx, 7); err != nil {
+			return err
+		}
+		if _, _, ok := cache.Get(ctx, "s1"); !ok || len(bus.published) != 0 {
+			t.Error("session was invalidated before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.Get(ctx, "s1"); ok || len(bus.published) != 1 {
+		t.Fatalf("session is still cached (published %v) after commit", bus.published)
+	}
+
+	// ロールバックされた場合は失効させない
+	cache.Set(ctx, "s1", 7, time.Now().Add(time.Hour))
+	rollback := errors.New("rollback")
+	err = store.ExecTx(ctx, func(txStore *Store) error {
+		if err := txStore.SessionRepo.DeleteByUserID(ctx, 7); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("err = %v, want rollback", err)
+	}
+	if _, _, ok := cache.Get(ctx, "s1"); !ok || len(bus.published) != 1 {
+		t.Fatal("rolled back deletion invalidated the session")
+	}
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionStore はセッションの参照キャッシュ
+// 複数インスタンスで動かす場合は Redis 実装を使うことで、参照・失効がインスタンス間で一貫する
+type SessionStore interface {
+	Get(ctx context.Context, sessionID string) (userID int, expiresAt time.Time, ok bool)
+	Set(ctx context.Context, sessionID string, userID int, expiresAt time.Time)
+	Delete(ctx context.Context, sessionIDs ...string)
+}
+
+type sessionCacheEntry struct {
+	userID    int
+	expiresAt time.Time
+}
+
+// プロセス内 LRU による実装（単一インスタンス用）
+// ttl を指定すると、DB 側で直接失効させたセッションも ttl 経過後には反映される (0 で無期限。Store 経由ではデフォルトの TTL が入る)
+type lruSessionStore struct {
+	cache *expirable.LRU[string, sessionCacheEntry] // sessionID -> {userID, expiresAt}
+}
+
+func NewLRUSessionStore(size int, ttl time.Duration) (SessionStore, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid session cache size: %d", size)
+	}
+	return &lruSessionStore{cache: expirable.NewLRU[string, sessionCacheEntry](size, nil, ttl)}, nil
+}
+
+func (s *lruSessionStore) Get(_ context.Context, sessionID string) (int, time.Time, bool) {
+	v, ok := s.cache.Get(sessionID)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	return v.userID, v.expiresAt, true
+}
+
+func (s *lruSessionStore) Set(_ context.Context, sessionID string, userID int, expiresAt time.Time) {
+	s.cache.Add(sessionID, sessionCacheEntry{userID: userID, expiresAt: expiresAt})
+}
+
+func (s *lruSessionStore) Delete(_ context.Context, sessionIDs ...string) {
+	for _, id := range sessionIDs {
+		s.cache.Remove(id)
+	}
+}
+
+const redisSessionKeyPrefix = "session:"
+
+// Redis による実装（複数インスタンス用）
+// 値は user_id、TTL は有効期限までの残り時間
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func NewRedisSessionStore(ctx context.Context, addr, password string, db int) (SessionStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return &redisSessionStore{client: client}, nil
+}
+
+func (s *redisSessionStore) Get(ctx context.Context, sessionID string) (int, time.Time, bool) {
+	key := redisSessionKeyPrefix + sessionID
+	pipe := s.client.Pipeline()
+	getCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		// redis.Nil はキャッシュミス、それ以外は DB にフォールバックさせる
+		return 0, time.Time{}, false
+	}
+	userID, err := strconv.Atoi(getCmd.Val())
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	ttl := ttlCmd.Val()
+	if ttl <= 0 {
+		return 0, time.Time{}, false
+	}
+	return userID, time.Now().Add(ttl), true
+}
+
+func (s *redisSessionStore) Set(ctx context.Context, sessionID string, userID int, expiresAt time.Time) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return
+	}
+	_ = s.client.Set(ctx, redisSessionKeyPrefix+sessionID, strconv.Itoa(userID), ttl).Err()
+}
+
+func (s *redisSessionStore) Delete(ctx context.Context, sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	keys := make([]string, len(sessionIDs))
+	for i, id := range sessionIDs {
+		keys[i] = redisSessionKeyPrefix + id
+	}
+	_ = s.client.Del(ctx, keys...).Err()
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLRUSessionStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLRUSessionStore(2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	store.Set(ctx, "a", 1, expiresAt)
+	store.Set(ctx, "b", 2, expiresAt)
+
+	if userID, got, ok := store.Get(ctx, "a"); !ok || userID != 1 || !got.Equal(expiresAt) {
+		t.Fatalf("Get(a) = %d, %v, %v; want 1, %v, true", userID, got, ok, expiresAt)
+	}
+	store.Delete(ctx, "a", "b")
+	if _, _, ok := store.Get(ctx, "b"); ok {
+		t.Fatal("deleted session is still cached")
+	}
+}
+
+func TestSessionStoreIsSharedAcrossStores(t *testing.T) {
+	ctx := context.Background()
+	shared, _ := NewLRUSessionStore(16, 0)
+	var deleted []any
+	db := &fakeDB{exec: func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "DELETE") {
+			deleted = append(deleted, args...)
+		}
+		return driver.RowsAffected(1), nil
+	}}
+	a := NewStore(db, WithSessionStore(shared))
+	b := NewStore(&fakeDB{get: func(context.Context, any, string, ...any) error { return sql.ErrNoRows }}, WithSessionStore(shared))
+
+	sessionID, _, err := a.SessionRepo.Create(ctx, 42, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b は DB に行がなくても、共有ストアからセッションを引ける
+	if userID, err := b.SessionRepo.FindUserBySessionID(ctx, sessionID); err != nil || userID != 42 {
+		t.Fatalf("FindUserBySessionID = %d, %v; want 42", userID, err)
+	}
+
+	if err := a.SessionRepo.Delete(ctx, sessionID); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != sessionID {
+		t.Fatalf("deleted %v, want the session row", deleted)
+	}
+	if _, err := b.SessionRepo.FindUserBySessionID(ctx, sessionID); err == nil {
+		t.Fatal("session revoked on one store is still valid on the other")
+	}
+}
+
+func TestFindUserBySessionIDRejectsExpiredCacheEntry(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := NewLRUSessionStore(16, 0)
+	repo := NewStore(&fakeDB{}, WithSessionStore(cache)).SessionRepo
+	cache.Set(ctx, "expired", 1, time.Now().Add(-time.Second))
+
+	if _, err := repo.FindUserBySessionID(ctx, "expired"); err == nil {
+		t.Fatal("expired session was accepted")
+	}
+	if _, _, ok := cache.Get(ctx, "expired"); ok {
+		t.Fatal("expired session was left in the cache")
+	}
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshSessionSlidesExpiration(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := NewLRUSessionStore(16, 0)
+	db := &fakeDB{
+		exec: func(context.Context, string, ...any) (sql.Result, error) { return driver.RowsAffected(1), nil },
+		get: func(_ context.Context, dest any, _ string, _ ...any) error {
+			*dest.(*int) = 7
+			return nil
+		},
+	}
+	repo := NewStore(db, WithSessionStore(cache)).SessionRepo
+	cache.Set(ctx, "s", 7, time.Now().Add(time.Minute))
+
+	expiresAt, err := repo.Refresh(ctx, "s", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Until(expiresAt) < 59*time.Minute {
+		t.Fatalf("expiresAt = %v, want about an hour from now", expiresAt)
+	}
+	if userID, cached, ok := cache.Get(ctx, "s"); !ok || userID != 7 || !cached.Equal(expiresAt) {
+		t.Fatalf("cache = %d, %v, %v; want the extended expiration", userID, cached, ok)
+	}
+}
+
+func TestRefreshExpiredSessionEvictsCache(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := NewLRUSessionStore(16, 0)
+	repo := NewStore(&fakeDB{}, WithSessionStore(cache)).SessionRepo
+	cache.Set(ctx, "s", 7, time.Now().Add(time.Minute))
+
+	if _, err := repo.Refresh(ctx, "s", time.Hour); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("err = %v, want sql.ErrNoRows", err)
+	}
+	if _, _, ok := cache.Get(ctx, "s"); ok {
+		t.Fatal("session that could not be refreshed is still cached")
+	}
+}
+
+func TestDeleteExpiredEvictsCache(t *testing.T) {
+	ctx := context.Background()
+	cache, _ := NewLRUSessionStore(16, 0)
+	db := &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		*dest.(*[]string) = []string{"old"}
+		return nil
+	}}
+	repo := NewStore(db, WithSessionStore(cache)).SessionRepo
+	cache.Set(ctx, "old", 7, time.Now().Add(time.Minute))
+	cache.Set(ctx, "live", 8, time.Now().Add(time.Minute))
+
+	n, err := repo.DeleteExpired(ctx, 100)
+	if err != nil || n != 1 {
+		t.Fatalf("DeleteExpired = %d, %v; want 1, nil", n, err)
+	}
+	if _, _, ok := cache.Get(ctx, "old"); ok {
+		t.Fatal("purged session is still cached")
+	}
+	if _, _, ok := cache.Get(ctx, "live"); !ok {
+		t.Fatal("live session was evicted")
+	}
+}
+
+func TestSessionReadDuringRevocationDoesNotRecache(t *testing.T) {
+	ctx := context.Background()
+	db, rec := newRecordingDB()
+	committed := func() bool {
+		for _, entry := range rec.entries() {
+			if entry == "COMMIT" {
+				return true
+			}
+		}
+		return false
+	}
+	rec.query = func(query string, _ []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		if strings.Contains(query, "SELECT session_uuid") {
+			return []string{"session_uuid"}, [][]driver.Value{{"s1"}}, nil
+		}
+		// コミットまでは他の接続から削除前の行が見える
+		if committed() {
+			return nil, nil, nil
+		}
+		return []string{"user_id", "expires_at"}, [][]driver.Value{{int64(7), time.Now().Add(time.Hour)}}, nil
+	}
+	cache, _ := NewLRUSessionStore(16, 0)
+	store := NewStore(db, WithSessionStore(cache))
+
+	cache.Set(ctx, "s1", 7, time.Now().Add(time.Hour))
+	err := store.ExecTx(ctx, func(txStore *Store) error {
+		if err := txStore.SessionRepo.DeleteByUserID(ctx, 7); err != nil {
+			return err
+		}
+		// 削除とコミットの間に別のリクエストが読む
+		_, err := store.SessionRepo.FindUserBySessionID(ctx, "s1")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SessionRepo.FindUserBySessionID(ctx, "s1"); err == nil {
+		t.Fatal("revoked session is still valid after commit")
+	}
+}
+
+func TestRolledBackSessionIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	db, _ := newRecordingDB()
+	cache, _ := NewLRUSessionStore(16, 0)
+	store := NewStore(db, WithSessionStore(cache))
+
+	var sessionID string
+	rollback := errors.New("rollback")
+	err := store.ExecTx(ctx, func(txStore *Store) error {
+		var err error
+		if sessionID, _, err = txStore.SessionRepo.Create(ctx, 7, time.Hour); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("err = %v, want rollback", err)
+	}
+	if _, _, ok := cache.Get(ctx, sessionID); ok {
+		t.Fatal("session from a rolled back transaction is cached")
+	}
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ShippingOrdersVersionStore は配送中一覧キャッシュのバージョンをインスタンス間で共有する
+// 他インスタンスで注文が更新されたら、各インスタンスのキャッシュを捨てさせるのに使う
+type ShippingOrdersVersionStore interface {
+	Current(ctx context.Context) (int64, error)
+	Bump(ctx context.Context) error
+}
+
+// DB を直接更新した場合は
+//
+//	redis-cli INCR shipping_orders:version
+//
+// で全インスタンスのキャッシュを捨てられる
+const redisShippingOrdersVersionKey = "shipping_orders:version"
+
+// Redis の INCR による実装（複数インスタンス用）
+type redisShippingOrdersVersionStore struct {
+	client *redis.Client
+}
+
+func NewRedisShippingOrdersVersionStore(ctx context.Context, addr, password string, db int) (ShippingOrdersVersionStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: password,
+		DB:       db,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return &redisShippingOrdersVersionStore{client: client}, nil
+}
+
+func (s *redisShippingOrdersVersionStore) Current(ctx context.Context) (int64, error) {
+	v, err := s.client.Get(ctx, redisShippingOrdersVersionKey).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (s *redisShippingOrdersVersionStore) Bump(ctx context.Context) error {
+	return s.client.Incr(ctx, redisShippingOrdersVersionKey).Err()
+}
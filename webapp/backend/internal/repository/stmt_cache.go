@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/samber/lo"
+)
+
+// プリペアドステートメントとしてキャッシュする SQL 文の数 (0 なら使わない)
+// interpolateParams=true では毎回サーバーで SQL を解析し直すので、よく使う一覧・件数のクエリだけ準備済みの文を使い回す
+var PreparedStatementCacheSize = 0
+
+// SQL 文ごとのプリペアドステートメントのキャッシュ
+// *sql.Stmt は接続ごとに必要になった時点で準備し直すので、DB 単位で持てば接続ごとにキャッシュされる
+type stmtCache struct {
+	db    DBTX // 非トランザクションの DB
+	stmts *lru.Cache[string, *sqlx.Stmt]
+}
+
+// size が 0 以下なら nil を返す (nil のまま使うと準備せずに実行する)
+func newStmtCache(db DBTX, size int) *stmtCache {
+	if size <= 0 {
+		return nil
+	}
+	// 追い出した文は使用中のクエリが終わってから閉じられる
+	stmts := lo.Must(lru.NewWithEvict(size, func(_ string, stmt *sqlx.Stmt) { stmt.Close() }))
+	return &stmtCache{db: db, stmts: stmts}
+}
+
+// query の準備済みの文を返す
+// db がトランザクションなら、そのトランザクションの接続で実行する文にして返す (コミット・ロールバック時に閉じられる)
+// 準備済みの文を使えない場合 (キャッシュ無効、sqlx 以外の DBTX) は nil を返す
+func (c *stmtCache) prepare(ctx context.Context, db DBTX, query string) (*sqlx.Stmt, error) {
+	if c == nil {
+		return nil, nil
+	}
+	var tx *sqlx.Tx
+	switch d := db.(type) {
+	case *sqlx.DB:
+	case *sqlx.Tx:
+		tx = d
+	default:
+		return nil, nil
+	}
+
+	stmt, ok := c.stmts.Get(query)
+	if !ok {
+		prepared, err := c.db.PreparexContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		// 同時に準備した場合は先に入ったほうを使う
+		if prev, found, _ := c.stmts.PeekOrAdd(query, prepared); found {
+			prepared.Close()
+			prepared = prev
+		}
+		stmt = prepared
+	}
+	if tx != nil {
+		return tx.StmtxContext(ctx, stmt), nil
+	}
+	return stmt, nil
+}
+
+func (c *stmtCache) getContext(ctx context.Context, db DBTX, dest any, query string, args ...any) error {
+	stmt, err := c.prepare(ctx, db, query)
+	if err != nil {
+		return err
+	}
+	if stmt == nil {
+		return db.GetContext(ctx, dest, query, args...)
+	}
+	return stmt.GetContext(ctx, dest, args...)
+}
+
+func (c *stmtCache) selectContext(ctx context.Context, db DBTX, dest any, query string, args ...any) error {
+	stmt, err := c.prepare(ctx, db, query)
+	if err != nil {
+		return err
+	}
+	if stmt == nil {
+		return db.SelectContext(ctx, dest, query, args...)
+	}
+	return stmt.SelectContext(ctx, dest, args...)
+}
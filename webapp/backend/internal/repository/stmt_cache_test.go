@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStmtCacheDisabled(t *testing.T) {
+	if c := newStmtCache(&fakeDB{}, 0); c != nil {
+		t.Fatal("cache must be disabled when size is 0")
+	}
+}
+
+func TestStmtCacheFallsBackForNonSqlxDB(t *testing.T) {
+	db := newListOrdersDB(0)
+	c := newStmtCache(db, 8)
+
+	var count int
+	if err := c.getContext(context.Background(), db, &count, "SELECT COUNT(*) FROM order_items"); err != nil {
+		t.Fatalf("getContext: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("count = %d, want 1", count)
+	}
+	if err := c.selectContext(context.Background(), db, &[]int{}, "SELECT 1"); err != nil {
+		t.Fatalf("selectContext: %v", err)
+	}
+	if len(db.selects) != 1 || c.stmts.Len() != 0 {
+		t.Fatalf("selects = %d, cached = %d; want a plain query without preparing", len(db.selects), c.stmts.Len())
+	}
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"sync"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -9,6 +10,9 @@ import (
 type Store struct {
 	db DBTX
 
+	// トランザクション中のみ設定される (コミット後に実行する処理)
+	hooks *commitHooks
+
 	sessionRepoState *sessionRepoState
 	productRepoState *productRepoState
 	orderRepoState   *orderRepoState
@@ -17,25 +21,107 @@ type Store struct {
 	SessionRepo *SessionRepository
 	ProductRepo *ProductRepository
 	OrderRepo   *OrderRepository
+	TokenRepo   *TokenRepository
+
+	RefreshTokenRepo    *RefreshTokenRepository
+	LoginEventRepo      *LoginEventRepository
+	UserIdentityRepo    *UserIdentityRepository
+	RecoveryCodeRepo    *RecoveryCodeRepository
+	IdempotencyRepo     *IdempotencyKeyRepository
+	WebhookRepo         *WebhookRepository
+	FavoriteRepo        *FavoriteRepository
+	DeliveryPlanRepo    *DeliveryPlanRepository
+	RobotRepo           *RobotRepository
+	RobotAPIKeyRepo     *RobotAPIKeyRepository
+	DeliveryFailureRepo *DeliveryFailureRepository
+
+	// 商品画像の保存先 (未設定なら nil)
+	Images ImageStore
 }
 
 // state を使う回すためのコンストラクタ
-func newStore(db DBTX, sessionState *sessionRepoState, productState *productRepoState, orderState *orderRepoState) *Store {
+func newStore(db DBTX, hooks *commitHooks, sessionState *sessionRepoState, productState *productRepoState, orderState *orderRepoState) *Store {
 	store := &Store{
-		db:               db,
-		sessionRepoState: sessionState,
-		productRepoState: productState,
-		orderRepoState:   orderState,
-		UserRepo:         NewUserRepository(db),
-		SessionRepo:      newSessionRepository(db, sessionState),
-		ProductRepo:      newProductRepository(db, productState),
-		OrderRepo:        newOrderRepository(db, orderState),
+		db:                  db,
+		hooks:               hooks,
+		sessionRepoState:    sessionState,
+		productRepoState:    productState,
+		orderRepoState:      orderState,
+		UserRepo:            NewUserRepository(db),
+		SessionRepo:         newSessionRepository(db, sessionState, hooks),
+		ProductRepo:         newProductRepository(db, productState, hooks),
+		OrderRepo:           newOrderRepository(db, orderState, hooks),
+		TokenRepo:           NewTokenRepository(db),
+		RefreshTokenRepo:    NewRefreshTokenRepository(db),
+		LoginEventRepo:      NewLoginEventRepository(db),
+		UserIdentityRepo:    NewUserIdentityRepository(db),
+		RecoveryCodeRepo:    NewRecoveryCodeRepository(db),
+		IdempotencyRepo:     NewIdempotencyKeyRepository(db),
+		WebhookRepo:         NewWebhookRepository(db),
+		FavoriteRepo:        NewFavoriteRepository(db),
+		DeliveryPlanRepo:    NewDeliveryPlanRepository(db),
+		RobotRepo:           NewRobotRepository(db),
+		RobotAPIKeyRepo:     NewRobotAPIKeyRepository(db),
+		DeliveryFailureRepo: NewDeliveryFailureRepository(db),
+		Images:              productState.images,
 	}
 	return store
 }
 
-func NewStore(db DBTX) *Store {
-	return newStore(db, &sessionRepoState{}, &productRepoState{}, &orderRepoState{})
+type StoreOption func(s *storeOptions)
+
+type storeOptions struct {
+	sessionStore       SessionStore
+	sessionCacheConfig SessionCacheConfig
+	sessionBus         SessionInvalidationBus
+
+	shippingOrdersVersion ShippingOrdersVersionStore
+
+	imageStore ImageStore
+}
+
+// セッションの参照キャッシュを差し替える（未指定ならプロセス内 LRU）
+func WithSessionStore(sessionStore SessionStore) StoreOption {
+	return func(o *storeOptions) {
+		o.sessionStore = sessionStore
+	}
+}
+
+// セッションキャッシュのサイズ・TTL・ネガティブキャッシュを設定する
+func WithSessionCacheConfig(cfg SessionCacheConfig) StoreOption {
+	return func(o *storeOptions) {
+		o.sessionCacheConfig = cfg
+	}
+}
+
+// セッション失効をインスタンス間で通知する (未指定なら通知しない)
+func WithSessionInvalidationBus(bus SessionInvalidationBus) StoreOption {
+	return func(o *storeOptions) {
+		o.sessionBus = bus
+	}
+}
+
+// 配送中一覧キャッシュのバージョンをインスタンス間で共有する (未指定ならプロセス内だけで管理する)
+func WithShippingOrdersVersionStore(versionStore ShippingOrdersVersionStore) StoreOption {
+	return func(o *storeOptions) {
+		o.shippingOrdersVersion = versionStore
+	}
+}
+
+// 商品画像の保存先を設定する (未指定なら画像のアップロードはできない)
+func WithImageStore(imageStore ImageStore) StoreOption {
+	return func(o *storeOptions) {
+		o.imageStore = imageStore
+	}
+}
+
+func NewStore(db DBTX, opts ...StoreOption) *Store {
+	var o storeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	sessionState := &sessionRepoState{sessionStore: o.sessionStore, cacheConfig: o.sessionCacheConfig, bus: o.sessionBus}
+	return newStore(db, nil, sessionState, &productRepoState{images: o.imageStore}, &orderRepoState{baseDB: db, sharedVersion: o.shippingOrdersVersion, stmts: newStmtCache(db, PreparedStatementCacheSize)})
 }
 
 func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error) error {
@@ -50,10 +136,43 @@ func (s *Store) ExecTx(ctx context.Context, fn func(txStore *Store) error) error
 	}
 	defer tx.Rollback()
 
-	txStore := newStore(tx, s.sessionRepoState, s.productRepoState, s.orderRepoState)
+	hooks := &commitHooks{}
+	txStore := newStore(tx, hooks, s.sessionRepoState, s.productRepoState, s.orderRepoState)
 	if err := fn(txStore); err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	hooks.run()
+	return nil
+}
+
+// コミット後に実行する処理 (キャッシュの無効化など)
+// ロールバックされた更新でキャッシュを消したり、コミット前の読み取りで古い値を詰め直されたりしないようにする
+type commitHooks struct {
+	mu  sync.Mutex
+	fns []func()
+}
+
+// トランザクション外 (h が nil) ならその場で実行する
+func (h *commitHooks) add(fn func()) {
+	if h == nil {
+		fn()
+		return
+	}
+	h.mu.Lock()
+	h.fns = append(h.fns, fn)
+	h.mu.Unlock()
+}
+
+func (h *commitHooks) run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
 }
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 生トークンは保存せず SHA-256 のみ保持する
+func HashToken(rawToken string) string {
+	digest := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(digest[:])
+}
+
+type TokenRepository struct {
+	db DBTX
+}
+
+func NewTokenRepository(db DBTX) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func (r *TokenRepository) Create(ctx context.Context, token *model.APIToken) (int64, error) {
+	query := `
+		INSERT INTO tokens (token_hash, user_id, name, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	result, err := r.db.ExecContext(ctx, query, token.TokenHash, token.UserID, token.Name, token.Scopes, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// 有効（未失効・期限内）なトークンをハッシュから取得
+func (r *TokenRepository) FindActiveByHash(ctx context.Context, tokenHash string) (*model.APIToken, error) {
+	var token model.APIToken
+	query := `
+		SELECT token_id, token_hash, user_id, name, scopes, created_at, expires_at, revoked_at
+		FROM tokens
+		WHERE token_hash = ?
+			AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > ?)`
+	if err := r.db.GetContext(ctx, &token, query, tokenHash, time.Now()); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *TokenRepository) Revoke(ctx context.Context, tokenID int64) error {
+	query := "UPDATE tokens SET revoked_at = ? WHERE token_id = ? AND revoked_at IS NULL"
+	result, err := r.db.ExecContext(ctx, query, time.Now(), tokenID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
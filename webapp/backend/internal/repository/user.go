@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"time"
 
 	"backend/internal/model"
 )
@@ -20,7 +21,7 @@ func NewUserRepository(db DBTX) *UserRepository {
 // ログイン時に使用
 func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*model.User, error) {
 	var user model.User
-	query := "SELECT user_id, password_hash, user_name FROM users WHERE user_name = ?"
+	query := "SELECT user_id, password_hash, user_name, failed_login_count, locked_until, role, totp_secret, totp_enabled FROM users WHERE user_name = ?"
 
 	err := r.db.GetContext(ctx, &user, query, userName)
 	if err != nil {
@@ -31,3 +32,115 @@ func (r *UserRepository) FindByUserName(ctx context.Context, userName string) (*
 	}
 	return &user, nil
 }
+
+// ユーザーIDからユーザー情報を取得
+func (r *UserRepository) FindByID(ctx context.Context, userID int) (*model.User, error) {
+	var user model.User
+	columns := "user_id, password_hash, user_name, failed_login_count, locked_until, role, totp_secret, totp_enabled, display_name, settings"
+	if OrderZoneEnabled {
+		columns += ", zone"
+	}
+	query := `
+		SELECT ` + columns + `
+		FROM users
+		WHERE user_id = ?`
+
+	if err := r.db.GetContext(ctx, &user, query, userID); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ログイン失敗回数を加算し、threshold に達したら lockedUntil までロックする
+// ロックした場合はカウンタを 0 に戻す
+func (r *UserRepository) RecordLoginFailure(ctx context.Context, userID int, threshold int, lockedUntil time.Time) error {
+	query := `
+		UPDATE users
+		SET
+			locked_until = IF(failed_login_count + 1 >= ?, ?, locked_until),
+			failed_login_count = IF(failed_login_count + 1 >= ?, 0, failed_login_count + 1)
+		WHERE user_id = ?`
+	_, err := r.db.ExecContext(ctx, query, threshold, lockedUntil, threshold, userID)
+	return err
+}
+
+// ログイン失敗回数とロックを解除する
+func (r *UserRepository) ResetLoginFailures(ctx context.Context, userID int) error {
+	query := "UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE user_id = ?"
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// ユーザーのロールを取得
+func (r *UserRepository) FindRoleByID(ctx context.Context, userID int) (string, error) {
+	var role string
+	if err := r.db.GetContext(ctx, &role, "SELECT role FROM users WHERE user_id = ?", userID); err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (r *UserRepository) UpdateRole(ctx context.Context, userID int, role string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE user_id = ?", role, userID)
+	return err
+}
+
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, userID int, passwordHash string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET password_hash = ? WHERE user_id = ?", passwordHash, userID)
+	return err
+}
+
+// TOTP の登録を開始する (確認が済むまでは無効のまま)
+func (r *UserRepository) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET totp_secret = ?, totp_enabled = FALSE WHERE user_id = ?", secret, userID)
+	return err
+}
+
+func (r *UserRepository) EnableTOTP(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE WHERE user_id = ?", userID)
+	return err
+}
+
+func (r *UserRepository) DisableTOTP(ctx context.Context, userID int) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled = FALSE WHERE user_id = ?", userID)
+	return err
+}
+
+// 現在のハッシュが oldHash のときだけ newHash に置き換える
+// 置き換えなかった場合は false を返す
+func (r *UserRepository) ReplacePasswordHash(ctx context.Context, userID int, oldHash, newHash string) (bool, error) {
+	query := "UPDATE users SET password_hash = ? WHERE user_id = ? AND password_hash = ?"
+	result, err := r.db.ExecContext(ctx, query, newHash, userID, oldHash)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// ゾーンは OrderZoneEnabled の場合のみ更新する
+func (r *UserRepository) UpdateProfile(ctx context.Context, userID int, displayName string, settings model.UserSettings, zone *string) error {
+	if OrderZoneEnabled {
+		_, err := r.db.ExecContext(ctx, "UPDATE users SET display_name = ?, settings = ?, zone = ? WHERE user_id = ?", displayName, settings, zone, userID)
+		return err
+	}
+	query := "UPDATE users SET display_name = ?, settings = ? WHERE user_id = ?"
+	_, err := r.db.ExecContext(ctx, query, displayName, settings, userID)
+	return err
+}
+
+// ユーザーを作成し、ユーザーIDを返す
+func (r *UserRepository) Create(ctx context.Context, userName, passwordHash string) (int, error) {
+	result, err := r.db.ExecContext(ctx, "INSERT INTO users (user_name, password_hash) VALUES (?, ?)", userName, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type UserIdentityRepository struct {
+	db DBTX
+}
+
+func NewUserIdentityRepository(db DBTX) *UserIdentityRepository {
+	return &UserIdentityRepository{db: db}
+}
+
+// 外部 ID (issuer, subject) に紐づくユーザーIDを取得
+func (r *UserIdentityRepository) FindUserID(ctx context.Context, issuer, subject string) (int, error) {
+	var userID int
+	query := "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?"
+	if err := r.db.GetContext(ctx, &userID, query, issuer, subject); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// 外部 ID をユーザーに紐付ける
+// 既に紐付け済み (別のユーザーを含む) なら false を返す
+func (r *UserIdentityRepository) Link(ctx context.Context, userID int, issuer, subject string) (bool, error) {
+	query := "INSERT INTO user_identities (user_id, issuer, subject, created_at) VALUES (?, ?, ?, ?)"
+	if _, err := r.db.ExecContext(ctx, query, userID, issuer, subject, time.Now()); err != nil {
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDuplicateEntry {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"backend/internal/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 注文ステータス変更時・在庫数がしきい値を下回ったときに Webhook の配信を積むか
+var WebhooksEnabled = false
+
+type WebhookRepository struct {
+	db DBTX
+}
+
+func NewWebhookRepository(db DBTX) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, webhook *model.Webhook) (int64, error) {
+	query := "INSERT INTO webhooks (user_id, url, secret, created_at) VALUES (?, ?, ?, ?)"
+	result, err := r.db.ExecContext(ctx, query, webhook.UserID, webhook.URL, webhook.Secret, webhook.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// ユーザーの Webhook 一覧 (userID が NULL なら管理者登録分)
+func (r *WebhookRepository) List(ctx context.Context, userID sql.NullInt64) ([]model.Webhook, error) {
+	webhooks := []model.Webhook{}
+	query := "SELECT id, user_id, url, created_at FROM webhooks WHERE user_id <=> ? ORDER BY id"
+	if err := r.db.SelectContext(ctx, &webhooks, query, userID); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// 削除対象が無ければ sql.ErrNoRows を返す
+func (r *WebhookRepository) Delete(ctx context.Context, userID sql.NullInt64, id int64) error {
+	result, err := r.db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND user_id <=> ?", id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 注文明細のステータス変更イベントを、注文したユーザーと管理者登録の Webhook 宛てに積む
+// shipped_status は今回進めた単位のステータスで、更新後の進捗もあわせて送る
+func (r *WebhookRepository) EnqueueOrderStatusEvents(ctx context.Context, orderIDs []int64, newStatus string) error {
+	if !WebhooksEnabled || len(orderIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	query, args, err := sqlx.In(`
+		INSERT INTO webhook_deliveries (webhook_id, payload, next_attempt_at, created_at)
+		SELECT
+			w.id,
+			JSON_OBJECT(
+				'event', 'order.status_changed',
+				'order_id', o.order_item_id,
+				'user_id', o.user_id,
+				'shipped_status', ?,
+				'quantity', o.quantity,
+				'dispatched_quantity', o.dispatched_quantity,
+				'completed_quantity', o.completed_quantity,
+				'occurred_at', ?
+			),
+			?,
+			?
+		FROM order_items o
+		JOIN webhooks w ON w.user_id = o.user_id OR w.user_id IS NULL
+		WHERE o.order_item_id IN (?)`, newStatus, now.Format(time.RFC3339), now, now, orderIDs)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, r.db.Rebind(query), args...)
+	return err
+}
+
+// 在庫数がしきい値を下回ったイベントを、管理者登録の Webhook 宛てに積む
+func (r *WebhookRepository) EnqueueLowStockEvents(ctx context.Context, alerts []model.LowStockAlert) error {
+	if !WebhooksEnabled {
+		return nil
+	}
+	now := time.Now()
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, payload, next_attempt_at, created_at)
+		SELECT
+			w.id,
+			JSON_OBJECT(
+				'event', 'product.low_stock',
+				'product_id', ?,
+				'stock', ?,
+				'threshold', ?,
+				'occurred_at', ?
+			),
+			?,
+			?
+		FROM webhooks w
+		WHERE w.user_id IS NULL`
+	for _, alert := range alerts {
+		if _, err := r.db.ExecContext(ctx, query, alert.ProductID, alert.Stock, alert.Threshold, now.Format(time.RFC3339), now, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 配信期限の来た未配信イベントを取得し、next_attempt_at を lease 後にずらして確保する
+// 他の dispatcher がロック中の行は SKIP LOCKED で飛ばすので、同じイベントを二重に配信しない
+// 配信中にプロセスが落ちても lease が切れれば再び配信対象になる
+// トランザクション内で呼ぶこと
+func (r *WebhookRepository) ClaimDue(ctx context.Context, maxAttempts, limit int, lease time.Duration) ([]model.WebhookDelivery, error) {
+	if _, ok := r.db.(*sqlx.Tx); !ok {
+		return nil, fmt.Errorf("ClaimDue must be called within a transaction")
+	}
+
+	now := time.Now()
+	var deliveries []model.WebhookDelivery
+	query := `
+		SELECT d.id, d.webhook_id, w.url, w.secret, d.payload, d.attempts
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.delivered_at IS NULL AND d.next_attempt_at <= ? AND d.attempts < ?
+		ORDER BY d.id
+		LIMIT ?
+		FOR UPDATE OF d SKIP LOCKED`
+	if err := r.db.SelectContext(ctx, &deliveries, query, now, maxAttempts, limit); err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return deliveries, nil
+	}
+
+	ids := make([]int64, len(deliveries))
+	for i, d := range deliveries {
+		ids[i] = d.ID
+	}
+	claimQuery, args, err := sqlx.In("UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (?)", now.Add(lease), ids)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.db.ExecContext(ctx, r.db.Rebind(claimQuery), args...); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func (r *WebhookRepository) MarkDelivered(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE webhook_deliveries SET delivered_at = ?, attempts = attempts + 1 WHERE id = ?", time.Now(), id)
+	return err
+}
+
+func (r *WebhookRepository) MarkFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	query := "UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?"
+	_, err := r.db.ExecContext(ctx, query, nextAttemptAt, lastError, id)
+	return err
+}
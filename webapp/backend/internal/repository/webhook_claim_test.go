@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestClaimDueLeasesDeliveriesInTransaction(t *testing.T) {
+	db, rec := newRecordingDB()
+	rec.query = func(string, []driver.NamedValue) ([]string, [][]driver.Value, error) {
+		return []string{"id", "webhook_id", "url", "secret", "payload", "attempts"}, [][]driver.Value{
+			{int64(1), int64(10), "https://example.com/a", "s", []byte(`{}`), int64(0)},
+			{int64(2), int64(10), "https://example.com/a", "s", []byte(`{}`), int64(3)},
+		}, nil
+	}
+	var leasedUntil time.Time
+	rec.exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		if strings.Contains(query, "next_attempt_at = ?") {
+			leasedUntil = args[0].Value.(time.Time)
+		}
+		return driver.RowsAffected(2), nil
+	}
+
+	var deliveries []model.WebhookDelivery
+	start := time.Now()
+	err := NewStore(db).ExecTx(context.Background(), func(txStore *Store) error {
+		var err error
+		deliveries, err = txStore.WebhookRepo.ClaimDue(context.Background(), 5, 100, time.Minute)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[1].Attempts != 3 {
+		t.Fatalf("deliveries = %+v, want both rows", deliveries)
+	}
+
+	log := rec.entries()
+	if len(log) != 3 ||
+		!strings.Contains(log[0], "SKIP LOCKED") ||
+		!strings.Contains(log[1], "UPDATE webhook_deliveries") || !strings.Contains(log[1], "IN (?, ?)") ||
+		log[2] != "COMMIT" {
+		t.Fatalf("log = %q, want a locking select, the lease update and commit", log)
+	}
+	if lease := leasedUntil.Sub(start); lease < time.Minute || lease > time.Minute+time.Second {
+		t.Fatalf("leased for %v, want about a minute", lease)
+	}
+}
+
+func TestClaimDueRequiresTransaction(t *testing.T) {
+	if _, err := NewStore(&fakeDB{}).WebhookRepo.ClaimDue(context.Background(), 5, 100, time.Minute); err == nil {
+		t.Fatal("want an error outside a transaction")
+	}
+}
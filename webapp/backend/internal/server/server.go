@@ -1,20 +1,27 @@
 package server
 
 import (
+	"backend/internal/config"
 	"backend/internal/db"
 	"backend/internal/handler"
 	"backend/internal/middleware"
 	"backend/internal/repository"
 	"backend/internal/service"
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jmoiron/sqlx"
 	pprotein "github.com/kaz/pprotein/integration"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Server struct {
@@ -27,26 +34,228 @@ func NewServer() (*Server, *sqlx.DB, error) {
 		return nil, nil, err
 	}
 
-	store := repository.NewStore(dbConn)
+	sessionStore, err := newSessionStore()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	repository.OrderBatchInsertChunkSize = config.Int("ORDER_BATCH_INSERT_CHUNK_SIZE", repository.OrderBatchInsertChunkSize)
+	repository.OrderStatusUpdateChunkSize = config.Int("ORDER_STATUS_UPDATE_CHUNK_SIZE", repository.OrderStatusUpdateChunkSize)
+	repository.ShippingOrdersMaxStaleness = config.Duration("SHIPPING_ORDERS_MAX_STALENESS", 0)
+	repository.PreparedStatementCacheSize = config.Int("DB_PREPARED_STATEMENT_CACHE_SIZE", 0)
+	repository.DeliveryWindowLookahead = config.Duration("DELIVERY_WINDOW_LOOKAHEAD", 0)
+	repository.OrderDestinationEnabled = config.Bool("ORDER_DESTINATION_ENABLED", false)
+	repository.ProductVolumeEnabled = config.Bool("PRODUCT_VOLUME_ENABLED", false)
+	repository.OrderZoneEnabled = config.Bool("ORDER_ZONE_ENABLED", false)
+	repository.OrderETAEnabled = config.Bool("ORDER_ETA_ENABLED", false)
+	repository.OrderListWindowCount = config.Bool("ORDER_LIST_WINDOW_COUNT", false)
+	repository.OrderSearchFullText = config.Bool("ORDER_SEARCH_FULLTEXT", false)
+	repository.OrderSearchNgramSize = config.Int("ORDER_SEARCH_NGRAM_SIZE", repository.OrderSearchNgramSize)
+	repository.ProductSearchFullText = config.Bool("PRODUCT_SEARCH_FULLTEXT", false)
+	repository.ProductSearchFuzzyMinScore = config.Float("PRODUCT_SEARCH_FUZZY_MIN_SCORE", repository.ProductSearchFuzzyMinScore)
+	repository.ProductListPageCacheSize = config.Int("PRODUCT_LIST_PAGE_CACHE_SIZE", repository.ProductListPageCacheSize)
+	repository.ProductListCacheMaxBytes = config.Int("PRODUCT_LIST_CACHE_MAX_BYTES", repository.ProductListCacheMaxBytes)
+	if locale := config.String("PRODUCT_NAME_SORT_LOCALE", ""); repository.ValidProductNameSortLocale(locale) {
+		repository.ProductNameSortLocale = locale
+	} else {
+		log.Printf("Warning: unsupported PRODUCT_NAME_SORT_LOCALE %q, sorting names by the column collation", locale)
+	}
+
+	sessionBus, err := newSessionInvalidationBus()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	shippingOrdersVersion, err := newShippingOrdersVersionStore()
+	if err != nil {
+		dbConn.Close()
+		return nil, nil, err
+	}
+	sessionCacheConfig := repository.SessionCacheConfig{
+		Size:         config.Int("SESSION_CACHE_SIZE", repository.DefaultSessionCacheConfig.Size),
+		TTL:          config.Duration("SESSION_CACHE_TTL", repository.DefaultSessionCacheConfig.TTL),
+		NegativeSize: config.Int("SESSION_NEGATIVE_CACHE_SIZE", repository.DefaultSessionCacheConfig.NegativeSize),
+		NegativeTTL:  config.Duration("SESSION_NEGATIVE_CACHE_TTL", repository.DefaultSessionCacheConfig.NegativeTTL),
+	}
+	store := repository.NewStore(dbConn,
+		repository.WithSessionStore(sessionStore),
+		repository.WithSessionCacheConfig(sessionCacheConfig),
+		repository.WithSessionInvalidationBus(sessionBus),
+		repository.WithShippingOrdersVersionStore(shippingOrdersVersion),
+		repository.WithImageStore(newImageStore()),
+	)
+
+	authService := service.NewAuthService(store, service.AuthConfig{
+		SessionDuration:      config.Duration("SESSION_DURATION", 24*time.Hour),
+		RefreshTokenDuration: config.Duration("REFRESH_TOKEN_DURATION", 30*24*time.Hour),
+		MaxLoginFailures:     config.Int("LOGIN_MAX_FAILURES", 0),
+		LoginFailureWindow:   config.Duration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LockoutThreshold:     config.Int("ACCOUNT_LOCKOUT_THRESHOLD", 0),
+		LockoutDuration:      config.Duration("ACCOUNT_LOCKOUT_DURATION", 15*time.Minute),
+		BcryptCost:           config.Int("BCRYPT_COST", bcrypt.DefaultCost),
+		OIDC: service.OIDCConfig{
+			Issuer:          config.String("OIDC_ISSUER", ""),
+			ClientID:        config.String("OIDC_CLIENT_ID", ""),
+			ClientSecret:    config.String("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:     config.String("OIDC_REDIRECT_URL", ""),
+			Scopes:          strings.Fields(config.String("OIDC_SCOPES", "")),
+			AutoCreateUsers: config.Bool("OIDC_AUTO_CREATE_USERS", false),
+		},
+	})
+	// 期限切れセッションの定期削除 (SESSION_PURGE_INTERVAL=0 で無効)
+	if interval := config.Duration("SESSION_PURGE_INTERVAL", 10*time.Minute); interval > 0 {
+		purger := service.NewSessionPurger(store, interval, config.Int("SESSION_PURGE_BATCH_SIZE", 1000))
+		go purger.Run(context.Background())
+	}
+
+	// 完了済みの古い注文明細を order_items_archive に移す (ORDER_ARCHIVE_ENABLED=true で有効)
+	if config.Bool("ORDER_ARCHIVE_ENABLED", false) {
+		repository.OrderArchiveEnabled = true
+		archiver := service.NewOrderArchiver(store,
+			config.Duration("ORDER_ARCHIVE_INTERVAL", time.Hour),
+			config.Duration("ORDER_ARCHIVE_AFTER", 30*24*time.Hour),
+			config.Int("ORDER_ARCHIVE_BATCH_SIZE", 1000),
+		)
+		go archiver.Run(context.Background())
+	}
+
+	// 注文ステータス変更・在庫不足の Webhook 通知 (WEBHOOKS_ENABLED=true で有効)
+	if config.Bool("WEBHOOKS_ENABLED", false) {
+		repository.WebhooksEnabled = true
+		dispatcher := service.NewWebhookDispatcher(store,
+			config.Duration("WEBHOOK_DISPATCH_INTERVAL", time.Second),
+			config.Int("WEBHOOK_MAX_ATTEMPTS", 10),
+		)
+		go dispatcher.Run(context.Background())
+	}
+
+	service.DeliveryPriorityWeight = config.Int("DELIVERY_PRIORITY_WEIGHT", service.DeliveryPriorityWeight)
+	if objective := config.String("DELIVERY_PLAN_OBJECTIVE", service.DeliveryObjectiveValue); service.ValidDeliveryPlanObjective(objective) {
+		service.DeliveryPlanObjective = objective
+	} else {
+		log.Printf("Warning: unsupported DELIVERY_PLAN_OBJECTIVE %q, maximizing value", objective)
+	}
+	service.DeliveryDeadlineHorizon = config.Duration("DELIVERY_DEADLINE_HORIZON", service.DeliveryDeadlineHorizon)
+	service.DeliveryDeadlineWeight = config.Int("DELIVERY_DEADLINE_WEIGHT", service.DeliveryDeadlineWeight)
+	service.DeliveryPlanStreaming = config.Bool("DELIVERY_PLAN_STREAMING", false)
+	if algorithm := config.String("DELIVERY_PLAN_ALGORITHM", service.DeliveryAlgorithmDP); service.ValidDeliveryPlanAlgorithm(algorithm) {
+		service.DeliveryPlanAlgorithm = algorithm
+	} else {
+		log.Printf("Warning: unsupported DELIVERY_PLAN_ALGORITHM %q, using dp", algorithm)
+	}
+	if epsilon := config.Float("DELIVERY_PLAN_FPTAS_EPSILON", service.DeliveryPlanFPTASEpsilon); epsilon > 0 && epsilon < 1 {
+		service.DeliveryPlanFPTASEpsilon = epsilon
+	} else {
+		log.Printf("Warning: DELIVERY_PLAN_FPTAS_EPSILON must be in (0, 1), using %v", service.DeliveryPlanFPTASEpsilon)
+	}
+	service.DeliveryPlanDPBudget = config.Int("DELIVERY_PLAN_DP_BUDGET", service.DeliveryPlanDPBudget)
+	service.DeliveryPlanCollapseDuplicates = config.Bool("DELIVERY_PLAN_COLLAPSE_DUPLICATES", service.DeliveryPlanCollapseDuplicates)
+	service.DeliveryPlanMaxOrders = config.Int("DELIVERY_PLAN_MAX_ORDERS", service.DeliveryPlanMaxOrders)
+	if margin := config.Int("DELIVERY_PLAN_CAPACITY_MARGIN_PERCENT", service.DeliveryPlanCapacityMarginPercent); margin >= 0 && margin < 100 {
+		service.DeliveryPlanCapacityMarginPercent = margin
+	} else {
+		log.Printf("Warning: DELIVERY_PLAN_CAPACITY_MARGIN_PERCENT must be in [0, 100), using %d", service.DeliveryPlanCapacityMarginPercent)
+	}
+	service.DeliveryPlanTimeBudget = config.Duration("DELIVERY_PLAN_TIME_BUDGET", service.DeliveryPlanTimeBudget)
+	service.DeliveryETAPerStop = config.Duration("DELIVERY_ETA_PER_STOP", service.DeliveryETAPerStop)
+	service.DeliveryETASpeed = config.Float("DELIVERY_ETA_SPEED", service.DeliveryETASpeed)
+	service.DeliveryPlanSkipLocked = config.Bool("DELIVERY_PLAN_SKIP_LOCKED", false)
+	service.RobotRegistryEnabled = config.Bool("ROBOT_REGISTRY_ENABLED", false)
+	service.DeliveryFailureEnabled = config.Bool("DELIVERY_FAILURE_ENABLED", false)
+	service.DeliveryFailureMaxRetries = config.Int("DELIVERY_FAILURE_MAX_RETRIES", service.DeliveryFailureMaxRetries)
+	service.RobotHeartbeatTimeout = config.Duration("ROBOT_HEARTBEAT_TIMEOUT", service.RobotHeartbeatTimeout)
+	service.RobotPushPollInterval = config.Duration("ROBOT_PUSH_POLL_INTERVAL", service.RobotPushPollInterval)
+	service.DeliveryPlanCacheSize = config.Int("DELIVERY_PLAN_CACHE_SIZE", service.DeliveryPlanCacheSize)
+	service.DeliveryPlanCacheTTL = config.Duration("DELIVERY_PLAN_CACHE_TTL", service.DeliveryPlanCacheTTL)
+	service.DeliveryPlanMaxConcurrent = config.Int("DELIVERY_PLAN_MAX_CONCURRENT", service.DeliveryPlanMaxConcurrent)
+
+	// 配送計画のリース (DELIVERY_PLAN_LEASE_TTL=0 で無効)
+	// 確認されないまま期限が切れた計画の注文を未配送に戻す
+	if ttl := config.Duration("DELIVERY_PLAN_LEASE_TTL", 0); ttl > 0 {
+		service.DeliveryPlanLeaseTTL = ttl
+		service.DeliveryPlanIdempotent = config.Bool("DELIVERY_PLAN_IDEMPOTENT", false)
+		reaper := service.NewDeliveryPlanReaper(store,
+			config.Duration("DELIVERY_PLAN_REAP_INTERVAL", 10*time.Second),
+			config.Int("DELIVERY_PLAN_REAP_BATCH_SIZE", 100),
+		)
+		go reaper.Run(context.Background())
+	} else if config.Bool("DELIVERY_PLAN_IDEMPOTENT", false) {
+		log.Println("Warning: DELIVERY_PLAN_IDEMPOTENT requires DELIVERY_PLAN_LEASE_TTL, ignoring")
+	}
+
+	service.LowStockThreshold = config.Int("LOW_STOCK_THRESHOLD", 0)
 
-	authService := service.NewAuthService(store)
 	orderService := service.NewOrderService(store)
 	productService := service.NewProductService(store)
+	// よく一緒に注文される商品の定期集計 (PRODUCT_RECOMMENDATION_INTERVAL=0 で無効)
+	if interval := config.Duration("PRODUCT_RECOMMENDATION_INTERVAL", 10*time.Minute); interval > 0 {
+		refresher := service.NewRecommendationRefresher(productService, interval,
+			config.Duration("PRODUCT_RECOMMENDATION_LOOKBACK", 30*24*time.Hour),
+		)
+		go refresher.Run(context.Background())
+	}
 	robotService := service.NewRobotService(store)
+	// 配送計画を非同期で作るワーカープール (/api/robot/delivery-plan/jobs)
+	service.DeliveryPlanJobTimeBudget = config.Duration("DELIVERY_PLAN_JOB_TIME_BUDGET", service.DeliveryPlanJobTimeBudget)
+	service.DeliveryPlanJobTTL = config.Duration("DELIVERY_PLAN_JOB_TTL", service.DeliveryPlanJobTTL)
+	planJobs := service.NewDeliveryPlanJobs(robotService,
+		config.Int("DELIVERY_PLAN_JOB_WORKERS", 2),
+		config.Int("DELIVERY_PLAN_JOB_QUEUE_SIZE", 64),
+	)
+	go planJobs.Run(context.Background())
+	webhookService := service.NewWebhookService(store)
 
-	authHandler := handler.NewAuthHandler(authService)
+	authHandler := handler.NewAuthHandler(authService, handler.CookieConfig{
+		Secure:   config.Bool("COOKIE_SECURE", handler.DefaultCookieConfig.Secure),
+		HttpOnly: config.Bool("COOKIE_HTTP_ONLY", handler.DefaultCookieConfig.HttpOnly),
+		SameSite: parseSameSite(config.String("COOKIE_SAME_SITE", "")),
+		Domain:   config.String("COOKIE_DOMAIN", handler.DefaultCookieConfig.Domain),
+		MaxAge:   config.Duration("COOKIE_MAX_AGE", handler.DefaultCookieConfig.MaxAge),
+	})
 	productHandler := handler.NewProductHandler(productService)
+	productHandler.ServeImagesDirectly = config.Bool("SERVE_IMAGES_DIRECTLY", false)
 	orderHandler := handler.NewOrderHandler(orderService)
-	robotHandler := handler.NewRobotHandler(robotService)
+	robotHandler := handler.NewRobotHandler(robotService, planJobs)
+	webhookHandler := handler.NewWebhookHandler(webhookService)
 
-	userAuthMW := middleware.UserAuthMiddleware(store.SessionRepo)
+	userAuth := middleware.UserAuthMiddleware(store.SessionRepo, store.TokenRepo)
 
-	robotAPIKey := os.Getenv("ROBOT_API_KEY")
-	if robotAPIKey == "" {
-		log.Println("Warning: ROBOT_API_KEY is not set. Using default key 'test-robot-key'")
-		robotAPIKey = "test-robot-key"
+	// ロボットは robot_api_keys のロボットごとのキーで認証する
+	// 全ロボット共通の ROBOT_API_KEY は ROBOT_SHARED_API_KEY_ENABLED=false で無効にできる
+	var robotAPIKey string
+	if config.Bool("ROBOT_SHARED_API_KEY_ENABLED", true) {
+		robotAPIKey = os.Getenv("ROBOT_API_KEY")
+		if robotAPIKey == "" {
+			log.Println("Warning: ROBOT_API_KEY is not set. Using default key 'test-robot-key'")
+			robotAPIKey = "test-robot-key"
+		}
+	}
+	robotAuthMW := middleware.RobotAuthMiddleware(store.RobotAPIKeyRepo, robotAPIKey,
+		config.Duration("ROBOT_API_KEY_CACHE_TTL", 10*time.Second),
+	)
+	adminOnlyMW := middleware.AdminOnly(store.UserRepo)
+
+	// Cookie 認証の更新系リクエストに対する CSRF 対策 (double-submit cookie)
+	// フロントエンドの axios はログイン時に発行する XSRF-TOKEN Cookie をヘッダーに載せて送る
+	// ベンチマーカーがヘッダーなしで呼ぶルートは setupRoutes で対象外にしている
+	csrfMW := func(next http.Handler) http.Handler { return next }
+	if config.Bool("CSRF_ENABLED", true) {
+		csrfMW = middleware.CSRFMiddleware()
+	}
+
+	// ログインの IP 単位レート制限 (LOGIN_RATE_LIMIT_PER_SEC=0 で無効)
+	loginRateLimitMW := func(next http.Handler) http.Handler { return next }
+	if rate := config.Float("LOGIN_RATE_LIMIT_PER_SEC", 0); rate > 0 {
+		limiter := middleware.NewTokenBucketLimiter(rate, config.Int("LOGIN_RATE_LIMIT_BURST", 10))
+		loginRateLimitMW = middleware.RateLimitByIPMiddleware(limiter)
+	}
+
+	// ロボットの API キー単位レート制限 (ROBOT_RATE_LIMIT_PER_SEC=0 で無効)
+	robotRateLimitMW := func(next http.Handler) http.Handler { return next }
+	if rate := config.Float("ROBOT_RATE_LIMIT_PER_SEC", 0); rate > 0 {
+		limiter := middleware.NewTokenBucketLimiter(rate, config.Int("ROBOT_RATE_LIMIT_BURST", 20))
+		robotRateLimitMW = middleware.RateLimitByAPIKeyMiddleware(limiter)
 	}
-	robotAuthMW := middleware.RobotAuthMiddleware(robotAPIKey)
 
 	r := chi.NewRouter()
 
@@ -61,33 +270,232 @@ func NewServer() (*Server, *sqlx.DB, error) {
 		Router: r,
 	}
 
-	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, userAuthMW, robotAuthMW)
+	s.setupRoutes(authHandler, productHandler, orderHandler, robotHandler, webhookHandler, userAuth, robotAuthMW, robotRateLimitMW, adminOnlyMW, csrfMW, loginRateLimitMW)
 
 	return s, dbConn, nil
 }
 
+// COOKIE_SAME_SITE (lax / strict / none) を http.SameSite に変換する
+// 未指定や不明な値の場合は属性を付けない
+func parseSameSite(v string) http.SameSite {
+	switch strings.ToLower(v) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
+
+// SESSION_STORE=redis のときは Redis をセッションキャッシュに使う（複数インスタンス構成用）
+// 未指定 (memory) の場合は nil を返し、Store 側のプロセス内 LRU を使う
+func newSessionStore() (repository.SessionStore, error) {
+	switch backend := os.Getenv("SESSION_STORE"); backend {
+	case "", "memory":
+		return nil, nil
+	case "redis":
+		addr, password, redisDB, err := redisConfig()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sessionStore, err := repository.NewRedisSessionStore(ctx, addr, password, redisDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to redis session store: %w", err)
+		}
+		log.Printf("Using redis session store (%s)", addr)
+		return sessionStore, nil
+	default:
+		return nil, fmt.Errorf("unknown SESSION_STORE: %s", backend)
+	}
+}
+
+// SESSION_INVALIDATION_BUS=redis のときは Redis pub/sub でセッション失効を他インスタンスに通知する
+// プロセス内 LRU を複数インスタンスで使う場合に有効にする
+func newSessionInvalidationBus() (repository.SessionInvalidationBus, error) {
+	switch backend := os.Getenv("SESSION_INVALIDATION_BUS"); backend {
+	case "", "none":
+		return nil, nil
+	case "redis":
+		addr, password, redisDB, err := redisConfig()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		bus, err := repository.NewRedisSessionInvalidationBus(ctx, addr, password, redisDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to redis invalidation bus: %w", err)
+		}
+		log.Printf("Using redis session invalidation bus (%s)", addr)
+		return bus, nil
+	default:
+		return nil, fmt.Errorf("unknown SESSION_INVALIDATION_BUS: %s", backend)
+	}
+}
+
+// SHIPPING_ORDERS_VERSION_STORE=redis のときは配送中一覧キャッシュのバージョンを Redis で共有する
+// 複数インスタンスで配送計画を返す場合に有効にする
+func newShippingOrdersVersionStore() (repository.ShippingOrdersVersionStore, error) {
+	switch backend := os.Getenv("SHIPPING_ORDERS_VERSION_STORE"); backend {
+	case "", "none":
+		return nil, nil
+	case "redis":
+		addr, password, redisDB, err := redisConfig()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		versionStore, err := repository.NewRedisShippingOrdersVersionStore(ctx, addr, password, redisDB)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to redis shipping orders version store: %w", err)
+		}
+		log.Printf("Using redis shipping orders version store (%s)", addr)
+		return versionStore, nil
+	default:
+		return nil, fmt.Errorf("unknown SHIPPING_ORDERS_VERSION_STORE: %s", backend)
+	}
+}
+
+// 商品画像の保存先 (IMAGE_STORE_DIR、nginx の /_protected/images と同じディレクトリ)
+// 使えない場合は画像のアップロードを無効にして起動する
+func newImageStore() repository.ImageStore {
+	dir := config.String("IMAGE_STORE_DIR", "/app/images")
+	imageStore, err := repository.NewLocalImageStore(dir)
+	if err != nil {
+		log.Printf("Warning: image upload is disabled: %v", err)
+		return nil
+	}
+	return imageStore
+}
+
+func redisConfig() (addr, password string, redisDB int, err error) {
+	addr = os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "redis:6379"
+	}
+	if v := os.Getenv("REDIS_DB"); v != "" {
+		redisDB, err = strconv.Atoi(v)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("invalid REDIS_DB: %w", err)
+		}
+	}
+	return addr, os.Getenv("REDIS_PASSWORD"), redisDB, nil
+}
+
 func (s *Server) setupRoutes(
 	authHandler *handler.AuthHandler,
 	productHandler *handler.ProductHandler,
 	orderHandler *handler.OrderHandler,
 	robotHandler *handler.RobotHandler,
-	userAuthMW func(http.Handler) http.Handler,
+	webhookHandler *handler.WebhookHandler,
+	userAuth func(scopes ...string) func(http.Handler) http.Handler,
 	robotAuthMW func(http.Handler) http.Handler,
+	robotRateLimitMW func(http.Handler) http.Handler,
+	adminOnlyMW func(http.Handler) http.Handler,
+	csrfMW func(http.Handler) http.Handler,
+	loginRateLimitMW func(http.Handler) http.Handler,
 ) {
-	s.Router.Post("/api/login", authHandler.Login)
+	s.Router.With(loginRateLimitMW).Post("/api/login", authHandler.Login)
+	s.Router.With(csrfMW).Post("/api/session/refresh", authHandler.RefreshSession)
+	s.Router.Get("/api/login/oidc/start", authHandler.OIDCStart)
+	s.Router.Get("/api/login/oidc/callback", authHandler.OIDCCallback)
+	s.Router.With(loginRateLimitMW).Post("/api/login/oidc/totp", authHandler.OIDCSecondFactor)
 
 	s.Router.Route("/api/v1", func(r chi.Router) {
-		r.Use(userAuthMW)
-		r.Post("/product", productHandler.List)
-		r.Post("/product/post", productHandler.CreateOrders)
-		r.Post("/orders", orderHandler.List)
-		r.Get("/image", productHandler.GetImage)
+		// ベンチマーカーが X-XSRF-TOKEN なしで呼ぶルートは CSRF トークンを検証せず、別サイトからのリクエストだけを拒否する
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.CrossSiteRequestMiddleware())
+			r.With(userAuth(middleware.ScopeProductsRead)).Post("/product", productHandler.List)
+			r.With(userAuth(middleware.ScopeOrdersWrite)).Post("/product/post", productHandler.CreateOrders)
+			r.With(userAuth(middleware.ScopeOrdersRead)).Post("/orders", orderHandler.List)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(csrfMW)
+			// API トークンでも呼べるのはスコープを指定したルートのみ
+			r.With(userAuth(middleware.ScopeProductsRead)).Get("/product", productHandler.List)
+			r.With(userAuth(middleware.ScopeProductsRead)).Get("/product/{productID}/price-history", productHandler.PriceHistory)
+			r.With(userAuth(middleware.ScopeProductsRead)).Get("/product/{productID}/recommendations", productHandler.Recommendations)
+			r.With(userAuth(middleware.ScopeProductsRead)).Get("/favorites", productHandler.ListFavorites)
+			r.With(userAuth(middleware.ScopeFavoritesWrite)).Post("/favorites/{productID}", productHandler.AddFavorite)
+			r.With(userAuth(middleware.ScopeFavoritesWrite)).Delete("/favorites/{productID}", productHandler.RemoveFavorite)
+			r.With(userAuth(middleware.ScopeOrdersRead)).Get("/orders", orderHandler.List)
+			r.With(userAuth(middleware.ScopeOrdersRead)).Get("/orders/stats", orderHandler.Stats)
+			r.With(userAuth(middleware.ScopeOrdersWrite)).Patch("/orders/status", orderHandler.UpdateStatuses)
+			r.With(userAuth(middleware.ScopeOrdersRead)).Get("/orders/{orderID}", orderHandler.Get)
+			r.With(userAuth(middleware.ScopeOrdersWrite)).Post("/orders/{orderID}/return", orderHandler.Return)
+			r.With(userAuth(middleware.ScopeProductsRead)).Get("/image", productHandler.GetImage)
+
+			// アカウント管理はセッション認証のみ
+			r.Group(func(r chi.Router) {
+				r.Use(userAuth())
+				r.Get("/me", authHandler.GetProfile)
+				r.Patch("/me", authHandler.UpdateProfile)
+				r.Post("/password", authHandler.ChangePassword)
+				r.Get("/sessions", authHandler.ListSessions)
+				r.Get("/login-history", authHandler.LoginHistory)
+				r.Delete("/sessions", authHandler.RevokeAllSessions)
+				r.Delete("/sessions/{sessionID}", authHandler.RevokeSession)
+				r.Post("/totp/enroll", authHandler.EnrollTOTP)
+				r.Post("/totp/confirm", authHandler.ConfirmTOTP)
+				r.Delete("/totp", authHandler.DisableTOTP)
+				r.Get("/oidc/link", authHandler.OIDCLink)
+				r.Post("/webhooks", webhookHandler.Create)
+				r.Get("/webhooks", webhookHandler.List)
+				r.Delete("/webhooks/{webhookID}", webhookHandler.Delete)
+			})
+		})
 	})
 
 	s.Router.Route("/api/robot", func(r chi.Router) {
-		r.Use(robotAuthMW)
+		r.Use(robotAuthMW, robotRateLimitMW)
 		r.Get("/delivery-plan", robotHandler.GetDeliveryPlan)
+		r.Get("/delivery-plan/preview", robotHandler.PreviewDeliveryPlan)
+		r.Post("/delivery-plan/jobs", robotHandler.SubmitDeliveryPlanJob)
+		r.Get("/delivery-plan/jobs/{jobID}", robotHandler.GetDeliveryPlanJob)
+		// nginx で Upgrade を転送する location が必要 (nginx/nginx.conf)
+		r.Get("/ws", robotHandler.DeliveryPlanSocket)
+		r.Get("/v2/delivery-plan", robotHandler.GetDeliveryPlanV2)
+		r.Post("/delivery-plan/{planID}/ack", robotHandler.AcknowledgeDeliveryPlan)
+		r.Post("/delivery-plan/{planID}/accept", robotHandler.AcceptDeliveryPlan)
+		// 自分 (キーのロボット) 以外の登録・状態は変更させない
+		r.With(middleware.RobotSelfOnly("robotID")).Put("/robots/{robotID}", robotHandler.RegisterRobot)
+		r.Get("/robots/{robotID}", robotHandler.GetRobot)
+		r.With(middleware.RobotSelfOnly("robotID")).Patch("/robots/{robotID}/state", robotHandler.UpdateRobotState)
 		r.Patch("/orders/status", robotHandler.UpdateOrderStatus)
+		r.Patch("/orders/status/batch", robotHandler.UpdateOrderStatuses)
+		r.Post("/heartbeat", robotHandler.Heartbeat)
+	})
+
+	s.Router.Route("/api/admin", func(r chi.Router) {
+		r.Use(csrfMW, userAuth(middleware.ScopeAdmin), adminOnlyMW)
+		r.Post("/users/{userID}/unlock", authHandler.UnlockUser)
+		r.Put("/users/{userID}/role", authHandler.UpdateUserRole)
+		r.Get("/session-cache/stats", authHandler.SessionCacheStats)
+		r.Get("/product-cache/stats", productHandler.ListCacheStats)
+		r.Get("/repo-metrics", handler.RepoMetrics)
+		r.Get("/planner-metrics", handler.PlannerMetrics)
+		r.Post("/planner-benchmark", robotHandler.BenchmarkPlanners)
+		r.Get("/robots", robotHandler.ListRobots)
+		r.Put("/robots/{robotID}", robotHandler.RegisterRobot)
+		r.Patch("/robots/{robotID}/state", robotHandler.UpdateRobotState)
+		r.Post("/robots/{robotID}/api-keys", robotHandler.IssueRobotAPIKey)
+		r.Get("/robots/{robotID}/api-keys", robotHandler.ListRobotAPIKeys)
+		r.Delete("/robot-api-keys/{keyID}", robotHandler.RevokeRobotAPIKey)
+		r.Post("/tokens", authHandler.IssueAPIToken)
+		r.Delete("/tokens/{tokenID}", authHandler.RevokeAPIToken)
+		r.Post("/webhooks", webhookHandler.CreateGlobal)
+		r.Get("/webhooks", webhookHandler.ListGlobal)
+		r.Delete("/webhooks/{webhookID}", webhookHandler.DeleteGlobal)
+		r.Delete("/orders/completed", orderHandler.PurgeCompleted)
+		r.Patch("/products/{productID}", productHandler.Update)
+		r.Post("/products/{productID}/image", productHandler.UploadImage)
 	})
 }
 
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestIssueAPITokenStoresOnlyTheHash(t *testing.T) {
+	var stored []any
+	db := &fakeDB{exec: func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.Contains(query, "INSERT INTO tokens") {
+			stored = args
+		}
+		return fakeResult{lastInsertID: 1, rowsAffected: 1}, nil
+	}}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	raw, token, err := s.IssueAPIToken(context.Background(), model.IssueAPITokenRequest{UserID: 1, Name: "ci", Scopes: []string{"orders:read", "orders:write"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw == "" || token.TokenHash != repository.HashToken(raw) || token.Scopes != "orders:read,orders:write" {
+		t.Fatalf("token = %+v, want the hash of %q with joined scopes", token, raw)
+	}
+	for _, arg := range stored {
+		if arg == raw {
+			t.Fatal("raw token written to the database")
+		}
+	}
+}
+
+func TestIssueAPITokenRejectsInvalidRequests(t *testing.T) {
+	s := NewAuthService(repository.NewStore(&fakeDB{}), AuthConfig{})
+	for _, req := range []model.IssueAPITokenRequest{
+		{Name: "ci", Scopes: []string{"orders:read"}},
+		{UserID: 1, Scopes: []string{"orders:read"}},
+		{UserID: 1, Name: "ci"},
+		{UserID: 1, Name: "ci", Scopes: []string{"orders:read"}, ExpiresIn: "-1h"},
+	} {
+		if _, _, err := s.IssueAPIToken(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%+v: err = %v, want ErrInvalidRequest", req, err)
+		}
+	}
+}
+
+func TestRevokeUnknownAPIToken(t *testing.T) {
+	s := NewAuthService(repository.NewStore(&fakeDB{}), AuthConfig{})
+	if err := s.RevokeAPIToken(context.Background(), 1); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("err = %v, want ErrTokenNotFound", err)
+	}
+}
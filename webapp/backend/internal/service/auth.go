@@ -2,34 +2,104 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
+	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"go.opentelemetry.io/otel"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrInvalidPassword = errors.New("invalid password")
+	ErrSessionNotFound = errors.New("session not found")
+	ErrTooManyAttempts = errors.New("too many login attempts")
+	ErrAccountLocked   = errors.New("account locked")
+	ErrTokenNotFound   = errors.New("token not found")
+	ErrInvalidRefresh  = errors.New("invalid refresh token")
+	ErrInvalidRequest  = errors.New("invalid request")
 	ErrInternalServer  = errors.New("internal server error")
+	// 外部 ID が別のユーザーに紐付け済み
+	ErrOIDCIdentityInUse = errors.New("oidc identity already linked")
 )
 
+type AuthConfig struct {
+	// セッションの有効期間
+	SessionDuration time.Duration
+	// remember-me 用リフレッシュトークンの有効期間
+	RefreshTokenDuration time.Duration
+
+	// ユーザー名ごとに LoginFailureWindow 内で許容する失敗回数 (0 で無効)
+	MaxLoginFailures   int
+	LoginFailureWindow time.Duration
+
+	// 連続 LockoutThreshold 回失敗したアカウントを LockoutDuration だけロックする (0 で無効)
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+
+	// パスワード更新時のハッシュコスト
+	// これより低いコストのハッシュはログイン成功時に非同期で作り直す
+	BcryptCost int
+
+	// OpenID Connect ログイン (Issuer 未設定で無効)
+	OIDC OIDCConfig
+}
+
 type AuthService struct {
 	store         *repository.Store
 	passwordCache *sync.Map
+	loginLimiter  *loginFailureLimiter
+	loginAudit    *loginAuditRecorder
+	rehasher      *passwordRehasher
+	oidc          *oidcClient
+	cfg           AuthConfig
+	// OIDC の紐付け予約 (state -> userID) と二要素認証待ち (チケット -> userID)
+	oidcLinks      *expirable.LRU[string, int]
+	oidcMFATickets *expirable.LRU[string, int]
+	// 同一ユーザー名・パスワードでの同時ログインの検索と bcrypt 検証をまとめる
+	credentialGroup singleflight.Group
 }
 
-func NewAuthService(store *repository.Store) *AuthService {
-	return &AuthService{store: store, passwordCache: &sync.Map{}}
+func NewAuthService(store *repository.Store, cfg AuthConfig) *AuthService {
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		cfg.BcryptCost = bcrypt.DefaultCost
+	}
+	if cfg.SessionDuration <= 0 {
+		cfg.SessionDuration = 24 * time.Hour
+	}
+	if cfg.RefreshTokenDuration <= 0 {
+		cfg.RefreshTokenDuration = 30 * 24 * time.Hour
+	}
+	var oidc *oidcClient
+	if cfg.OIDC.Enabled() {
+		oidc = newOIDCClient(cfg.OIDC)
+	}
+	s := &AuthService{
+		store:         store,
+		passwordCache: &sync.Map{},
+		loginLimiter:  newLoginFailureLimiter(cfg.MaxLoginFailures, cfg.LoginFailureWindow),
+		loginAudit:    newLoginAuditRecorder(store),
+		oidc:          oidc,
+		cfg:           cfg,
+
+		oidcLinks:      expirable.NewLRU[string, int](10000, nil, oidcLinkTTL),
+		oidcMFATickets: expirable.NewLRU[string, int](10000, nil, oidcMFATicketTTL),
+	}
+	s.rehasher = newPasswordRehasher(store, cfg.BcryptCost, s.purgePasswordCache)
+	return s
 }
 
 func makePasswordCacheKey(passwordHash, password string) string {
@@ -37,43 +107,656 @@ func makePasswordCacheKey(passwordHash, password string) string {
 	return passwordHash + ":" + hex.EncodeToString(digest[:])
 }
 
-func (s *AuthService) Login(ctx context.Context, userName, password string) (string, time.Time, error) {
+// oldHash から作られた検証済みキャッシュを削除する
+func (s *AuthService) purgePasswordCache(passwordHash string) {
+	prefix := passwordHash + ":"
+	s.passwordCache.Range(func(key, _ any) bool {
+		if k, ok := key.(string); ok && strings.HasPrefix(k, prefix) {
+			s.passwordCache.Delete(key)
+		}
+		return true
+	})
+}
+
+type LoginResult struct {
+	SessionID string
+	ExpiresAt time.Time
+
+	// remember-me 指定時のみ
+	RefreshToken          string
+	RefreshTokenExpiresAt time.Time
+
+	// OIDC ログインで二要素認証が必要な場合のみ (ErrTOTPRequired と一緒に返す)
+	MFATicket string
+}
+
+func (s *AuthService) Login(ctx context.Context, req model.LoginRequest) (*LoginResult, error) {
 	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.Login")
 	defer span.End()
 
-	var sessionID string
+	userName, password := req.UserName, req.Password
+
+	if blocked, _ := s.loginLimiter.blocked(userName); blocked {
+		log.Printf("[Login] 失敗回数超過のため拒否(userName: %s)", userName)
+		s.loginAudit.record(req, 0, ErrTooManyAttempts)
+		return nil, ErrTooManyAttempts
+	}
+
+	var result LoginResult
+	var userID int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.verifyCredentials(ctx, userName, password)
+		if user != nil {
+			userID = user.UserID
+		}
+		if err != nil {
+			if errors.Is(err, ErrInvalidPassword) {
+				span.RecordError(err)
+			}
+			return err
+		}
+
+		if user.TOTPEnabled {
+			if err := s.verifySecondFactor(ctx, user, req); err != nil {
+				if errors.Is(err, ErrInvalidTOTP) {
+					s.loginLimiter.recordFailure(userName)
+					s.recordLockoutFailure(ctx, user.UserID)
+				}
+				return err
+			}
+		}
+
+		s.loginLimiter.reset(userName)
+		if user.FailedLoginCount > 0 || user.LockedUntil.Valid {
+			if err := s.store.UserRepo.ResetLoginFailures(ctx, user.UserID); err != nil {
+				log.Printf("[Login] 失敗回数リセット失敗: %v", err)
+			}
+		}
+
+		result.SessionID, result.ExpiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, s.cfg.SessionDuration)
+		if err != nil {
+			log.Printf("[Login] セッション生成失敗: %v", err)
+			return ErrInternalServer
+		}
+
+		if req.RememberMe {
+			result.RefreshToken, result.RefreshTokenExpiresAt, err = s.issueRefreshToken(ctx, s.store, user.UserID)
+			if err != nil {
+				log.Printf("[Login] リフレッシュトークン生成失敗: %v", err)
+				return ErrInternalServer
+			}
+		}
+		return nil
+	})
+	s.loginAudit.record(req, userID, err)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ユーザー検索とパスワード検証を行う
+// 同じユーザー名・パスワードでの同時リクエストは singleflight で 1 回にまとめる
+// まとめた処理は最初の呼び出し元のキャンセルに巻き込まれないよう、切り離したコンテキストで実行する
+// 失敗回数はまとめられた呼び出し元ごとに 1 回ずつ記録する
+func (s *AuthService) verifyCredentials(ctx context.Context, userName, password string) (*model.User, error) {
+	digest := sha256.Sum256([]byte(password))
+	key := userName + ":" + hex.EncodeToString(digest[:])
+
+	v, err, _ := s.credentialGroup.Do(key, func() (any, error) {
+		var user *model.User
+		err := utils.WithTimeout(context.WithoutCancel(ctx), func(ctx context.Context) error {
+			var err error
+			user, err = s.checkCredentials(ctx, userName, password)
+			return err
+		})
+		return user, err
+	})
+	user, _ := v.(*model.User)
+	if errors.Is(err, ErrInvalidPassword) && user != nil {
+		s.loginLimiter.recordFailure(userName)
+		s.recordLockoutFailure(ctx, user.UserID)
+	}
+	return user, err
+}
+
+func (s *AuthService) checkCredentials(ctx context.Context, userName, password string) (*model.User, error) {
+	user, err := s.store.UserRepo.FindByUserName(ctx, userName)
+	if err != nil {
+		log.Printf("[Login] ユーザー検索失敗(userName: %s): %v", userName, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, ErrInternalServer
+	}
+
+	if user.LockedUntil.Valid && time.Now().Before(user.LockedUntil.Time) {
+		log.Printf("[Login] アカウントロック中(userName: %s, until: %s)", userName, user.LockedUntil.Time)
+		return user, ErrAccountLocked
+	}
+
+	cacheKey := makePasswordCacheKey(user.PasswordHash, password)
+	if _, ok := s.passwordCache.Load(cacheKey); !ok {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			log.Printf("[Login] パスワード検証失敗: %v", err)
+			return user, ErrInvalidPassword
+		}
+		s.passwordCache.Store(cacheKey, struct{}{})
+	}
+	s.rehasher.enqueue(user.UserID, user.PasswordHash, password)
+	return user, nil
+}
+
+func (s *AuthService) issueRefreshToken(ctx context.Context, store *repository.Store, userID int) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	rawToken := hex.EncodeToString(buf)
+	expiresAt := time.Now().Add(s.cfg.RefreshTokenDuration)
+	if err := store.RefreshTokenRepo.Create(ctx, userID, repository.HashToken(rawToken), expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return rawToken, expiresAt, nil
+}
+
+// リフレッシュトークンから新しいセッションを発行する
+// 使用したリフレッシュトークンは失効させ、新しいものに差し替える (rotation)
+func (s *AuthService) RefreshWithToken(ctx context.Context, rawToken string) (*LoginResult, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.RefreshWithToken")
+	defer span.End()
+
+	var result LoginResult
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			token, err := txStore.RefreshTokenRepo.FindActiveByHash(ctx, repository.HashToken(rawToken))
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrInvalidRefresh
+				}
+				return err
+			}
+			revoked, err := txStore.RefreshTokenRepo.Revoke(ctx, token.ID)
+			if err != nil {
+				return err
+			}
+			if !revoked {
+				return ErrInvalidRefresh
+			}
+
+			result.SessionID, result.ExpiresAt, err = txStore.SessionRepo.Create(ctx, token.UserID, s.cfg.SessionDuration)
+			if err != nil {
+				return err
+			}
+			result.RefreshToken, result.RefreshTokenExpiresAt, err = s.issueRefreshToken(ctx, txStore, token.UserID)
+			return err
+		})
+	})
+	if err != nil {
+		if !errors.Is(err, ErrInvalidRefresh) {
+			log.Printf("[RefreshWithToken] セッション再発行失敗: %v", err)
+		}
+		return nil, err
+	}
+	return &result, nil
+}
+
+// 有効なセッションの有効期限を延長する
+func (s *AuthService) RefreshSession(ctx context.Context, sessionID string) (time.Time, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.RefreshSession")
+	defer span.End()
+
 	var expiresAt time.Time
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
-		user, err := s.store.UserRepo.FindByUserName(ctx, userName)
+		var err error
+		expiresAt, err = s.store.SessionRepo.Refresh(ctx, sessionID, s.cfg.SessionDuration)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrSessionNotFound
+			}
+			log.Printf("[RefreshSession] セッション延長失敗: %v", err)
+			return ErrInternalServer
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expiresAt, nil
+}
+
+func (s *AuthService) recordLockoutFailure(ctx context.Context, userID int) {
+	if s.cfg.LockoutThreshold <= 0 || s.cfg.LockoutDuration <= 0 {
+		return
+	}
+	lockedUntil := time.Now().Add(s.cfg.LockoutDuration)
+	if err := s.store.UserRepo.RecordLoginFailure(ctx, userID, s.cfg.LockoutThreshold, lockedUntil); err != nil {
+		log.Printf("[Login] 失敗回数の記録失敗: %v", err)
+	}
+}
+
+// 管理者によるアカウントロック解除
+func (s *AuthService) UnlockUser(ctx context.Context, userID int) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if err := s.store.UserRepo.ResetLoginFailures(ctx, userID); err != nil {
+			return err
+		}
+		s.loginLimiter.reset(user.UserName)
+		return nil
+	})
+}
+
+// サービス間呼び出し用の API トークンを発行する
+// 生トークンを返すのはこの 1 回だけで、DB にはハッシュのみ保存する
+func (s *AuthService) IssueAPIToken(ctx context.Context, req model.IssueAPITokenRequest) (string, *model.APIToken, error) {
+	if req.UserID <= 0 || req.Name == "" || len(req.Scopes) == 0 {
+		return "", nil, ErrInvalidRequest
+	}
+	for _, scope := range req.Scopes {
+		if !model.ValidTokenScope(scope) {
+			return "", nil, ErrInvalidRequest
+		}
+	}
+	now := time.Now()
+	token := &model.APIToken{
+		UserID:    req.UserID,
+		Name:      req.Name,
+		Scopes:    strings.Join(req.Scopes, ","),
+		CreatedAt: now,
+	}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return "", nil, ErrInvalidRequest
+		}
+		token.ExpiresAt = sql.NullTime{Time: now.Add(d), Valid: true}
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	rawToken := hex.EncodeToString(buf)
+	token.TokenHash = repository.HashToken(rawToken)
+
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if _, err := s.store.UserRepo.FindByID(ctx, req.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		tokenID, err := s.store.TokenRepo.Create(ctx, token)
+		if err != nil {
+			return err
+		}
+		token.TokenID = tokenID
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return rawToken, token, nil
+}
+
+func (s *AuthService) RevokeAPIToken(ctx context.Context, tokenID int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if err := s.store.TokenRepo.Revoke(ctx, tokenID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrTokenNotFound
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// ユーザーのロールを変更する（管理者用）
+func (s *AuthService) UpdateUserRole(ctx context.Context, userID int, role string) error {
+	if role != model.RoleUser && role != model.RoleAdmin {
+		return ErrInvalidRequest
+	}
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if _, err := s.store.UserRepo.FindByID(ctx, userID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		return s.store.UserRepo.UpdateRole(ctx, userID, role)
+	})
+}
+
+// 現在のパスワードを検証してパスワードを変更し、既存セッションを全て失効させる
+func (s *AuthService) ChangePassword(ctx context.Context, userID int, currentPassword, newPassword string) error {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.ChangePassword")
+	defer span.End()
+
+	if newPassword == "" {
+		return ErrInvalidRequest
+	}
+
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
 		if err != nil {
-			log.Printf("[Login] ユーザー検索失敗(userName: %s): %v", userName, err)
 			if errors.Is(err, sql.ErrNoRows) {
 				return ErrUserNotFound
 			}
+			return err
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+			return ErrInvalidPassword
+		}
+
+		newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), s.cfg.BcryptCost)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.UserRepo.UpdatePasswordHash(ctx, userID, string(newHash)); err != nil {
+				return err
+			}
+			if err := txStore.RefreshTokenRepo.RevokeByUserID(ctx, userID); err != nil {
+				return err
+			}
+			return txStore.SessionRepo.DeleteByUserID(ctx, userID)
+		})
+		if err != nil {
+			log.Printf("[ChangePassword] パスワード更新失敗: %v", err)
 			return ErrInternalServer
 		}
 
-		cacheKey := makePasswordCacheKey(user.PasswordHash, password)
-		if _, ok := s.passwordCache.Load(cacheKey); !ok {
-			err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+		s.purgePasswordCache(user.PasswordHash)
+		return nil
+	})
+}
+
+// ユーザーの有効なセッション一覧を取得する
+func (s *AuthService) ListSessions(ctx context.Context, userID int, currentSessionID string) ([]model.UserSession, error) {
+	var sessions []model.UserSession
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		sessions, err = s.store.SessionRepo.ListByUserID(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].SessionUUID == currentSessionID
+	}
+	return sessions, nil
+}
+
+func (s *AuthService) RevokeSession(ctx context.Context, userID int, id int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if err := s.store.SessionRepo.DeleteByID(ctx, userID, id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrSessionNotFound
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// 全端末のセッションを失効させる (remember-me のリフレッシュトークンも含む)
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID int) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if err := s.store.RefreshTokenRepo.RevokeByUserID(ctx, userID); err != nil {
+			return err
+		}
+		return s.store.SessionRepo.DeleteByUserID(ctx, userID)
+	})
+}
+
+// 自分のログイン履歴を取得する
+func (s *AuthService) LoginHistory(ctx context.Context, userID int, page, pageSize int) ([]model.LoginEvent, int, error) {
+	var events []model.LoginEvent
+	var total int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		events, total, err = s.store.LoginEventRepo.ListByUserID(ctx, userID, pageSize, (page-1)*pageSize)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (s *AuthService) SessionCacheStats() repository.SessionCacheStats {
+	return s.store.SessionRepo.CacheStats()
+}
+
+func (s *AuthService) OIDCEnabled() bool {
+	return s.oidc != nil
+}
+
+// OIDC の紐付け予約・二要素認証待ちチケットの有効期間
+const (
+	oidcLinkTTL      = 10 * time.Minute
+	oidcMFATicketTTL = 5 * time.Minute
+)
+
+// IdP の認可エンドポイントへのリダイレクト先 URL を返す
+func (s *AuthService) OIDCAuthURL(ctx context.Context, state, codeVerifier, nonce string) (string, error) {
+	if s.oidc == nil {
+		return "", ErrOIDCDisabled
+	}
+	return s.oidc.AuthCodeURL(ctx, state, codeVerifier, nonce)
+}
+
+// ログイン中のユーザーに外部 ID を紐付けるための認可 URL を返す
+// コールバックで同じ state が返ってきたら CompleteOIDCLink で userID に紐付ける
+// (予約はプロセス内に持つので、コールバックは同じインスタンスに届く必要がある)
+func (s *AuthService) OIDCLinkURL(ctx context.Context, userID int, state, codeVerifier, nonce string) (string, error) {
+	if s.oidc == nil {
+		return "", ErrOIDCDisabled
+	}
+	authURL, err := s.oidc.AuthCodeURL(ctx, state, codeVerifier, nonce)
+	if err != nil {
+		return "", err
+	}
+	s.oidcLinks.Add(state, userID)
+	return authURL, nil
+}
+
+// state が紐付けの予約なら外部 ID を紐付けて true を返す (予約でなければ何もせず false)
+func (s *AuthService) CompleteOIDCLink(ctx context.Context, state, code, codeVerifier, nonce string) (bool, error) {
+	if s.oidc == nil {
+		return false, ErrOIDCDisabled
+	}
+	userID, ok := s.oidcLinks.Get(state)
+	if !ok {
+		return false, nil
+	}
+	s.oidcLinks.Remove(state)
+
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		claims, err := s.oidc.Exchange(ctx, code, codeVerifier, nonce)
+		if err != nil {
+			log.Printf("[CompleteOIDCLink] トークン交換失敗: %v", err)
+			return ErrInvalidPassword
+		}
+		linked, err := s.store.UserIdentityRepo.Link(ctx, userID, s.oidc.cfg.Issuer, claims.Subject)
+		if err != nil {
+			return err
+		}
+		if !linked {
+			return ErrOIDCIdentityInUse
+		}
+		return nil
+	})
+	return true, err
+}
+
+// OIDC の認可コードでログインし、パスワードログインと同じセッションを発行する
+// 外部 ID が未紐付けの場合は設定に応じて新しいユーザーを作成する (既存ユーザーには自動で紐付けない)
+// 二要素認証が有効なユーザーは ErrTOTPRequired と MFATicket を返すので、CompleteOIDCSecondFactor で完了させる
+func (s *AuthService) LoginWithOIDC(ctx context.Context, code, codeVerifier, nonce string, req model.LoginRequest) (*LoginResult, error) {
+	ctx, span := otel.Tracer("service.auth").Start(ctx, "AuthService.LoginWithOIDC")
+	defer span.End()
+
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	var result LoginResult
+	var userID int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		claims, err := s.oidc.Exchange(ctx, code, codeVerifier, nonce)
+		if err != nil {
+			log.Printf("[LoginWithOIDC] トークン交換失敗: %v", err)
+			return ErrInvalidPassword
+		}
+		req.UserName = claims.PreferredUsername
+		if req.UserName == "" {
+			req.UserName = claims.Email
+		}
+
+		userID, err = s.resolveOIDCUser(ctx, claims, req.UserName)
+		if err != nil {
+			return err
+		}
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.LockedUntil.Valid && time.Now().Before(user.LockedUntil.Time) {
+			log.Printf("[LoginWithOIDC] アカウントロック中(userID: %d, until: %s)", userID, user.LockedUntil.Time)
+			return ErrAccountLocked
+		}
+		if user.TOTPEnabled {
+			ticket, err := NewOIDCRandomString()
 			if err != nil {
-				log.Printf("[Login] パスワード検証失敗: %v", err)
-				span.RecordError(err)
-				return ErrInvalidPassword
+				return err
 			}
-			s.passwordCache.Store(cacheKey, struct{}{})
+			s.oidcMFATickets.Add(ticket, userID)
+			result.MFATicket = ticket
+			return ErrTOTPRequired
 		}
 
-		sessionDuration := 24 * time.Hour
-		sessionID, expiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, sessionDuration)
+		result.SessionID, result.ExpiresAt, err = s.store.SessionRepo.Create(ctx, userID, s.cfg.SessionDuration)
 		if err != nil {
-			log.Printf("[Login] セッション生成失敗: %v", err)
+			log.Printf("[LoginWithOIDC] セッション生成失敗: %v", err)
 			return ErrInternalServer
 		}
 		return nil
 	})
+	s.loginAudit.record(req, userID, err)
+	if errors.Is(err, ErrTOTPRequired) {
+		return &result, err
+	}
 	if err != nil {
-		return "", time.Time{}, err
+		return nil, err
+	}
+	return &result, nil
+}
+
+// OIDC ログインの二要素認証を完了してセッションを発行する
+// 失敗したチケットは破棄するので、やり直す場合は OIDC ログインからになる
+func (s *AuthService) CompleteOIDCSecondFactor(ctx context.Context, ticket string, req model.LoginRequest) (*LoginResult, error) {
+	userID, ok := s.oidcMFATickets.Get(ticket)
+	if !ok {
+		return nil, ErrInvalidTOTP
+	}
+	s.oidcMFATickets.Remove(ticket)
+
+	var result LoginResult
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		req.UserName = user.UserName
+		if user.LockedUntil.Valid && time.Now().Before(user.LockedUntil.Time) {
+			return ErrAccountLocked
+		}
+		if err := s.verifySecondFactor(ctx, user, req); err != nil {
+			if errors.Is(err, ErrInvalidTOTP) || errors.Is(err, ErrTOTPRequired) {
+				s.loginLimiter.recordFailure(user.UserName)
+				s.recordLockoutFailure(ctx, user.UserID)
+				return ErrInvalidTOTP
+			}
+			return err
+		}
+		if user.FailedLoginCount > 0 || user.LockedUntil.Valid {
+			if err := s.store.UserRepo.ResetLoginFailures(ctx, user.UserID); err != nil {
+				log.Printf("[CompleteOIDCSecondFactor] 失敗回数リセット失敗: %v", err)
+			}
+		}
+
+		result.SessionID, result.ExpiresAt, err = s.store.SessionRepo.Create(ctx, user.UserID, s.cfg.SessionDuration)
+		if err != nil {
+			log.Printf("[CompleteOIDCSecondFactor] セッション生成失敗: %v", err)
+			return ErrInternalServer
+		}
+		return nil
+	})
+	s.loginAudit.record(req, userID, err)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// 外部 ID に紐づくユーザーを返す
+// 未紐付けなら AutoCreateUsers のときだけ新しいユーザーを作る
+// ユーザー名が既存ユーザーと重なる場合は乗っ取りにならないよう別名で作る
+func (s *AuthService) resolveOIDCUser(ctx context.Context, claims *OIDCClaims, userName string) (int, error) {
+	issuer := s.oidc.cfg.Issuer
+
+	userID, err := s.store.UserIdentityRepo.FindUserID(ctx, issuer, claims.Subject)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if !s.oidc.cfg.AutoCreateUsers {
+		return 0, ErrUserNotFound
+	}
+
+	err = s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		name := userName
+		if name != "" {
+			if _, err := txStore.UserRepo.FindByUserName(ctx, name); err == nil {
+				name = ""
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		if name == "" {
+			digest := sha256.Sum256([]byte(issuer + "|" + claims.Subject))
+			name = "oidc-" + hex.EncodeToString(digest[:6])
+		}
+		// パスワードログインはできないよう、bcrypt として不正なハッシュを入れておく
+		var err error
+		userID, err = txStore.UserRepo.Create(ctx, name, "!oidc")
+		if err != nil {
+			return err
+		}
+		linked, err := txStore.UserIdentityRepo.Link(ctx, userID, issuer, claims.Subject)
+		if err != nil {
+			return err
+		}
+		if !linked {
+			// 同時に同じ ID でログインされた
+			return ErrOIDCIdentityInUse
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
-	return sessionID, expiresAt, nil
+	return userID, nil
 }
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestIssueAPITokenRejectsUnknownScope(t *testing.T) {
+	s := &AuthService{}
+	for _, scopes := range [][]string{
+		{"orders:delete"},
+		{model.ScopeOrdersRead, "Admin"},
+		{""},
+	} {
+		_, _, err := s.IssueAPIToken(context.Background(), model.IssueAPITokenRequest{UserID: 1, Name: "ci", Scopes: scopes})
+		if !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("scopes %q: err = %v, want ErrInvalidRequest", scopes, err)
+		}
+	}
+}
+
+// FindByUserName を release が閉じられるまで止める DBTX
+type blockingUserDB struct {
+	*fakeDB
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newBlockingAuthService(t *testing.T) (*AuthService, *blockingUserDB) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &blockingUserDB{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	db.fakeDB = &fakeDB{get: func(ctx context.Context, dest any, _ string, _ ...any) error {
+		db.entered <- struct{}{}
+		<-db.release
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		*dest.(*model.User) = model.User{UserID: 1, UserName: "alice", PasswordHash: string(hash)}
+		return nil
+	}}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{MaxLoginFailures: 100, LoginFailureWindow: time.Minute})
+	return s, db
+}
+
+func TestVerifyCredentialsDetachedFromFirstCaller(t *testing.T) {
+	s, db := newBlockingAuthService(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.verifyCredentials(ctx, "alice", "wrong")
+		done <- err
+	}()
+	<-db.entered
+	cancel()
+	close(db.release)
+
+	if err := <-done; !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("err = %v, want ErrInvalidPassword", err)
+	}
+}
+
+func TestVerifyCredentialsRecordsFailurePerCaller(t *testing.T) {
+	s, db := newBlockingAuthService(t)
+
+	const callers = 3
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.verifyCredentials(context.Background(), "alice", "wrong")
+			errs <- err
+		}()
+	}
+	<-db.entered
+	// 全員が singleflight に合流するのを待ってから検索を終わらせる
+	time.Sleep(50 * time.Millisecond)
+	close(db.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if !errors.Is(err, ErrInvalidPassword) {
+			t.Fatalf("err = %v, want ErrInvalidPassword", err)
+		}
+	}
+	s.loginLimiter.mu.Lock()
+	got := s.loginLimiter.failures["alice"].count
+	s.loginLimiter.mu.Unlock()
+	if got != callers {
+		t.Fatalf("recorded failures = %d, want %d", got, callers)
+	}
+}
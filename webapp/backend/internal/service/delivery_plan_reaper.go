@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/samber/lo"
+)
+
+// リースが切れても確認されなかった配送計画の注文を未配送 (shipping) に戻すバックグラウンドジョブ
+// ロボットが計画を受け取った後に落ちた場合に、注文が配送中のまま残らないようにする
+// 1 トランザクションで解放する計画は batchSize 件まで
+type DeliveryPlanReaper struct {
+	store     *repository.Store
+	interval  time.Duration
+	batchSize int
+}
+
+func NewDeliveryPlanReaper(store *repository.Store, interval time.Duration, batchSize int) *DeliveryPlanReaper {
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &DeliveryPlanReaper{store: store, interval: interval, batchSize: batchSize}
+}
+
+// ctx がキャンセルされるまで interval ごとに解放を実行する
+func (p *DeliveryPlanReaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.reap(ctx)
+		}
+	}
+}
+
+func (p *DeliveryPlanReaper) reap(ctx context.Context) {
+	now := time.Now()
+	plans, reverted := 0, 0
+	for {
+		var n, m int
+		batchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		err := p.store.ExecTx(batchCtx, func(txStore *repository.Store) error {
+			var err error
+			n, m, err = releaseExpiredDeliveryPlans(batchCtx, txStore, now, p.batchSize)
+			return err
+		})
+		cancel()
+		if err != nil {
+			log.Printf("[DeliveryPlanReaper] 配送計画の解放失敗: %v", err)
+			return
+		}
+		plans += n
+		reverted += m
+		if n < p.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+	if plans > 0 {
+		log.Printf("[DeliveryPlanReaper] リース切れの配送計画を %d 件解放し、注文明細 %d 件を未配送に戻した", plans, reverted)
+	}
+}
+
+// before までにリースが切れた計画を limit 件まで解放し、解放した計画の数と未配送に戻した明細の数を返す
+func releaseExpiredDeliveryPlans(ctx context.Context, txStore *repository.Store, before time.Time, limit int) (int, int, error) {
+	planIDs, err := txStore.DeliveryPlanRepo.LockExpired(ctx, before, limit)
+	if err != nil || len(planIDs) == 0 {
+		return 0, 0, err
+	}
+	items, err := txStore.DeliveryPlanRepo.GetItems(ctx, planIDs)
+	if err != nil {
+		return 0, 0, err
+	}
+	reverted, err := txStore.OrderRepo.RevertDispatched(ctx, items)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := txStore.DeliveryPlanRepo.MarkReleased(ctx, planIDs); err != nil {
+		return 0, 0, err
+	}
+	if reverted > 0 {
+		orderIDs := lo.Map(items, func(item model.OrderVersion, _ int) int64 { return item.OrderID })
+		if err := txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, orderIDs, "shipping"); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(planIDs), reverted, nil
+}
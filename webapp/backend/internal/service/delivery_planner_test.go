@@ -0,0 +1,75 @@
+package service
+
+import (
+	"math/rand"
+	"testing"
+
+	"backend/internal/model"
+)
+
+// 全組み合わせを試して容量内の最大価値を求める
+func bruteForceBestValue(orders []model.Order, capacity int) int {
+	best := 0
+	for mask := 0; mask < 1<<len(orders); mask++ {
+		weight, value := 0, 0
+		for i, o := range orders {
+			if mask&(1<<i) != 0 {
+				weight += o.Weight
+				value += o.Value
+			}
+		}
+		if weight <= capacity && value > best {
+			best = value
+		}
+	}
+	return best
+}
+
+func TestDeliveryPlannerFindsOptimalPlan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		orders := make([]model.Order, rng.Intn(10))
+		for i := range orders {
+			orders[i] = model.Order{OrderID: int64(i + 1), Weight: rng.Intn(8) + 1, Value: rng.Intn(50)}
+		}
+		capacity := rng.Intn(20)
+
+		// 1 件ずつ渡しても一覧から求めた場合と同じく最適になる
+		p := newDeliveryPlanner("r1", capacity)
+		for _, o := range orders {
+			p.add(o)
+		}
+		plan := p.plan()
+
+		if want := bruteForceBestValue(orders, capacity); plan.TotalValue != want {
+			t.Fatalf("round %d: total value = %d, want %d (orders %+v, capacity %d)", round, plan.TotalValue, want, orders, capacity)
+		}
+		weight, value := 0, 0
+		for _, o := range plan.Orders {
+			weight += o.Weight
+			value += o.Value
+		}
+		if weight != plan.TotalWeight || value != plan.TotalValue || weight > capacity {
+			t.Fatalf("round %d: plan totals %d/%d do not match its orders %+v", round, plan.TotalWeight, plan.TotalValue, plan.Orders)
+		}
+	}
+}
+
+func TestDeliveryPlannerSkipsInvalidOrders(t *testing.T) {
+	p := newDeliveryPlanner("r1", 10)
+	for _, o := range []model.Order{
+		{OrderID: 1, Weight: 0, Value: 10},
+		{OrderID: 2, Weight: 11, Value: 10},
+		{OrderID: 3, Weight: 1, Value: -1},
+		{OrderID: 4, Weight: 1, Value: 1, Priority: -1},
+	} {
+		p.add(o)
+	}
+	if plan := p.plan(); len(plan.Orders) != 0 || plan.RobotID != "r1" {
+		t.Fatalf("plan = %+v, want an empty plan for r1", plan)
+	}
+
+	if plan := newDeliveryPlanner("r1", -1).plan(); len(plan.Orders) != 0 {
+		t.Fatalf("plan with negative capacity = %+v, want empty", plan)
+	}
+}
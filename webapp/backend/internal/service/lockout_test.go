@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 1 人のユーザーだけを持ち、失敗回数の更新を記録する
+type lockoutDB struct {
+	fakeDB
+	user    model.User
+	updates []string
+}
+
+func newLockoutDB(t *testing.T, password string) *lockoutDB {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &lockoutDB{user: model.User{UserID: 1, UserName: "alice", PasswordHash: string(hash)}}
+	db.get = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if u, ok := dest.(*model.User); ok {
+			*u = db.user
+		}
+		return nil
+	}
+	db.exec = func(_ context.Context, query string, _ ...any) (sql.Result, error) {
+		if strings.Contains(query, "UPDATE users") {
+			db.updates = append(db.updates, query)
+		}
+		return driver.RowsAffected(1), nil
+	}
+	return db
+}
+
+func TestLoginRejectsLockedAccount(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	db.user.LockedUntil = sql.NullTime{Time: time.Now().Add(time.Minute), Valid: true}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{LockoutThreshold: 3, LockoutDuration: time.Minute})
+
+	if _, err := s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw"}); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("err = %v, want ErrAccountLocked even with the right password", err)
+	}
+}
+
+func TestLoginRecordsFailuresAndResetsOnSuccess(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	s := NewAuthService(repository.NewStore(db), AuthConfig{LockoutThreshold: 3, LockoutDuration: time.Minute})
+
+	if _, err := s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "wrong"}); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("err = %v, want ErrInvalidPassword", err)
+	}
+	if len(db.updates) != 1 || !strings.Contains(db.updates[0], "locked_until = IF") {
+		t.Fatalf("updates = %q, want the failure recorded", db.updates)
+	}
+
+	db.user.FailedLoginCount = 1
+	if _, err := s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.updates) != 2 || !strings.Contains(db.updates[1], "failed_login_count = 0") {
+		t.Fatalf("updates = %q, want the counter reset after a successful login", db.updates)
+	}
+}
+
+func TestLockoutIsDisabledByDefault(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+	s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "wrong"})
+	if len(db.updates) != 0 {
+		t.Fatalf("updates = %q, want none without a lockout threshold", db.updates)
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+	if err := s.UnlockUser(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.updates) != 1 || !strings.Contains(db.updates[0], "locked_until = NULL") {
+		t.Fatalf("updates = %q, want the lock cleared", db.updates)
+	}
+
+	db.get = func(context.Context, any, string, ...any) error { return sql.ErrNoRows }
+	if err := s.UnlockUser(context.Background(), 2); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want ErrUserNotFound", err)
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+const loginAuditQueueSize = 1024
+
+// ログイン試行の監査ログを非同期に書き込む
+// ログインのレイテンシに影響させないため、キューが詰まっている場合は捨てる
+type loginAuditRecorder struct {
+	store *repository.Store
+	queue chan *model.LoginEvent
+}
+
+func newLoginAuditRecorder(store *repository.Store) *loginAuditRecorder {
+	r := &loginAuditRecorder{
+		store: store,
+		queue: make(chan *model.LoginEvent, loginAuditQueueSize),
+	}
+	go r.run()
+	return r
+}
+
+func (r *loginAuditRecorder) record(req model.LoginRequest, userID int, failure error) {
+	event := &model.LoginEvent{
+		UserName:  req.UserName,
+		IP:        req.IP,
+		UserAgent: truncate(req.UserAgent, 512),
+		Success:   failure == nil,
+		CreatedAt: time.Now(),
+	}
+	if userID > 0 {
+		event.UserID = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	if failure != nil {
+		event.FailureReason = sql.NullString{String: failure.Error(), Valid: true}
+	}
+
+	select {
+	case r.queue <- event:
+	default:
+		log.Printf("[LoginAudit] キューが溢れたため破棄(userName: %s)", req.UserName)
+	}
+}
+
+func (r *loginAuditRecorder) run() {
+	for event := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := r.store.LoginEventRepo.Create(ctx, event); err != nil {
+			log.Printf("[LoginAudit] 書き込み失敗: %v", err)
+		}
+		cancel()
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestLoginAttemptsAreAudited(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	events := make(chan []any, 4)
+	exec := db.exec
+	db.exec = func(ctx context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.Contains(query, "INSERT INTO login_events") {
+			events <- args
+		}
+		return exec(ctx, query, args...)
+	}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "wrong", IP: "192.0.2.1", UserAgent: "test"})
+	s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw", IP: "192.0.2.1", UserAgent: "test"})
+
+	for i, wantSuccess := range []bool{false, true} {
+		select {
+		case args := <-events:
+			// user_id, user_name, ip, user_agent, success, failure_reason, created_at
+			if args[0] != (sql.NullInt64{Int64: 1, Valid: true}) || args[2] != "192.0.2.1" || args[4] != wantSuccess {
+				t.Fatalf("event %d = %v, want success=%v for user 1", i, args, wantSuccess)
+			}
+			if reason := args[5].(sql.NullString); reason.Valid == wantSuccess {
+				t.Fatalf("event %d: failure reason = %+v", i, reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d was not recorded", i)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("abcdef", 3); got != "abc" {
+		t.Fatalf("truncate = %q, want abc", got)
+	}
+	if got := truncate("ab", 3); got != "ab" {
+		t.Fatalf("truncate = %q, want ab", got)
+	}
+}
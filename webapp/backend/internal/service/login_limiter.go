@@ -0,0 +1,94 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// これを超えたら期限切れのエントリを掃除する
+const loginFailureLimiterMaxEntries = 10000
+
+// ユーザー名ごとのログイン失敗回数カウンタ
+// window 内に maxFailures 回失敗したユーザー名は window が明けるまで bcrypt 検証まで進ませない
+type loginFailureLimiter struct {
+	maxFailures int
+	window      time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*loginFailureCounter
+}
+
+type loginFailureCounter struct {
+	count   int
+	resetAt time.Time
+}
+
+func newLoginFailureLimiter(maxFailures int, window time.Duration) *loginFailureLimiter {
+	return &loginFailureLimiter{
+		maxFailures: maxFailures,
+		window:      window,
+		failures:    make(map[string]*loginFailureCounter),
+	}
+}
+
+func (l *loginFailureLimiter) enabled() bool {
+	return l.maxFailures > 0 && l.window > 0
+}
+
+// ロック中であれば解除までの残り時間を返す
+func (l *loginFailureLimiter) blocked(userName string) (bool, time.Duration) {
+	if !l.enabled() {
+		return false, 0
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.failures[userName]
+	if !ok {
+		return false, 0
+	}
+	if !now.Before(c.resetAt) {
+		delete(l.failures, userName)
+		return false, 0
+	}
+	if c.count >= l.maxFailures {
+		return true, c.resetAt.Sub(now)
+	}
+	return false, 0
+}
+
+func (l *loginFailureLimiter) recordFailure(userName string) {
+	if !l.enabled() {
+		return
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.failures) >= loginFailureLimiterMaxEntries {
+		for name, c := range l.failures {
+			if !now.Before(c.resetAt) {
+				delete(l.failures, name)
+			}
+		}
+	}
+
+	c, ok := l.failures[userName]
+	if !ok || !now.Before(c.resetAt) {
+		c = &loginFailureCounter{resetAt: now.Add(l.window)}
+		l.failures[userName] = c
+	}
+	c.count++
+}
+
+func (l *loginFailureLimiter) reset(userName string) {
+	if !l.enabled() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, userName)
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginFailureLimiter(t *testing.T) {
+	l := newLoginFailureLimiter(2, time.Minute)
+	l.recordFailure("alice")
+	if blocked, _ := l.blocked("alice"); blocked {
+		t.Fatal("blocked before reaching the limit")
+	}
+	l.recordFailure("alice")
+	if blocked, _ := l.blocked("alice"); !blocked {
+		t.Fatal("not blocked after reaching the limit")
+	}
+	if blocked, _ := l.blocked("bob"); blocked {
+		t.Fatal("other users must not be blocked")
+	}
+	l.reset("alice")
+	if blocked, _ := l.blocked("alice"); blocked {
+		t.Fatal("still blocked after reset")
+	}
+}
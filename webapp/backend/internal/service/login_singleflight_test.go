@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestConcurrentLoginsShareUserLookup(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	get := db.get
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	db.get = func(ctx context.Context, dest any, query string, args ...any) error {
+		if _, ok := dest.(*model.User); ok {
+			lookups.Add(1)
+			<-release
+		}
+		return get(ctx, dest, query, args...)
+	}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw"})
+			errs <- err
+		}()
+	}
+	// 全員が singleflight に合流するのを待ってから検索を返す
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := lookups.Load(); got != 1 {
+		t.Fatalf("lookups = %d, want concurrent logins to share one lookup", got)
+	}
+}
@@ -0,0 +1,8 @@
+package service
+
+import "backend/internal/repository"
+
+// リポジトリのメソッドごとの呼び出し回数・エラー数・所要時間 (プロセス起動からの累計)
+func RepoMetrics() []repository.RepoMethodStats {
+	return repository.RepoMetricsSnapshot()
+}
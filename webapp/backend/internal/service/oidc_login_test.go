@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/goccy/go-json"
+)
+
+// discovery / jwks / token を返すだけの IdP
+// トークンエンドポイントは claims と nonce "n1" を入れた署名済みの ID トークンを返す
+func newFakeIdP(t *testing.T, claims OIDCClaims) *httptest.Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &testIDP{key: key}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcDiscovery{
+				Issuer:                srv.URL,
+				AuthorizationEndpoint: srv.URL + "/authorize",
+				TokenEndpoint:         srv.URL + "/token",
+				JWKSURI:               srv.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]any{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"kid": "k1",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			})
+		case "/token":
+			if r.FormValue("code") != "good-code" || r.FormValue("code_verifier") != "verifier" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			idToken := signer.sign(t, "RS256", map[string]any{
+				"iss":                srv.URL,
+				"aud":                "client",
+				"sub":                claims.Subject,
+				"preferred_username": claims.PreferredUsername,
+				"email":              claims.Email,
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"nonce":              "n1",
+			})
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// user_identities と users を最小限に持つ
+type oidcUserDB struct {
+	fakeDB
+	identities map[string]int
+	users      map[string]int
+	created    []string
+}
+
+func newOIDCUserDB() *oidcUserDB {
+	db := &oidcUserDB{identities: map[string]int{}, users: map[string]int{"alice": 7}}
+	db.get = func(_ context.Context, dest any, query string, args ...any) error {
+		switch {
+		case strings.Contains(query, "FROM user_identities"):
+			id, ok := db.identities[args[1].(string)]
+			if !ok {
+				return sql.ErrNoRows
+			}
+			*dest.(*int) = id
+		case strings.Contains(query, "WHERE user_id = ?"):
+			for name, id := range db.users {
+				if id == args[0].(int) {
+					*dest.(*model.User) = model.User{UserID: id, UserName: name}
+					return nil
+				}
+			}
+			return sql.ErrNoRows
+		case strings.Contains(query, "FROM users"):
+			id, ok := db.users[args[0].(string)]
+			if !ok {
+				return sql.ErrNoRows
+			}
+			*dest.(*model.User) = model.User{UserID: id, UserName: args[0].(string)}
+		}
+		return nil
+	}
+	db.exec = func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		switch {
+		case strings.HasPrefix(query, "INSERT INTO user_identities"):
+			db.identities[args[2].(string)] = args[0].(int)
+		case strings.HasPrefix(query, "INSERT INTO users"):
+			db.created = append(db.created, args[0].(string))
+			db.users[args[0].(string)] = 100
+			return fakeResult{lastInsertID: 100, rowsAffected: 1}, nil
+		}
+		return fakeResult{rowsAffected: 1}, nil
+	}
+	return db
+}
+
+func newOIDCAuthService(t *testing.T, db *oidcUserDB, claims OIDCClaims, autoCreate bool) *AuthService {
+	idp := newFakeIdP(t, claims)
+	return NewAuthService(repository.NewStore(db), AuthConfig{OIDC: OIDCConfig{
+		Issuer:          idp.URL,
+		ClientID:        "client",
+		RedirectURL:     "https://app.example/callback",
+		AutoCreateUsers: autoCreate,
+	}})
+}
+
+func TestLoginWithOIDCLinkedUser(t *testing.T) {
+	db := newOIDCUserDB()
+	db.identities["sub-1"] = 7
+	s := newOIDCAuthService(t, db, OIDCClaims{Subject: "sub-1", PreferredUsername: "renamed"}, false)
+
+	result, err := s.LoginWithOIDC(context.Background(), "good-code", "verifier", "n1", model.LoginRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SessionID == "" {
+		t.Fatal("want a session to be issued")
+	}
+	if len(db.created) != 0 {
+		t.Fatalf("created = %v, want no new users", db.created)
+	}
+}
+
+func TestLoginWithOIDCDoesNotLinkExistingUser(t *testing.T) {
+	claims := OIDCClaims{Subject: "sub-2", PreferredUsername: "alice"}
+
+	db := newOIDCUserDB()
+	s := newOIDCAuthService(t, db, claims, false)
+	if _, err := s.LoginWithOIDC(context.Background(), "good-code", "verifier", "n1", model.LoginRequest{}); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want ErrUserNotFound", err)
+	}
+	if len(db.identities) != 0 {
+		t.Fatalf("identities = %v, want alice left unlinked", db.identities)
+	}
+
+	// 自動作成する場合も同名の既存ユーザーには紐付けず、別名で作る
+	db = newOIDCUserDB()
+	s = newOIDCAuthService(t, db, claims, true)
+	if _, err := s.LoginWithOIDC(context.Background(), "good-code", "verifier", "n1", model.LoginRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.created) != 1 || !strings.HasPrefix(db.created[0], "oidc-") || db.identities["sub-2"] != 100 {
+		t.Fatalf("created = %v, identities = %v; want a new oidc- user linked instead of alice", db.created, db.identities)
+	}
+}
+
+func TestLoginWithOIDCCreatesUnknownUser(t *testing.T) {
+	db := newOIDCUserDB()
+	s := newOIDCAuthService(t, db, OIDCClaims{Subject: "sub-3", Email: "bob@example.com"}, true)
+	if _, err := s.LoginWithOIDC(context.Background(), "good-code", "verifier", "n1", model.LoginRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.created) != 1 || db.created[0] != "bob@example.com" || db.identities["sub-3"] != 100 {
+		t.Fatalf("created = %v, identities = %v; want bob created from the email claim and linked", db.created, db.identities)
+	}
+}
+
+func TestLoginWithOIDCRejectsBadExchange(t *testing.T) {
+	db := newOIDCUserDB()
+	db.identities["sub-1"] = 7
+	s := newOIDCAuthService(t, db, OIDCClaims{Subject: "sub-1"}, false)
+	if _, err := s.LoginWithOIDC(context.Background(), "bad-code", "verifier", "n1", model.LoginRequest{}); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("bad code: err = %v, want ErrInvalidPassword", err)
+	}
+	if _, err := s.LoginWithOIDC(context.Background(), "good-code", "verifier", "other-nonce", model.LoginRequest{}); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("wrong nonce: err = %v, want ErrInvalidPassword", err)
+	}
+}
+
+func TestCompleteOIDCLink(t *testing.T) {
+	db := newOIDCUserDB()
+	s := newOIDCAuthService(t, db, OIDCClaims{Subject: "sub-4"}, false)
+	ctx := context.Background()
+
+	// 予約していない state は紐付けではない
+	if ok, err := s.CompleteOIDCLink(ctx, "unknown", "good-code", "verifier", "n1"); ok || err != nil {
+		t.Fatalf("ok = %v, err = %v; want a plain login callback", ok, err)
+	}
+
+	if _, err := s.OIDCLinkURL(ctx, 7, "st", "verifier", "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.CompleteOIDCLink(ctx, "st", "good-code", "verifier", "n1"); !ok || err != nil {
+		t.Fatalf("ok = %v, err = %v; want the link to complete", ok, err)
+	}
+	if db.identities["sub-4"] != 7 {
+		t.Fatalf("identities = %v, want sub-4 linked to alice (7)", db.identities)
+	}
+
+	// 予約は一度しか使えない
+	if ok, _ := s.CompleteOIDCLink(ctx, "st", "good-code", "verifier", "n1"); ok {
+		t.Fatal("link reservation was reused")
+	}
+}
+
+func TestOIDCAuthURLUsesPKCE(t *testing.T) {
+	s := newOIDCAuthService(t, newOIDCUserDB(), OIDCClaims{}, false)
+	u, err := s.OIDCAuthURL(context.Background(), "st", "verifier", "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"/authorize?", "state=st", "nonce=n1", "code_challenge_method=S256", "client_id=client"} {
+		if !strings.Contains(u, want) {
+			t.Errorf("url %q does not contain %q", u, want)
+		}
+	}
+	if strings.Contains(u, "verifier") {
+		t.Errorf("url %q leaks the code verifier", u)
+	}
+
+	disabled := NewAuthService(repository.NewStore(&fakeDB{}), AuthConfig{})
+	if _, err := disabled.OIDCAuthURL(context.Background(), "st", "verifier", "n1"); !errors.Is(err, ErrOIDCDisabled) {
+		t.Fatalf("err = %v, want ErrOIDCDisabled", err)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+type testIDP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIDP{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   idp.server.URL,
+			"jwks_uri": idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *testIDP) sign(t *testing.T, alg string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "k1"})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newTestIDP(t)
+	client := newOIDCClient(OIDCConfig{Issuer: idp.server.URL, ClientID: "shark", RedirectURL: "http://localhost/cb"})
+	now := time.Now()
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss":   idp.server.URL,
+			"aud":   "shark",
+			"sub":   "user-1",
+			"exp":   now.Add(time.Hour).Unix(),
+			"nonce": "n1",
+		}
+	}
+
+	claims, err := client.verifyIDToken(context.Background(), idp.sign(t, "RS256", valid()), "n1", now)
+	if err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if claims.Subject != "user-1" {
+		t.Errorf("sub = %q, want user-1", claims.Subject)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(map[string]any)
+		nonce  string
+	}{
+		{"wrong nonce", func(map[string]any) {}, "other"},
+		{"empty nonce", func(c map[string]any) { c["nonce"] = "" }, ""},
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example" }, "n1"},
+		{"wrong audience", func(c map[string]any) { c["aud"] = []string{"someone-else"} }, "n1"},
+		{"expired", func(c map[string]any) { c["exp"] = now.Add(-time.Hour).Unix() }, "n1"},
+		{"missing sub", func(c map[string]any) { delete(c, "sub") }, "n1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.mutate(c)
+			if _, err := client.verifyIDToken(context.Background(), idp.sign(t, "RS256", c), tt.nonce, now); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	t.Run("tampered payload", func(t *testing.T) {
+		parts := strings.Split(idp.sign(t, "RS256", valid()), ".")
+		forged, _ := json.Marshal(map[string]any{"iss": idp.server.URL, "aud": "shark", "sub": "admin", "exp": now.Add(time.Hour).Unix(), "nonce": "n1"})
+		parts[1] = base64.RawURLEncoding.EncodeToString(forged)
+		if _, err := client.verifyIDToken(context.Background(), strings.Join(parts, "."), "n1", now); err == nil {
+			t.Error("expected signature error")
+		}
+	})
+
+	t.Run("alg none", func(t *testing.T) {
+		header, _ := json.Marshal(map[string]string{"alg": "none", "kid": "k1"})
+		payload, _ := json.Marshal(valid())
+		token := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+		if _, err := client.verifyIDToken(context.Background(), token, "n1", now); err == nil {
+			t.Error("expected unsupported alg error")
+		}
+	})
+}
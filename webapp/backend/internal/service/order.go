@@ -5,8 +5,53 @@ import (
 	"backend/internal/repository"
 	"backend/internal/service/utils"
 	"context"
+	"database/sql"
+	"errors"
+	"github.com/samber/lo"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+var (
+	ErrOrderNotFound           = errors.New("order not found")
+	ErrInvalidStatusTransition = errors.New("invalid status transition")
+	// 他の更新と競合した (再取得してやり直す)
+	ErrOrderConflict = errors.New("order was modified concurrently")
+)
+
+// ユーザー自身が行えるステータス遷移 (遷移先 -> 遷移元)
+// 受け取り確認 (delivering -> completed) のみ許可する
+var userStatusTransitions = map[string]map[string]bool{
+	"completed": {"delivering": true},
+}
+
+// 明細のうち status にある単位の数
+func unitsInStatus(o model.Order, status string) int {
+	switch status {
+	case "shipping":
+		return o.Quantity - o.DispatchedQuantity
+	case "delivering":
+		return o.DispatchedQuantity - o.CompletedQuantity
+	case "completed":
+		return o.CompletedQuantity
+	}
+	return 0
+}
+
+const maxBulkStatusUpdate = 1000
+
+var validShippedStatuses = map[string]bool{
+	"shipping":   true,
+	"delivering": true,
+	"completed":  true,
+	"returned":   true,
+}
+
+// 返品理由の最大文字数 (order_items.return_reason)
+const maxReturnReasonLength = 255
+
 type OrderService struct {
 	store *repository.Store
 }
@@ -17,6 +62,20 @@ func NewOrderService(store *repository.Store) *OrderService {
 
 // ユーザーの注文履歴を取得
 func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.ListRequest) ([]model.Order, int, error) {
+	for _, status := range req.Statuses {
+		if !validShippedStatuses[status] {
+			return nil, 0, ErrInvalidRequest
+		}
+	}
+	if req.CreatedFrom != nil && req.CreatedTo != nil && !req.CreatedFrom.Before(*req.CreatedTo) {
+		return nil, 0, ErrInvalidRequest
+	}
+	for _, field := range req.Fields {
+		if _, ok := model.OrderListFields[field]; !ok {
+			return nil, 0, ErrInvalidRequest
+		}
+	}
+
 	var orders []model.Order
 	var total int
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
@@ -32,3 +91,201 @@ func (s *OrderService) FetchOrders(ctx context.Context, userID int, req model.Li
 	}
 	return orders, total, nil
 }
+
+// ユーザーの注文詳細を取得
+func (s *OrderService) GetOrder(ctx context.Context, userID int, orderID int64) (*model.Order, error) {
+	var order *model.Order
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		order, err = s.store.OrderRepo.GetOrderByID(ctx, userID, orderID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// ユーザー自身の注文明細のステータスを一括更新
+// 遷移元のステータスにある単位をすべて newStatus に進める
+func (s *OrderService) UpdateStatuses(ctx context.Context, userID int, orderIDs []int64, newStatus string) error {
+	allowedFrom, ok := userStatusTransitions[newStatus]
+	if !ok || len(orderIDs) == 0 || len(orderIDs) > maxBulkStatusUpdate {
+		return ErrInvalidRequest
+	}
+	orderIDs = lo.Uniq(orderIDs)
+
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			progress, err := txStore.OrderRepo.GetProgressForUpdate(ctx, userID, orderIDs)
+			if err != nil {
+				return err
+			}
+			// 他人の注文や存在しない注文が含まれていれば全体を拒否する
+			if len(progress) != len(orderIDs) {
+				return ErrOrderNotFound
+			}
+			// FOR UPDATE でロック済みなのでバージョンは確認しない
+			targets := make([]model.OrderVersion, 0, len(orderIDs))
+			for _, id := range orderIDs {
+				quantity := 0
+				for from := range allowedFrom {
+					quantity += unitsInStatus(progress[id], from)
+				}
+				if quantity == 0 {
+					return ErrInvalidStatusTransition
+				}
+				targets = append(targets, model.OrderVersion{OrderID: id, Version: repository.AnyVersion, Quantity: quantity})
+			}
+			if err := txStore.OrderRepo.UpdateStatuses(ctx, targets, newStatus); err != nil {
+				return err
+			}
+			return txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, orderIDs, newStatus)
+		})
+	})
+}
+
+// 完了済みの注文明細を返品する
+// redeliver を指定した場合は同じ商品・数量の明細を新しく作って配送し直し、その ID を返す
+func (s *OrderService) ReturnOrder(ctx context.Context, userID int, orderID int64, req model.ReturnOrderRequest) (*model.ReturnOrderResponse, error) {
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" || utf8.RuneCountInString(reason) > maxReturnReasonLength {
+		return nil, ErrInvalidRequest
+	}
+
+	resp := &model.ReturnOrderResponse{OrderID: orderID}
+	var lowStock []model.LowStockAlert
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			progress, err := txStore.OrderRepo.GetProgressForUpdate(ctx, userID, []int64{orderID})
+			if err != nil {
+				return err
+			}
+			item, ok := progress[orderID]
+			if !ok {
+				return ErrOrderNotFound
+			}
+			if item.ShippedStatus != "completed" {
+				return ErrInvalidStatusTransition
+			}
+
+			// 返品された商品は在庫に戻す
+			if err := txStore.ProductRepo.AdjustStock(ctx, item.ProductID, item.Quantity); err != nil {
+				return err
+			}
+
+			var replacementID sql.NullInt64
+			if req.Redeliver {
+				replacement := &model.Order{
+					ProductID: item.ProductID,
+					Quantity:  item.Quantity,
+					Priority:  item.Priority,
+					Metadata:  item.Metadata,
+
+					DestLatitude:  item.DestLatitude,
+					DestLongitude: item.DestLongitude,
+
+					Zone: item.Zone,
+				}
+				if lowStock, err = reserveStock(ctx, txStore, []*model.Order{replacement}); err != nil {
+					return err
+				}
+				if _, err := txStore.OrderRepo.BatchCreate(ctx, userID, []*model.Order{replacement}); err != nil {
+					return err
+				}
+				replacementID = sql.NullInt64{Int64: replacement.OrderID, Valid: true}
+				resp.ReplacementOrderID = &replacement.OrderID
+			}
+
+			if err := txStore.OrderRepo.MarkReturned(ctx, orderID, reason, replacementID); err != nil {
+				if errors.Is(err, repository.ErrInsufficientQuantity) {
+					return ErrInvalidStatusTransition
+				}
+				return err
+			}
+			if err := txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, []int64{orderID}, "returned"); err != nil {
+				return err
+			}
+			if replacementID.Valid {
+				return txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, []int64{replacementID.Int64}, "shipping")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	logLowStockAlerts(lowStock)
+	return resp, nil
+}
+
+// 管理者による完了済み注文の一括削除 (またはアーカイブ)
+type PurgeCompletedRequest struct {
+	Before    time.Time
+	Archive   bool          // 削除せず order_items_archive に移す
+	BatchSize int           // 1 トランザクションで処理する件数
+	Pause     time.Duration // バッチの間に空ける時間 (他のクエリにロックを譲る)
+}
+
+// 一括削除の 1 バッチの最大件数
+const maxPurgeBatchSize = 10000
+
+// arrived_at が Before より前の完了済み明細をバッチごとに削除 (またはアーカイブ) する
+// バッチごとに progress にそれまでの合計件数を渡し、最後に合計件数を返す
+// ctx がキャンセルされたら途中で止める (処理済みのバッチはコミット済み)
+func (s *OrderService) PurgeCompleted(ctx context.Context, req PurgeCompletedRequest, progress func(total int)) (int, error) {
+	if req.Before.IsZero() || req.BatchSize <= 0 || req.BatchSize > maxPurgeBatchSize || req.Pause < 0 {
+		return 0, ErrInvalidRequest
+	}
+
+	total := 0
+	for {
+		var n int
+		err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+			return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+				var err error
+				if req.Archive {
+					n, err = txStore.OrderRepo.ArchiveCompleted(ctx, req.Before, req.BatchSize)
+				} else {
+					n, err = txStore.OrderRepo.DeleteCompleted(ctx, req.Before, req.BatchSize)
+				}
+				return err
+			})
+		})
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if progress != nil {
+			progress(total)
+		}
+		if n < req.BatchSize {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(req.Pause):
+		}
+	}
+	log.Printf("[OrderPurge] 完了済み注文明細を %d 件%s", total, lo.Ternary(req.Archive, "アーカイブ", "削除"))
+	return total, nil
+}
+
+// ユーザーの注文統計を取得
+func (s *OrderService) GetOrderStats(ctx context.Context, userID int) (*model.OrderStats, error) {
+	var stats *model.OrderStats
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		stats, err = s.store.OrderRepo.GetOrderStats(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/repository"
+)
+
+// 完了から retention 以上経った注文明細を order_items_archive に移すバックグラウンドジョブ
+// 1 トランザクションで移す件数は batchSize 件まで
+type OrderArchiver struct {
+	store     *repository.Store
+	interval  time.Duration
+	retention time.Duration
+	batchSize int
+}
+
+func NewOrderArchiver(store *repository.Store, interval, retention time.Duration, batchSize int) *OrderArchiver {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	return &OrderArchiver{store: store, interval: interval, retention: retention, batchSize: batchSize}
+}
+
+// ctx がキャンセルされるまで interval ごとにアーカイブを実行する
+func (a *OrderArchiver) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.archive(ctx)
+		}
+	}
+}
+
+func (a *OrderArchiver) archive(ctx context.Context) {
+	before := time.Now().Add(-a.retention)
+	total := 0
+	for {
+		var n int
+		batchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := a.store.ExecTx(batchCtx, func(txStore *repository.Store) error {
+			var err error
+			n, err = txStore.OrderRepo.ArchiveCompleted(batchCtx, before, a.batchSize)
+			return err
+		})
+		cancel()
+		if err != nil {
+			log.Printf("[OrderArchiver] 注文のアーカイブ失敗: %v", err)
+			return
+		}
+		total += n
+		if n < a.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("[OrderArchiver] 完了済み注文明細を %d 件アーカイブ", total)
+	}
+}
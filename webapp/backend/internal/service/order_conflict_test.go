@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestGenerateDeliveryPlanReportsConflict(t *testing.T) {
+	affected := int64(0)
+	db := &fakeDB{
+		sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+			if orders, ok := dest.(*[]model.Order); ok {
+				*orders = []model.Order{{OrderID: 1, Weight: 1, Value: 10, Version: 2}}
+			}
+			return nil
+		},
+		exec: func(_ context.Context, query string, _ ...any) (sql.Result, error) {
+			if strings.HasPrefix(query, "UPDATE order_items o JOIN") {
+				return fakeResult{rowsAffected: affected}, nil
+			}
+			return fakeResult{}, nil
+		},
+	}
+	s := NewRobotService(repository.NewStore(db))
+	ctx := context.Background()
+
+	// 計画中に他の更新でバージョンが変わっていた
+	if _, err := s.GenerateDeliveryPlan(ctx, "r1", model.DeliveryPlanParams{Capacity: 10}); !errors.Is(err, ErrOrderConflict) {
+		t.Fatalf("err = %v, want ErrOrderConflict", err)
+	}
+
+	affected = 1
+	plan, err := s.GenerateDeliveryPlan(ctx, "r1", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Orders) != 1 || plan.Orders[0].Version != 2 {
+		t.Fatalf("plan = %+v, want order 1 read at version 2", plan)
+	}
+}
+
+func TestUpdateOrderStatusVersion(t *testing.T) {
+	var args []any
+	db := &fakeDB{exec: func(_ context.Context, query string, a ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "UPDATE order_items o JOIN") {
+			args = a
+		}
+		return fakeResult{}, nil
+	}}
+	s := NewRobotService(repository.NewStore(db))
+	ctx := context.Background()
+
+	version := int64(5)
+	if err := s.UpdateOrderStatus(ctx, 1, "delivering", &version); !errors.Is(err, ErrOrderConflict) {
+		t.Fatalf("err = %v, want ErrOrderConflict for a stale version", err)
+	}
+	found := false
+	for _, a := range args {
+		found = found || a == version
+	}
+	if !found {
+		t.Fatalf("update args = %v, want the expected version %d", args, version)
+	}
+
+	// バージョンを指定しなければ確認せず、更新できなければ遷移元の数量が足りない
+	if err := s.UpdateOrderStatus(ctx, 1, "delivering", nil); !errors.Is(err, ErrInvalidStatusTransition) {
+		t.Fatalf("err = %v, want ErrInvalidStatusTransition without a version", err)
+	}
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestGetOrderIsScopedToUser(t *testing.T) {
+	// 注文 10 はユーザー 1 のもの
+	db := &fakeDB{get: func(_ context.Context, dest any, _ string, args ...any) error {
+		if args[0] != int64(10) || args[1] != 1 {
+			return sql.ErrNoRows
+		}
+		*dest.(*model.Order) = model.Order{OrderID: 10, UserID: 1, ProductName: "robot", Image: "robot.png"}
+		return nil
+	}}
+	s := NewOrderService(repository.NewStore(db))
+	ctx := context.Background()
+
+	order, err := s.GetOrder(ctx, 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.OrderID != 10 || order.Image != "robot.png" {
+		t.Fatalf("order = %+v, want order 10 with its product image", order)
+	}
+	if _, err := s.GetOrder(ctx, 2, 10); !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("err = %v, want ErrOrderNotFound for another user's order", err)
+	}
+}
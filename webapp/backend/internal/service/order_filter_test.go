@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestFetchOrdersValidatesFilters(t *testing.T) {
+	s := NewOrderService(repository.NewStore(&fakeDB{}))
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+
+	for name, req := range map[string]model.ListRequest{
+		"unknown status": {Statuses: []string{"shipping", "lost"}},
+		"empty range":    {CreatedFrom: &now, CreatedTo: &now},
+		"reversed range": {CreatedFrom: &now, CreatedTo: &earlier},
+	} {
+		if _, _, err := s.FetchOrders(context.Background(), 1, req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: err = %v, want ErrInvalidRequest", name, err)
+		}
+	}
+
+	if _, _, err := s.FetchOrders(context.Background(), 1, model.ListRequest{
+		Statuses:    []string{"delivering"},
+		CreatedFrom: &earlier,
+		CreatedTo:   &now,
+	}); err != nil {
+		t.Fatalf("valid filter: %v", err)
+	}
+}
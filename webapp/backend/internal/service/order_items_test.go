@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestUpdateStatusesMovesUnitsInSourceStatus(t *testing.T) {
+	var updateArgs []any
+	db := &fakeDB{
+		sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+			// 3 個のうち 2 個が配送に出ていて、1 個は受け取り済み
+			if orders, ok := dest.(*[]model.Order); ok {
+				*orders = []model.Order{{OrderID: 1, UserID: 1, Quantity: 3, DispatchedQuantity: 2, CompletedQuantity: 1}}
+			}
+			return nil
+		},
+		exec: func(_ context.Context, query string, args ...any) (sql.Result, error) {
+			if strings.HasPrefix(query, "UPDATE order_items o JOIN") {
+				updateArgs = args
+			}
+			return fakeResult{rowsAffected: 1}, nil
+		},
+	}
+	s := NewOrderService(repository.NewStore(db))
+
+	if err := s.UpdateStatuses(context.Background(), 1, []int64{1}, "completed"); err != nil {
+		t.Fatal(err)
+	}
+	// 配送中の 1 個だけを完了にする
+	if want := []any{int64(1), repository.AnyVersion, 1}; !reflect.DeepEqual(updateArgs, want) {
+		t.Fatalf("update args = %v, want %v", updateArgs, want)
+	}
+}
+
+func TestCreateOrdersRejectsTooLargeQuantity(t *testing.T) {
+	s := NewProductService(repository.NewStore(&fakeDB{}))
+	items := []model.RequestItem{{ProductID: 1, Quantity: MaxOrderItemQuantity + 1}}
+	if _, err := s.CreateOrders(context.Background(), 1, items, ""); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest", err)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/internal/repository"
+)
+
+// user 1 の注文明細のステータスを持ち、数量を進める UPDATE を記録する
+func newOrderStatusDB(statuses map[int64]string) (*fakeDB, *[]string) {
+	var updates []string
+	db := &fakeDB{}
+	db.sel = func(_ context.Context, dest any, _ string, args ...any) error {
+		if args[0] != 1 {
+			return nil
+		}
+		rows := reflect.ValueOf(dest).Elem()
+		for _, arg := range args[1:] {
+			status, ok := statuses[arg.(int64)]
+			if !ok {
+				continue
+			}
+			row := reflect.New(rows.Type().Elem()).Elem()
+			row.FieldByName("OrderID").SetInt(arg.(int64))
+			row.FieldByName("ShippedStatus").SetString(status)
+			// 数量 1 の明細として進捗を持たせる
+			row.FieldByName("Quantity").SetInt(1)
+			if status != "shipping" {
+				row.FieldByName("DispatchedQuantity").SetInt(1)
+			}
+			if status == "completed" {
+				row.FieldByName("CompletedQuantity").SetInt(1)
+			}
+			rows.Set(reflect.Append(rows, row))
+		}
+		return nil
+	}
+	db.exec = func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "UPDATE order_items o JOIN") {
+			updates = append(updates, query)
+			// 導出表の (明細, バージョン, 数量) ごとに 1 行更新できたことにする
+			return fakeResult{rowsAffected: int64(len(args) / 3)}, nil
+		}
+		return fakeResult{rowsAffected: 1}, nil
+	}
+	return db, &updates
+}
+
+func TestUpdateStatusesByUser(t *testing.T) {
+	statuses := map[int64]string{1: "delivering", 2: "delivering", 3: "shipping"}
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		userID    int
+		orderIDs  []int64
+		newStatus string
+		wantErr   error
+	}{
+		{"confirm receipt", 1, []int64{1, 2, 2}, "completed", nil},
+		{"not delivering yet", 1, []int64{1, 3}, "completed", ErrInvalidStatusTransition},
+		{"other user's orders", 2, []int64{1}, "completed", ErrOrderNotFound},
+		{"unknown order", 1, []int64{1, 99}, "completed", ErrOrderNotFound},
+		{"status not allowed for users", 1, []int64{3}, "delivering", ErrInvalidRequest},
+		{"empty", 1, nil, "completed", ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, updates := newOrderStatusDB(statuses)
+			s := NewOrderService(repository.NewStore(db))
+
+			err := s.UpdateStatuses(ctx, tt.userID, tt.orderIDs, tt.newStatus)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if wantUpdates := tt.wantErr == nil; (len(*updates) > 0) != wantUpdates {
+				t.Fatalf("updates = %v, want updated = %v", *updates, wantUpdates)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 明細 item を行ロック付きで返し、更新は affected 件とする DBTX
+func newReturnOrderDB(item *model.Order, affected int64) *fakeDB {
+	return &fakeDB{
+		sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+			if rows, ok := dest.(*[]model.Order); ok && item != nil {
+				*rows = []model.Order{*item}
+			}
+			return nil
+		},
+		exec: func(context.Context, string, ...any) (sql.Result, error) {
+			return fakeResult{rowsAffected: affected}, nil
+		},
+	}
+}
+
+func TestReturnOrder(t *testing.T) {
+	completed := &model.Order{OrderID: 5, Quantity: 2, DispatchedQuantity: 2, CompletedQuantity: 2, ShippedStatus: "completed"}
+	delivering := &model.Order{OrderID: 5, Quantity: 2, DispatchedQuantity: 2, CompletedQuantity: 1, ShippedStatus: "delivering"}
+
+	tests := []struct {
+		name     string
+		item     *model.Order
+		affected int64
+		reason   string
+		wantErr  error
+	}{
+		{"completed", completed, 1, "壊れていた", nil},
+		{"not completed", delivering, 1, "壊れていた", ErrInvalidStatusTransition},
+		{"other user", nil, 1, "壊れていた", ErrOrderNotFound},
+		{"already returned", completed, 0, "壊れていた", ErrInvalidStatusTransition},
+		{"empty reason", completed, 1, "  ", ErrInvalidRequest},
+		{"long reason", completed, 1, strings.Repeat("あ", maxReturnReasonLength+1), ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newReturnOrderDB(tt.item, tt.affected)
+			s := NewOrderService(repository.NewStore(db))
+			resp, err := s.ReturnOrder(context.Background(), 1, 5, model.ReturnOrderRequest{Reason: tt.reason})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if resp.OrderID != 5 || resp.ReplacementOrderID != nil {
+				t.Fatalf("resp = %+v", resp)
+			}
+			if execs := db.queries(); len(execs) != 2 || !strings.Contains(execs[0], "stock = stock + ?") || !strings.Contains(execs[1], "returned_at = NOW()") {
+				t.Fatalf("execs = %v, want the restock and the return update", execs)
+			}
+		})
+	}
+}
+
+func TestPurgeCompletedRunsBatchesUntilShort(t *testing.T) {
+	db := newPurgeDB(2, 2, 1)
+	s := NewOrderService(repository.NewStore(db))
+
+	var reported []int
+	total, err := s.PurgeCompleted(context.Background(), PurgeCompletedRequest{Before: time.Now(), BatchSize: 2}, func(total int) {
+		reported = append(reported, total)
+	})
+	if err != nil {
+		t.Fatalf("PurgeCompleted: %v", err)
+	}
+	if total != 5 || len(db.calls) != 3 {
+		t.Fatalf("total = %d, batches = %d; want 5 and 3", total, len(db.calls))
+	}
+	if want := []int{2, 4, 5}; !slices.Equal(reported, want) {
+		t.Fatalf("progress = %v, want %v", reported, want)
+	}
+}
+
+func TestPurgeCompletedValidatesRequest(t *testing.T) {
+	s := NewOrderService(repository.NewStore(newPurgeDB()))
+	for name, req := range map[string]PurgeCompletedRequest{
+		"no before":      {BatchSize: 10},
+		"no batch size":  {Before: time.Now()},
+		"too many":       {Before: time.Now(), BatchSize: maxPurgeBatchSize + 1},
+		"negative pause": {Before: time.Now(), BatchSize: 10, Pause: -time.Second},
+	} {
+		if _, err := s.PurgeCompleted(context.Background(), req, nil); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: err = %v, want ErrInvalidRequest", name, err)
+		}
+	}
+}
+
+// DELETE ごとに affected を先頭から順に返す DBTX
+func newPurgeDB(affected ...int64) *fakeDB {
+	return &fakeDB{exec: func(context.Context, string, ...any) (sql.Result, error) {
+		n := affected[0]
+		affected = affected[1:]
+		return fakeResult{rowsAffected: n}, nil
+	}}
+}
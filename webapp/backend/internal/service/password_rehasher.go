@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"backend/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordRehashQueueSize = 256
+
+// 設定より低いコストで作られたハッシュをログイン成功時に作り直す
+// bcrypt はリクエストのレイテンシに響くので、キューに積んで非同期に処理する
+type passwordRehasher struct {
+	store      *repository.Store
+	cost       int
+	onRehashed func(oldHash string)
+
+	queue    chan passwordRehashJob
+	inflight sync.Map // userID -> struct{}
+}
+
+type passwordRehashJob struct {
+	userID   int
+	oldHash  string
+	password string
+}
+
+func newPasswordRehasher(store *repository.Store, cost int, onRehashed func(oldHash string)) *passwordRehasher {
+	r := &passwordRehasher{
+		store:      store,
+		cost:       cost,
+		onRehashed: onRehashed,
+		queue:      make(chan passwordRehashJob, passwordRehashQueueSize),
+	}
+	go r.run()
+	return r
+}
+
+func (r *passwordRehasher) needsRehash(passwordHash string) bool {
+	cost, err := bcrypt.Cost([]byte(passwordHash))
+	return err == nil && cost < r.cost
+}
+
+// 同じユーザーの作り直しが処理中なら何もしない
+func (r *passwordRehasher) enqueue(userID int, oldHash, password string) {
+	if !r.needsRehash(oldHash) {
+		return
+	}
+	if _, loaded := r.inflight.LoadOrStore(userID, struct{}{}); loaded {
+		return
+	}
+	select {
+	case r.queue <- passwordRehashJob{userID: userID, oldHash: oldHash, password: password}:
+	default:
+		r.inflight.Delete(userID)
+	}
+}
+
+func (r *passwordRehasher) run() {
+	for job := range r.queue {
+		r.rehash(job)
+		r.inflight.Delete(job.userID)
+	}
+}
+
+func (r *passwordRehasher) rehash(job passwordRehashJob) {
+	newHash, err := bcrypt.GenerateFromPassword([]byte(job.password), r.cost)
+	if err != nil {
+		log.Printf("[PasswordRehash] ハッシュ生成失敗(userID: %d): %v", job.userID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// 途中でパスワードが変更されていたら上書きしない
+	updated, err := r.store.UserRepo.ReplacePasswordHash(ctx, job.userID, job.oldHash, string(newHash))
+	if err != nil {
+		log.Printf("[PasswordRehash] 更新失敗(userID: %d): %v", job.userID, err)
+		return
+	}
+	if updated {
+		r.onRehashed(job.oldHash)
+	}
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginRehashesLowCostHash(t *testing.T) {
+	db := newLockoutDB(t, "pw") // bcrypt.MinCost
+	oldHash := db.user.PasswordHash
+	replaced := make(chan []any, 1)
+	exec := db.exec
+	db.exec = func(ctx context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "UPDATE users SET password_hash") {
+			replaced <- args
+			return fakeResult{rowsAffected: 1}, nil
+		}
+		return exec(ctx, query, args...)
+	}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{BcryptCost: bcrypt.MinCost + 1})
+
+	if _, err := s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case args := <-replaced:
+		newHash, userID, conditionHash := args[0].(string), args[1], args[2]
+		if userID != 1 || conditionHash != oldHash {
+			t.Fatalf("args = %v, want the update to be conditional on the old hash", args)
+		}
+		if cost, _ := bcrypt.Cost([]byte(newHash)); cost != bcrypt.MinCost+1 {
+			t.Fatalf("new hash cost = %d, want %d", cost, bcrypt.MinCost+1)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(newHash), []byte("pw")) != nil {
+			t.Fatal("new hash does not match the password")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("low-cost hash was not rehashed")
+	}
+}
+
+func TestPasswordRehasherSkipsCurrentCost(t *testing.T) {
+	r := &passwordRehasher{cost: bcrypt.MinCost}
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	if r.needsRehash(string(hash)) {
+		t.Fatal("hash with the configured cost must not be rehashed")
+	}
+	if r.needsRehash("!oidc") {
+		t.Fatal("non-bcrypt hash must not be rehashed")
+	}
+}
+
+func TestPasswordRehasherKeepsChangedPassword(t *testing.T) {
+	// 作り直しの間にパスワードが変わっていれば UPDATE は 0 件になる
+	db := &fakeDB{exec: func(context.Context, string, ...any) (sql.Result, error) {
+		return fakeResult{}, nil
+	}}
+	purged := false
+	r := &passwordRehasher{
+		store:      repository.NewStore(db),
+		cost:       bcrypt.MinCost,
+		onRehashed: func(string) { purged = true },
+	}
+	r.rehash(passwordRehashJob{userID: 1, oldHash: "old", password: "pw"})
+	if purged {
+		t.Fatal("password cache was purged although the hash was not replaced")
+	}
+}
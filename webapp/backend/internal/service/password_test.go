@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestChangePasswordRevokesSessions(t *testing.T) {
+	ctx := context.Background()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old"), bcrypt.MinCost)
+	user := model.User{UserID: 1, UserName: "alice", PasswordHash: string(hash)}
+	var newHash string
+	db := &fakeDB{
+		get: func(_ context.Context, dest any, _ string, _ ...any) error {
+			*dest.(*model.User) = user
+			return nil
+		},
+		sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+			*dest.(*[]string) = []string{"s1", "s2"}
+			return nil
+		},
+		exec: func(_ context.Context, query string, args ...any) (sql.Result, error) {
+			if strings.HasPrefix(query, "UPDATE users SET password_hash") {
+				newHash = args[0].(string)
+			}
+			return fakeResult{rowsAffected: 1}, nil
+		},
+	}
+	sessions, _ := repository.NewLRUSessionStore(16, 0)
+	sessions.Set(ctx, "s1", 1, time.Now().Add(time.Hour))
+	sessions.Set(ctx, "s2", 1, time.Now().Add(time.Hour))
+	s := NewAuthService(repository.NewStore(db, repository.WithSessionStore(sessions)), AuthConfig{BcryptCost: bcrypt.MinCost + 1})
+
+	if err := s.ChangePassword(ctx, 1, "wrong", "new"); !errors.Is(err, ErrInvalidPassword) {
+		t.Fatalf("err = %v, want ErrInvalidPassword", err)
+	}
+	if err := s.ChangePassword(ctx, 1, "old", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(newHash), []byte("new")) != nil {
+		t.Fatal("new password hash not stored")
+	}
+	if cost, _ := bcrypt.Cost([]byte(newHash)); cost != bcrypt.MinCost+1 {
+		t.Fatalf("cost = %d, want the configured cost", cost)
+	}
+	for _, id := range []string{"s1", "s2"} {
+		if _, _, ok := sessions.Get(ctx, id); ok {
+			t.Fatalf("session %s is still cached after the password change", id)
+		}
+	}
+}
+
+func TestChangePasswordRequiresNewPassword(t *testing.T) {
+	s := NewAuthService(repository.NewStore(&fakeDB{}), AuthConfig{})
+	if err := s.ChangePassword(context.Background(), 1, "old", ""); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestChangePasswordPurgesVerifiedPasswordCache(t *testing.T) {
+	s := NewAuthService(repository.NewStore(&fakeDB{}), AuthConfig{})
+	s.passwordCache.Store(makePasswordCacheKey("old-hash", "pw"), struct{}{})
+	s.passwordCache.Store(makePasswordCacheKey("other-hash", "pw"), struct{}{})
+
+	s.purgePasswordCache("old-hash")
+	if _, ok := s.passwordCache.Load(makePasswordCacheKey("old-hash", "pw")); ok {
+		t.Fatal("verified password for the old hash is still cached")
+	}
+	if _, ok := s.passwordCache.Load(makePasswordCacheKey("other-hash", "pw")); !ok {
+		t.Fatal("other users' cache entries must be kept")
+	}
+}
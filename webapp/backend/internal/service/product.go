@@ -1,55 +1,525 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"github.com/samber/lo"
 	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"backend/internal/model"
 	"backend/internal/repository"
+	"backend/internal/service/utils"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type ProductService struct {
 	store *repository.Store
+
+	// 画像パスとサイズごとに、配信する縮小版のパス
+	imageVariants     *lru.Cache[imageVariantKey, string]
+	imageVariantGroup singleflight.Group
+	// 画像パスと形式ごとに、変換済みの画像 (WebP / AVIF) があるか
+	imageFormats *expirable.LRU[imageFormatKey, bool]
+
+	// よく一緒に注文される商品 (RefreshRecommendations で差し替える)
+	recommendations atomic.Pointer[productRecommendations]
 }
 
+// 縮小版のパスを覚えておく件数
+var ProductImageVariantCacheSize = 4096
+
+// 変換済みの画像の有無を覚えておく時間 (後から置いた変換済みの画像はこの時間が過ぎると配信される)
+var ProductImageFormatCacheTTL = time.Minute
+
 func NewProductService(store *repository.Store) *ProductService {
-	return &ProductService{store: store}
+	return &ProductService{
+		store:         store,
+		imageVariants: lo.Must(lru.New[imageVariantKey, string](ProductImageVariantCacheSize)),
+		imageFormats:  expirable.NewLRU[imageFormatKey, bool](ProductImageVariantCacheSize, nil, ProductImageFormatCacheTTL),
+	}
+}
+
+var (
+	// 同じ Idempotency-Key が別の内容のリクエストに使われた
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused with different request")
+	// 同じ Idempotency-Key のリクエストがまだ処理中
+	ErrIdempotencyInProgress = errors.New("idempotency key in progress")
+
+	errIdempotentReplay = errors.New("idempotent replay")
+
+	// 在庫が足りない商品がある (詳細は OutOfStockError)
+	ErrOutOfStock = errors.New("out of stock")
+	// 新しい注文を受け付けない商品がある (詳細は InactiveProductError)
+	ErrProductInactive = errors.New("product is not available")
+
+	ErrProductNotFound = errors.New("product not found")
+	// 画像の保存先が設定されていない
+	ErrImageStoreUnavailable = errors.New("image store is not configured")
+	// 対応していない画像形式
+	ErrUnsupportedImageType = errors.New("unsupported image type")
+	ErrImageNotFound        = errors.New("image not found")
+)
+
+// 在庫不足の商品ごとの詳細 (errors.Is(err, ErrOutOfStock) が true になる)
+type OutOfStockError struct {
+	Items []model.StockRejection
+}
+
+func (e *OutOfStockError) Error() string {
+	parts := make([]string, len(e.Items))
+	for i, item := range e.Items {
+		parts[i] = fmt.Sprintf("product %d: requested %d, available %d", item.ProductID, item.Requested, item.Available)
+	}
+	return "out of stock: " + strings.Join(parts, ", ")
+}
+
+func (e *OutOfStockError) Unwrap() error { return ErrOutOfStock }
+
+// 新しい注文を受け付けない (is_active が FALSE の) 商品 (errors.Is(err, ErrProductInactive) が true になる)
+type InactiveProductError struct {
+	ProductIDs []int
+}
+
+func (e *InactiveProductError) Error() string {
+	return fmt.Sprintf("inactive products: %v", e.ProductIDs)
 }
 
-func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem) ([]string, error) {
+func (e *InactiveProductError) Unwrap() error { return ErrProductInactive }
+
+// 注文で在庫数がこの値を下回ったら通知する (ログと管理者登録の Webhook、0 なら通知しない)
+// 下回ったときに 1 回だけ通知し、下回ったままの注文では通知しない
+var LowStockThreshold = 0
+
+// 注文に指定できる優先度の上限
+const MaxOrderPriority = 9
+
+// 1 明細に指定できる数量の上限 (配送計画用ビューで展開できる数、17_order_items.sql)
+const MaxOrderItemQuantity = 10000
+
+// 明細のメタデータ (JSON) の最大サイズ
+const MaxOrderMetadataBytes = 4096
+
+// ゾーン名の最大長 (users.zone, order_items.zone)
+const MaxZoneLength = 64
+
+// 注文ヘッダーと商品ごとの明細を作成し、作成した明細 ID (注文 ID) を返す
+// 数量 0 以下の商品は無視する
+// idempotencyKey を指定した場合、同じキーでの再送には最初の結果を返す
+func (s *ProductService) CreateOrders(ctx context.Context, userID int, items []model.RequestItem, idempotencyKey string) ([]string, error) {
+	for _, item := range items {
+		if item.Priority < 0 || item.Priority > MaxOrderPriority || item.Quantity > MaxOrderItemQuantity {
+			return nil, ErrInvalidRequest
+		}
+		if item.DeliverAfter != nil && item.DeliverBefore != nil && !item.DeliverAfter.Before(*item.DeliverBefore) {
+			return nil, ErrInvalidRequest
+		}
+		if !validDestination(item.DestLatitude, item.DestLongitude) {
+			return nil, ErrInvalidRequest
+		}
+		if item.Zone != nil && len(*item.Zone) > MaxZoneLength {
+			return nil, ErrInvalidRequest
+		}
+		if item.Metadata != nil {
+			b, err := json.Marshal(item.Metadata)
+			if err != nil || len(b) > MaxOrderMetadataBytes {
+				return nil, ErrInvalidRequest
+			}
+		}
+	}
+
 	var insertedOrderIDs []string
+	var lowStock []model.LowStockAlert
+	requestHash := hashOrderItems(items)
 
 	err := s.store.ExecTx(ctx, func(txStore *repository.Store) error {
-		ordersToCreate := lo.FlatMap(items, func(item model.RequestItem, _ int) []*model.Order {
-			return lo.RepeatBy(item.Quantity, func(_ int) *model.Order {
-				return &model.Order{
-					UserID:    userID,
-					ProductID: item.ProductID,
-				}
-			})
-		})
-		if len(ordersToCreate) == 0 {
-			return nil
+		if idempotencyKey != "" {
+			claimed, err := txStore.IdempotencyRepo.Claim(ctx, userID, idempotencyKey, requestHash)
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				return errIdempotentReplay
+			}
 		}
 
-		var err error
-		insertedOrderIDs, err = txStore.OrderRepo.BatchCreate(ctx, ordersToCreate)
-		if err != nil {
-			return err
+		// ゾーンを省略した明細はユーザーのゾーンに配送する
+		var userZone *string
+		if repository.OrderZoneEnabled && lo.ContainsBy(items, func(item model.RequestItem) bool { return item.Zone == nil }) {
+			user, err := txStore.UserRepo.FindByID(ctx, userID)
+			if err != nil {
+				return err
+			}
+			userZone = user.Zone
+		}
+
+		ordersToCreate := lo.FilterMap(items, func(item model.RequestItem, _ int) (*model.Order, bool) {
+			return &model.Order{
+				ProductID: item.ProductID,
+				Quantity:  item.Quantity,
+				Priority:  item.Priority,
+				Metadata:  item.Metadata,
+
+				DeliverAfter:  item.DeliverAfter,
+				DeliverBefore: item.DeliverBefore,
+
+				DestLatitude:  item.DestLatitude,
+				DestLongitude: item.DestLongitude,
+
+				Zone: orderZone(item.Zone, userZone),
+			}, item.Quantity > 0
+		})
+		if len(ordersToCreate) > 0 {
+			if err := rejectInactiveProducts(ctx, txStore, ordersToCreate); err != nil {
+				return err
+			}
+			var err error
+			if lowStock, err = reserveStock(ctx, txStore, ordersToCreate); err != nil {
+				return err
+			}
+			insertedOrderIDs, err = txStore.OrderRepo.BatchCreate(ctx, userID, ordersToCreate)
+			if err != nil {
+				return err
+			}
 		}
 
+		if idempotencyKey != "" {
+			return txStore.IdempotencyRepo.Complete(ctx, userID, idempotencyKey, insertedOrderIDs)
+		}
 		return nil
 	})
 
+	if errors.Is(err, errIdempotentReplay) {
+		return s.replayCreateOrders(ctx, userID, idempotencyKey, requestHash)
+	}
 	if err != nil {
 		return nil, err
 	}
-	log.Printf("Created %d orders for user %d", len(insertedOrderIDs), userID)
+	log.Printf("Created %d order items for user %d", len(insertedOrderIDs), userID)
+	logLowStockAlerts(lowStock)
 	return insertedOrderIDs, nil
 }
 
+// 新しい注文を受け付けない商品があれば、それらすべてを含む InactiveProductError を返す
+// 返品時の再配送 (ReturnOrder) は注文済みの明細なので確認しない
+func rejectInactiveProducts(ctx context.Context, txStore *repository.Store, orders []*model.Order) error {
+	productIDs := lo.Uniq(lo.Map(orders, func(o *model.Order, _ int) int { return o.ProductID }))
+	slices.Sort(productIDs)
+	inactive, err := txStore.ProductRepo.GetInactiveIDs(ctx, productIDs)
+	if err != nil {
+		return err
+	}
+	if len(inactive) > 0 {
+		return &InactiveProductError{ProductIDs: inactive}
+	}
+	return nil
+}
+
+// 明細の数量分の在庫を引き当てる (トランザクション内で呼ぶこと)
+// 足りない商品があれば何も減らさずに、すべての不足分を含む OutOfStockError を返す
+// 在庫数が LowStockThreshold を下回った商品は Webhook の配信を積み、コミット後のログ用に返す
+func reserveStock(ctx context.Context, txStore *repository.Store, orders []*model.Order) ([]model.LowStockAlert, error) {
+	requested := make(map[int]int, len(orders))
+	for _, o := range orders {
+		requested[o.ProductID] += o.Quantity
+	}
+	productIDs := lo.Keys(requested)
+	slices.Sort(productIDs)
+
+	stocks, err := txStore.ProductRepo.GetStocksForUpdate(ctx, productIDs)
+	if err != nil {
+		return nil, err
+	}
+	var rejected []model.StockRejection
+	for _, id := range productIDs {
+		if stock, ok := stocks[id]; ok && stock < requested[id] {
+			rejected = append(rejected, model.StockRejection{ProductID: id, Requested: requested[id], Available: stock})
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, &OutOfStockError{Items: rejected}
+	}
+	var lowStock []model.LowStockAlert
+	for _, id := range productIDs {
+		stock, ok := stocks[id]
+		if !ok {
+			continue
+		}
+		if err := txStore.ProductRepo.AdjustStock(ctx, id, -requested[id]); err != nil {
+			return nil, err
+		}
+		if remaining := stock - requested[id]; stock >= LowStockThreshold && remaining < LowStockThreshold {
+			lowStock = append(lowStock, model.LowStockAlert{ProductID: id, Stock: remaining, Threshold: LowStockThreshold})
+		}
+	}
+	if len(lowStock) > 0 {
+		if err := txStore.WebhookRepo.EnqueueLowStockEvents(ctx, lowStock); err != nil {
+			return nil, err
+		}
+	}
+	return lowStock, nil
+}
+
+func logLowStockAlerts(alerts []model.LowStockAlert) {
+	for _, alert := range alerts {
+		log.Printf("[Stock] 商品 %d の在庫が %d 個になりました (しきい値 %d)", alert.ProductID, alert.Stock, alert.Threshold)
+	}
+}
+
+func (s *ProductService) replayCreateOrders(ctx context.Context, userID int, idempotencyKey, requestHash string) ([]string, error) {
+	record, err := s.store.IdempotencyRepo.Find(ctx, userID, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+	if record.RequestHash != requestHash {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !record.Completed {
+		return nil, ErrIdempotencyInProgress
+	}
+	log.Printf("Replayed %d order items for user %d (idempotency key)", len(record.OrderIDs), userID)
+	return record.OrderIDs, nil
+}
+
+// 配送先は両方指定するか両方省略する (注文明細に配送先を持たない場合は指定できない)
+func validDestination(lat, lng *float64) bool {
+	if lat == nil && lng == nil {
+		return true
+	}
+	return repository.OrderDestinationEnabled && lat != nil && lng != nil &&
+		*lat >= -90 && *lat <= 90 && *lng >= -180 && *lng <= 180
+}
+
+// 同じキーで内容の違うリクエストを検出するためのハッシュ
+func hashOrderItems(items []model.RequestItem) string {
+	h := sha256.New()
+	for _, item := range items {
+		fmt.Fprintf(h, "%d:%d;", item.ProductID, item.Quantity)
+		if item.Priority != 0 {
+			fmt.Fprintf(h, "p%d;", item.Priority)
+		}
+		if item.Metadata != nil {
+			// キーの順番によらず同じ内容なら同じハッシュになる (マップのキーはソートされる)
+			b, _ := json.Marshal(item.Metadata)
+			fmt.Fprintf(h, "m%s;", b)
+		}
+		if item.DeliverAfter != nil {
+			fmt.Fprintf(h, "a%d;", item.DeliverAfter.Unix())
+		}
+		if item.DeliverBefore != nil {
+			fmt.Fprintf(h, "b%d;", item.DeliverBefore.Unix())
+		}
+		if item.DestLatitude != nil && item.DestLongitude != nil {
+			fmt.Fprintf(h, "d%g,%g;", *item.DestLatitude, *item.DestLongitude)
+		}
+		if item.Zone != nil {
+			fmt.Fprintf(h, "z%s;", *item.Zone)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 明細のゾーン (省略したらユーザーのゾーン、空文字列ならゾーンなし)
+func orderZone(zone, userZone *string) *string {
+	if zone == nil {
+		zone = userZone
+	}
+	if zone == nil || *zone == "" {
+		return nil
+	}
+	return zone
+}
+
 func (s *ProductService) FetchProducts(ctx context.Context, userID int, req model.ListRequest) ([]model.Product, int, error) {
+	for _, field := range req.Fields {
+		if _, ok := model.ProductListFields[field]; !ok {
+			return nil, 0, ErrInvalidRequest
+		}
+	}
+	if !validRange(req.MinValue, req.MaxValue) || !validRange(req.MinWeight, req.MaxWeight) {
+		return nil, 0, ErrInvalidRequest
+	}
 	products, total, err := s.store.ProductRepo.ListProducts(ctx, userID, req)
 	return products, total, err
 }
+
+// 範囲の両端は 0 以上で、下限が上限を超えない (nil はその側を絞り込まない)
+func validRange(lo, hi *int) bool {
+	if (lo != nil && *lo < 0) || (hi != nil && *hi < 0) {
+		return false
+	}
+	return lo == nil || hi == nil || *lo <= *hi
+}
+
+// アップロードできる商品画像の最大サイズ
+const MaxProductImageBytes = 5 << 20
+
+// アップロードした画像を置くディレクトリ (画像ストアのルートからの相対パス)
+// これ以外の画像 (初期データ) は差し替えても削除しない
+const uploadedProductImageDir = "products"
+
+// 受け付ける画像形式と保存時の拡張子
+var productImageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// 商品画像を保存して products.image を差し替え、新しい画像のパスを返す
+// 形式はファイル名ではなく中身から判定する
+func (s *ProductService) UpdateProductImage(ctx context.Context, productID int, data []byte) (string, error) {
+	if s.store.Images == nil {
+		return "", ErrImageStoreUnavailable
+	}
+	if len(data) == 0 || len(data) > MaxProductImageBytes {
+		return "", ErrInvalidRequest
+	}
+	ext, ok := productImageExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", ErrUnsupportedImageType
+	}
+
+	// 同じパスを上書きすると配信中の古い画像が混ざるので、毎回新しい名前で保存する
+	name := path.Join(uploadedProductImageDir, strconv.Itoa(productID), uuid.NewString()+ext)
+	if err := s.store.Images.Save(ctx, name, bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+
+	var old string
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			old, err = txStore.ProductRepo.UpdateImage(ctx, productID, name)
+			return err
+		})
+	})
+	if err != nil {
+		if delErr := s.store.Images.Delete(context.WithoutCancel(ctx), name); delErr != nil {
+			log.Printf("[ProductImage] 保存した画像の削除に失敗: %v", delErr)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrProductNotFound
+		}
+		return "", err
+	}
+
+	s.generateProductImageVariants(ctx, name, data)
+
+	if strings.HasPrefix(old, uploadedProductImageDir+"/") {
+		if err := s.store.Images.Delete(ctx, old); err != nil {
+			log.Printf("[ProductImage] 差し替え前の画像の削除に失敗: %v", err)
+		}
+		s.deleteProductImageVariants(ctx, old)
+		s.deleteProductImageFormats(ctx, old)
+	}
+	return name, nil
+}
+
+// 価格の変更履歴として返す最大件数
+const maxProductValueHistory = 1000
+
+// 商品の価格と、一覧に出すか (新しい注文を受け付けるか) を変更する (管理者用)
+// 指定されたものだけを変更する
+func (s *ProductService) UpdateProduct(ctx context.Context, adminID, productID int, req model.UpdateProductRequest) error {
+	if (req.Value == nil && req.IsActive == nil) || (req.Value != nil && *req.Value < 0) {
+		return ErrInvalidRequest
+	}
+	var valueChanged, activeChanged bool
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			if req.Value != nil {
+				if valueChanged, err = txStore.ProductRepo.UpdateValue(ctx, productID, *req.Value, adminID); err != nil {
+					return err
+				}
+			}
+			if req.IsActive != nil {
+				if activeChanged, err = txStore.ProductRepo.UpdateActive(ctx, productID, *req.IsActive); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if valueChanged {
+		log.Printf("[Product] 商品 %d の価格を %d に変更 (admin %d)", productID, *req.Value, adminID)
+	}
+	if activeChanged {
+		log.Printf("[Product] 商品 %d の is_active を %t に変更 (admin %d)", productID, *req.IsActive, adminID)
+	}
+	return nil
+}
+
+// 商品の価格の変更履歴
+func (s *ProductService) ProductValueHistory(ctx context.Context, productID int) (*model.ProductValueHistoryResponse, error) {
+	resp := &model.ProductValueHistoryResponse{ProductID: productID}
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		resp.CurrentValue, resp.History, err = s.store.ProductRepo.GetValueHistory(ctx, productID, maxProductValueHistory)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *ProductService) ListCacheStats() repository.ProductListCacheStats {
+	return s.store.ProductRepo.ListCacheStats()
+}
+
+// お気に入りに追加する (追加済みなら何もしない)
+func (s *ProductService) AddFavorite(ctx context.Context, userID, productID int) error {
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.FavoriteRepo.Add(ctx, userID, productID)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProductNotFound
+	}
+	return err
+}
+
+// お気に入りから外す (登録されていなければ何もしない)
+func (s *ProductService) RemoveFavorite(ctx context.Context, userID, productID int) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.FavoriteRepo.Remove(ctx, userID, productID)
+	})
+}
+
+// お気に入りの商品を追加した新しい順に取得
+func (s *ProductService) ListFavorites(ctx context.Context, userID int, req model.ListRequest) ([]model.FavoriteProduct, int, error) {
+	var favorites []model.FavoriteProduct
+	var total int
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		favorites, total, err = s.store.FavoriteRepo.List(ctx, userID, req.PageSize, req.Offset)
+		return err
+	})
+	return favorites, total, err
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 商品画像の縮小版の長辺のピクセル数 (GetImage の size で指定する)
+var ProductImageVariantSizes = []int{64, 256}
+
+// 縮小版を置くディレクトリ (画像ストアのルートからの相対パス)
+// variants/{size}/{元の画像のパス} に置く
+const productImageVariantDir = "variants"
+
+// 変換済みの画像を置くディレクトリ (画像ストアのルートからの相対パス)
+// formats/{形式}/{元の画像 (縮小版) のパスの拡張子を形式に変えたもの} に置く
+// WebP / AVIF は標準ライブラリでエンコードできないので、cwebp や avifenc などで事前に作って置く
+const productImageFormatDir = "formats"
+
+// 変換済みの画像を配信する形式 (優先する順)
+var ProductImageFormats = []string{"avif", "webp"}
+
+type imageFormatKey struct {
+	image  string
+	format string
+}
+
+type imageVariantKey struct {
+	image string
+	size  int
+}
+
+// 画像ストアの画像を開く (GetImage で直接配信する場合)
+func (s *ProductService) OpenProductImage(ctx context.Context, imagePath string) (io.ReadCloser, error) {
+	if s.store.Images == nil {
+		return nil, ErrImageStoreUnavailable
+	}
+	r, err := s.store.Images.Open(ctx, imagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrImageNotFound
+	}
+	return r, err
+}
+
+// 指定したサイズの縮小版のパスを返す
+// 縮小版がまだなければ元の画像から作って保存する
+// 元の画像がそのサイズ以下、または縮小できない形式 (webp など) なら元の画像のパスを返す
+func (s *ProductService) ProductImageVariant(ctx context.Context, imagePath string, size int) (string, error) {
+	if !isProductImageVariantSize(size) {
+		return "", ErrInvalidRequest
+	}
+	if s.store.Images == nil {
+		return imagePath, nil
+	}
+
+	key := imageVariantKey{image: imagePath, size: size}
+	if name, ok := s.imageVariants.Get(key); ok {
+		return name, nil
+	}
+	v, err, _ := s.imageVariantGroup.Do(imagePath+"\x00"+strconv.Itoa(size), func() (interface{}, error) {
+		name := productImageVariantName(imagePath, size)
+		if r, err := s.store.Images.Open(ctx, name); err == nil {
+			r.Close()
+			return name, nil
+		}
+
+		r, err := s.store.Images.Open(ctx, imagePath)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		src, format, err := image.Decode(r)
+		if err != nil {
+			// 標準ライブラリで読めない形式は縮小せずに配信する
+			log.Printf("[ProductImage] 縮小版を作れない画像: %s: %v", imagePath, err)
+			return imagePath, nil
+		}
+		return s.saveProductImageVariant(ctx, imagePath, src, format, size)
+	})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", ErrImageNotFound
+		}
+		return "", err
+	}
+	name := v.(string)
+	s.imageVariants.Add(key, name)
+	return name, nil
+}
+
+// accepted (クライアントが受け付ける形式) のうち、変換済みの画像がある最も優先する形式のパスを返す
+// 変換済みの画像がなければ imagePath を返す
+func (s *ProductService) ProductImageAlternate(ctx context.Context, imagePath string, accepted []string) string {
+	if s.store.Images == nil {
+		return imagePath
+	}
+	for _, format := range ProductImageFormats {
+		if !slices.Contains(accepted, format) {
+			continue
+		}
+		name := productImageFormatName(imagePath, format)
+		key := imageFormatKey{image: imagePath, format: format}
+		exists, ok := s.imageFormats.Get(key)
+		if !ok {
+			r, err := s.store.Images.Open(ctx, name)
+			if err == nil {
+				r.Close()
+			} else if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("[ProductImage] 変換済みの画像を開けない: %s: %v", name, err)
+			}
+			exists = err == nil
+			s.imageFormats.Add(key, exists)
+		}
+		if exists {
+			return name
+		}
+	}
+	return imagePath
+}
+
+// アップロード時にすべてのサイズの縮小版を作っておく (失敗しても初回の表示時に作り直す)
+func (s *ProductService) generateProductImageVariants(ctx context.Context, imagePath string, data []byte) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[ProductImage] 縮小版を作れない画像: %s: %v", imagePath, err)
+		return
+	}
+	for _, size := range ProductImageVariantSizes {
+		name, err := s.saveProductImageVariant(ctx, imagePath, src, format, size)
+		if err != nil {
+			log.Printf("[ProductImage] 縮小版の保存に失敗: %s (%dpx): %v", imagePath, size, err)
+			continue
+		}
+		s.imageVariants.Add(imageVariantKey{image: imagePath, size: size}, name)
+	}
+}
+
+func (s *ProductService) deleteProductImageVariants(ctx context.Context, imagePath string) {
+	for _, size := range ProductImageVariantSizes {
+		if err := s.store.Images.Delete(ctx, productImageVariantName(imagePath, size)); err != nil {
+			log.Printf("[ProductImage] 縮小版の削除に失敗: %s (%dpx): %v", imagePath, size, err)
+		}
+		s.imageVariants.Remove(imageVariantKey{image: imagePath, size: size})
+	}
+}
+
+// 元の画像と縮小版の変換済みの画像を消す
+func (s *ProductService) deleteProductImageFormats(ctx context.Context, imagePath string) {
+	images := []string{imagePath}
+	for _, size := range ProductImageVariantSizes {
+		images = append(images, productImageVariantName(imagePath, size))
+	}
+	for _, image := range images {
+		for _, format := range ProductImageFormats {
+			if err := s.store.Images.Delete(ctx, productImageFormatName(image, format)); err != nil {
+				log.Printf("[ProductImage] 変換済みの画像の削除に失敗: %s (%s): %v", image, format, err)
+			}
+			s.imageFormats.Remove(imageFormatKey{image: image, format: format})
+		}
+	}
+}
+
+// 縮小版を保存してパスを返す (元の画像がそのサイズ以下なら元の画像のパスを返す)
+func (s *ProductService) saveProductImageVariant(ctx context.Context, imagePath string, src image.Image, format string, size int) (string, error) {
+	b := src.Bounds()
+	if max(b.Dx(), b.Dy()) <= size {
+		return imagePath, nil
+	}
+	var buf bytes.Buffer
+	dst := resizeImage(src, size)
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return "", err
+	}
+	name := productImageVariantName(imagePath, size)
+	if err := s.store.Images.Save(ctx, name, &buf); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func isProductImageVariantSize(size int) bool {
+	for _, s := range ProductImageVariantSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// jpeg 以外 (png, gif) の縮小版は png で保存するので拡張子も .png にする
+func productImageVariantName(imagePath string, size int) string {
+	ext := strings.ToLower(path.Ext(imagePath))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
+		imagePath = strings.TrimSuffix(imagePath, path.Ext(imagePath)) + ".png"
+	}
+	return path.Join(productImageVariantDir, strconv.Itoa(size), imagePath)
+}
+
+func productImageFormatName(imagePath, format string) string {
+	return path.Join(productImageFormatDir, format, strings.TrimSuffix(imagePath, path.Ext(imagePath))+"."+format)
+}
+
+// 長辺が size になるよう縦横比を保って縮小する (面積平均)
+func resizeImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := size, size
+	if w >= h {
+		dh = max(1, h*size/w)
+	} else {
+		dw = max(1, w*size/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := b.Min.Y+y*h/dh, b.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := b.Min.X+x*w/dw, b.Min.X+max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/service/utils"
+)
+
+// 商品ごとに覚えておく「よく一緒に注文される商品」の件数 (API で返せる最大件数)
+const MaxProductRecommendations = 20
+
+// 商品 ID -> 一緒に注文された注文数の多い順の商品
+type productRecommendations map[int][]model.ProductCoOccurrence
+
+// 注文の共起を集計し直す (RecommendationRefresher から定期的に呼ばれる)
+// 集計中も直前の結果を返し続け、集計が終わったら差し替える
+func (s *ProductService) RefreshRecommendations(ctx context.Context, lookback time.Duration) error {
+	rows, err := s.store.ProductRepo.ListCoOccurrences(ctx, time.Now().Add(-lookback))
+	if err != nil {
+		return err
+	}
+	recs := buildProductRecommendations(rows, MaxProductRecommendations)
+	s.recommendations.Store(&recs)
+	return nil
+}
+
+func buildProductRecommendations(rows []model.ProductCoOccurrence, limit int) productRecommendations {
+	recs := make(productRecommendations)
+	for _, row := range rows {
+		recs[row.ProductID] = append(recs[row.ProductID], row)
+	}
+	for id, related := range recs {
+		slices.SortFunc(related, func(a, b model.ProductCoOccurrence) int {
+			if a.Orders != b.Orders {
+				return b.Orders - a.Orders
+			}
+			return a.RelatedProductID - b.RelatedProductID
+		})
+		recs[id] = slices.Clip(related[:min(len(related), limit)])
+	}
+	return recs
+}
+
+// よく一緒に注文される商品を多い順に limit 件返す
+// まだ集計していなければ空を返す
+func (s *ProductService) Recommendations(ctx context.Context, productID, limit int) ([]model.ProductRecommendation, error) {
+	if limit <= 0 || limit > MaxProductRecommendations {
+		return nil, ErrInvalidRequest
+	}
+	result := []model.ProductRecommendation{}
+	recs := s.recommendations.Load()
+	if recs == nil {
+		return result, nil
+	}
+	related := (*recs)[productID]
+	related = related[:min(len(related), limit)]
+	if len(related) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int, len(related))
+	for i, r := range related {
+		ids[i] = r.RelatedProductID
+	}
+	var products []model.Product
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		products, err = s.store.ProductRepo.GetByIDs(ctx, ids)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]model.Product, len(products))
+	for _, p := range products {
+		byID[p.ProductID] = p
+	}
+	// 集計後に削除された商品は飛ばす
+	for _, r := range related {
+		if p, ok := byID[r.RelatedProductID]; ok {
+			result = append(result, model.ProductRecommendation{Product: p, CoOrderCount: r.Orders})
+		}
+	}
+	return result, nil
+}
+
+// よく一緒に注文される商品を定期的に集計し直すバックグラウンドジョブ
+type RecommendationRefresher struct {
+	svc      *ProductService
+	interval time.Duration
+	lookback time.Duration
+}
+
+// lookback より前の注文は集計に含めない
+func NewRecommendationRefresher(svc *ProductService, interval, lookback time.Duration) *RecommendationRefresher {
+	return &RecommendationRefresher{svc: svc, interval: interval, lookback: lookback}
+}
+
+// 起動直後に 1 回集計し、ctx がキャンセルされるまで interval ごとに集計し直す
+func (r *RecommendationRefresher) Run(ctx context.Context) {
+	r.refresh(ctx)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.refresh(ctx)
+		}
+	}
+}
+
+func (r *RecommendationRefresher) refresh(ctx context.Context) {
+	refreshCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	start := time.Now()
+	if err := r.svc.RefreshRecommendations(refreshCtx, r.lookback); err != nil {
+		log.Printf("[Recommendation] 集計失敗: %v", err)
+		return
+	}
+	log.Printf("[Recommendation] 集計完了 (%v)", time.Since(start))
+}
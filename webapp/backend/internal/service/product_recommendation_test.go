@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 注文の共起と商品を返す DBTX
+func newRecommendationDB(coOccurrences []model.ProductCoOccurrence, products []model.Product) *fakeDB {
+	return &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		switch rows := dest.(type) {
+		case *[]model.ProductCoOccurrence:
+			*rows = coOccurrences
+		case *[]model.Product:
+			*rows = products
+		}
+		return nil
+	}}
+}
+
+func TestBuildProductRecommendations(t *testing.T) {
+	rows := []model.ProductCoOccurrence{
+		{ProductID: 1, RelatedProductID: 2, Orders: 3},
+		{ProductID: 1, RelatedProductID: 3, Orders: 5},
+		{ProductID: 1, RelatedProductID: 4, Orders: 3},
+		{ProductID: 2, RelatedProductID: 1, Orders: 3},
+	}
+	recs := buildProductRecommendations(rows, 2)
+	want := []model.ProductCoOccurrence{
+		{ProductID: 1, RelatedProductID: 3, Orders: 5},
+		{ProductID: 1, RelatedProductID: 2, Orders: 3}, // 同数なら商品 ID 順
+	}
+	if !reflect.DeepEqual(recs[1], want) {
+		t.Fatalf("recs[1] = %+v, want %+v", recs[1], want)
+	}
+	if len(recs[2]) != 1 || len(recs[3]) != 0 {
+		t.Fatalf("recs = %+v", recs)
+	}
+}
+
+func TestRecommendations(t *testing.T) {
+	db := newRecommendationDB(
+		[]model.ProductCoOccurrence{
+			{ProductID: 1, RelatedProductID: 2, Orders: 3},
+			{ProductID: 1, RelatedProductID: 3, Orders: 5},
+			{ProductID: 1, RelatedProductID: 4, Orders: 1},
+		},
+		// 商品 4 は集計後に削除された
+		[]model.Product{{ProductID: 2, Name: "みかん"}, {ProductID: 3, Name: "りんご"}},
+	)
+	s := NewProductService(repository.NewStore(db))
+	ctx := context.Background()
+
+	if recs, err := s.Recommendations(ctx, 1, 10); err != nil || len(recs) != 0 {
+		t.Fatalf("before refresh = %+v, %v; want empty", recs, err)
+	}
+	if err := s.RefreshRecommendations(ctx, 30*24*time.Hour); err != nil {
+		t.Fatalf("RefreshRecommendations: %v", err)
+	}
+
+	recs, err := s.Recommendations(ctx, 1, 10)
+	if err != nil {
+		t.Fatalf("Recommendations: %v", err)
+	}
+	if len(recs) != 2 || recs[0].Name != "りんご" || recs[0].CoOrderCount != 5 || recs[1].Name != "みかん" || recs[1].CoOrderCount != 3 {
+		t.Fatalf("recs = %+v", recs)
+	}
+	if recs, err := s.Recommendations(ctx, 99, 10); err != nil || len(recs) != 0 {
+		t.Fatalf("unknown product = %+v, %v; want empty", recs, err)
+	}
+	for _, limit := range []int{0, MaxProductRecommendations + 1} {
+		if _, err := s.Recommendations(ctx, 1, limit); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("limit %d: err = %v, want ErrInvalidRequest", limit, err)
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestHashOrderItems(t *testing.T) {
+	windowStart := time.Date(2025, 9, 1, 10, 0, 0, 0, time.UTC)
+	base := []model.RequestItem{{ProductID: 1, Quantity: 2}, {ProductID: 3, Quantity: 1}}
+	if hashOrderItems(base) != hashOrderItems([]model.RequestItem{{ProductID: 1, Quantity: 2}, {ProductID: 3, Quantity: 1}}) {
+		t.Fatal("same items must hash the same")
+	}
+	for name, items := range map[string][]model.RequestItem{
+		"quantity": {{ProductID: 1, Quantity: 3}, {ProductID: 3, Quantity: 1}},
+		"order":    {{ProductID: 3, Quantity: 1}, {ProductID: 1, Quantity: 2}},
+		"priority": {{ProductID: 1, Quantity: 2, Priority: 1}, {ProductID: 3, Quantity: 1}},
+		"metadata": {{ProductID: 1, Quantity: 2, Metadata: model.OrderMetadata{"note": "置き配"}}, {ProductID: 3, Quantity: 1}},
+		"window":   {{ProductID: 1, Quantity: 2, DeliverAfter: &windowStart}, {ProductID: 3, Quantity: 1}},
+	} {
+		if hashOrderItems(items) == hashOrderItems(base) {
+			t.Errorf("%s change must change the hash", name)
+		}
+	}
+}
+
+func TestHashOrderItemsMetadataKeyOrder(t *testing.T) {
+	a := []model.RequestItem{{ProductID: 1, Quantity: 1, Metadata: model.OrderMetadata{"a": 1, "b": "x"}}}
+	b := []model.RequestItem{{ProductID: 1, Quantity: 1, Metadata: model.OrderMetadata{"b": "x", "a": 1}}}
+	if hashOrderItems(a) != hashOrderItems(b) {
+		t.Fatal("metadata with the same content must hash the same")
+	}
+}
+
+func TestCreateOrdersRejectsInvalidDeliveryWindow(t *testing.T) {
+	s := NewProductService(repository.NewStore(newIdempotencyReplayDB(nil, "")))
+	after := time.Now().Add(2 * time.Hour)
+	before := time.Now().Add(time.Hour)
+	items := []model.RequestItem{{ProductID: 1, Quantity: 1, DeliverAfter: &after, DeliverBefore: &before}}
+	if _, err := s.CreateOrders(context.Background(), 1, items, ""); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest", err)
+	}
+}
+
+func TestCreateOrdersRejectsOversizedMetadata(t *testing.T) {
+	s := NewProductService(repository.NewStore(newIdempotencyReplayDB(nil, "")))
+	items := []model.RequestItem{{ProductID: 1, Quantity: 1, Metadata: model.OrderMetadata{"note": strings.Repeat("x", MaxOrderMetadataBytes)}}}
+	if _, err := s.CreateOrders(context.Background(), 1, items, ""); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest", err)
+	}
+}
+
+// Idempotency-Key が既に使われている状態を再現する DBTX
+// INSERT は重複エラーにし、SELECT は record を返す
+func newIdempotencyReplayDB(record *model.IdempotencyRecord, orderID string) *fakeDB {
+	return &fakeDB{
+		get: func(_ context.Context, dest any, _ string, _ ...any) error {
+			v := reflect.ValueOf(dest).Elem()
+			v.FieldByName("RequestHash").SetString(record.RequestHash)
+			if record.Completed {
+				v.FieldByName("OrderIDs").SetBytes([]byte(`["` + orderID + `"]`))
+			}
+			return nil
+		},
+		exec: func(context.Context, string, ...any) (sql.Result, error) {
+			return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+		},
+	}
+}
+
+func TestCreateOrdersIdempotentReplay(t *testing.T) {
+	items := []model.RequestItem{{ProductID: 1, Quantity: 2}}
+
+	tests := []struct {
+		name    string
+		record  model.IdempotencyRecord
+		wantErr error
+	}{
+		{"completed", model.IdempotencyRecord{RequestHash: hashOrderItems(items), Completed: true}, nil},
+		{"in progress", model.IdempotencyRecord{RequestHash: hashOrderItems(items)}, ErrIdempotencyInProgress},
+		{"different request", model.IdempotencyRecord{RequestHash: "other", Completed: true}, ErrIdempotencyKeyReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newIdempotencyReplayDB(&tt.record, "42")
+			s := NewProductService(repository.NewStore(db))
+
+			ids, err := s.CreateOrders(context.Background(), 1, items, "key-1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (len(ids) != 1 || ids[0] != "42") {
+				t.Fatalf("ids = %v, want the first response [42]", ids)
+			}
+		})
+	}
+}
+
+// products の在庫数を返す DBTX (stocks にない商品は在庫を管理しない)
+// inactive は新しい注文を受け付けない商品
+func newStockDB(stocks map[int]int, inactive ...int) *fakeDB {
+	db := newReturnOrderDB(nil, 0)
+	db.sel = func(_ context.Context, dest any, _ string, args ...any) error {
+		if ids, ok := dest.(*[]int); ok {
+			for _, arg := range args {
+				if slices.Contains(inactive, arg.(int)) {
+					*ids = append(*ids, arg.(int))
+				}
+			}
+			return nil
+		}
+		rows := reflect.ValueOf(dest).Elem()
+		for _, arg := range args {
+			id := arg.(int)
+			stock, ok := stocks[id]
+			if !ok {
+				continue
+			}
+			row := reflect.New(rows.Type().Elem()).Elem()
+			row.FieldByName("ProductID").SetInt(int64(id))
+			row.FieldByName("Stock").Set(reflect.ValueOf(sql.NullInt64{Int64: int64(stock), Valid: true}))
+			rows.Set(reflect.Append(rows, row))
+		}
+		return nil
+	}
+	return db
+}
+
+func TestCreateOrdersRejectsOutOfStock(t *testing.T) {
+	db := newStockDB(map[int]int{1: 3, 2: 0})
+	s := NewProductService(repository.NewStore(db))
+	items := []model.RequestItem{
+		{ProductID: 1, Quantity: 2},
+		{ProductID: 2, Quantity: 1},
+		{ProductID: 1, Quantity: 2},
+		{ProductID: 3, Quantity: 100},
+	}
+
+	_, err := s.CreateOrders(context.Background(), 1, items, "")
+	var outOfStock *OutOfStockError
+	if !errors.Is(err, ErrOutOfStock) || !errors.As(err, &outOfStock) {
+		t.Fatalf("err = %v, want OutOfStockError", err)
+	}
+	want := []model.StockRejection{
+		{ProductID: 1, Requested: 4, Available: 3},
+		{ProductID: 2, Requested: 1, Available: 0},
+	}
+	if !reflect.DeepEqual(outOfStock.Items, want) {
+		t.Fatalf("rejections = %+v, want %+v", outOfStock.Items, want)
+	}
+	if execs := db.queries(); len(execs) != 0 {
+		t.Fatalf("execs = %v, want no stock change", execs)
+	}
+}
+
+func TestCreateOrdersRejectsInactiveProducts(t *testing.T) {
+	db := newStockDB(map[int]int{2: 0}, 2, 3)
+	s := NewProductService(repository.NewStore(db))
+	items := []model.RequestItem{
+		{ProductID: 1, Quantity: 1},
+		{ProductID: 3, Quantity: 1},
+		{ProductID: 2, Quantity: 1},
+		{ProductID: 3, Quantity: 2},
+	}
+
+	_, err := s.CreateOrders(context.Background(), 1, items, "")
+	var inactive *InactiveProductError
+	if !errors.Is(err, ErrProductInactive) || !errors.As(err, &inactive) {
+		t.Fatalf("err = %v, want InactiveProductError", err)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(inactive.ProductIDs, want) {
+		t.Fatalf("inactive = %v, want %v", inactive.ProductIDs, want)
+	}
+	if execs := db.queries(); len(execs) != 0 {
+		t.Fatalf("execs = %v, want no order created", execs)
+	}
+}
+
+func TestReserveStockDecrementsManagedProducts(t *testing.T) {
+	db := newStockDB(map[int]int{1: 5})
+	store := repository.NewStore(db)
+	orders := []*model.Order{{ProductID: 3, Quantity: 100}, {ProductID: 1, Quantity: 2}, {ProductID: 1, Quantity: 3}}
+
+	if _, err := reserveStock(context.Background(), store, orders); err != nil {
+		t.Fatalf("reserveStock: %v", err)
+	}
+	if len(db.calls) != 1 || !reflect.DeepEqual(db.calls[0].args, []any{-5, 1}) {
+		t.Fatalf("stock updates = %v, want one decrement of product 1 by 5", db.calls)
+	}
+}
+
+func TestReserveStockAlertsWhenCrossingThreshold(t *testing.T) {
+	defer func(threshold int, enabled bool) {
+		LowStockThreshold, repository.WebhooksEnabled = threshold, enabled
+	}(LowStockThreshold, repository.WebhooksEnabled)
+	LowStockThreshold, repository.WebhooksEnabled = 5, true
+
+	// 1 はしきい値を下回る、2 は下回らない、3 はすでに下回っている
+	db := newStockDB(map[int]int{1: 6, 2: 10, 3: 4})
+	store := repository.NewStore(db)
+	orders := []*model.Order{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 3}, {ProductID: 3, Quantity: 1}}
+
+	alerts, err := reserveStock(context.Background(), store, orders)
+	if err != nil {
+		t.Fatalf("reserveStock: %v", err)
+	}
+	if want := []model.LowStockAlert{{ProductID: 1, Stock: 4, Threshold: 5}}; !reflect.DeepEqual(alerts, want) {
+		t.Fatalf("alerts = %+v, want %+v", alerts, want)
+	}
+	last := db.calls[len(db.calls)-1]
+	if !strings.Contains(last.query, "product.low_stock") || !reflect.DeepEqual(last.args[:3], []any{1, 4, 5}) {
+		t.Fatalf("last exec = %s %v, want the low stock webhook for product 1", last.query, last.args)
+	}
+}
+
+// 保存・削除した画像を記録する ImageStore
+type fakeImageStore struct {
+	files   map[string][]byte
+	saved   []string
+	deleted []string
+}
+
+func (s *fakeImageStore) Open(_ context.Context, name string) (io.ReadCloser, error) {
+	data, ok := s.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *fakeImageStore) Save(_ context.Context, name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.files == nil {
+		s.files = make(map[string][]byte)
+	}
+	s.files[name] = data
+	s.saved = append(s.saved, name)
+	return nil
+}
+
+func (s *fakeImageStore) Delete(_ context.Context, name string) error {
+	delete(s.files, name)
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+// 差し替え前の画像パスを返す DBTX (image が nil なら商品が存在しない)
+func newProductImageDB(image *string) *fakeDB {
+	db := newReturnOrderDB(nil, 0)
+	db.get = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if image == nil {
+			return sql.ErrNoRows
+		}
+		*dest.(*string) = *image
+		return nil
+	}
+	return db
+}
+
+// 幅 w、高さ h の単色の PNG
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUpdateProductImage(t *testing.T) {
+	testPNG := encodeTestPNG(t, 300, 200)
+	seed, uploaded := "chello_01.png", "products/7/old.png"
+	tests := []struct {
+		name        string
+		image       *string
+		data        []byte
+		wantErr     error
+		wantDeleted int
+	}{
+		{"replaces seed image", &seed, testPNG, nil, 0},
+		{"replaces uploaded image", &uploaded, testPNG, nil, 9}, // 元の画像と縮小版 2 つ、それぞれの変換済みの画像 (avif, webp)
+		{"product not found", nil, testPNG, ErrProductNotFound, 1},
+		{"unsupported type", &seed, []byte("plain text"), ErrUnsupportedImageType, 0},
+		{"empty", &seed, nil, ErrInvalidRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images := &fakeImageStore{}
+			s := NewProductService(repository.NewStore(newProductImageDB(tt.image), repository.WithImageStore(images)))
+			name, err := s.UpdateProductImage(context.Background(), 7, tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(images.deleted) != tt.wantDeleted {
+				t.Fatalf("deleted = %v, want %d files", images.deleted, tt.wantDeleted)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			wantSaved := []string{name, "variants/64/" + name, "variants/256/" + name}
+			if !strings.HasPrefix(name, "products/7/") || !strings.HasSuffix(name, ".png") || !reflect.DeepEqual(images.saved, wantSaved) {
+				t.Fatalf("name = %q, saved = %v", name, images.saved)
+			}
+			wantDeleted := []string{uploaded, "variants/64/" + uploaded, "variants/256/" + uploaded}
+			if tt.wantDeleted > 0 && !reflect.DeepEqual(images.deleted[:3], wantDeleted) {
+				t.Fatalf("deleted = %v, want %v", images.deleted, wantDeleted)
+			}
+		})
+	}
+}
+
+func TestUpdateProductImageWithoutStore(t *testing.T) {
+	s := NewProductService(repository.NewStore(newProductImageDB(nil)))
+	if _, err := s.UpdateProductImage(context.Background(), 7, encodeTestPNG(t, 1, 1)); !errors.Is(err, ErrImageStoreUnavailable) {
+		t.Fatalf("err = %v, want ErrImageStoreUnavailable", err)
+	}
+}
+
+func TestProductImageVariant(t *testing.T) {
+	images := &fakeImageStore{files: map[string][]byte{
+		"large.png":  encodeTestPNG(t, 300, 150),
+		"small.png":  encodeTestPNG(t, 40, 40),
+		"photo.gif":  encodeTestPNG(t, 300, 300), // 拡張子と中身が違っても中身で判定する
+		"broken.png": []byte("not an image"),
+	}}
+	s := NewProductService(repository.NewStore(newProductImageDB(nil), repository.WithImageStore(images)))
+	ctx := context.Background()
+
+	tests := []struct {
+		image   string
+		size    int
+		want    string
+		wantErr error
+	}{
+		{"large.png", 64, "variants/64/large.png", nil},
+		{"large.png", 256, "variants/256/large.png", nil},
+		{"small.png", 64, "small.png", nil},
+		{"photo.gif", 64, "variants/64/photo.png", nil},
+		{"broken.png", 64, "broken.png", nil},
+		{"missing.png", 64, "", ErrImageNotFound},
+		{"large.png", 100, "", ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		got, err := s.ProductImageVariant(ctx, tt.image, tt.size)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("ProductImageVariant(%q, %d) = %q, %v; want %q, %v", tt.image, tt.size, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(images.files["variants/64/large.png"]))
+	if err != nil {
+		t.Fatalf("decode variant: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Fatalf("variant size = %dx%d, want 64x32", b.Dx(), b.Dy())
+	}
+
+	// 2 回目は保存済みの縮小版を使う
+	saved := len(images.saved)
+	if got, _ := s.ProductImageVariant(ctx, "large.png", 64); got != "variants/64/large.png" || len(images.saved) != saved {
+		t.Fatalf("second call = %q, saved %d more", got, len(images.saved)-saved)
+	}
+}
+
+func TestProductImageAlternate(t *testing.T) {
+	images := &fakeImageStore{files: map[string][]byte{
+		"apple.png":                          {},
+		"formats/webp/apple.webp":            {},
+		"formats/avif/variants/64/pear.avif": {},
+	}}
+	s := NewProductService(repository.NewStore(newProductImageDB(nil), repository.WithImageStore(images)))
+	ctx := context.Background()
+
+	tests := []struct {
+		image    string
+		accepted []string
+		want     string
+	}{
+		{"apple.png", []string{"avif", "webp"}, "formats/webp/apple.webp"},
+		{"apple.png", []string{"avif"}, "apple.png"},
+		{"apple.png", nil, "apple.png"},
+		{"variants/64/pear.png", []string{"webp", "avif"}, "formats/avif/variants/64/pear.avif"},
+	}
+	for _, tt := range tests {
+		if got := s.ProductImageAlternate(ctx, tt.image, tt.accepted); got != tt.want {
+			t.Errorf("ProductImageAlternate(%q, %v) = %q, want %q", tt.image, tt.accepted, got, tt.want)
+		}
+	}
+
+	// 差し替えで消した変換済みの画像は返さない
+	s.deleteProductImageFormats(ctx, "apple.png")
+	if got := s.ProductImageAlternate(ctx, "apple.png", []string{"webp"}); got != "apple.png" {
+		t.Fatalf("after delete = %q, want the original", got)
+	}
+	if !slices.Contains(images.deleted, "formats/avif/variants/256/apple.avif") {
+		t.Fatalf("deleted = %v, want variant formats removed too", images.deleted)
+	}
+}
+
+// 商品の現在の価格を返す DBTX (value が nil なら商品が存在しない)
+// 価格は value、is_active は常に TRUE を返す (行は Value / IsActive フィールドに詰める)
+func newProductValueDB(value *int) *fakeDB {
+	db := newReturnOrderDB(nil, 0)
+	db.get = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if value == nil {
+			return sql.ErrNoRows
+		}
+		row := reflect.ValueOf(dest).Elem()
+		row.FieldByName("Value").SetInt(int64(*value))
+		row.FieldByName("IsActive").SetBool(true)
+		return nil
+	}
+	return db
+}
+
+func TestUpdateProduct(t *testing.T) {
+	current, same, changed, negative := 100, 100, 120, -1
+	active, inactive := true, false
+	tests := []struct {
+		name      string
+		current   *int
+		value     *int
+		isActive  *bool
+		wantErr   error
+		wantExecs int
+	}{
+		{"changed", &current, &changed, nil, nil, 2}, // 価格の更新と履歴の追加
+		{"same value", &current, &same, nil, nil, 0},
+		{"not found", nil, &changed, nil, ErrProductNotFound, 0},
+		{"negative", &current, &negative, nil, ErrInvalidRequest, 0},
+		{"nothing to update", &current, nil, nil, ErrInvalidRequest, 0},
+		{"deactivate", &current, nil, &inactive, nil, 1},
+		{"already active", &current, nil, &active, nil, 0},
+		{"value and deactivate", &current, &changed, &inactive, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newProductValueDB(tt.current)
+			s := NewProductService(repository.NewStore(db))
+			err := s.UpdateProduct(context.Background(), 1, 7, model.UpdateProductRequest{Value: tt.value, IsActive: tt.isActive})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			execs := db.queries()
+			if len(execs) != tt.wantExecs {
+				t.Fatalf("execs = %v, want %d", execs, tt.wantExecs)
+			}
+			if tt.value != nil && tt.wantExecs >= 2 && !strings.Contains(execs[1], "INSERT INTO product_value_history") {
+				t.Fatalf("execs = %v, want the history insert", execs)
+			}
+			if tt.isActive != nil && tt.wantExecs > 0 && !strings.Contains(execs[len(execs)-1], "SET is_active") {
+				t.Fatalf("execs = %v, want the is_active update", execs)
+			}
+		})
+	}
+}
+
+func TestProductValueHistoryNotFound(t *testing.T) {
+	s := NewProductService(repository.NewStore(newProductValueDB(nil)))
+	if _, err := s.ProductValueHistory(context.Background(), 7); !errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("err = %v, want ErrProductNotFound", err)
+	}
+}
+
+func TestFetchProductsRejectsInvalidRange(t *testing.T) {
+	s := NewProductService(repository.NewStore(newProductValueDB(nil)))
+	low, high, negative := 500, 100, -1
+	for _, req := range []model.ListRequest{
+		{MinValue: &low, MaxValue: &high},
+		{MinWeight: &low, MaxWeight: &high},
+		{MaxValue: &negative},
+	} {
+		req.PageSize = 20
+		if _, _, err := s.FetchProducts(context.Background(), 1, req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("FetchProducts(%+v) err = %v, want ErrInvalidRequest", req, err)
+		}
+	}
+}
+
+func TestFetchProductsRejectsUnknownFields(t *testing.T) {
+	s := NewProductService(repository.NewStore(newProductValueDB(nil)))
+	req := model.ListRequest{Fields: []string{"name", "password"}, PageSize: 20}
+	if _, _, err := s.FetchProducts(context.Background(), 1, req); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest", err)
+	}
+}
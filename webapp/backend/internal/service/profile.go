@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"unicode/utf8"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxUserSettingsKeys  = 64
+)
+
+func (s *AuthService) GetProfile(ctx context.Context, userID int) (*model.User, error) {
+	var user *model.User
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = s.store.UserRepo.FindByID(ctx, userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// 表示名と設定値、ゾーンを更新し、更新後のユーザー情報を返す
+// 設定値はリクエストに含まれるキーだけをマージする
+func (s *AuthService) UpdateProfile(ctx context.Context, userID int, req model.UpdateProfileRequest) (*model.User, error) {
+	if req.DisplayName != nil && utf8.RuneCountInString(*req.DisplayName) > maxDisplayNameLength {
+		return nil, ErrInvalidRequest
+	}
+	if req.Zone != nil && len(*req.Zone) > MaxZoneLength {
+		return nil, ErrInvalidRequest
+	}
+
+	var user *model.User
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			var err error
+			user, err = txStore.UserRepo.FindByID(ctx, userID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return ErrUserNotFound
+				}
+				return err
+			}
+
+			if req.DisplayName != nil {
+				user.DisplayName = *req.DisplayName
+			}
+			if req.Zone != nil {
+				user.Zone = orderZone(req.Zone, nil)
+			}
+			if user.Settings == nil {
+				user.Settings = model.UserSettings{}
+			}
+			for key, value := range req.Settings {
+				if value == nil {
+					delete(user.Settings, key)
+				} else {
+					user.Settings[key] = value
+				}
+			}
+			if len(user.Settings) > maxUserSettingsKeys {
+				return ErrInvalidRequest
+			}
+
+			return txStore.UserRepo.UpdateProfile(ctx, userID, user.DisplayName, user.Settings, user.Zone)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// users を 1 行だけ持ち、UpdateProfile の引数を記録する
+type profileDB struct {
+	fakeDB
+	user    model.User
+	updates [][]any
+}
+
+func newProfileDB() *profileDB {
+	db := &profileDB{user: model.User{
+		UserID:      1,
+		UserName:    "alice",
+		DisplayName: "Alice",
+		Settings:    model.UserSettings{"theme": "dark", "lang": "ja"},
+	}}
+	db.get = func(_ context.Context, dest any, _ string, args ...any) error {
+		if args[0] != db.user.UserID {
+			return sql.ErrNoRows
+		}
+		user := db.user
+		user.Settings = model.UserSettings{}
+		for k, v := range db.user.Settings {
+			user.Settings[k] = v
+		}
+		*dest.(*model.User) = user
+		return nil
+	}
+	db.exec = func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "UPDATE users SET display_name") {
+			db.updates = append(db.updates, args)
+		}
+		return fakeResult{rowsAffected: 1}, nil
+	}
+	return db
+}
+
+func TestUpdateProfileMergesSettings(t *testing.T) {
+	db := newProfileDB()
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	user, err := s.UpdateProfile(context.Background(), 1, model.UpdateProfileRequest{
+		Settings: map[string]any{"lang": nil, "notify": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DisplayName != "Alice" {
+		t.Errorf("display name = %q, want it unchanged when omitted", user.DisplayName)
+	}
+	if _, ok := user.Settings["lang"]; ok || user.Settings["theme"] != "dark" || user.Settings["notify"] != true {
+		t.Errorf("settings = %v, want lang removed, theme kept and notify added", user.Settings)
+	}
+	if len(db.updates) != 1 || db.updates[0][0] != "Alice" {
+		t.Fatalf("updates = %v, want one UPDATE with the merged profile", db.updates)
+	}
+}
+
+func TestUpdateProfileValidation(t *testing.T) {
+	db := newProfileDB()
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+	ctx := context.Background()
+
+	long := strings.Repeat("あ", maxDisplayNameLength+1)
+	if _, err := s.UpdateProfile(ctx, 1, model.UpdateProfileRequest{DisplayName: &long}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest for a long display name", err)
+	}
+
+	settings := map[string]any{}
+	for i := range maxUserSettingsKeys {
+		settings[strings.Repeat("k", i+1)] = i
+	}
+	if _, err := s.UpdateProfile(ctx, 1, model.UpdateProfileRequest{Settings: settings}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest for too many settings", err)
+	}
+	if len(db.updates) != 0 {
+		t.Fatalf("updates = %v, want nothing written for invalid requests", db.updates)
+	}
+
+	if _, err := s.GetProfile(ctx, 2); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestUserSettingsScan(t *testing.T) {
+	var settings model.UserSettings
+	if err := settings.Scan(nil); err != nil || settings == nil || len(settings) != 0 {
+		t.Fatalf("Scan(nil) = %v, %v; want empty settings", settings, err)
+	}
+	if err := settings.Scan([]byte(`{"theme":"dark"}`)); err != nil || settings["theme"] != "dark" {
+		t.Fatalf("Scan = %v, %v", settings, err)
+	}
+	v, err := settings.Value()
+	if err != nil || v != `{"theme":"dark"}` {
+		t.Fatalf("Value = %v, %v", v, err)
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// refresh_tokens を 1 行だけ持つ
+type refreshTokenDB struct {
+	fakeDB
+	revoked  bool
+	inserted []string
+}
+
+func newRefreshTokenDB() *refreshTokenDB {
+	db := &refreshTokenDB{}
+	db.get = func(_ context.Context, dest any, _ string, args ...any) error {
+		if args[0] != repository.HashToken("valid") || db.revoked {
+			return sql.ErrNoRows
+		}
+		*dest.(*model.RefreshToken) = model.RefreshToken{ID: 1, UserID: 7}
+		return nil
+	}
+	db.exec = func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		switch {
+		case strings.HasPrefix(query, "UPDATE refresh_tokens SET revoked_at"):
+			if db.revoked {
+				return fakeResult{}, nil
+			}
+			db.revoked = true
+			return fakeResult{rowsAffected: 1}, nil
+		case strings.HasPrefix(query, "INSERT INTO refresh_tokens"):
+			db.inserted = append(db.inserted, args[0].(string))
+		}
+		return fakeResult{rowsAffected: 1}, nil
+	}
+	return db
+}
+
+func TestRefreshWithTokenRotatesToken(t *testing.T) {
+	db := newRefreshTokenDB()
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	result, err := s.RefreshWithToken(context.Background(), "valid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SessionID == "" || result.RefreshToken == "" || result.RefreshToken == "valid" {
+		t.Fatalf("result = %+v, want a new session and a new refresh token", result)
+	}
+	if !db.revoked || len(db.inserted) != 1 || db.inserted[0] != repository.HashToken(result.RefreshToken) {
+		t.Fatalf("revoked = %v, inserted = %v; want the old token revoked and the new one stored hashed", db.revoked, db.inserted)
+	}
+
+	// 使用済みのトークンは二度と使えない
+	if _, err := s.RefreshWithToken(context.Background(), "valid"); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("reuse: err = %v, want ErrInvalidRefresh", err)
+	}
+}
+
+func TestRefreshWithUnknownToken(t *testing.T) {
+	s := NewAuthService(repository.NewStore(newRefreshTokenDB()), AuthConfig{})
+	if _, err := s.RefreshWithToken(context.Background(), "forged"); !errors.Is(err, ErrInvalidRefresh) {
+		t.Fatalf("err = %v, want ErrInvalidRefresh", err)
+	}
+}
+
+func TestLoginIssuesRefreshTokenOnlyWithRememberMe(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	var inserted int
+	exec := db.exec
+	db.exec = func(ctx context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "INSERT INTO refresh_tokens") {
+			inserted++
+		}
+		return exec(ctx, query, args...)
+	}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	result, err := s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw"})
+	if err != nil || result.RefreshToken != "" || inserted != 0 {
+		t.Fatalf("result = %+v, err = %v; want no refresh token without remember-me", result, err)
+	}
+	result, err = s.Login(context.Background(), model.LoginRequest{UserName: "alice", Password: "pw", RememberMe: true})
+	if err != nil || result.RefreshToken == "" || inserted != 1 {
+		t.Fatalf("result = %+v, err = %v; want a refresh token with remember-me", result, err)
+	}
+}
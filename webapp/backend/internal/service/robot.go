@@ -4,125 +4,1090 @@ import (
 	"backend/internal/model"
 	"backend/internal/repository"
 	"backend/internal/service/utils"
+	"cmp"
 	"context"
+	"database/sql"
+	"errors"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/samber/lo"
+	"go.opentelemetry.io/otel"
 	"log"
+	"slices"
+	"time"
 )
 
+// 配送計画で優先度 1 あたり価値に上乗せする重み (0 なら同価値のときの優先にのみ使う)
+var DeliveryPriorityWeight = 0
+
+// 配送計画の目的
+const (
+	// 価値 (と DeliveryPriorityWeight、DeliveryDeadlineWeight の上乗せ) の合計を最大にする
+	DeliveryObjectiveValue = "value"
+	// 配達期限 (deliver_before) に間に合わなくなりそうな注文をできるだけ多く含め、その中で価値を最大にする
+	DeliveryObjectiveDeadline = "deadline"
+)
+
+var DeliveryPlanObjective = DeliveryObjectiveValue
+
+func ValidDeliveryPlanObjective(objective string) bool {
+	return objective == DeliveryObjectiveValue || objective == DeliveryObjectiveDeadline
+}
+
+// 配達期限までこの時間を切った (または過ぎた) 注文を、間に合わなくなりそうな注文とみなす
+var DeliveryDeadlineHorizon = time.Hour
+
+// value の目的で、間に合わなくなりそうな注文の価値に上乗せする重み
+var DeliveryDeadlineWeight = 0
+
+// 配送計画の作成時に配送中の注文をキャッシュせず DB から逐次読み込むか
+var DeliveryPlanStreaming = false
+
+// 配送計画の DP で、重さ、容積、スコアが同じ注文をまとめて個数制限付きナップサックとして解くか
+// 同じ商品の注文が多いと DP に入れる品物の数が大きく減る (計画の価値は変わらない)
+var DeliveryPlanCollapseDuplicates = true
+
+// 1 つの配送計画に含める注文 (1 個単位) の上限 (0 なら無制限)
+// 超えたらスコアの高い注文から上限まで残す (計画は approximate になる)
+var DeliveryPlanMaxOrders = 0
+
+// 積載量と容積のうち計画に使わずに残す割合 (パーセント、0 以上 100 未満)
+var DeliveryPlanCapacityMarginPercent = 0
+
+// 配送計画の DP で更新するマスの数 (注文ごとの重さの範囲の合計) の上限 (0 なら無制限)
+// 超えた後の注文は DP に入れず、DP の解の残り容量に価値密度の高い順に詰める (計画は approximate になる)
+var DeliveryPlanDPBudget = 2_000_000_000
+
+// 配送計画の DP にかける時間の上限 (0 なら無制限)
+// 過ぎたらその時点の DP の解に残りの注文を貪欲法で詰めて返す (計画は approximate になる)
+var DeliveryPlanTimeBudget = 5 * time.Second
+
+// 容積も考える 2 次元の DP で確保するマスの数の上限 (1 マス 32 バイト)
+// 超える場合は DP を使わず、すべての注文を価値密度の高い順に貪欲法で詰める (計画は approximate になる)
+const deliveryPlanMaxVolumeCells = 1 << 22
+
+// DP を打ち切るかをこの件数の注文ごとに確認する (1 件あたり最大 W 回の更新)
+const deliveryPlanCheckInterval = 64
+
+// 配送計画で選んだ明細を SELECT ... FOR UPDATE SKIP LOCKED でロックしてから配送中にするか (MySQL 8.0 以降)
+// 並行して計画を作ったロボットがロックしている明細は、バージョンの競合で失敗させずに計画から外す
+var DeliveryPlanSkipLocked = false
+
+// 配送計画のリース期間 (0 ならリースを記録しない)
+// 期限までに確認されなかった計画の注文は DeliveryPlanReaper が未配送に戻す (26_delivery_plans.sql を適用している場合のみ有効にする)
+var DeliveryPlanLeaseTTL time.Duration = 0
+
+// リースを記録した計画に UUID と計画そのものを記録し、再要求されたら新しく作らずに返すか
+// 31_delivery_plans_idempotency.sql を適用し、DeliveryPlanLeaseTTL が 0 でない場合のみ有効にする
+var DeliveryPlanIdempotent = false
+
+// 同時に解く配送計画の数の上限 (0 なら無制限)
+// 上限に達していたら待たずに ErrDeliveryPlanBusy を返す (ロボットには後で再試行させる)
+var DeliveryPlanMaxConcurrent = 0
+
+var ErrDeliveryPlanBusy = errors.New("too many delivery plans in progress")
+
+// 確認する配送計画がない (他のロボットのもの、リース切れを含む)
+var ErrDeliveryPlanNotFound = errors.New("delivery plan not found")
+
+// 配送ロボットの登録 (robots) を使うか (27_robots.sql を適用している場合のみ有効にする)
+// 有効なら、登録済みのロボットの配送計画は登録された積載量で作る
+var RobotRegistryEnabled = false
+
+// 最後のハートビートからこの時間が過ぎたロボットを offline とみなす
+var RobotHeartbeatTimeout = 30 * time.Second
+
+var (
+	ErrRobotRegistryDisabled = errors.New("robot registry is not enabled")
+	ErrRobotNotFound         = errors.New("robot not found")
+	ErrRobotOffline          = errors.New("robot is offline")
+)
+
+// 同じ配送中一覧 (バージョン) と積載量で解いた計画を使い回す件数 (0 なら使い回さない)
+// 計画で注文を配送中にすればバージョンが進むので、使い回すのは空の計画や競合で失敗した計画の再試行が主になる
+var DeliveryPlanCacheSize = 64
+
+// 配達希望期間の判定が時刻に依存するので、バージョンが同じでもこの時間が過ぎたら解き直す
+var DeliveryPlanCacheTTL = time.Second
+
+type deliveryPlanKey struct {
+	version        int64
+	capacity       int
+	volumeCapacity int
+	algorithm      string
+	zone           string
+}
+
 type RobotService struct {
 	store *repository.Store
+
+	// 解いた計画 (RobotID とリースは含めない、nil なら使い回さない)
+	plans *expirable.LRU[deliveryPlanKey, model.DeliveryPlan]
+
+	// 計画を解いている数のセマフォ (nil なら無制限)
+	planSlots chan struct{}
 }
 
 func NewRobotService(store *repository.Store) *RobotService {
-	return &RobotService{store: store}
+	s := &RobotService{store: store}
+	if DeliveryPlanCacheSize > 0 {
+		s.plans = expirable.NewLRU[deliveryPlanKey, model.DeliveryPlan](DeliveryPlanCacheSize, nil, DeliveryPlanCacheTTL)
+	}
+	if DeliveryPlanMaxConcurrent > 0 {
+		s.planSlots = make(chan struct{}, DeliveryPlanMaxConcurrent)
+	}
+	return s
 }
 
-func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, capacity int) (*model.DeliveryPlan, error) {
-	var plan model.DeliveryPlan
+// capacity が 0 以下なら登録された積載量を使う (登録済みのロボットは登録された積載量を超えない)
+// 登録されていないロボットは capacity を指定しなければ ErrInvalidRequest を返す
+// volumeCapacity も同様 (0 以下で登録もなければ容積を考えない、repository.ProductVolumeEnabled の場合のみ)
+// DeliveryPlanIdempotent なら、PlanUUID の計画か、まだ確認していない計画があればそれを返す (PlanUUID の計画がなければ ErrDeliveryPlanNotFound)
+// Algorithm が空なら DeliveryPlanAlgorithm で解く
+func (s *RobotService) GenerateDeliveryPlan(ctx context.Context, robotID string, params model.DeliveryPlanParams) (*model.DeliveryPlan, error) {
+	plan, _, err := s.generateDeliveryPlan(ctx, robotID, params, false)
+	return plan, err
+}
+
+// GenerateDeliveryPlan に加え、計画の明細ごとの商品と metadata を返す (v2 の API 用)
+// 明細は計画と同じトランザクションで読むので、読めなければ計画ごと失敗する
+func (s *RobotService) GenerateDeliveryPlanWithDetails(ctx context.Context, robotID string, params model.DeliveryPlanParams) (*model.DeliveryPlan, map[int64]model.Order, error) {
+	return s.generateDeliveryPlan(ctx, robotID, params, true)
+}
+
+func (s *RobotService) generateDeliveryPlan(ctx context.Context, robotID string, params model.DeliveryPlanParams, withDetails bool) (*model.DeliveryPlan, map[int64]model.Order, error) {
+	planUUID := params.PlanUUID
+	var (
+		plan    model.DeliveryPlan
+		details map[int64]model.Order
+	)
 
 	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
 		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if DeliveryPlanIdempotent {
+				// 応答を受け取れずに再要求したロボットには、すでに配送中にした計画を返す
+				stored, err := storedDeliveryPlan(ctx, txStore, robotID, planUUID)
+				if err != nil {
+					return err
+				}
+				if stored != nil {
+					plan = *stored
+					if withDetails {
+						orderIDs := lo.Uniq(lo.Map(plan.Orders, func(order model.Order, _ int) int64 { return order.OrderID }))
+						details, err = txStore.OrderRepo.GetDeliveryDetails(ctx, orderIDs)
+					}
+					return err
+				}
+			} else if planUUID != "" {
+				return ErrDeliveryPlanNotFound
+			}
 
-			orders, err := txStore.OrderRepo.GetShippingOrders(ctx)
+			registered, err := deliveryCapacity(ctx, txStore, robotID, &params.Capacity, &params.VolumeCapacity)
 			if err != nil {
 				return err
 			}
-			plan, err = bestSelectOrdersForDelivery(ctx, orders, robotID, capacity)
+			plan, err = s.planDeliveries(ctx, txStore, robotID, params)
 			if err != nil {
 				return err
 			}
-			if len(plan.Orders) > 0 {
-				orderIDs := make([]int64, len(plan.Orders))
-				for i, order := range plan.Orders {
-					orderIDs[i] = order.OrderID
+			if DeliveryPlanSkipLocked && len(plan.Orders) > 0 {
+				if err := claimDeliveryPlan(ctx, txStore, &plan); err != nil {
+					return err
 				}
+			}
+			if err := routeDeliveryPlan(ctx, txStore, &plan); err != nil {
+				return err
+			}
+			if len(plan.Orders) > 0 {
+				// 計画は 1 個ずつなので、同じ明細から選んだ個数をまとめて配送中にする
+				targets := lo.Map(plan.Orders, func(order model.Order, _ int) model.OrderVersion {
+					return model.OrderVersion{OrderID: order.OrderID, Version: order.Version, Quantity: 1}
+				})
+				orderIDs := lo.Uniq(lo.Map(plan.Orders, func(order model.Order, _ int) int64 { return order.OrderID }))
 
-				if err := txStore.OrderRepo.UpdateStatuses(ctx, orderIDs, "delivering"); err != nil {
+				// 計画中に他のロボットやユーザーが更新していたら競合として失敗させる
+				if err := txStore.OrderRepo.UpdateStatuses(ctx, targets, "delivering"); err != nil {
+					if errors.Is(err, repository.ErrVersionConflict) {
+						return ErrOrderConflict
+					}
 					return err
 				}
-				log.Printf("Updated status to 'delivering' for %d orders", len(orderIDs))
+				if err := txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, orderIDs, "delivering"); err != nil {
+					return err
+				}
+				if repository.OrderETAEnabled {
+					if err := txStore.OrderRepo.SetEstimatedArrivals(ctx, deliveryETAs(&plan, time.Now())); err != nil {
+						return err
+					}
+				}
+				if DeliveryPlanLeaseTTL > 0 {
+					expiresAt := time.Now().Add(DeliveryPlanLeaseTTL)
+					planID, err := txStore.DeliveryPlanRepo.Create(ctx, robotID, expiresAt, targets)
+					if err != nil {
+						return err
+					}
+					plan.PlanID = planID
+					plan.LeaseExpiresAt = &expiresAt
+
+					if DeliveryPlanIdempotent {
+						plan.PlanUUID = uuid.NewString()
+						payload, err := json.Marshal(plan)
+						if err != nil {
+							return err
+						}
+						if err := txStore.DeliveryPlanRepo.SavePayload(ctx, planID, plan.PlanUUID, payload); err != nil {
+							return err
+						}
+					}
+				}
+				if registered {
+					if err := txStore.RobotRepo.UpdateState(ctx, robotID, model.RobotStateDelivering); err != nil {
+						return err
+					}
+				}
+				log.Printf("Updated status to 'delivering' for %d units of %d order items", len(plan.Orders), len(orderIDs))
+
+				if withDetails {
+					details, err = txStore.OrderRepo.GetDeliveryDetails(ctx, orderIDs)
+					if err != nil {
+						return err
+					}
+				}
 			}
 			return nil
 		})
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &plan, details, nil
+}
+
+// 注文を更新せずに計画だけを作る (積載量の決め方は GenerateDeliveryPlan と同じ)
+// 他のロボットが先に配送中にすれば、同じ計画になるとは限らない
+func (s *RobotService) PreviewDeliveryPlan(ctx context.Context, robotID string, params model.DeliveryPlanParams) (*model.DeliveryPlan, error) {
+	var plan model.DeliveryPlan
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if _, err := deliveryCapacity(ctx, s.store, robotID, &params.Capacity, &params.VolumeCapacity); err != nil {
+			return err
+		}
+		var err error
+		plan, err = s.planDeliveries(ctx, s.store, robotID, params)
+		if err != nil {
+			return err
+		}
+		return routeDeliveryPlan(ctx, s.store, &plan)
+	})
 	if err != nil {
 		return nil, err
 	}
+	plan.Preview = true
+	return &plan, nil
+}
+
+// 配送中の注文から計画を解く (注文は更新しない、params の積載量は deliveryCapacity で決めたもの)
+// 逐次読み込むのは dp で解く場合のみ (ほかのアルゴリズムは一覧を並べ替えるので読み込む)
+func (s *RobotService) planDeliveries(ctx context.Context, txStore *repository.Store, robotID string, params model.DeliveryPlanParams) (model.DeliveryPlan, error) {
+	ctx, span := otel.Tracer("service.robot").Start(ctx, "RobotService.planDeliveries")
+	defer span.End()
+
+	if s.planSlots != nil {
+		select {
+		case s.planSlots <- struct{}{}:
+			defer func() { <-s.planSlots }()
+		default:
+			return model.DeliveryPlan{}, ErrDeliveryPlanBusy
+		}
+	}
+
+	// DP だけを打ち切る (注文の読み込みは ctx で行う)
+	planCtx, cancel := deliveryPlanContext(ctx)
+	defer cancel()
+
+	if params.Algorithm == "" {
+		params.Algorithm = DeliveryPlanAlgorithm
+	}
+	params.Capacity = withCapacityMargin(params.Capacity)
+	params.VolumeCapacity = withCapacityMargin(params.VolumeCapacity)
+	if !DeliveryPlanStreaming || params.Algorithm != DeliveryAlgorithmDP {
+		return s.solveDeliveryPlan(ctx, planCtx, txStore, robotID, params)
+	}
+	// 配送中の注文を一覧として持たずに 1 行ずつ計画に反映する
+	// 読み込みと DP が交互になるので、読み込みの時間も含めて集計する
+	start := time.Now()
+	planner := newVolumeDeliveryPlanner(robotID, params.Capacity, params.VolumeCapacity)
+	planner.done = planCtx.Done()
+	inputOrders := 0
+	if err := txStore.OrderRepo.ForEachShippingOrder(ctx, params.Zone, func(o model.Order) error {
+		planner.add(o)
+		inputOrders++
+		return nil
+	}); err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	plan := planner.plan()
+	limitDeliveryPlanOrders(&plan)
+	observeDeliveryPlan(ctx, params.Algorithm, inputOrders, planner.W, planner.V, &plan, time.Since(start))
+	return plan, nil
+}
 
+// 配送先の座標があれば、計画に回る順番を付ける
+// 計画のキャッシュはロボットの現在地によらないので、ルートは毎回求める
+func routeDeliveryPlan(ctx context.Context, txStore *repository.Store, plan *model.DeliveryPlan) error {
+	if !repository.OrderDestinationEnabled || len(plan.Orders) == 0 {
+		return nil
+	}
+	start, err := robotLocation(ctx, txStore, plan.RobotID)
+	if err != nil {
+		return err
+	}
+	plan.Route = planRoute(plan.Orders, start)
+	return nil
+}
+
+// 記録した計画 (planUUID が空ならまだ確認していない最新の計画、なければ nil)
+func storedDeliveryPlan(ctx context.Context, txStore *repository.Store, robotID, planUUID string) (*model.DeliveryPlan, error) {
+	payload, err := txStore.DeliveryPlanRepo.FindPayload(ctx, robotID, planUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if planUUID != "" {
+			return nil, ErrDeliveryPlanNotFound
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plan model.DeliveryPlan
+	if err := json.Unmarshal(payload, &plan); err != nil {
+		return nil, err
+	}
+	plan.Replayed = true
 	return &plan, nil
 }
 
-func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string) error {
+// 配送計画 v2 で明細に付ける取り扱いフラグ
+// metadata の handling (文字列の配列) に、配達期限が迫っている (DeliveryDeadlineHorizon 以内) なら urgent を加える
+func DeliveryHandlingFlags(o model.Order, now time.Time) []string {
+	var flags []string
+	if handling, ok := o.Metadata["handling"].([]any); ok {
+		for _, v := range handling {
+			if flag, ok := v.(string); ok && flag != "" && !slices.Contains(flags, flag) {
+				flags = append(flags, flag)
+			}
+		}
+	}
+	if o.DeliverBefore != nil && o.DeliverBefore.Before(now.Add(DeliveryDeadlineHorizon)) && !slices.Contains(flags, model.HandlingUrgent) {
+		flags = append(flags, model.HandlingUrgent)
+	}
+	return flags
+}
+
+// 注文明細の 1 個を newStatus (delivering / completed) に進める
+// 配送計画の 1 件ごとに呼ぶ想定で、同じ明細から複数個を選んでいればその回数だけ呼ぶ
+// version が nil の場合はバージョンを確認せずに更新する
+func (s *RobotService) UpdateOrderStatus(ctx context.Context, orderID int64, newStatus string, version *int64) error {
+	if newStatus != "delivering" && newStatus != "completed" {
+		return ErrInvalidRequest
+	}
+	target := model.OrderVersion{OrderID: orderID, Version: repository.AnyVersion, Quantity: 1}
+	if version != nil {
+		target.Version = *version
+	}
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.OrderRepo.UpdateStatuses(ctx, []model.OrderVersion{target}, newStatus); err != nil {
+				if errors.Is(err, repository.ErrVersionConflict) {
+					return ErrOrderConflict
+				}
+				if errors.Is(err, repository.ErrInsufficientQuantity) {
+					return ErrInvalidStatusTransition
+				}
+				return err
+			}
+			return txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, []int64{orderID}, newStatus)
+		})
+	})
+}
+
+// 注文明細の単位をまとめて newStatus (delivering / completed) に進める
+// UpdateOrderStatus を orderIDs の順に呼ぶのと同じで、同じ明細を複数回指定すればその個数だけ進める
+// 1 件でも遷移元の数量が足りなければ全体を更新しない
+func (s *RobotService) UpdateOrderStatuses(ctx context.Context, orderIDs []int64, newStatus string) error {
+	if (newStatus != "delivering" && newStatus != "completed") || len(orderIDs) == 0 || len(orderIDs) > maxBulkStatusUpdate {
+		return ErrInvalidRequest
+	}
+	targets := lo.Map(orderIDs, func(id int64, _ int) model.OrderVersion {
+		return model.OrderVersion{OrderID: id, Version: repository.AnyVersion, Quantity: 1}
+	})
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.OrderRepo.UpdateStatuses(ctx, targets, newStatus); err != nil {
+				if errors.Is(err, repository.ErrInsufficientQuantity) {
+					return ErrInvalidStatusTransition
+				}
+				return err
+			}
+			return txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, lo.Uniq(orderIDs), newStatus)
+		})
+	})
+}
+
+// ロボットが配送計画を引き受けたことを記録する (以降はリースが切れても注文を戻さない)
+func (s *RobotService) AcknowledgeDeliveryPlan(ctx context.Context, robotID string, planID int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		err := s.store.DeliveryPlanRepo.Acknowledge(ctx, planID, robotID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDeliveryPlanNotFound
+		}
+		return err
+	})
+}
+
+// 配送計画のうち orderIDs の明細だけを引き受け、ほかの明細の単位を未配送に戻す (確認も兼ねる)
+// 計画にない明細を指定したら ErrInvalidRequest、確認できない計画なら ErrDeliveryPlanNotFound を返す
+// 計画の記録 (delivery_plan_items と、あれば計画そのもの) は引き受けた明細だけにする
+func (s *RobotService) AcceptDeliveryPlan(ctx context.Context, robotID string, planID int64, orderIDs []int64) (*model.AcceptDeliveryPlanResponse, error) {
+	resp := &model.AcceptDeliveryPlanResponse{PlanID: planID, Accepted: []int64{}, Reverted: []int64{}}
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			err := txStore.DeliveryPlanRepo.LockOpen(ctx, planID, robotID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDeliveryPlanNotFound
+			}
+			if err != nil {
+				return err
+			}
+			items, err := txStore.DeliveryPlanRepo.GetItems(ctx, []int64{planID})
+			if err != nil {
+				return err
+			}
+			accepted := make(map[int64]bool, len(orderIDs))
+			for _, id := range orderIDs {
+				accepted[id] = true
+			}
+			var rejected []model.OrderVersion
+			for _, item := range items {
+				if accepted[item.OrderID] {
+					resp.Accepted = append(resp.Accepted, item.OrderID)
+				} else {
+					rejected = append(rejected, item)
+					resp.Reverted = append(resp.Reverted, item.OrderID)
+				}
+			}
+			if len(resp.Accepted) != len(accepted) {
+				return ErrInvalidRequest
+			}
+
+			if len(rejected) > 0 {
+				reverted, err := txStore.OrderRepo.RevertDispatched(ctx, rejected)
+				if err != nil {
+					return err
+				}
+				if reverted > 0 {
+					if err := txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, resp.Reverted, "shipping"); err != nil {
+						return err
+					}
+				}
+				if err := txStore.DeliveryPlanRepo.RemoveItems(ctx, planID, resp.Reverted); err != nil {
+					return err
+				}
+				if DeliveryPlanIdempotent {
+					if err := trimStoredDeliveryPlan(ctx, txStore, planID, accepted); err != nil {
+						return err
+					}
+				}
+			}
+			if err := txStore.DeliveryPlanRepo.Acknowledge(ctx, planID, robotID); err != nil {
+				return err
+			}
+			if len(resp.Accepted) == 0 && RobotRegistryEnabled {
+				// 何も引き受けなかったロボットは次の計画を受け取れる
+				if err := txStore.RobotRepo.UpdateState(ctx, robotID, model.RobotStateIdle); err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 記録した計画から、引き受けなかった明細の単位を外す (計画を記録していなければ何もしない)
+func trimStoredDeliveryPlan(ctx context.Context, txStore *repository.Store, planID int64, accepted map[int64]bool) error {
+	payload, err := txStore.DeliveryPlanRepo.GetPayload(ctx, planID)
+	if err != nil || payload == nil {
+		return err
+	}
+	var plan model.DeliveryPlan
+	if err := json.Unmarshal(payload, &plan); err != nil {
+		return err
+	}
+	plan.Orders = lo.Filter(plan.Orders, func(o model.Order, _ int) bool { return accepted[o.OrderID] })
+	plan.Route = lo.Filter(plan.Route, func(stop model.RouteStop, _ int) bool { return accepted[stop.OrderID] })
+	plan.TotalWeight, plan.TotalVolume, plan.TotalValue = 0, 0, 0
+	for _, o := range plan.Orders {
+		plan.TotalWeight += o.Weight
+		plan.TotalVolume += o.Volume
+		plan.TotalValue += o.Value
+	}
+	if payload, err = json.Marshal(plan); err != nil {
+		return err
+	}
+	return txStore.DeliveryPlanRepo.SavePayload(ctx, planID, plan.PlanUUID, payload)
+}
+
+// 計画で選んだ明細をロックし、ロックできなかった (他のロボットの計画にある) 明細や計画中に変わった明細の単位を外す
+// 外した分の容量は埋め直さない (計画は approximate になる)
+func claimDeliveryPlan(ctx context.Context, txStore *repository.Store, plan *model.DeliveryPlan) error {
+	orderIDs := lo.Uniq(lo.Map(plan.Orders, func(o model.Order, _ int) int64 { return o.OrderID }))
+	locked, err := txStore.OrderRepo.LockForDispatch(ctx, orderIDs)
+	if err != nil {
+		return err
+	}
+	// 計画の一覧はキャッシュと共有しているので、書き換えずに作り直す
+	claimed := make([]model.Order, 0, len(plan.Orders))
+	used := make(map[int64]int, len(orderIDs))
+	totalWeight, totalVolume, totalValue := 0, 0, 0
+	for _, o := range plan.Orders {
+		row, ok := locked[o.OrderID]
+		if !ok || row.Version != o.Version || used[o.OrderID] >= row.Quantity {
+			continue
+		}
+		used[o.OrderID]++
+		claimed = append(claimed, o)
+		totalWeight += o.Weight
+		totalVolume += o.Volume
+		totalValue += o.Value
+	}
+	if dropped := len(plan.Orders) - len(claimed); dropped > 0 {
+		log.Printf("[DeliveryPlan] 他の計画がロック中または更新済みの %d 個を計画から外した", dropped)
+		plan.Orders = claimed
+		plan.TotalWeight = totalWeight
+		plan.TotalVolume = totalVolume
+		plan.TotalValue = totalValue
+		plan.Approximate = true
+	}
+	return nil
+}
+
+// 配送中一覧から計画を解く (同じバージョンと積載量、アルゴリズム、ゾーンで解いた計画があれば解き直さない)
+// 一覧を読む前後でバージョンが変わっていたら、どちらのバージョンの一覧か分からないので使い回さない
+func (s *RobotService) solveDeliveryPlan(ctx, planCtx context.Context, txStore *repository.Store, robotID string, params model.DeliveryPlanParams) (model.DeliveryPlan, error) {
+	capacity, volumeCapacity, algorithm := params.Capacity, params.VolumeCapacity, params.Algorithm
+	version, err := txStore.OrderRepo.GetShippingOrdersVersion(ctx)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	orders, err := txStore.OrderRepo.GetShippingOrders(ctx, params.Zone)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	after, err := txStore.OrderRepo.GetShippingOrdersVersion(ctx)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	key := deliveryPlanKey{version: version, capacity: capacity, volumeCapacity: volumeCapacity, algorithm: algorithm, zone: params.Zone}
+	cacheable := s.plans != nil && version == after
+	if cacheable {
+		if plan, ok := s.plans.Get(key); ok {
+			observeDeliveryPlanCacheHit(ctx, algorithm)
+			plan.RobotID = robotID
+			return plan, nil
+		}
+	}
+
+	start := time.Now()
+	plan, err := deliveryPlannerFor(algorithm).Plan(planCtx, orders, robotID, capacity, volumeCapacity)
+	if err != nil {
+		return model.DeliveryPlan{}, err
+	}
+	limitDeliveryPlanOrders(&plan)
+	observeDeliveryPlan(ctx, algorithm, len(orders), max(capacity, 0), max(volumeCapacity, 0), &plan, time.Since(start))
+	if cacheable {
+		s.plans.Add(key, plan)
+	}
+	return plan, nil
+}
+
+// DeliveryPlanCapacityMarginPercent を除いた積載量 (容積の 0 は容積を考えないので、残すのは 1 以上)
+func withCapacityMargin(capacity int) int {
+	if capacity <= 0 || DeliveryPlanCapacityMarginPercent <= 0 {
+		return capacity
+	}
+	return max(capacity-capacity*DeliveryPlanCapacityMarginPercent/100, 1)
+}
+
+// DeliveryPlanMaxOrders を超える計画は、スコアの高い注文から上限まで残す
+// 容量の制約は残した注文でも満たすが、上限つきで最適とは限らないので approximate にする
+func limitDeliveryPlanOrders(plan *model.DeliveryPlan) {
+	if DeliveryPlanMaxOrders <= 0 || len(plan.Orders) <= DeliveryPlanMaxOrders {
+		return
+	}
+	p := newGreedyDeliveryPlanner(plan.RobotID, 0, 0)
+	// 計画を使い回す場合があるので、元の一覧は並べ替えない
+	orders := slices.Clone(plan.Orders)
+	slices.SortStableFunc(orders, func(a, b model.Order) int {
+		as, bs := p.score(a), p.score(b)
+		switch {
+		case as.better(bs):
+			return -1
+		case bs.better(as):
+			return 1
+		}
+		return 0
+	})
+	plan.Orders = orders[:DeliveryPlanMaxOrders]
+	plan.TotalWeight, plan.TotalVolume, plan.TotalValue = 0, 0, 0
+	for _, o := range plan.Orders {
+		plan.TotalWeight += o.Weight
+		plan.TotalVolume += o.Volume
+		plan.TotalValue += o.Value
+	}
+	plan.Approximate = true
+}
+
+// 登録された積載量で capacity と volumeCapacity を決め、ロボットが登録済みかを返す
+// 商品に容積を持たなければ volumeCapacity は 0 (容積を考えない) にする
+func deliveryCapacity(ctx context.Context, txStore *repository.Store, robotID string, capacity, volumeCapacity *int) (bool, error) {
+	if !repository.ProductVolumeEnabled || *volumeCapacity < 0 {
+		*volumeCapacity = 0
+	}
+	if RobotRegistryEnabled {
+		robot, err := txStore.RobotRepo.Get(ctx, robotID)
+		if err == nil {
+			if robot.State == model.RobotStateOffline {
+				return false, ErrRobotOffline
+			}
+			if *capacity <= 0 || *capacity > robot.Capacity {
+				*capacity = robot.Capacity
+			}
+			if v := robot.VolumeCapacity; v != nil && repository.ProductVolumeEnabled && (*volumeCapacity == 0 || *volumeCapacity > *v) {
+				*volumeCapacity = *v
+			}
+			return true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+	}
+	if *capacity <= 0 {
+		return false, ErrInvalidRequest
+	}
+	return false, nil
+}
+
+func validRobotState(state string) bool {
+	return state == model.RobotStateIdle || state == model.RobotStateDelivering || state == model.RobotStateOffline
+}
+
+// 配送ロボットを登録する (登録済みなら積載量と状態を更新する)
+func (s *RobotService) RegisterRobot(ctx context.Context, robotID string, req model.RegisterRobotRequest) (*model.Robot, error) {
+	if !RobotRegistryEnabled {
+		return nil, ErrRobotRegistryDisabled
+	}
+	if robotID == "" || req.Capacity <= 0 || (req.VolumeCapacity != nil && *req.VolumeCapacity <= 0) || (req.State != "" && !validRobotState(req.State)) {
+		return nil, ErrInvalidRequest
+	}
+	var robot *model.Robot
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.RobotRepo.Upsert(ctx, robotID, req.Capacity, req.VolumeCapacity, req.State); err != nil {
+				return err
+			}
+			var err error
+			robot, err = txStore.RobotRepo.Get(ctx, robotID)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return robot, nil
+}
+
+func (s *RobotService) GetRobot(ctx context.Context, robotID string) (*model.Robot, error) {
+	if !RobotRegistryEnabled {
+		return nil, ErrRobotRegistryDisabled
+	}
+	var robot *model.Robot
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		robot, err = s.store.RobotRepo.Get(ctx, robotID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRobotNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return robot, nil
+}
+
+// ロボットが報告した状態を記録する (offline のロボットには配送計画を作らない)
+func (s *RobotService) UpdateRobotState(ctx context.Context, robotID, state string) error {
+	if !RobotRegistryEnabled {
+		return ErrRobotRegistryDisabled
+	}
+	if !validRobotState(state) {
+		return ErrInvalidRequest
+	}
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		err := s.store.RobotRepo.UpdateState(ctx, robotID, state)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRobotNotFound
+		}
+		return err
+	})
+}
+
+// ロボットが報告したバッテリー残量、現在地、積んでいる重さを記録する
+func (s *RobotService) Heartbeat(ctx context.Context, robotID string, hb model.RobotHeartbeatRequest) error {
+	if hb.BatteryPercent < 0 || hb.BatteryPercent > 100 || hb.LoadWeight < 0 ||
+		hb.Latitude < -90 || hb.Latitude > 90 || hb.Longitude < -180 || hb.Longitude > 180 {
+		return ErrInvalidRequest
+	}
 	return utils.WithTimeout(ctx, func(ctx context.Context) error {
-		return s.store.OrderRepo.UpdateStatuses(ctx, []int64{orderID}, newStatus)
+		return s.store.RobotRepo.SaveTelemetry(ctx, robotID, hb)
 	})
 }
 
+// 各ロボットが最後に報告した状態 (RobotHeartbeatTimeout を過ぎていれば offline)
+func (s *RobotService) ListRobotTelemetry(ctx context.Context) ([]model.RobotTelemetry, error) {
+	var telemetry []model.RobotTelemetry
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		telemetry, err = s.store.RobotRepo.ListTelemetry(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range telemetry {
+		telemetry[i].Offline = now.Sub(telemetry[i].ReportedAt) > RobotHeartbeatTimeout
+	}
+	return telemetry, nil
+}
+
+func deliveryPlanContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	budget := DeliveryPlanTimeBudget
+	if b, ok := ctx.Value(deliveryPlanTimeBudgetKey{}).(time.Duration); ok {
+		budget = b
+	}
+	if budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, budget)
+}
+
+type deliveryPlanTimeBudgetKey struct{}
+
+// ctx で作る計画だけ DeliveryPlanTimeBudget の代わりに budget を使う
+func withDeliveryPlanTimeBudget(ctx context.Context, budget time.Duration) context.Context {
+	return context.WithValue(ctx, deliveryPlanTimeBudgetKey{}, budget)
+}
+
+// ctx が終わったら DP を打ち切り、残りの注文は貪欲法で詰める
 func bestSelectOrdersForDelivery(
 	ctx context.Context,
 	orders []model.Order,
 	robotID string,
 	robotCapacity int,
+	volumeCapacity int,
 ) (model.DeliveryPlan, error) {
-	n := len(orders)
-	if n == 0 || robotCapacity <= 0 {
-		return model.DeliveryPlan{RobotID: robotID}, nil
+	planner := newVolumeDeliveryPlanner(robotID, robotCapacity, volumeCapacity)
+	planner.done = ctx.Done()
+	if DeliveryPlanDPBudget > 0 && len(orders)*planner.cells() > DeliveryPlanDPBudget {
+		// 上限までに価値密度の高い注文を DP で解き、残りを貪欲法で詰める
+		orders = slices.Clone(orders)
+		slices.SortStableFunc(orders, planner.compareDensity)
 	}
+	if DeliveryPlanCollapseDuplicates {
+		planner.addCollapsed(orders)
+	} else {
+		for _, o := range orders {
+			planner.add(o)
+		}
+	}
+	return planner.plan(), nil
+}
 
-	W := robotCapacity
-	type knapChoice struct {
-		orderIndex int
-		prev       *knapChoice
+// 注文を 1 件ずつ受け取って 0-1 ナップサックを解く (容積の上限 V があれば重さと容積の 2 次元)
+// 経路復元用に DP で選ばれうる注文を持つので、呼び出し側は注文一覧を保持しなくてよい
+type deliveryPlanner struct {
+	robotID string
+	W       int
+	V       int // 0 なら容積を考えない
+
+	// 重さ w 以下、容積 v 以下での最良のスコアの合計 (dp[w*(V+1)+v])
+	// 容積も考えてマスの数が上限を超える場合は確保せず、すべて貪欲法で詰める (nil)
+	dp   []planScore
+	rows []knapRow // 経路復元用 (dp を 1 マスでも更新した注文のみ、追加順)
+
+	// 配達希望期間や配達期限の判定に使う
+	now time.Time
+
+	// DP で更新したマスの数と、DeliveryPlanDPBudget を超えた (または done が閉じた) 後に受け取った注文
+	ops  int
+	rest []model.Order
+	// 閉じたら DP を打ち切る (nil なら打ち切らない)
+	done  <-chan struct{}
+	added int
+
+	// DP を使わず、はじめからすべて貪欲法で詰める (greedy のアルゴリズム)
+	greedy bool
+}
+
+// 注文ごとに、dp[cw][cv] をその注文を加えて更新したかを (cw - weight) * (V - volume + 1) + (cv - volume) ビット目に持つ
+// マスごとに選択をポインタでつなぐと更新のたびに割り当てが起きるので、1 注文 1 ビット列にまとめる
+type knapRow struct {
+	order model.Order
+	// 同じ注文をまとめて 1 つにした場合の注文 (order は使わない、addBundle)
+	bundle []model.Order
+	weight int
+	volume int // DP で使った容積 (容積を考えない場合は 0)
+	taken  []uint64
+}
+
+// 選んだ場合に計画に加える注文
+func (r *knapRow) orders() []model.Order {
+	if r.bundle != nil {
+		return r.bundle
+	}
+	return []model.Order{r.order}
+}
+
+func (r *knapRow) has(cw, cv, V int) bool {
+	dw, dv := cw-r.weight, cv-r.volume
+	if dw < 0 || dv < 0 {
+		return false
 	}
+	i := dw*(V-r.volume+1) + dv
+	return r.taken[i/64]&(1<<(i%64)) != 0
+}
 
-	dp := make([]int, W+1)              // 重さ w 以下での最大価値
-	choices := make([]*knapChoice, W+1) // dp[w] を構成する最後の選択
+func newDeliveryPlanner(robotID string, robotCapacity int) *deliveryPlanner {
+	return newVolumeDeliveryPlanner(robotID, robotCapacity, 0)
+}
 
-	// orders は 100k 件, W は 100k 件が上限?
-	// TODO: 10^10 回ループする可能性があるので、タイムアウトの考慮が必要?
-	for i, o := range orders {
-		w, v := o.Weight, o.Value
-		if w <= 0 || v < 0 {
-			// 一応 validation
-			continue
+// volumeCapacity が 0 以下なら容積を考えない
+func newVolumeDeliveryPlanner(robotID string, robotCapacity, volumeCapacity int) *deliveryPlanner {
+	p := &deliveryPlanner{
+		robotID: robotID,
+		W:       max(robotCapacity, 0),
+		V:       max(volumeCapacity, 0),
+		now:     time.Now(),
+	}
+	if p.V == 0 || p.cells() <= deliveryPlanMaxVolumeCells {
+		p.dp = make([]planScore, p.cells())
+	}
+	return p
+}
+
+// DP を確保せず、すべての注文を価値密度の高い順に詰める
+func newGreedyDeliveryPlanner(robotID string, robotCapacity, volumeCapacity int) *deliveryPlanner {
+	return &deliveryPlanner{
+		robotID: robotID,
+		W:       max(robotCapacity, 0),
+		V:       max(volumeCapacity, 0),
+		now:     time.Now(),
+		greedy:  true,
+	}
+}
+
+// DP のマスの数
+func (p *deliveryPlanner) cells() int {
+	return (p.W + 1) * (p.V + 1)
+}
+
+// DP と容量の確認で使う注文の容積 (容積を考えない場合は 0)
+func (p *deliveryPlanner) volume(o model.Order) int {
+	if p.V == 0 {
+		return 0
+	}
+	return o.Volume
+}
+
+// done が閉じていれば true (確認は deliveryPlanCheckInterval 件ごと)
+func (p *deliveryPlanner) expired() bool {
+	p.added++
+	if p.done == nil || p.added%deliveryPlanCheckInterval != 1 {
+		return false
+	}
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// 注文 (または組み合わせの合計) のスコア
+// urgent、value、prio の大きい順、early の小さい順に比べる
+type planScore struct {
+	urgent int // 間に合わなくなりそうな注文の数 (deadline の目的のときのみ)
+	value  int // 価値 + 重み*優先度 (+ 重み*間に合わなくなりそうか)
+	prio   int // 優先度
+	early  int // 配達希望期間がまだ始まっていない注文の数
+}
+
+func (a planScore) plus(b planScore) planScore {
+	return planScore{urgent: a.urgent + b.urgent, value: a.value + b.value, prio: a.prio + b.prio, early: a.early + b.early}
+}
+
+func (a planScore) better(b planScore) bool {
+	if a.urgent != b.urgent {
+		return a.urgent > b.urgent
+	}
+	if a.value != b.value {
+		return a.value > b.value
+	}
+	if a.prio != b.prio {
+		return a.prio > b.prio
+	}
+	return a.early < b.early
+}
+
+// 配達希望期間がまだ始まっていない注文は優先度を上乗せせず、期限が迫っているともみなさない
+func (p *deliveryPlanner) score(o model.Order) planScore {
+	if o.BeforeDeliveryWindow(p.now) {
+		return planScore{value: o.Value, early: 1}
+	}
+	s := planScore{value: o.Value + DeliveryPriorityWeight*o.Priority, prio: o.Priority}
+	if o.DeliverBefore != nil && o.DeliverBefore.Sub(p.now) <= DeliveryDeadlineHorizon {
+		if DeliveryPlanObjective == DeliveryObjectiveDeadline {
+			s.urgent = 1
+		} else {
+			s.value += DeliveryDeadlineWeight
 		}
-		if w > W {
-			continue
+	}
+	return s
+}
+
+// 重さ (と容積) あたりのスコアの高い順 (期限が迫っている注文が先、同じなら優先度の高い順、配達希望期間前でない順)
+// 容積も考える場合は、重さと容積をそれぞれの上限で割った和あたりで比べる
+func (p *deliveryPlanner) compareDensity(a, b model.Order) int {
+	as, bs := p.score(a), p.score(b)
+	if c := cmp.Compare(bs.urgent, as.urgent); c != 0 {
+		return c
+	}
+	// 重さ 0 以下の注文は add で捨てるので、順番はどこでもよい
+	aw, bw := max(a.Weight, 1), max(b.Weight, 1)
+	if p.V > 0 {
+		aw, bw = aw*p.V+p.volume(a)*p.W, bw*p.V+p.volume(b)*p.W
+	}
+	if c := cmp.Compare(bs.value*aw, as.value*bw); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(bs.prio, as.prio); c != 0 {
+		return c
+	}
+	return cmp.Compare(as.early, bs.early)
+}
+
+// orders は 100k 件, W は 100k 件が上限?
+// 10^10 回ループしないよう、DeliveryPlanDPBudget を超えた後や done が閉じた後の注文は plan で貪欲法で詰める
+func (p *deliveryPlanner) add(o model.Order) {
+	w, vol := o.Weight, p.volume(o)
+	if w <= 0 || vol < 0 || o.Value < 0 || o.Priority < 0 {
+		// 一応 validation
+		return
+	}
+	if w > p.W || vol > p.V {
+		return
+	}
+	p.addRow(knapRow{order: o, weight: w, volume: vol}, p.score(o))
+}
+
+// 重さ row.weight、容積 row.volume、スコア score の品物として DP を更新する
+func (p *deliveryPlanner) addRow(row knapRow, score planScore) {
+	w, vol := row.weight, row.volume
+	cost := (p.W - w + 1) * (p.V - vol + 1)
+	if p.dp == nil || len(p.rest) > 0 || (DeliveryPlanDPBudget > 0 && p.ops+cost > DeliveryPlanDPBudget) || p.expired() {
+		if row.bundle != nil {
+			p.rest = append(p.rest, row.bundle...)
+		} else {
+			p.rest = append(p.rest, row.order)
 		}
-		for cw := W; cw >= w; cw-- {
-			alt := dp[cw-w] + v
-			if alt > dp[cw] {
-				dp[cw] = alt
-				choices[cw] = &knapChoice{orderIndex: i, prev: choices[cw-w]}
+		return
+	}
+	p.ops += cost
+	taken := make([]uint64, (cost+63)/64)
+	updated := false
+	stride, rowStride := p.V+1, p.V-vol+1
+	for cw := p.W; cw >= w; cw-- {
+		for cv := p.V; cv >= vol; cv-- {
+			if alt := p.dp[(cw-w)*stride+cv-vol].plus(score); alt.better(p.dp[cw*stride+cv]) {
+				p.dp[cw*stride+cv] = alt
+				bit := (cw-w)*rowStride + cv - vol
+				taken[bit/64] |= 1 << (bit % 64)
+				updated = true
 			}
 		}
 	}
+	if updated {
+		row.taken = taken
+		p.rows = append(p.rows, row)
+	}
+}
 
-	// 最良価値の重さを特定
-	bestW, bestV := 0, 0
-	for w := 0; w <= W; w++ {
-		if dp[w] > bestV {
-			bestV = dp[w]
-			bestW = w
+func (p *deliveryPlanner) plan() model.DeliveryPlan {
+	// 最良スコアのマスを特定
+	best := 0
+	for i := 1; i < len(p.dp); i++ {
+		if p.dp[i].better(p.dp[best]) {
+			best = i
 		}
 	}
 
-	// 経路復元
+	// 経路復元 (後に加えた注文から、その注文で更新したマスなら選んだとして重さと容積を戻す)
 	var (
 		picked      []model.Order
 		totalWeight int
+		totalVolume int
 		totalValue  int
 	)
-	for node := choices[bestW]; node != nil; node = node.prev {
-		order := orders[node.orderIndex]
-		picked = append(picked, order)
-		totalWeight += order.Weight
-		totalValue += order.Value
+	for i, cw, cv := len(p.rows)-1, best/(p.V+1), best%(p.V+1); i >= 0 && cw > 0; i-- {
+		if r := &p.rows[i]; r.has(cw, cv, p.V) {
+			for _, o := range r.orders() {
+				picked = append(picked, o)
+				totalWeight += o.Weight
+				totalVolume += o.Volume
+				totalValue += o.Value
+			}
+			cw -= r.weight
+			cv -= r.volume
+		}
+	}
+
+	// DP に入れなかった注文を、残り容量に価値密度の高い順に詰める
+	if len(p.rest) > 0 {
+		slices.SortStableFunc(p.rest, p.compareDensity)
+		for _, o := range p.rest {
+			if totalWeight+o.Weight <= p.W && (p.V == 0 || totalVolume+o.Volume <= p.V) {
+				picked = append(picked, o)
+				totalWeight += o.Weight
+				totalVolume += o.Volume
+				totalValue += o.Value
+			}
+		}
+		if !p.greedy {
+			log.Printf("[DeliveryPlan] DP を打ち切ったため %d 件を貪欲法で選択 (robot %s)", len(p.rest), p.robotID)
+		}
 	}
 
 	return model.DeliveryPlan{
-		RobotID:     robotID,
+		RobotID:     p.robotID,
 		TotalWeight: totalWeight,
+		TotalVolume: totalVolume,
 		TotalValue:  totalValue,
 		Orders:      picked,
-	}, nil
+		Approximate: len(p.rest) > 0,
+	}
 }
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+var ErrRobotAPIKeyNotFound = errors.New("robot api key not found")
+
+// ロボットの API キーを発行し、生キーと記録したキーを返す（管理者用）
+// RotateGrace を指定すると、ロボットの既存のキーをその時間の後に失効させる (その間に新しいキーに切り替える)
+func (s *RobotService) IssueRobotAPIKey(ctx context.Context, robotID string, req model.IssueRobotAPIKeyRequest) (string, *model.RobotAPIKey, error) {
+	if robotID == "" {
+		return "", nil, ErrInvalidRequest
+	}
+	var grace time.Duration
+	if req.RotateGrace != "" {
+		d, err := time.ParseDuration(req.RotateGrace)
+		if err != nil || d < 0 {
+			return "", nil, ErrInvalidRequest
+		}
+		grace = d
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	rawKey := hex.EncodeToString(buf)
+	key := &model.RobotAPIKey{
+		KeyHash:   repository.HashToken(rawKey),
+		RobotID:   robotID,
+		CreatedAt: time.Now(),
+	}
+
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			keyID, err := txStore.RobotAPIKeyRepo.Create(ctx, key)
+			if err != nil {
+				return err
+			}
+			key.KeyID = keyID
+			if req.RotateGrace == "" {
+				return nil
+			}
+			return txStore.RobotAPIKeyRepo.RevokeOthers(ctx, robotID, keyID, key.CreatedAt.Add(grace))
+		})
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return rawKey, key, nil
+}
+
+// ロボットのキーの一覧（管理者用、失効したものも含む）
+func (s *RobotService) ListRobotAPIKeys(ctx context.Context, robotID string) ([]model.RobotAPIKey, error) {
+	var keys []model.RobotAPIKey
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		keys, err = s.store.RobotAPIKeyRepo.ListByRobot(ctx, robotID)
+		return err
+	})
+	return keys, err
+}
+
+// キーを即時に失効させる（管理者用）
+// 認証の結果はキャッシュするので、失効後も ROBOT_API_KEY_CACHE_TTL の間は使えることがある
+func (s *RobotService) RevokeRobotAPIKey(ctx context.Context, keyID int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		if err := s.store.RobotAPIKeyRepo.Revoke(ctx, keyID, time.Now()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRobotAPIKeyNotFound
+			}
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestIssueRobotAPIKey(t *testing.T) {
+	db := newReturnOrderDB(nil, 1)
+	s := NewRobotService(repository.NewStore(db))
+
+	if _, _, err := s.IssueRobotAPIKey(context.Background(), "robot", model.IssueRobotAPIKeyRequest{RotateGrace: "-1m"}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest", err)
+	}
+
+	rawKey, key, err := s.IssueRobotAPIKey(context.Background(), "robot", model.IssueRobotAPIKeyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rawKey) != 64 || key.KeyHash != repository.HashToken(rawKey) || key.RobotID != "robot" {
+		t.Fatalf("key = %+v for %q, want the hash of the raw key", key, rawKey)
+	}
+	if len(db.calls) != 1 {
+		t.Fatalf("execs = %v, want only the insert without rotate_grace", db.queries())
+	}
+
+	// ローテーションでは既存のキーも失効させる
+	if _, _, err := s.IssueRobotAPIKey(context.Background(), "robot", model.IssueRobotAPIKeyRequest{RotateGrace: "5m"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.calls) != 3 || !strings.Contains(db.calls[2].query, "key_id <> ?") {
+		t.Fatalf("execs = %v, want the other keys revoked", db.queries())
+	}
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"time"
+
+	"backend/internal/model"
+)
+
+// 到着予定日時の見積もりで、立ち寄り先 1 か所あたりにかかる時間 (荷降ろしなど)
+// 座標がない立ち寄り先は、移動の時間もこれに含める
+var DeliveryETAPerStop = 5 * time.Minute
+
+// 座標のある立ち寄り先の間を移動する速さ (m/s)
+var DeliveryETASpeed = 5.0
+
+// 計画の明細ごとの到着予定日時を見積もる
+// ルートがあればその順に、直前の立ち寄り先からの移動時間と立ち寄り先ごとの時間を積み上げる
+// ルートがなければ計画で明細が最初に現れた順 (キューの位置) に DeliveryETAPerStop ずつ積み上げる
+// ロボットの現在地から最初の立ち寄り先までの移動は含めない
+func deliveryETAs(plan *model.DeliveryPlan, now time.Time) map[int64]time.Time {
+	etas := make(map[int64]time.Time, len(plan.Orders))
+	if len(plan.Route) == 0 {
+		at := now
+		for _, o := range plan.Orders {
+			if _, ok := etas[o.OrderID]; !ok {
+				at = at.Add(DeliveryETAPerStop)
+				etas[o.OrderID] = at
+			}
+		}
+		return etas
+	}
+
+	at := now
+	var prev *geoPoint
+	for _, stop := range plan.Route {
+		if stop.Latitude != nil && stop.Longitude != nil {
+			p := geoPoint{lat: *stop.Latitude, lng: *stop.Longitude}
+			if prev != nil && DeliveryETASpeed > 0 {
+				at = at.Add(time.Duration(haversine(*prev, p) / DeliveryETASpeed * float64(time.Second)))
+			}
+			prev = &p
+		}
+		at = at.Add(DeliveryETAPerStop)
+		etas[stop.OrderID] = at
+	}
+	return etas
+}
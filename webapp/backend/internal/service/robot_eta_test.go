@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/model"
+)
+
+func TestDeliveryETAsFollowQueuePosition(t *testing.T) {
+	defer func(perStop time.Duration) { DeliveryETAPerStop = perStop }(DeliveryETAPerStop)
+	DeliveryETAPerStop = 10 * time.Minute
+	now := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	plan := &model.DeliveryPlan{Orders: []model.Order{{OrderID: 3}, {OrderID: 1}, {OrderID: 3}, {OrderID: 2}}}
+	etas := deliveryETAs(plan, now)
+	for id, want := range map[int64]time.Duration{3: 10 * time.Minute, 1: 20 * time.Minute, 2: 30 * time.Minute} {
+		if got := etas[id]; !got.Equal(now.Add(want)) {
+			t.Errorf("order %d: eta = %v, want %v", id, got, now.Add(want))
+		}
+	}
+}
+
+func TestDeliveryETAsAddTravelTimeAlongRoute(t *testing.T) {
+	defer func(perStop time.Duration, speed float64) {
+		DeliveryETAPerStop, DeliveryETASpeed = perStop, speed
+	}(DeliveryETAPerStop, DeliveryETASpeed)
+	DeliveryETAPerStop, DeliveryETASpeed = time.Minute, 10
+	now := time.Date(2025, 9, 1, 12, 0, 0, 0, time.UTC)
+
+	lat, lng1, lng2 := 0.0, 0.0, 0.01 // 赤道上で約 1112 m
+	plan := &model.DeliveryPlan{Route: []model.RouteStop{
+		{OrderID: 1, Latitude: &lat, Longitude: &lng1},
+		{OrderID: 2, Latitude: &lat, Longitude: &lng2},
+		{OrderID: 3},
+	}}
+	etas := deliveryETAs(plan, now)
+	if !etas[1].Equal(now.Add(time.Minute)) {
+		t.Fatalf("first stop eta = %v, want one stop after now", etas[1])
+	}
+	travel := etas[2].Sub(etas[1]) - time.Minute
+	if travel < 110*time.Second || travel > 112*time.Second {
+		t.Fatalf("travel time = %v, want about 111s", travel)
+	}
+	if !etas[3].Equal(etas[2].Add(time.Minute)) {
+		t.Fatalf("stop without coordinates eta = %v, want one stop after the previous", etas[3])
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+// ロボットからの配送の失敗の報告を受け付けるか (35_delivery_failures.sql を適用している場合のみ有効にする)
+var DeliveryFailureEnabled = false
+
+// 配送に失敗した明細を自動で未配送に戻す回数の上限
+// 超えたら未配送に戻さず、手動で確認する明細として記録する
+var DeliveryFailureMaxRetries = 3
+
+var ErrDeliveryFailureDisabled = errors.New("delivery failure reporting is not enabled")
+
+var deliveryFailureReasons = map[string]bool{
+	model.DeliveryFailureRecipientAbsent: true,
+	model.DeliveryFailureAddressNotFound: true,
+	model.DeliveryFailureAccessDenied:    true,
+	model.DeliveryFailureDamaged:         true,
+	model.DeliveryFailureOther:           true,
+}
+
+func ValidDeliveryFailureReason(reason string) bool {
+	return deliveryFailureReasons[reason]
+}
+
+// 配送中の明細の 1 個の配送に失敗したことを記録する
+// 失敗の回数が DeliveryFailureMaxRetries 以下ならその 1 個を未配送に戻し、超えたら配送中のまま手動で確認する明細にする
+// 配送中の単位がない明細なら ErrInvalidStatusTransition を返す
+func (s *RobotService) ReportDeliveryFailure(ctx context.Context, robotID string, orderID int64, reason string) (*model.DeliveryFailureResult, error) {
+	if !DeliveryFailureEnabled {
+		return nil, ErrDeliveryFailureDisabled
+	}
+	if !ValidDeliveryFailureReason(reason) {
+		return nil, ErrInvalidRequest
+	}
+
+	result := &model.DeliveryFailureResult{OrderID: orderID}
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			attempts, err := txStore.OrderRepo.IncrementFailedAttempts(ctx, orderID)
+			if errors.Is(err, repository.ErrInsufficientQuantity) {
+				return ErrInvalidStatusTransition
+			}
+			if err != nil {
+				return err
+			}
+			result.Attempts = attempts
+
+			if attempts <= DeliveryFailureMaxRetries {
+				target := model.OrderVersion{OrderID: orderID, Version: repository.AnyVersion, Quantity: 1}
+				if _, err := txStore.OrderRepo.RevertDispatched(ctx, []model.OrderVersion{target}); err != nil {
+					return err
+				}
+				result.Requeued = true
+			} else {
+				if err := txStore.OrderRepo.MarkReviewRequired(ctx, orderID); err != nil {
+					return err
+				}
+				result.ReviewRequired = true
+			}
+
+			failure := &model.DeliveryFailure{
+				OrderID:   orderID,
+				RobotID:   robotID,
+				Reason:    reason,
+				Requeued:  result.Requeued,
+				CreatedAt: time.Now(),
+			}
+			if err := txStore.DeliveryFailureRepo.Create(ctx, failure); err != nil {
+				return err
+			}
+			return txStore.WebhookRepo.EnqueueOrderStatusEvents(ctx, []int64{orderID}, "failed")
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.ReviewRequired {
+		log.Printf("[DeliveryFailure] 明細 %d の配送に %d 回失敗したため手動での確認に回した (robot %s, %s)", orderID, result.Attempts, robotID, reason)
+	}
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 失敗の回数を attempts として返し、更新は affected 件とする
+func newDeliveryFailureDB(attempts int, affected int64) *fakeDB {
+	db := newReturnOrderDB(nil, affected)
+	db.get = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if n, ok := dest.(*int); ok {
+			*n = attempts
+		}
+		return nil
+	}
+	return db
+}
+
+func TestReportDeliveryFailure(t *testing.T) {
+	defer func(enabled bool, retries int) {
+		DeliveryFailureEnabled, DeliveryFailureMaxRetries = enabled, retries
+	}(DeliveryFailureEnabled, DeliveryFailureMaxRetries)
+	DeliveryFailureEnabled, DeliveryFailureMaxRetries = true, 2
+
+	tests := []struct {
+		name       string
+		attempts   int
+		affected   int64
+		reason     string
+		wantErr    error
+		wantUpdate string
+	}{
+		{"requeue", 1, 1, model.DeliveryFailureRecipientAbsent, nil, "dispatched_quantity - LEAST"},
+		{"last retry", 2, 1, model.DeliveryFailureDamaged, nil, "dispatched_quantity - LEAST"},
+		{"review", 3, 1, model.DeliveryFailureOther, nil, "review_required_at = NOW()"},
+		{"not delivering", 1, 0, model.DeliveryFailureOther, ErrInvalidStatusTransition, ""},
+		{"unknown reason", 1, 1, "lost", ErrInvalidRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDeliveryFailureDB(tt.attempts, tt.affected)
+			s := NewRobotService(repository.NewStore(db))
+			result, err := s.ReportDeliveryFailure(context.Background(), "robot", 5, tt.reason)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if result.Attempts != tt.attempts || result.Requeued == result.ReviewRequired {
+				t.Fatalf("result = %+v", result)
+			}
+			if execs := db.queries(); len(execs) != 3 || !strings.Contains(execs[1], tt.wantUpdate) || !strings.Contains(execs[2], "INSERT INTO delivery_failures") {
+				t.Fatalf("execs = %v, want the counter, %q and the failure record", execs, tt.wantUpdate)
+			}
+		})
+	}
+
+	DeliveryFailureEnabled = false
+	s := NewRobotService(repository.NewStore(newDeliveryFailureDB(0, 0)))
+	if _, err := s.ReportDeliveryFailure(context.Background(), "robot", 5, model.DeliveryFailureOther); !errors.Is(err, ErrDeliveryFailureDisabled) {
+		t.Fatalf("err = %v, want ErrDeliveryFailureDisabled", err)
+	}
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"backend/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+)
+
+var (
+	ErrDeliveryPlanJobNotFound  = errors.New("delivery plan job not found")
+	ErrDeliveryPlanJobQueueFull = errors.New("delivery plan job queue is full")
+)
+
+// ジョブで DP にかける時間の上限 (リクエストを待たせないので DeliveryPlanTimeBudget より長くてよい)
+// トランザクションごと utils.WithTimeout の上限で打ち切られるので、それより短くする
+var DeliveryPlanJobTimeBudget = 15 * time.Second
+
+// 終わったジョブの結果を取得できる期間
+var DeliveryPlanJobTTL = 10 * time.Minute
+
+// 保持するジョブの数の上限 (超えたら古いものから捨てる)
+const deliveryPlanJobCacheSize = 4096
+
+type deliveryPlanJobRequest struct {
+	jobID   string
+	robotID string
+	params  model.DeliveryPlanParams
+}
+
+// 配送計画を非同期で作るワーカープール (/delivery-plan/jobs)
+// ジョブはメモリに持つので、結果は受け付けたインスタンスでしか取得できない
+// 作った計画は取得されなくても注文を配送中にするので、リースを記録していなければ配送中のまま残る
+type DeliveryPlanJobs struct {
+	robots  *RobotService
+	workers int
+	queue   chan deliveryPlanJobRequest
+
+	mu   sync.Mutex
+	jobs *expirable.LRU[string, *model.DeliveryPlanJob]
+}
+
+func NewDeliveryPlanJobs(robots *RobotService, workers, queueSize int) *DeliveryPlanJobs {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = 64
+	}
+	return &DeliveryPlanJobs{
+		robots:  robots,
+		workers: workers,
+		queue:   make(chan deliveryPlanJobRequest, queueSize),
+		jobs:    expirable.NewLRU[string, *model.DeliveryPlanJob](deliveryPlanJobCacheSize, nil, DeliveryPlanJobTTL),
+	}
+}
+
+// ctx がキャンセルされるまで workers 個のワーカーでジョブを処理する
+func (j *DeliveryPlanJobs) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < j.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req := <-j.queue:
+					j.run(ctx, req)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ジョブを受け付ける (待ちが queueSize 件を超えたら ErrDeliveryPlanJobQueueFull)
+func (j *DeliveryPlanJobs) Submit(robotID string, params model.DeliveryPlanParams) (*model.DeliveryPlanJob, error) {
+	job := &model.DeliveryPlanJob{
+		JobID:     uuid.NewString(),
+		RobotID:   robotID,
+		Status:    model.DeliveryPlanJobQueued,
+		CreatedAt: time.Now(),
+	}
+	j.mu.Lock()
+	j.jobs.Add(job.JobID, job)
+	snapshot := *job
+	j.mu.Unlock()
+
+	select {
+	case j.queue <- deliveryPlanJobRequest{jobID: job.JobID, robotID: robotID, params: params}:
+		return &snapshot, nil
+	default:
+		j.mu.Lock()
+		j.jobs.Remove(job.JobID)
+		j.mu.Unlock()
+		return nil, ErrDeliveryPlanJobQueueFull
+	}
+}
+
+// robotID が受け付けさせたジョブの状態 (ほかのロボットのジョブは ErrDeliveryPlanJobNotFound)
+func (j *DeliveryPlanJobs) Get(robotID, jobID string) (*model.DeliveryPlanJob, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs.Get(jobID)
+	if !ok || job.RobotID != robotID {
+		return nil, ErrDeliveryPlanJobNotFound
+	}
+	snapshot := *job
+	return &snapshot, nil
+}
+
+func (j *DeliveryPlanJobs) run(ctx context.Context, req deliveryPlanJobRequest) {
+	// 待っている間に捨てられたジョブは解かない (解いても結果を取得できない)
+	if !j.update(req.jobID, func(job *model.DeliveryPlanJob) { job.Status = model.DeliveryPlanJobRunning }) {
+		return
+	}
+	plan, err := j.robots.GenerateDeliveryPlan(withDeliveryPlanTimeBudget(ctx, DeliveryPlanJobTimeBudget), req.robotID, req.params)
+	finished := time.Now()
+	if !j.update(req.jobID, func(job *model.DeliveryPlanJob) {
+		job.FinishedAt = &finished
+		if err != nil {
+			job.Status = model.DeliveryPlanJobFailed
+			job.Err = err
+			return
+		}
+		job.Status = model.DeliveryPlanJobDone
+		job.Plan = plan
+	}) && err == nil {
+		log.Printf("[DeliveryPlanJobs] ジョブ %s の結果を保持できなかった (robot %s)", req.jobID, req.robotID)
+	}
+}
+
+// ジョブがまだあれば fn で更新して true を返す
+func (j *DeliveryPlanJobs) update(jobID string, fn func(*model.DeliveryPlanJob)) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs.Peek(jobID)
+	if !ok {
+		return false
+	}
+	fn(job)
+	return true
+}
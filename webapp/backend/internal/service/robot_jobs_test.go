@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestDeliveryPlanJobs(t *testing.T) {
+	db := newReturnOrderDB(&model.Order{OrderID: 1, Weight: 2, Value: 5}, 1)
+	jobs := NewDeliveryPlanJobs(NewRobotService(repository.NewStore(db)), 1, 1)
+
+	job, err := jobs.Submit("robot", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil || job.Status != model.DeliveryPlanJobQueued {
+		t.Fatalf("Submit = %+v, %v; want a queued job", job, err)
+	}
+	// ワーカーが動いていなければ待ちがあふれる
+	if _, err := jobs.Submit("robot", model.DeliveryPlanParams{Capacity: 10}); !errors.Is(err, ErrDeliveryPlanJobQueueFull) {
+		t.Fatalf("err = %v, want ErrDeliveryPlanJobQueueFull", err)
+	}
+	if _, err := jobs.Get("other", job.JobID); !errors.Is(err, ErrDeliveryPlanJobNotFound) {
+		t.Fatalf("other robot err = %v, want ErrDeliveryPlanJobNotFound", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go jobs.Run(ctx)
+	deadline := time.Now().Add(time.Second)
+	for {
+		got, err := jobs.Get("robot", job.JobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status == model.DeliveryPlanJobDone {
+			if got.Plan == nil || len(got.Plan.Orders) != 1 || got.FinishedAt == nil {
+				t.Fatalf("job = %+v, want the plan with order 1", got)
+			}
+			break
+		}
+		if got.Status == model.DeliveryPlanJobFailed || time.Now().After(deadline) {
+			t.Fatalf("job = %+v, want done", got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliveryPlanJobRecordsFailure(t *testing.T) {
+	jobs := NewDeliveryPlanJobs(NewRobotService(repository.NewStore(newReturnOrderDB(nil, 0))), 1, 1)
+	// 登録されていないロボットで capacity を指定しない
+	job, err := jobs.Submit("robot", model.DeliveryPlanParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.run(context.Background(), <-jobs.queue)
+	got, _ := jobs.Get("robot", job.JobID)
+	if got.Status != model.DeliveryPlanJobFailed || !errors.Is(got.Err, ErrInvalidRequest) {
+		t.Fatalf("job = %+v, want failed with ErrInvalidRequest", got)
+	}
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"math"
+	"slices"
+
+	"backend/internal/model"
+)
+
+// 配送計画のアルゴリズム (DeliveryPlanAlgorithm か、リクエストの algorithm で選ぶ)
+// 品質と計算時間のどちらを優先するかで選び分ける
+const (
+	// 重さ (と容積) の DP による厳密解 (計算量か時間の上限に達したら残りを貪欲法で詰める)
+	DeliveryAlgorithmDP = "dp"
+	// 価値密度の高い順に詰めるだけ (最も速いが最適とは限らない)
+	DeliveryAlgorithmGreedy = "greedy"
+	// 価値を丸めた DP による近似解 (価値の合計は最適解の 1 - DeliveryPlanFPTASEpsilon 倍以上)
+	DeliveryAlgorithmFPTAS = "fptas"
+	// 分枝限定法による厳密解 (探索するノードの上限に達したらそれまでの最良の解、多くの分布で dp より速い)
+	DeliveryAlgorithmBranchAndBound = "branch-and-bound"
+)
+
+var DeliveryPlanAlgorithm = DeliveryAlgorithmDP
+
+// fptas の許容誤差
+var DeliveryPlanFPTASEpsilon = 0.1
+
+// fptas の DP で更新するマスの数 (注文数 × 丸めた価値の合計) の上限
+// 超える場合は価値をさらに粗く丸める (近似の保証は弱くなる)
+const deliveryPlanFPTASMaxCells = 1 << 27
+
+// branch-and-bound で探索するノードの上限 (超えたらその時点の最良の解を返す)
+const deliveryPlanBranchAndBoundMaxNodes = 1_000_000
+
+type Planner interface {
+	// 容量 (volumeCapacity が 0 なら重さのみ) に収まる注文の組を選ぶ
+	Plan(ctx context.Context, orders []model.Order, robotID string, capacity, volumeCapacity int) (model.DeliveryPlan, error)
+}
+
+var deliveryPlanners = map[string]Planner{
+	DeliveryAlgorithmDP:             dpPlanner{},
+	DeliveryAlgorithmGreedy:         greedyPlanner{},
+	DeliveryAlgorithmFPTAS:          fptasPlanner{},
+	DeliveryAlgorithmBranchAndBound: branchAndBoundPlanner{},
+}
+
+func ValidDeliveryPlanAlgorithm(algorithm string) bool {
+	_, ok := deliveryPlanners[algorithm]
+	return ok
+}
+
+// 空なら DeliveryPlanAlgorithm
+func deliveryPlannerFor(algorithm string) Planner {
+	if algorithm == "" {
+		algorithm = DeliveryPlanAlgorithm
+	}
+	if p, ok := deliveryPlanners[algorithm]; ok {
+		return p
+	}
+	return dpPlanner{}
+}
+
+type dpPlanner struct{}
+
+func (dpPlanner) Plan(ctx context.Context, orders []model.Order, robotID string, capacity, volumeCapacity int) (model.DeliveryPlan, error) {
+	return bestSelectOrdersForDelivery(ctx, orders, robotID, capacity, volumeCapacity)
+}
+
+type greedyPlanner struct{}
+
+func (greedyPlanner) Plan(_ context.Context, orders []model.Order, robotID string, capacity, volumeCapacity int) (model.DeliveryPlan, error) {
+	p := newGreedyDeliveryPlanner(robotID, capacity, volumeCapacity)
+	for _, o := range orders {
+		p.add(o)
+	}
+	return p.plan(), nil
+}
+
+// 計画に選べる注文 (容量を超える注文と、不正な値の注文を除く)
+func (p *deliveryPlanner) candidates(orders []model.Order) []model.Order {
+	return slices.DeleteFunc(slices.Clone(orders), func(o model.Order) bool {
+		return o.Weight <= 0 || o.Weight > p.W || p.volume(o) < 0 || p.volume(o) > p.V || o.Value < 0 || o.Priority < 0
+	})
+}
+
+// orders のうち picked 番目の注文を選び、残り容量にほかの注文を価値密度の高い順に詰めて計画にする
+func (p *deliveryPlanner) fill(picked []int, orders []model.Order, approximate bool) model.DeliveryPlan {
+	plan := model.DeliveryPlan{RobotID: p.robotID, Approximate: approximate}
+	used := make([]bool, len(orders))
+	add := func(i int) {
+		o := orders[i]
+		used[i] = true
+		plan.Orders = append(plan.Orders, o)
+		plan.TotalWeight += o.Weight
+		plan.TotalVolume += o.Volume
+		plan.TotalValue += o.Value
+	}
+	for _, i := range picked {
+		add(i)
+	}
+	rest := make([]int, 0, len(orders)-len(picked))
+	for i := range orders {
+		if !used[i] {
+			rest = append(rest, i)
+		}
+	}
+	slices.SortStableFunc(rest, func(a, b int) int { return p.compareDensity(orders[a], orders[b]) })
+	for _, i := range rest {
+		if o := orders[i]; plan.TotalWeight+o.Weight <= p.W && (p.V == 0 || plan.TotalVolume+o.Volume <= p.V) {
+			add(i)
+		}
+	}
+	return plan
+}
+
+// 価値を K で割って丸め、丸めた価値ごとの最小の重さを DP で求める
+// 容積を考える場合は、丸めた価値ごとに重さ最小の組の容積を持ち、容積を超える組は作らない (近似の保証はない)
+type fptasPlanner struct{}
+
+func (fptasPlanner) Plan(ctx context.Context, orders []model.Order, robotID string, capacity, volumeCapacity int) (model.DeliveryPlan, error) {
+	p := newGreedyDeliveryPlanner(robotID, capacity, volumeCapacity)
+	items := p.candidates(orders)
+	n := len(items)
+	if n == 0 {
+		return p.fill(nil, nil, false), nil
+	}
+
+	// deadline の目的でも、間に合わなくなりそうかは考えずに価値だけで丸める
+	values := make([]int, n)
+	maxValue, sum := 0, 0
+	for i, o := range items {
+		values[i] = p.score(o).value
+		maxValue = max(maxValue, values[i])
+		sum += values[i]
+	}
+	if maxValue == 0 {
+		return p.fill(nil, items, true), nil
+	}
+	k := max(DeliveryPlanFPTASEpsilon*float64(maxValue)/float64(n), 1)
+	if cells := float64(n) * float64(sum) / k; cells > deliveryPlanFPTASMaxCells {
+		k *= cells / deliveryPlanFPTASMaxCells
+	}
+	scaled := make([]int, n)
+	total := 0
+	for i, v := range values {
+		scaled[i] = int(float64(v) / k)
+		total += scaled[i]
+	}
+
+	// weight[s] = 丸めた価値の合計がちょうど s になる組の最小の重さ (作れなければ MaxInt)
+	weight := make([]int, total+1)
+	volume := make([]int, total+1)
+	for s := 1; s <= total; s++ {
+		weight[s] = math.MaxInt
+	}
+	rows := make([][]uint64, n)
+	reach := 0
+	done := ctx.Done()
+	for i, o := range items {
+		if i%deliveryPlanCheckInterval == 0 {
+			select {
+			case <-done:
+				// 打ち切ったら、そこまでの解に残りを貪欲法で詰める
+				return p.fill(fptasPicked(scaled, rows[:i], weight, p.W), items, true), nil
+			default:
+			}
+		}
+		v := scaled[i]
+		rows[i] = make([]uint64, (reach+v+1+63)/64)
+		for s := reach + v; s >= v; s-- {
+			prev := weight[s-v]
+			if prev == math.MaxInt || prev+o.Weight > p.W || (p.V > 0 && volume[s-v]+o.Volume > p.V) {
+				continue
+			}
+			if prev+o.Weight < weight[s] {
+				weight[s] = prev + o.Weight
+				volume[s] = volume[s-v] + p.volume(o)
+				rows[i][s/64] |= 1 << (s % 64)
+			}
+		}
+		reach += v
+	}
+	return p.fill(fptasPicked(scaled, rows, weight, p.W), items, true), nil
+}
+
+// 重さ W 以下で丸めた価値の合計が最大の組を復元する (items の添字)
+func fptasPicked(scaled []int, rows [][]uint64, weight []int, W int) []int {
+	best := 0
+	for s := len(weight) - 1; s > 0; s-- {
+		if weight[s] <= W {
+			best = s
+			break
+		}
+	}
+	var picked []int
+	for i := len(rows) - 1; i >= 0 && best > 0; i-- {
+		if best/64 < len(rows[i]) && rows[i][best/64]&(1<<(best%64)) != 0 {
+			picked = append(picked, i)
+			best -= scaled[i]
+		}
+	}
+	return picked
+}
+
+// 価値密度の高い順に、入れる / 入れないを深さ優先で探索する
+// 上界は重さだけを考えた分数ナップサック (容積の制約を外した緩和) で、最良の解を超えられない枝を刈る
+// 最初の最良の解は貪欲法で詰めた解
+type branchAndBoundPlanner struct{}
+
+func (branchAndBoundPlanner) Plan(ctx context.Context, orders []model.Order, robotID string, capacity, volumeCapacity int) (model.DeliveryPlan, error) {
+	p := newGreedyDeliveryPlanner(robotID, capacity, volumeCapacity)
+	items := p.candidates(orders)
+	values := p.scalarValues(items)
+
+	// 上界を分数ナップサックで求めるため、1 つにした価値の重さあたりの高い順に並べる
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if c := cmp.Compare(values[b]*items[a].Weight, values[a]*items[b].Weight); c != 0 {
+			return c
+		}
+		return p.compareDensity(items[a], items[b])
+	})
+	bb := &branchAndBound{W: p.W, V: p.V, done: ctx.Done()}
+	for _, i := range order {
+		bb.items = append(bb.items, items[i])
+		bb.values = append(bb.values, values[i])
+	}
+	bb.take = make([]bool, len(items))
+	bb.best = make([]bool, len(items))
+	bb.seedGreedy()
+	bb.search(0, 0, 0, 0)
+
+	var picked []int
+	for i, ok := range bb.best {
+		if ok {
+			picked = append(picked, i)
+		}
+	}
+	if bb.truncated {
+		log.Printf("[DeliveryPlan] 分枝限定法を %d ノードで打ち切った (robot %s)", bb.nodes, robotID)
+	}
+	// 打ち切った場合は、見つかった最良の解に残りを詰める
+	return p.fill(picked, bb.items, bb.truncated), nil
+}
+
+// スコアを 1 つの値にする (deadline の目的なら、間に合わなくなりそうな注文 1 件がほかのすべての価値の合計より重い)
+// 優先度と配達希望期間による同点の扱いは考えない
+func (p *deliveryPlanner) scalarValues(items []model.Order) []int {
+	scores := make([]planScore, len(items))
+	total := 0
+	for i, o := range items {
+		scores[i] = p.score(o)
+		total += scores[i].value
+	}
+	values := make([]int, len(items))
+	for i, s := range scores {
+		values[i] = s.urgent*(total+1) + s.value
+	}
+	return values
+}
+
+type branchAndBound struct {
+	items  []model.Order
+	values []int
+	W, V   int
+	done   <-chan struct{}
+
+	take      []bool
+	best      []bool
+	bestValue int
+	nodes     int
+	truncated bool
+}
+
+func (bb *branchAndBound) search(i, weight, volume, value int) {
+	if bb.truncated {
+		return
+	}
+	bb.nodes++
+	if bb.nodes > deliveryPlanBranchAndBoundMaxNodes {
+		bb.truncated = true
+		return
+	}
+	if bb.nodes%deliveryPlanCheckInterval == 0 {
+		select {
+		case <-bb.done:
+			bb.truncated = true
+			return
+		default:
+		}
+	}
+	if value > bb.bestValue {
+		bb.bestValue = value
+		copy(bb.best, bb.take)
+	}
+	if i == len(bb.items) || bb.bound(i, weight, value) <= float64(bb.bestValue) {
+		return
+	}
+	o := bb.items[i]
+	if weight+o.Weight <= bb.W && (bb.V == 0 || volume+o.Volume <= bb.V) {
+		bb.take[i] = true
+		bb.search(i+1, weight+o.Weight, volume+o.Volume, value+bb.values[i])
+		bb.take[i] = false
+	}
+	bb.search(i+1, weight, volume, value)
+}
+
+// 探索の前に、並べた順に詰められるだけ詰めた解を最良の解にしておく
+// 最初から上界と近い解があるので、探索の早い段階から枝を刈れる
+func (bb *branchAndBound) seedGreedy() {
+	weight, volume := 0, 0
+	for i, o := range bb.items {
+		if weight+o.Weight <= bb.W && (bb.V == 0 || volume+o.Volume <= bb.V) {
+			bb.best[i] = true
+			bb.bestValue += bb.values[i]
+			weight += o.Weight
+			volume += o.Volume
+		}
+	}
+}
+
+// i 番目以降を重さだけを考えて分数で詰めた場合の価値の上界 (価値の重さあたりの高い順に並んでいること)
+func (bb *branchAndBound) bound(i, weight, value int) float64 {
+	bound := float64(value)
+	rest := bb.W - weight
+	for ; i < len(bb.items) && rest > 0; i++ {
+		o := bb.items[i]
+		if o.Weight <= rest {
+			rest -= o.Weight
+			bound += float64(bb.values[i])
+			continue
+		}
+		return bound + float64(bb.values[i])*float64(rest)/float64(o.Weight)
+	}
+	return bound
+}
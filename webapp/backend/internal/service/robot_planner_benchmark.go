@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"maps"
+	"math/rand"
+	"slices"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/service/utils"
+)
+
+// 配送計画のベンチマークの入力の上限 (管理者用でも 1 リクエストで重くなりすぎないように)
+const (
+	plannerBenchmarkMaxOrders = 100_000
+	plannerBenchmarkMaxRuns   = 10
+)
+
+const (
+	PlannerBenchmarkSynthetic = "synthetic"
+	PlannerBenchmarkSnapshot  = "snapshot"
+)
+
+// 同じ注文をアルゴリズムごとに解き、価値と所要時間を比べる（管理者用）
+// 注文は更新せず、配送計画の集計にも含めない
+func (s *RobotService) BenchmarkPlanners(ctx context.Context, req model.PlannerBenchmarkRequest) (*model.PlannerBenchmarkResponse, error) {
+	if req.Source == "" {
+		req.Source = PlannerBenchmarkSynthetic
+	}
+	if req.Runs == 0 {
+		req.Runs = 1
+	}
+	if req.Capacity <= 0 || req.VolumeCapacity < 0 || req.TimeBudgetMs < 0 || req.Runs < 0 || req.Runs > plannerBenchmarkMaxRuns {
+		return nil, ErrInvalidRequest
+	}
+	algorithms := req.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = slices.Sorted(maps.Keys(deliveryPlanners))
+	}
+	for _, a := range algorithms {
+		if !ValidDeliveryPlanAlgorithm(a) {
+			return nil, ErrInvalidRequest
+		}
+	}
+
+	var orders []model.Order
+	switch req.Source {
+	case PlannerBenchmarkSynthetic:
+		if req.Orders <= 0 || req.Orders > plannerBenchmarkMaxOrders || req.MaxWeight <= 0 || req.MaxValue <= 0 || req.MaxVolume < 0 {
+			return nil, ErrInvalidRequest
+		}
+		orders = syntheticShippingOrders(req)
+	case PlannerBenchmarkSnapshot:
+		err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+			var err error
+			orders, err = s.store.OrderRepo.GetShippingOrders(ctx, req.Zone)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidRequest
+	}
+
+	if req.TimeBudgetMs > 0 {
+		ctx = withDeliveryPlanTimeBudget(ctx, time.Duration(req.TimeBudgetMs)*time.Millisecond)
+	}
+	resp := &model.PlannerBenchmarkResponse{
+		Source:         req.Source,
+		InputOrders:    len(orders),
+		Capacity:       req.Capacity,
+		VolumeCapacity: req.VolumeCapacity,
+	}
+	for _, algorithm := range algorithms {
+		result, err := benchmarkPlanner(ctx, algorithm, orders, req.Capacity, req.VolumeCapacity, req.Runs)
+		if err != nil {
+			return nil, err
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	best := 0
+	for _, r := range resp.Results {
+		best = max(best, r.TotalValue)
+	}
+	for i := range resp.Results {
+		if best > 0 {
+			resp.Results[i].ValueRatio = float64(resp.Results[i].TotalValue) / float64(best)
+		} else {
+			resp.Results[i].ValueRatio = 1
+		}
+	}
+	return resp, nil
+}
+
+// runs 回解いた所要時間と、最後に解いた計画の価値
+func benchmarkPlanner(ctx context.Context, algorithm string, orders []model.Order, capacity, volumeCapacity, runs int) (model.PlannerBenchmarkResult, error) {
+	result := model.PlannerBenchmarkResult{Algorithm: algorithm}
+	var total time.Duration
+	for run := range runs {
+		planCtx, cancel := deliveryPlanContext(ctx)
+		start := time.Now()
+		plan, err := deliveryPlannerFor(algorithm).Plan(planCtx, orders, "benchmark", capacity, volumeCapacity)
+		elapsed := time.Since(start)
+		cancel()
+		if err != nil {
+			return result, err
+		}
+		ms := float64(elapsed) / float64(time.Millisecond)
+		if run == 0 || ms < result.MinMs {
+			result.MinMs = ms
+		}
+		result.MaxMs = max(result.MaxMs, ms)
+		total += elapsed
+
+		result.PickedOrders = len(plan.Orders)
+		result.TotalWeight = plan.TotalWeight
+		result.TotalVolume = plan.TotalVolume
+		result.TotalValue = plan.TotalValue
+		result.Approximate = plan.Approximate
+	}
+	result.AvgMs = float64(total) / float64(time.Millisecond) / float64(runs)
+	return result, nil
+}
+
+// Seed から再現できる配送中の注文 (1 個単位、優先度と配達希望期間なし)
+func syntheticShippingOrders(req model.PlannerBenchmarkRequest) []model.Order {
+	rng := rand.New(rand.NewSource(req.Seed))
+	orders := make([]model.Order, req.Orders)
+	for i := range orders {
+		orders[i] = model.Order{
+			OrderID: int64(i + 1),
+			Weight:  1 + rng.Intn(req.MaxWeight),
+			Value:   1 + rng.Intn(req.MaxValue),
+		}
+		if req.MaxVolume > 0 {
+			orders[i].Volume = 1 + rng.Intn(req.MaxVolume)
+		}
+	}
+	return orders
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestBenchmarkPlannersComparesAlgorithms(t *testing.T) {
+	before := plannerStatsFor(DeliveryAlgorithmDP)
+	s := &RobotService{}
+	req := model.PlannerBenchmarkRequest{Orders: 200, MaxWeight: 20, MaxValue: 100, Seed: 1, Capacity: 100, Runs: 2}
+	resp, err := s.BenchmarkPlanners(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.InputOrders != 200 || len(resp.Results) != len(deliveryPlanners) {
+		t.Fatalf("resp = %+v, want 200 orders and every algorithm", resp)
+	}
+	var dp model.PlannerBenchmarkResult
+	for _, r := range resp.Results {
+		if r.TotalWeight > req.Capacity || r.ValueRatio > 1 || r.MinMs > r.MaxMs {
+			t.Errorf("%s: invalid result %+v", r.Algorithm, r)
+		}
+		if r.Algorithm == DeliveryAlgorithmDP {
+			dp = r
+		}
+	}
+	if dp.ValueRatio != 1 {
+		t.Fatalf("dp value ratio = %v, want the optimal value", dp.ValueRatio)
+	}
+	if plannerStatsFor(DeliveryAlgorithmDP).Solves != before.Solves {
+		t.Fatal("benchmark runs must not be recorded in planner metrics")
+	}
+
+	again, err := s.BenchmarkPlanners(context.Background(), req)
+	if err != nil || again.Results[0].TotalValue != resp.Results[0].TotalValue {
+		t.Fatal("the same seed must produce the same orders")
+	}
+}
+
+func TestBenchmarkPlannersRejectsInvalidRequests(t *testing.T) {
+	s := &RobotService{}
+	for _, req := range []model.PlannerBenchmarkRequest{
+		{Orders: 10, MaxWeight: 5, MaxValue: 5},
+		{Orders: 10, MaxWeight: 5, MaxValue: 5, Capacity: 10, Algorithms: []string{"simplex"}},
+		{Orders: 0, MaxWeight: 5, MaxValue: 5, Capacity: 10},
+		{Orders: 10, MaxWeight: 5, MaxValue: 5, Capacity: 10, Source: "csv"},
+		{Orders: 10, MaxWeight: 5, MaxValue: 5, Capacity: 10, Runs: plannerBenchmarkMaxRuns + 1},
+	} {
+		if _, err := s.BenchmarkPlanners(context.Background(), req); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%+v: err = %v, want ErrInvalidRequest", req, err)
+		}
+	}
+}
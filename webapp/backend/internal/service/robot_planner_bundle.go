@@ -0,0 +1,55 @@
+package service
+
+import "backend/internal/model"
+
+// DP で区別できない注文 (重さ、容積、スコアが同じ) のまとまり
+type bundleKey struct {
+	weight int
+	volume int
+	score  planScore
+}
+
+func (a planScore) times(k int) planScore {
+	return planScore{urgent: a.urgent * k, value: a.value * k, prio: a.prio * k, early: a.early * k}
+}
+
+// 区別できない注文をまとめ、個数を 1, 2, 4, ... に分けた品物として DP に入れる (二進分割)
+// c 個の注文は log c 個の品物になり、その組み合わせで 0 から c 個までのどの個数も選べる
+// 品物を選んだら、まとめた注文をすべて計画に加える
+func (p *deliveryPlanner) addCollapsed(orders []model.Order) {
+	index := map[bundleKey]int{}
+	var groups [][]model.Order
+	for _, o := range orders {
+		w, vol := o.Weight, p.volume(o)
+		if w <= 0 || vol < 0 || o.Value < 0 || o.Priority < 0 || w > p.W || vol > p.V {
+			continue
+		}
+		key := bundleKey{weight: w, volume: vol, score: p.score(o)}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], o)
+	}
+
+	for _, g := range groups {
+		w, vol, score := g[0].Weight, p.volume(g[0]), p.score(g[0])
+		// 容量に入りきらない分はどう組み合わせても選べない
+		n := min(len(g), p.W/w)
+		if vol > 0 {
+			n = min(n, p.V/vol)
+		}
+		g = g[:n]
+		for k := 1; len(g) > 0; k *= 2 {
+			take := min(k, len(g))
+			if take == 1 {
+				p.addRow(knapRow{order: g[0], weight: w, volume: vol}, score)
+			} else {
+				p.addRow(knapRow{bundle: g[:take:take], weight: w * take, volume: vol * take}, score.times(take))
+			}
+			g = g[take:]
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"backend/internal/model"
+)
+
+func TestCollapsedDeliveryPlanMatchesUncollapsed(t *testing.T) {
+	defer func(collapse bool) { DeliveryPlanCollapseDuplicates = collapse }(DeliveryPlanCollapseDuplicates)
+	rng := rand.New(rand.NewSource(3))
+	// 5 種類の商品の注文が 1 個ずつ
+	products := []model.Order{{Weight: 3, Value: 7}, {Weight: 5, Value: 11}, {Weight: 7, Value: 20}, {Weight: 2, Value: 3}, {Weight: 11, Value: 30}}
+	orders := make([]model.Order, 300)
+	for i := range orders {
+		orders[i] = products[rng.Intn(len(products))]
+		orders[i].OrderID = int64(i + 1)
+	}
+
+	for _, capacity := range []int{1, 10, 57, 100} {
+		DeliveryPlanCollapseDuplicates = false
+		want, _ := bestSelectOrdersForDelivery(context.Background(), orders, "robot", capacity, 0)
+		DeliveryPlanCollapseDuplicates = true
+		got, _ := bestSelectOrdersForDelivery(context.Background(), orders, "robot", capacity, 0)
+
+		if got.TotalValue != want.TotalValue || got.TotalWeight > capacity || got.Approximate {
+			t.Fatalf("capacity %d: collapsed plan = %d/%d, want value %d", capacity, got.TotalValue, got.TotalWeight, want.TotalValue)
+		}
+		seen := map[int64]bool{}
+		weight := 0
+		for _, o := range got.Orders {
+			if seen[o.OrderID] {
+				t.Fatalf("capacity %d: order %d picked twice", capacity, o.OrderID)
+			}
+			seen[o.OrderID] = true
+			weight += o.Weight
+		}
+		if weight != got.TotalWeight {
+			t.Fatalf("capacity %d: total weight = %d, orders weigh %d", capacity, got.TotalWeight, weight)
+		}
+	}
+}
+
+func TestAddCollapsedSplitsDuplicatesIntoPowersOfTwo(t *testing.T) {
+	orders := make([]model.Order, 10)
+	for i := range orders {
+		orders[i] = model.Order{OrderID: int64(i + 1), Weight: 1, Value: 1}
+	}
+	planner := newDeliveryPlanner("robot", 100)
+	planner.addCollapsed(orders)
+
+	// 10 = 1 + 2 + 4 + 3
+	var sizes []int
+	for _, r := range planner.rows {
+		sizes = append(sizes, len(r.orders()))
+	}
+	if len(sizes) != 4 || sizes[0] != 1 || sizes[1] != 2 || sizes[2] != 4 || sizes[3] != 3 {
+		t.Fatalf("bundle sizes = %v, want [1 2 4 3]", sizes)
+	}
+	if plan := planner.plan(); len(plan.Orders) != 10 || plan.TotalValue != 10 {
+		t.Fatalf("plan = %+v, want every order", plan)
+	}
+}
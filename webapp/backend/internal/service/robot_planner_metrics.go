@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"backend/internal/model"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 配送計画を解いた時間のヒストグラムの上限 (ミリ秒、最後のバケットは上限なし)
+var plannerDurationBucketsMs = []int64{1, 5, 10, 50, 100, 500, 1000, 5000, 15000}
+
+// アルゴリズムごとの配送計画の集計 (プロセス起動からの累計)
+// 平均は合計を Solves で割って求める
+type PlannerStats struct {
+	Algorithm string `json:"algorithm"`
+	// 解いた回数と、そのうち打ち切りなどで最適とは限らない計画になった回数
+	Solves      int64 `json:"solves"`
+	Approximate int64 `json:"approximate"`
+	// 同じバージョンの一覧で解いた計画を使い回した回数 (Solves には含めない)
+	CacheHits int64   `json:"cache_hits"`
+	TotalMs   float64 `json:"total_ms"`
+	// 入力の注文 (1 個単位) と選んだ注文の数、選んだ価値の合計
+	InputOrders  int64 `json:"input_orders"`
+	PickedOrders int64 `json:"picked_orders"`
+	Value        int64 `json:"value"`
+	// 積載量と容積に対する使用率の合計 (容積は容積を考えた VolumeSolves 回のみ)
+	WeightUtilization float64 `json:"weight_utilization"`
+	VolumeUtilization float64 `json:"volume_utilization"`
+	VolumeSolves      int64   `json:"volume_solves"`
+	// Buckets[i] は所要時間が BucketsMs[i] ミリ秒以下だった回数 (累積ではない)
+	// 最後の要素は最大のバケットを超えた回数
+	BucketsMs []int64 `json:"buckets_ms"`
+	Buckets   []int64 `json:"buckets"`
+}
+
+type plannerMetrics struct {
+	solves, approximate, cacheHits, totalNanos atomic.Int64
+	inputOrders, pickedOrders, value           atomic.Int64
+	volumeSolves                               atomic.Int64
+	buckets                                    []atomic.Int64
+
+	// 使用率は小数なので mu で足す
+	mu                sync.Mutex
+	weightUtilization float64
+	volumeUtilization float64
+}
+
+var plannerStats sync.Map // algorithm -> *plannerMetrics
+
+func plannerMetricsFor(algorithm string) *plannerMetrics {
+	m, ok := plannerStats.Load(algorithm)
+	if !ok {
+		m, _ = plannerStats.LoadOrStore(algorithm, &plannerMetrics{buckets: make([]atomic.Int64, len(plannerDurationBucketsMs)+1)})
+	}
+	return m.(*plannerMetrics)
+}
+
+// 解いた計画を集計し、span があれば属性に付ける
+func observeDeliveryPlan(ctx context.Context, algorithm string, inputOrders, capacity, volumeCapacity int, plan *model.DeliveryPlan, elapsed time.Duration) {
+	m := plannerMetricsFor(algorithm)
+	m.solves.Add(1)
+	if plan.Approximate {
+		m.approximate.Add(1)
+	}
+	m.totalNanos.Add(int64(elapsed))
+	i, _ := slices.BinarySearch(plannerDurationBucketsMs, elapsed.Milliseconds())
+	m.buckets[i].Add(1)
+	m.inputOrders.Add(int64(inputOrders))
+	m.pickedOrders.Add(int64(len(plan.Orders)))
+	m.value.Add(int64(plan.TotalValue))
+
+	weightUtilization, volumeUtilization := utilization(plan.TotalWeight, capacity), utilization(plan.TotalVolume, volumeCapacity)
+	if volumeCapacity > 0 {
+		m.volumeSolves.Add(1)
+	}
+	m.mu.Lock()
+	m.weightUtilization += weightUtilization
+	m.volumeUtilization += volumeUtilization
+	m.mu.Unlock()
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("delivery_plan.algorithm", algorithm),
+		attribute.Int("delivery_plan.input_orders", inputOrders),
+		attribute.Int("delivery_plan.picked_orders", len(plan.Orders)),
+		attribute.Int("delivery_plan.value", plan.TotalValue),
+		attribute.Int("delivery_plan.capacity", capacity),
+		attribute.Float64("delivery_plan.weight_utilization", weightUtilization),
+		attribute.Float64("delivery_plan.volume_utilization", volumeUtilization),
+		attribute.Bool("delivery_plan.approximate", plan.Approximate),
+		attribute.Int64("delivery_plan.solve_ms", elapsed.Milliseconds()),
+	)
+}
+
+func observeDeliveryPlanCacheHit(ctx context.Context, algorithm string) {
+	plannerMetricsFor(algorithm).cacheHits.Add(1)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("delivery_plan.algorithm", algorithm),
+		attribute.Bool("delivery_plan.cache_hit", true),
+	)
+}
+
+func utilization(used, capacity int) float64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return float64(used) / float64(capacity)
+}
+
+// アルゴリズムごとの集計をアルゴリズム名の順に返す
+func PlannerMetrics() []PlannerStats {
+	var stats []PlannerStats
+	plannerStats.Range(func(key, value any) bool {
+		m := value.(*plannerMetrics)
+		s := PlannerStats{
+			Algorithm:    key.(string),
+			Solves:       m.solves.Load(),
+			Approximate:  m.approximate.Load(),
+			CacheHits:    m.cacheHits.Load(),
+			TotalMs:      float64(m.totalNanos.Load()) / float64(time.Millisecond),
+			InputOrders:  m.inputOrders.Load(),
+			PickedOrders: m.pickedOrders.Load(),
+			Value:        m.value.Load(),
+			VolumeSolves: m.volumeSolves.Load(),
+			BucketsMs:    plannerDurationBucketsMs,
+			Buckets:      make([]int64, len(m.buckets)),
+		}
+		m.mu.Lock()
+		s.WeightUtilization, s.VolumeUtilization = m.weightUtilization, m.volumeUtilization
+		m.mu.Unlock()
+		for i := range m.buckets {
+			s.Buckets[i] = m.buckets[i].Load()
+		}
+		stats = append(stats, s)
+		return true
+	})
+	slices.SortFunc(stats, func(a, b PlannerStats) int { return strings.Compare(a.Algorithm, b.Algorithm) })
+	return stats
+}
+
+// Prometheus のテキスト形式で書き出す
+func WritePlannerMetricsPrometheus(w io.Writer, stats []PlannerStats) error {
+	type counter struct {
+		name, help, typ string
+		value           func(PlannerStats) float64
+	}
+	counters := []counter{
+		{"delivery_planner_solves_total", "Delivery plans solved.", "counter", func(s PlannerStats) float64 { return float64(s.Solves) }},
+		{"delivery_planner_approximate_total", "Delivery plans that may not be optimal.", "counter", func(s PlannerStats) float64 { return float64(s.Approximate) }},
+		{"delivery_planner_cache_hits_total", "Delivery plans reused from the plan cache.", "counter", func(s PlannerStats) float64 { return float64(s.CacheHits) }},
+		{"delivery_planner_input_orders_total", "Order units given to the planner.", "counter", func(s PlannerStats) float64 { return float64(s.InputOrders) }},
+		{"delivery_planner_picked_orders_total", "Order units chosen by the planner.", "counter", func(s PlannerStats) float64 { return float64(s.PickedOrders) }},
+		{"delivery_planner_value_total", "Total value of chosen orders.", "counter", func(s PlannerStats) float64 { return float64(s.Value) }},
+		{"delivery_planner_weight_utilization_sum", "Sum of chosen weight divided by capacity.", "counter", func(s PlannerStats) float64 { return s.WeightUtilization }},
+		{"delivery_planner_volume_utilization_sum", "Sum of chosen volume divided by volume capacity.", "counter", func(s PlannerStats) float64 { return s.VolumeUtilization }},
+		{"delivery_planner_volume_solves_total", "Delivery plans solved with a volume capacity.", "counter", func(s PlannerStats) float64 { return float64(s.VolumeSolves) }},
+	}
+	for _, c := range counters {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.typ); err != nil {
+			return err
+		}
+		for _, s := range stats {
+			if _, err := fmt.Fprintf(w, "%s{algorithm=%q} %g\n", c.name, s.Algorithm, c.value(s)); err != nil {
+				return err
+			}
+		}
+	}
+
+	const histogram = "delivery_planner_duration_seconds"
+	if _, err := fmt.Fprintf(w, "# HELP %s Time spent solving delivery plans.\n# TYPE %s histogram\n", histogram, histogram); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		var cumulative int64
+		for i, le := range s.BucketsMs {
+			cumulative += s.Buckets[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket{algorithm=%q,le=\"%g\"} %d\n", histogram, s.Algorithm, float64(le)/1000, cumulative); err != nil {
+				return err
+			}
+		}
+		cumulative += s.Buckets[len(s.Buckets)-1]
+		if _, err := fmt.Fprintf(w, "%s_bucket{algorithm=%q,le=\"+Inf\"} %d\n%s_sum{algorithm=%q} %g\n%s_count{algorithm=%q} %d\n",
+			histogram, s.Algorithm, cumulative, histogram, s.Algorithm, s.TotalMs/1000, histogram, s.Algorithm, s.Solves); err != nil {
+			return err
+		}
+	}
+	return nil
+}
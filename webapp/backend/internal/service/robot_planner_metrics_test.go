@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func plannerStatsFor(algorithm string) PlannerStats {
+	for _, s := range PlannerMetrics() {
+		if s.Algorithm == algorithm {
+			return s
+		}
+	}
+	return PlannerStats{}
+}
+
+func TestSolveDeliveryPlanRecordsPlannerMetrics(t *testing.T) {
+	before := plannerStatsFor(DeliveryAlgorithmGreedy)
+
+	db := newReturnOrderDB(&model.Order{OrderID: 1, Weight: 4, Value: 5}, 0)
+	s := NewRobotService(repository.NewStore(db))
+	for range 2 {
+		if _, err := s.solveDeliveryPlan(context.Background(), context.Background(), s.store, "robot", model.DeliveryPlanParams{Capacity: 10, Algorithm: DeliveryAlgorithmGreedy}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	after := plannerStatsFor(DeliveryAlgorithmGreedy)
+	if after.Solves-before.Solves != 1 || after.CacheHits-before.CacheHits != 1 {
+		t.Fatalf("solves %d, cache hits %d; want one solve and one cache hit", after.Solves-before.Solves, after.CacheHits-before.CacheHits)
+	}
+	if after.InputOrders-before.InputOrders != 1 || after.Value-before.Value != 5 {
+		t.Fatalf("stats = %+v, want one input order of value 5", after)
+	}
+	if got := after.WeightUtilization - before.WeightUtilization; got < 0.39 || got > 0.41 {
+		t.Fatalf("weight utilization = %v, want 0.4", got)
+	}
+}
+
+func TestWritePlannerMetricsPrometheus(t *testing.T) {
+	stats := []PlannerStats{{
+		Algorithm: "dp", Solves: 3, TotalMs: 1500,
+		BucketsMs: []int64{1, 1000}, Buckets: []int64{1, 1, 1},
+	}}
+	var b strings.Builder
+	if err := WritePlannerMetricsPrometheus(&b, stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`delivery_planner_solves_total{algorithm="dp"} 3`,
+		`delivery_planner_duration_seconds_bucket{algorithm="dp",le="1"} 2`,
+		`delivery_planner_duration_seconds_bucket{algorithm="dp",le="+Inf"} 3`,
+		`delivery_planner_duration_seconds_sum{algorithm="dp"} 1.5`,
+		`delivery_planner_duration_seconds_count{algorithm="dp"} 3`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, b.String())
+		}
+	}
+}
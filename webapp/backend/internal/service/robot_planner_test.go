@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"backend/internal/model"
+)
+
+// すべての組を試した価値の合計の最大
+func bruteForceDeliveryValue(orders []model.Order, W, V int) int {
+	best := 0
+	for mask := 0; mask < 1<<len(orders); mask++ {
+		weight, volume, value := 0, 0, 0
+		for i, o := range orders {
+			if mask&(1<<i) != 0 {
+				weight += o.Weight
+				volume += o.Volume
+				value += o.Value
+			}
+		}
+		if weight <= W && (V == 0 || volume <= V) {
+			best = max(best, value)
+		}
+	}
+	return best
+}
+
+func TestDeliveryPlannersProduceFeasiblePlans(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		orders := make([]model.Order, 12)
+		for i := range orders {
+			orders[i] = model.Order{OrderID: int64(i + 1), Weight: 1 + rng.Intn(10), Volume: 1 + rng.Intn(10), Value: rng.Intn(100)}
+		}
+		W, V := 10+rng.Intn(20), 0
+		if trial%2 == 1 {
+			V = 10 + rng.Intn(20)
+		}
+		want := bruteForceDeliveryValue(orders, W, V)
+
+		for algorithm, planner := range deliveryPlanners {
+			plan, err := planner.Plan(context.Background(), orders, "robot", W, V)
+			if err != nil {
+				t.Fatalf("%s: %v", algorithm, err)
+			}
+			weight, volume, value := 0, 0, 0
+			seen := map[int64]bool{}
+			for _, o := range plan.Orders {
+				if seen[o.OrderID] {
+					t.Fatalf("%s: order %d picked twice", algorithm, o.OrderID)
+				}
+				seen[o.OrderID] = true
+				weight += o.Weight
+				volume += o.Volume
+				value += o.Value
+			}
+			if weight != plan.TotalWeight || volume != plan.TotalVolume || value != plan.TotalValue {
+				t.Fatalf("%s: totals = %+v, want weight %d volume %d value %d", algorithm, plan, weight, volume, value)
+			}
+			if weight > W || (V > 0 && volume > V) || value > want {
+				t.Fatalf("%s: plan %+v exceeds capacity %d/%d or optimum %d", algorithm, plan, W, V, want)
+			}
+
+			switch algorithm {
+			case DeliveryAlgorithmDP, DeliveryAlgorithmBranchAndBound:
+				if value != want {
+					t.Fatalf("%s: value = %d, want optimum %d (W %d, V %d)", algorithm, value, want, W, V)
+				}
+			case DeliveryAlgorithmFPTAS:
+				if V == 0 && float64(value) < (1-DeliveryPlanFPTASEpsilon)*float64(want) {
+					t.Fatalf("fptas: value = %d, want at least %.0f", value, (1-DeliveryPlanFPTASEpsilon)*float64(want))
+				}
+			}
+		}
+	}
+}
+
+func TestDeliveryPlannerFor(t *testing.T) {
+	if _, ok := deliveryPlannerFor(DeliveryAlgorithmGreedy).(greedyPlanner); !ok {
+		t.Fatal("greedy should select greedyPlanner")
+	}
+	if _, ok := deliveryPlannerFor("").(dpPlanner); !ok {
+		t.Fatal("empty algorithm should fall back to DeliveryPlanAlgorithm")
+	}
+	if ValidDeliveryPlanAlgorithm("simplex") {
+		t.Fatal("unknown algorithm should be invalid")
+	}
+}
+
+// 注文が多くても、上界で枝を刈れば打ち切らずに DP と同じ最適解になる
+func TestBranchAndBoundSolvesLargeInstancesExactly(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	orders := make([]model.Order, 2000)
+	for i := range orders {
+		orders[i] = model.Order{OrderID: int64(i + 1), Weight: 1 + rng.Intn(30), Value: 1 + rng.Intn(1000)}
+	}
+	want, err := dpPlanner{}.Plan(context.Background(), orders, "robot", 500, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := branchAndBoundPlanner{}.Plan(context.Background(), orders, "robot", 500, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Approximate || got.TotalValue != want.TotalValue {
+		t.Fatalf("branch-and-bound = %d (approximate %v), want optimum %d", got.TotalValue, got.Approximate, want.TotalValue)
+	}
+}
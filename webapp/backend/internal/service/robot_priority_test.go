@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func pickedOrderIDs(plan model.DeliveryPlan) map[int64]bool {
+	ids := map[int64]bool{}
+	for _, o := range plan.Orders {
+		ids[o.OrderID] = true
+	}
+	return ids
+}
+
+func TestDeliveryPlanPrefersPriorityOnTie(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 100},
+		{OrderID: 2, Weight: 5, Value: 100, Priority: 3},
+		{OrderID: 3, Weight: 5, Value: 100},
+	}
+	plan, err := bestSelectOrdersForDelivery(context.Background(), orders, "r1", 5, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := pickedOrderIDs(plan); len(ids) != 1 || !ids[2] {
+		t.Fatalf("picked %v, want the high-priority order among equal values", ids)
+	}
+	if plan.TotalValue != 100 {
+		t.Fatalf("total value = %d, want the plain value without the priority bonus", plan.TotalValue)
+	}
+}
+
+func TestDeliveryPlanPriorityDoesNotOutweighValue(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 101},
+		{OrderID: 2, Weight: 5, Value: 100, Priority: 9},
+	}
+	plan, _ := bestSelectOrdersForDelivery(context.Background(), orders, "r1", 5, 0)
+	if ids := pickedOrderIDs(plan); !ids[1] {
+		t.Fatalf("picked %v, want the more valuable order with the default weight", ids)
+	}
+
+	DeliveryPriorityWeight = 1
+	t.Cleanup(func() { DeliveryPriorityWeight = 0 })
+	plan, _ = bestSelectOrdersForDelivery(context.Background(), orders, "r1", 5, 0)
+	if ids := pickedOrderIDs(plan); !ids[2] {
+		t.Fatalf("picked %v, want the priority bonus to win with a positive weight", ids)
+	}
+}
+
+func TestCreateOrdersRejectsInvalidPriority(t *testing.T) {
+	s := NewProductService(repository.NewStore(&fakeDB{}))
+	for _, priority := range []int{-1, MaxOrderPriority + 1} {
+		items := []model.RequestItem{{ProductID: 1, Quantity: 1, Priority: priority}}
+		if _, err := s.CreateOrders(context.Background(), 1, items, ""); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("priority %d: err = %v, want ErrInvalidRequest", priority, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"backend/internal/model"
+)
+
+// 配送計画を待つロボットが、他インスタンスでの配送中一覧の更新に気づくまでの最大の間隔
+var RobotPushPollInterval = 5 * time.Second
+
+// 空でない配送計画が作れるまで待って返す (ctx が終われば ctx.Err() を返す)
+// 配送中一覧のバージョンが進むたび (他インスタンスでの更新は RobotPushPollInterval ごと) に作り直す
+func (s *RobotService) WaitDeliveryPlan(ctx context.Context, robotID string, params model.DeliveryPlanParams) (*model.DeliveryPlan, error) {
+	for {
+		// 計画を作る前に待ち始め、作っている間の更新を取りこぼさない
+		changed := s.store.OrderRepo.ShippingOrdersChanged()
+		plan, err := s.GenerateDeliveryPlan(ctx, robotID, params)
+		if err == nil && len(plan.Orders) > 0 {
+			return plan, nil
+		}
+		// 競合した場合は、先に配送中にした計画のコミットでバージョンが進むのを待って作り直す
+		// 同時に作れる計画の上限に達していた場合も、切断せずに待ってから作り直す
+		if err != nil && !errors.Is(err, ErrOrderConflict) && !errors.Is(err, ErrDeliveryPlanBusy) {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+
+		timer := time.NewTimer(RobotPushPollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestWaitDeliveryPlanRetriesUntilOrdersAppear(t *testing.T) {
+	defer func(interval time.Duration, size int) {
+		RobotPushPollInterval, DeliveryPlanCacheSize = interval, size
+	}(RobotPushPollInterval, DeliveryPlanCacheSize)
+	RobotPushPollInterval = 10 * time.Millisecond
+	DeliveryPlanCacheSize = 0
+
+	// 最初の配送中一覧の読み込みだけ空にする
+	var selects atomic.Int32
+	db := newReturnOrderDB(&model.Order{OrderID: 1, Weight: 2, Value: 5}, 1)
+	sel := db.sel
+	db.sel = func(ctx context.Context, dest any, query string, args ...any) error {
+		if _, ok := dest.(*[]model.Order); ok && selects.Add(1) == 1 {
+			return nil
+		}
+		return sel(ctx, dest, query, args...)
+	}
+	s := NewRobotService(repository.NewStore(db))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	plan, err := s.WaitDeliveryPlan(ctx, "robot", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Orders) != 1 || selects.Load() < 2 {
+		t.Fatalf("plan = %+v after %d reads, want the order read on retry", plan, selects.Load())
+	}
+}
+
+func TestWaitDeliveryPlanStopsWhenCancelled(t *testing.T) {
+	s := NewRobotService(repository.NewStore(newReturnOrderDB(nil, 0)))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := s.WaitDeliveryPlan(ctx, "robot", model.DeliveryPlanParams{Capacity: 10}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestWaitDeliveryPlanRetriesWhenSlotsAreFull(t *testing.T) {
+	defer func(interval time.Duration, size int) {
+		RobotPushPollInterval, DeliveryPlanCacheSize = interval, size
+	}(RobotPushPollInterval, DeliveryPlanCacheSize)
+	RobotPushPollInterval = 10 * time.Millisecond
+	DeliveryPlanCacheSize = 0
+
+	s := NewRobotService(repository.NewStore(newReturnOrderDB(&model.Order{OrderID: 1, Weight: 2, Value: 5}, 1)))
+	s.planSlots = make(chan struct{}, 1)
+	s.planSlots <- struct{}{}
+	time.AfterFunc(30*time.Millisecond, func() { <-s.planSlots })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	plan, err := s.WaitDeliveryPlan(ctx, "robot", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil {
+		t.Fatalf("err = %v, want a plan once a slot is free", err)
+	}
+	if len(plan.Orders) != 1 {
+		t.Fatalf("plan = %+v, want the order", plan)
+	}
+}
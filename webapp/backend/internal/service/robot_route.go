@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 2-opt で改善するルートの立ち寄り先の上限 (1 回の改善で O(n^2))
+// 超えたら最近傍法の順番のまま返す
+const deliveryRouteTwoOptMaxStops = 300
+
+// 2-opt で改善を繰り返す回数の上限
+const deliveryRouteTwoOptPasses = 8
+
+type geoPoint struct {
+	lat, lng float64
+}
+
+// 大円距離 (m)
+func haversine(a, b geoPoint) float64 {
+	const earthRadius = 6371000.0
+	toRad := math.Pi / 180
+	dLat := (b.lat - a.lat) * toRad
+	dLng := (b.lng - a.lng) * toRad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.lat*toRad)*math.Cos(b.lat*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// ロボットが最後に報告した現在地 (報告がなければ nil)
+func robotLocation(ctx context.Context, txStore *repository.Store, robotID string) (*geoPoint, error) {
+	telemetry, err := txStore.RobotRepo.GetTelemetry(ctx, robotID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &geoPoint{lat: telemetry.Latitude, lng: telemetry.Longitude}, nil
+}
+
+// 計画で選んだ注文を回る順番を決める
+// start (nil なら最初の立ち寄り先は自由) から最近傍法で並べ、2-opt で交差をほどく
+func planRoute(orders []model.Order, start *geoPoint) []model.RouteStop {
+	// 同じ明細の単位は 1 か所にまとめる (計画で最初に現れた順)
+	type stopRef struct {
+		located bool
+		i       int
+	}
+	var located, unlocated []model.RouteStop
+	index := make(map[int64]stopRef, len(orders))
+	for _, o := range orders {
+		if ref, ok := index[o.OrderID]; ok {
+			if ref.located {
+				located[ref.i].Units++
+			} else {
+				unlocated[ref.i].Units++
+			}
+			continue
+		}
+		stop := model.RouteStop{OrderID: o.OrderID, Units: 1, Latitude: o.DestLatitude, Longitude: o.DestLongitude}
+		if o.DestLatitude != nil && o.DestLongitude != nil {
+			index[o.OrderID] = stopRef{located: true, i: len(located)}
+			located = append(located, stop)
+		} else {
+			index[o.OrderID] = stopRef{i: len(unlocated)}
+			unlocated = append(unlocated, stop)
+		}
+	}
+
+	points := make([]geoPoint, len(located))
+	for i, stop := range located {
+		points[i] = geoPoint{lat: *stop.Latitude, lng: *stop.Longitude}
+	}
+	order := nearestNeighborRoute(points, start)
+	if len(order) <= deliveryRouteTwoOptMaxStops {
+		twoOptRoute(order, points, start)
+	}
+
+	route := make([]model.RouteStop, 0, len(located)+len(unlocated))
+	for _, i := range order {
+		route = append(route, located[i])
+	}
+	return append(route, unlocated...)
+}
+
+// start から、まだ立ち寄っていない最も近い点を順に選ぶ
+func nearestNeighborRoute(points []geoPoint, start *geoPoint) []int {
+	n := len(points)
+	order := make([]int, 0, n)
+	visited := make([]bool, n)
+	if n == 0 {
+		return order
+	}
+	cur := 0
+	if start == nil {
+		visited[0] = true
+		order = append(order, 0)
+	}
+	for len(order) < n {
+		from := *start
+		if len(order) > 0 {
+			from = points[cur]
+		}
+		best, bestDist := -1, math.Inf(1)
+		for i, p := range points {
+			if visited[i] {
+				continue
+			}
+			if d := haversine(from, p); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		visited[best] = true
+		order = append(order, best)
+		cur = best
+	}
+	return order
+}
+
+// 区間を反転して短くなる限り繰り返す (帰りは考えない片道のルート)
+func twoOptRoute(order []int, points []geoPoint, start *geoPoint) {
+	n := len(order)
+	// i 番目の手前の点 (先頭なら start、start がなければ距離 0)
+	dist := func(i, j int) float64 {
+		if i < 0 {
+			if start == nil {
+				return 0
+			}
+			return haversine(*start, points[order[j]])
+		}
+		return haversine(points[order[i]], points[order[j]])
+	}
+	for pass := 0; pass < deliveryRouteTwoOptPasses; pass++ {
+		improved := false
+		for i := 0; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				// [i, j] を反転すると、i-1 → i と j → j+1 が i-1 → j と i → j+1 に替わる
+				before := dist(i-1, i)
+				after := dist(i-1, j)
+				if j+1 < n {
+					before += dist(j, j+1)
+					after += dist(i, j+1)
+				}
+				if after < before-1e-9 {
+					for l, r := i, j; l < r; l, r = l+1, r-1 {
+						order[l], order[r] = order[r], order[l]
+					}
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			return
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/samber/lo"
+)
+
+func TestPlanRouteVisitsNearestFirst(t *testing.T) {
+	at := func(id int64, lng float64) model.Order {
+		lat := 35.0
+		return model.Order{OrderID: id, DestLatitude: &lat, DestLongitude: &lng}
+	}
+	orders := []model.Order{
+		at(3, 139.03),
+		{OrderID: 9}, // 配送先なし
+		at(1, 139.01),
+		at(3, 139.03),
+		at(2, 139.02),
+	}
+	route := planRoute(orders, &geoPoint{lat: 35.0, lng: 139.0})
+
+	ids := lo.Map(route, func(s model.RouteStop, _ int) int64 { return s.OrderID })
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3, 9}) {
+		t.Fatalf("route = %v, want nearest first and the order without destination last", ids)
+	}
+	if route[2].Units != 2 || route[3].Latitude != nil {
+		t.Fatalf("route = %+v, want the units of order 3 merged", route)
+	}
+}
+
+func TestTwoOptRouteUncrossesPath(t *testing.T) {
+	points := []geoPoint{{0, 0}, {0, 0.01}, {0, 0.02}, {0, 0.03}}
+	order := []int{0, 2, 1, 3}
+	twoOptRoute(order, points, nil)
+	if !reflect.DeepEqual(order, []int{0, 1, 2, 3}) {
+		t.Fatalf("order = %v, want the straight path", order)
+	}
+}
+
+func TestValidDestination(t *testing.T) {
+	defer func(enabled bool) { repository.OrderDestinationEnabled = enabled }(repository.OrderDestinationEnabled)
+	lat, lng, far := 35.0, 139.0, 200.0
+
+	repository.OrderDestinationEnabled = false
+	if !validDestination(nil, nil) || validDestination(&lat, &lng) {
+		t.Fatal("destinations must be rejected unless enabled")
+	}
+	repository.OrderDestinationEnabled = true
+	if !validDestination(&lat, &lng) || validDestination(&lat, nil) || validDestination(&lat, &far) {
+		t.Fatal("destination must have both coordinates in range")
+	}
+}
@@ -0,0 +1,643 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+
+	"github.com/samber/lo"
+)
+
+func TestDeliveryPlannerDeprioritizesOrdersBeforeWindow(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+
+	planner := newDeliveryPlanner("robot", 1)
+	planner.add(model.Order{OrderID: 1, Weight: 1, Value: 10, DeliverAfter: &later})
+	planner.add(model.Order{OrderID: 2, Weight: 1, Value: 10})
+	plan := planner.plan()
+	if len(plan.Orders) != 1 || plan.Orders[0].OrderID != 2 {
+		t.Fatalf("orders = %v, want the order whose window is open", plan.Orders)
+	}
+}
+
+func TestDeliveryPlannerIgnoresPriorityBeforeWindow(t *testing.T) {
+	defer func(w int) { DeliveryPriorityWeight = w }(DeliveryPriorityWeight)
+	DeliveryPriorityWeight = 100
+	later := time.Now().Add(time.Hour)
+
+	planner := newDeliveryPlanner("robot", 1)
+	planner.add(model.Order{OrderID: 1, Weight: 1, Value: 10, Priority: 9, DeliverAfter: &later})
+	planner.add(model.Order{OrderID: 2, Weight: 1, Value: 20})
+	plan := planner.plan()
+	if len(plan.Orders) != 1 || plan.Orders[0].OrderID != 2 {
+		t.Fatalf("orders = %v, want the higher value order", plan.Orders)
+	}
+}
+
+func TestDeliveryPlannerReconstructsFromBitRows(t *testing.T) {
+	// 容量 100 でビット列が 64 を跨ぐ
+	planner := newDeliveryPlanner("robot", 100)
+	planner.add(model.Order{OrderID: 1, Weight: 30, Value: 30})
+	planner.add(model.Order{OrderID: 2, Weight: 101, Value: 999}) // 容量超過で行を持たない
+	planner.add(model.Order{OrderID: 3, Weight: 70, Value: 80})
+	planner.add(model.Order{OrderID: 4, Weight: 40, Value: 10}) // どのマスも更新しない
+	planner.add(model.Order{OrderID: 5, Weight: 35, Value: 5})  // 重さ 65-69 だけ更新するが最適解には入らない
+	plan := planner.plan()
+
+	ids := lo.Map(plan.Orders, func(o model.Order, _ int) int64 { return o.OrderID })
+	if !reflect.DeepEqual(ids, []int64{3, 1}) || plan.TotalWeight != 100 || plan.TotalValue != 110 {
+		t.Fatalf("plan = %+v (orders %v), want orders 3 and 1", plan, ids)
+	}
+	rows := lo.Map(planner.rows, func(r knapRow, _ int) int64 { return r.order.OrderID })
+	if !reflect.DeepEqual(rows, []int64{1, 3, 5}) {
+		t.Fatalf("rows = %v, want only orders that updated dp", rows)
+	}
+}
+
+func TestDeliveryPlannerFallsBackToGreedyOverBudget(t *testing.T) {
+	defer func(budget int) { DeliveryPlanDPBudget = budget }(DeliveryPlanDPBudget)
+	// 容量 10 で重さ 6 の注文 1 件分 (5 マス) だけ DP で解く
+	DeliveryPlanDPBudget = 5
+
+	planner := newDeliveryPlanner("robot", 10)
+	planner.add(model.Order{OrderID: 1, Weight: 6, Value: 6})
+	planner.add(model.Order{OrderID: 2, Weight: 4, Value: 2})
+	planner.add(model.Order{OrderID: 3, Weight: 4, Value: 8})
+	planner.add(model.Order{OrderID: 4, Weight: 5, Value: 50}) // 残り容量 4 に入らない
+	plan := planner.plan()
+
+	ids := lo.Map(plan.Orders, func(o model.Order, _ int) int64 { return o.OrderID })
+	if !plan.Approximate || !reflect.DeepEqual(ids, []int64{1, 3}) || plan.TotalWeight != 10 || plan.TotalValue != 14 {
+		t.Fatalf("plan = %+v (orders %v), want DP pick 1 and greedy pick 3", plan, ids)
+	}
+}
+
+func TestBestSelectOrdersForDeliverySortsByDensityOverBudget(t *testing.T) {
+	defer func(budget int) { DeliveryPlanDPBudget = budget }(DeliveryPlanDPBudget)
+	orders := []model.Order{
+		{OrderID: 1, Weight: 5, Value: 5},
+		{OrderID: 2, Weight: 5, Value: 40},
+		{OrderID: 3, Weight: 5, Value: 20},
+	}
+
+	DeliveryPlanDPBudget = 0
+	exact, _ := bestSelectOrdersForDelivery(context.Background(), orders, "robot", 10, 0)
+	DeliveryPlanDPBudget = 6 // 1 件分だけ DP で解く
+	approx, _ := bestSelectOrdersForDelivery(context.Background(), orders, "robot", 10, 0)
+
+	if exact.Approximate || exact.TotalValue != 60 {
+		t.Fatalf("exact plan = %+v, want value 60", exact)
+	}
+	if !approx.Approximate || approx.TotalValue != 60 || approx.Orders[0].OrderID != 2 {
+		t.Fatalf("approximate plan = %+v, want the densest order solved by DP first", approx)
+	}
+	if orders[0].OrderID != 1 {
+		t.Fatal("input orders must not be reordered")
+	}
+}
+
+func TestBestSelectOrdersForDeliveryStopsDPWhenCancelled(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 6, Value: 6},
+		{OrderID: 2, Weight: 5, Value: 5},
+		{OrderID: 3, Weight: 5, Value: 5},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// 打ち切った時点の解 (何も選んでいない) に、価値密度の高い順に詰める
+	plan, err := bestSelectOrdersForDelivery(ctx, orders, "robot", 10, 0)
+	if err != nil {
+		t.Fatalf("bestSelectOrdersForDelivery: %v", err)
+	}
+	ids := lo.Map(plan.Orders, func(o model.Order, _ int) int64 { return o.OrderID })
+	if !plan.Approximate || !reflect.DeepEqual(ids, []int64{1}) {
+		t.Fatalf("plan = %+v (orders %v), want an approximate greedy plan", plan, ids)
+	}
+
+	exact, _ := bestSelectOrdersForDelivery(context.Background(), orders, "robot", 10, 0)
+	if exact.Approximate || exact.TotalValue != 10 {
+		t.Fatalf("exact plan = %+v, want orders 2 and 3", exact)
+	}
+}
+
+func TestDeliveryPlannerChecksDonePeriodically(t *testing.T) {
+	done := make(chan struct{})
+	planner := newDeliveryPlanner("robot", 1000)
+	planner.done = done
+	planner.add(model.Order{OrderID: 0, Weight: 1, Value: 1})
+	close(done)
+	for i := 1; i <= deliveryPlanCheckInterval; i++ {
+		planner.add(model.Order{OrderID: int64(i), Weight: 1, Value: 1})
+	}
+	// 閉じた後も次の確認までは DP で解く
+	if len(planner.rest) != 1 || planner.rest[0].OrderID != deliveryPlanCheckInterval {
+		t.Fatalf("rest = %v, want only the order after the next check", planner.rest)
+	}
+}
+
+// 計画 ID の一覧には expired を、明細の一覧 (計画の明細やロックできた明細) には items を返し、更新は affected 件とする DBTX
+func newLeaseDB(affected int64, expired []int64, items []model.OrderVersion) *fakeDB {
+	db := newReturnOrderDB(nil, affected)
+	db.sel = func(_ context.Context, dest any, _ string, _ ...any) error {
+		switch dest := dest.(type) {
+		case *[]int64:
+			*dest = expired
+		case *[]model.OrderVersion:
+			*dest = items
+		}
+		return nil
+	}
+	return db
+}
+
+func TestReleaseExpiredDeliveryPlans(t *testing.T) {
+	db := newLeaseDB(1, []int64{4, 5}, []model.OrderVersion{{OrderID: 10, Quantity: 2}})
+	plans, reverted, err := releaseExpiredDeliveryPlans(context.Background(), repository.NewStore(db), time.Now(), 10)
+	if err != nil || plans != 2 || reverted != 1 {
+		t.Fatalf("release = %d plans, %d items, %v", plans, reverted, err)
+	}
+	// 未配送に戻してから計画を解放済みにする
+	if len(db.calls) != 2 || !strings.Contains(db.calls[0].query, "LEAST(v.quantity") || !strings.Contains(db.calls[1].query, "released_at = NOW()") {
+		t.Fatalf("execs = %v", db.queries())
+	}
+
+	db = newLeaseDB(0, nil, nil)
+	if plans, _, err := releaseExpiredDeliveryPlans(context.Background(), repository.NewStore(db), time.Now(), 10); err != nil || plans != 0 || len(db.calls) != 0 {
+		t.Fatalf("release without expired plans = %d, %v (execs %v)", plans, err, db.queries())
+	}
+}
+
+func TestAcknowledgeDeliveryPlanNotFound(t *testing.T) {
+	s := NewRobotService(repository.NewStore(newReturnOrderDB(nil, 0)))
+	if err := s.AcknowledgeDeliveryPlan(context.Background(), "robot", 1); !errors.Is(err, ErrDeliveryPlanNotFound) {
+		t.Fatalf("err = %v, want ErrDeliveryPlanNotFound", err)
+	}
+}
+
+// robot を登録済みのロボットとして返す DBTX (nil なら未登録)
+func newRobotDB(robot *model.Robot) *fakeDB {
+	db := newReturnOrderDB(nil, 0)
+	db.get = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if r, ok := dest.(*model.Robot); ok {
+			if robot == nil {
+				return sql.ErrNoRows
+			}
+			*r = *robot
+		}
+		return nil
+	}
+	return db
+}
+
+func TestDeliveryCapacity(t *testing.T) {
+	defer func(enabled bool) { RobotRegistryEnabled = enabled }(RobotRegistryEnabled)
+	registered := &model.Robot{RobotID: "robot", Capacity: 50, State: model.RobotStateIdle}
+	offline := &model.Robot{RobotID: "robot", Capacity: 50, State: model.RobotStateOffline}
+
+	tests := []struct {
+		name           string
+		enabled        bool
+		robot          *model.Robot
+		capacity       int
+		wantCapacity   int
+		wantRegistered bool
+		wantErr        error
+	}{
+		{"registry disabled", false, registered, 80, 80, false, nil},
+		{"registry disabled without capacity", false, registered, 0, 0, false, ErrInvalidRequest},
+		{"registered default", true, registered, 0, 50, true, nil},
+		{"registered over capacity", true, registered, 80, 50, true, nil},
+		{"registered under capacity", true, registered, 30, 30, true, nil},
+		{"offline", true, offline, 30, 0, false, ErrRobotOffline},
+		{"unregistered", true, nil, 10, 10, false, nil},
+		{"unregistered without capacity", true, nil, 0, 0, false, ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RobotRegistryEnabled = tt.enabled
+			capacity, volumeCapacity := tt.capacity, 0
+			got, err := deliveryCapacity(context.Background(), repository.NewStore(newRobotDB(tt.robot)), "robot", &capacity, &volumeCapacity)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && (capacity != tt.wantCapacity || got != tt.wantRegistered) {
+				t.Fatalf("capacity = %d, registered = %v, want %d, %v", capacity, got, tt.wantCapacity, tt.wantRegistered)
+			}
+		})
+	}
+}
+
+func TestRegisterRobotValidation(t *testing.T) {
+	defer func(enabled bool) { RobotRegistryEnabled = enabled }(RobotRegistryEnabled)
+	s := NewRobotService(repository.NewStore(newRobotDB(nil)))
+
+	RobotRegistryEnabled = false
+	if _, err := s.RegisterRobot(context.Background(), "robot", model.RegisterRobotRequest{Capacity: 10}); !errors.Is(err, ErrRobotRegistryDisabled) {
+		t.Fatalf("err = %v, want ErrRobotRegistryDisabled", err)
+	}
+
+	RobotRegistryEnabled = true
+	for _, req := range []model.RegisterRobotRequest{{Capacity: 0}, {Capacity: 10, State: "sleeping"}} {
+		if _, err := s.RegisterRobot(context.Background(), "robot", req); !errors.Is(err, ErrInvalidRequest) {
+			t.Fatalf("RegisterRobot(%+v) err = %v, want ErrInvalidRequest", req, err)
+		}
+	}
+	if err := s.UpdateRobotState(context.Background(), "robot", model.RobotStateOffline); !errors.Is(err, ErrRobotNotFound) {
+		t.Fatalf("UpdateRobotState err = %v, want ErrRobotNotFound", err)
+	}
+}
+
+func TestSolveDeliveryPlanReusesPlanOfSameVersion(t *testing.T) {
+	db := newReturnOrderDB(&model.Order{OrderID: 1, Weight: 2, Value: 5}, 1)
+	store := repository.NewStore(db)
+	s := NewRobotService(store)
+	solve := func(robotID string, capacity int) model.DeliveryPlan {
+		t.Helper()
+		plan, err := s.solveDeliveryPlan(context.Background(), context.Background(), store, robotID, model.DeliveryPlanParams{Capacity: capacity, Algorithm: DeliveryAlgorithmDP})
+		if err != nil {
+			t.Fatalf("solveDeliveryPlan: %v", err)
+		}
+		return plan
+	}
+
+	if plan := solve("robot-1", 10); plan.TotalValue != 5 || s.plans.Len() != 1 {
+		t.Fatalf("plan = %+v, cached = %d", plan, s.plans.Len())
+	}
+	// 使い回したことが分かるように書き換えておく
+	key := s.plans.Keys()[0]
+	cached, _ := s.plans.Peek(key)
+	cached.TotalValue = 999
+	s.plans.Add(key, cached)
+	if plan := solve("robot-2", 10); plan.TotalValue != 999 || plan.RobotID != "robot-2" {
+		t.Fatalf("plan = %+v, want the cached plan for robot-2", plan)
+	}
+	if plan := solve("robot-2", 1); plan.TotalValue != 0 {
+		t.Fatalf("plan = %+v, want a plan solved for the other capacity", plan)
+	}
+
+	// 配送中一覧が変わったら解き直す
+	if err := store.OrderRepo.MarkReturned(context.Background(), 1, "壊れていた", sql.NullInt64{}); err != nil {
+		t.Fatalf("MarkReturned: %v", err)
+	}
+	if plan := solve("robot-2", 10); plan.TotalValue != 5 {
+		t.Fatalf("plan = %+v, want a plan solved for the new version", plan)
+	}
+}
+
+func TestClaimDeliveryPlanDropsUnitsLockedElsewhere(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Version: 1, Weight: 1, Value: 10},
+		{OrderID: 1, Version: 1, Weight: 1, Value: 10},
+		{OrderID: 2, Version: 1, Weight: 2, Value: 20}, // 他のロボットがロック中
+		{OrderID: 3, Version: 2, Weight: 3, Value: 30}, // 計画中に更新された
+	}
+	plan := model.DeliveryPlan{Orders: orders, TotalWeight: 7, TotalValue: 70}
+	// 明細 1 は残り 1 個しか未配送でない
+	db := newLeaseDB(0, nil, []model.OrderVersion{{OrderID: 1, Version: 1, Quantity: 1}, {OrderID: 3, Version: 3, Quantity: 1}})
+	if err := claimDeliveryPlan(context.Background(), repository.NewStore(db), &plan); err != nil {
+		t.Fatalf("claimDeliveryPlan: %v", err)
+	}
+	ids := lo.Map(plan.Orders, func(o model.Order, _ int) int64 { return o.OrderID })
+	if !reflect.DeepEqual(ids, []int64{1}) || plan.TotalWeight != 1 || plan.TotalValue != 10 || !plan.Approximate {
+		t.Fatalf("plan = %+v, want only one unit of order 1", plan)
+	}
+	if len(orders) != 4 || orders[2].OrderID != 2 {
+		t.Fatalf("original orders were modified: %v", orders)
+	}
+}
+
+func TestRobotUpdateOrderStatuses(t *testing.T) {
+	tests := []struct {
+		name      string
+		orderIDs  []int64
+		newStatus string
+		affected  int64
+		wantErr   error
+	}{
+		{"completed", []int64{1, 1, 2}, "completed", 2, nil},
+		{"insufficient", []int64{1, 2}, "completed", 1, ErrInvalidStatusTransition},
+		{"invalid status", []int64{1}, "shipping", 1, ErrInvalidRequest},
+		{"empty", nil, "delivering", 0, ErrInvalidRequest},
+		{"too many", make([]int64, maxBulkStatusUpdate+1), "delivering", 0, ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newReturnOrderDB(nil, tt.affected)
+			err := NewRobotService(repository.NewStore(db)).UpdateOrderStatuses(context.Background(), tt.orderIDs, tt.newStatus)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			// 同じ明細の指定は 1 行にまとめて 1 回の UPDATE で進める
+			if tt.wantErr == nil && len(db.calls) != 2 {
+				t.Fatalf("execs = %v, want one status update and arrived_at", db.queries())
+			}
+		})
+	}
+}
+
+// telemetry を報告済みの状態として返す DBTX
+func newTelemetryDB(telemetry ...model.RobotTelemetry) *fakeDB {
+	db := newReturnOrderDB(nil, 0)
+	db.sel = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if rows, ok := dest.(*[]model.RobotTelemetry); ok {
+			*rows = append(*rows, telemetry...)
+		}
+		return nil
+	}
+	return db
+}
+
+func TestHeartbeatValidation(t *testing.T) {
+	s := NewRobotService(repository.NewStore(newTelemetryDB()))
+	valid := model.RobotHeartbeatRequest{BatteryPercent: 80, Latitude: 35.6, Longitude: 139.7, LoadWeight: 10}
+	if err := s.Heartbeat(context.Background(), "robot", valid); err != nil {
+		t.Fatalf("Heartbeat: %v", err)
+	}
+	invalid := []model.RobotHeartbeatRequest{
+		{BatteryPercent: 101},
+		{BatteryPercent: 50, Latitude: 91},
+		{BatteryPercent: 50, Longitude: -181},
+		{BatteryPercent: 50, LoadWeight: -1},
+	}
+	for _, hb := range invalid {
+		if err := s.Heartbeat(context.Background(), "robot", hb); !errors.Is(err, ErrInvalidRequest) {
+			t.Fatalf("Heartbeat(%+v) err = %v, want ErrInvalidRequest", hb, err)
+		}
+	}
+}
+
+func TestListRobotTelemetryMarksStaleRobotsOffline(t *testing.T) {
+	defer func(timeout time.Duration) { RobotHeartbeatTimeout = timeout }(RobotHeartbeatTimeout)
+	RobotHeartbeatTimeout = time.Minute
+	db := newTelemetryDB(
+		model.RobotTelemetry{RobotID: "fresh", ReportedAt: time.Now().Add(-10 * time.Second)},
+		model.RobotTelemetry{RobotID: "stale", ReportedAt: time.Now().Add(-2 * time.Minute)},
+	)
+	telemetry, err := NewRobotService(repository.NewStore(db)).ListRobotTelemetry(context.Background())
+	if err != nil {
+		t.Fatalf("ListRobotTelemetry: %v", err)
+	}
+	if len(telemetry) != 2 || telemetry[0].Offline || !telemetry[1].Offline {
+		t.Fatalf("telemetry = %+v, want only the stale robot offline", telemetry)
+	}
+}
+
+func TestDeliveryPlannerDeadlineObjective(t *testing.T) {
+	defer func(objective string, weight int) {
+		DeliveryPlanObjective, DeliveryDeadlineWeight = objective, weight
+	}(DeliveryPlanObjective, DeliveryDeadlineWeight)
+	soon := time.Now().Add(10 * time.Minute)
+	later := time.Now().Add(24 * time.Hour)
+	orders := []model.Order{
+		{OrderID: 1, Weight: 2, Value: 50, DeliverBefore: &later},
+		{OrderID: 2, Weight: 1, Value: 5, DeliverBefore: &soon},
+		{OrderID: 3, Weight: 1, Value: 5, DeliverBefore: &soon},
+	}
+	solve := func() []int64 {
+		planner := newDeliveryPlanner("robot", 2)
+		for _, o := range orders {
+			planner.add(o)
+		}
+		return lo.Map(planner.plan().Orders, func(o model.Order, _ int) int64 { return o.OrderID })
+	}
+
+	DeliveryPlanObjective, DeliveryDeadlineWeight = DeliveryObjectiveValue, 0
+	if ids := solve(); !reflect.DeepEqual(ids, []int64{1}) {
+		t.Fatalf("value objective picked %v, want the most valuable order", ids)
+	}
+	// 価値に上乗せする重みで期限の迫った注文を優先する
+	DeliveryDeadlineWeight = 30
+	if ids := solve(); len(ids) != 2 || slices.Contains(ids, 1) {
+		t.Fatalf("weighted value objective picked %v, want both urgent orders", ids)
+	}
+	// deadline の目的では価値によらず期限の迫った注文を優先する
+	DeliveryPlanObjective, DeliveryDeadlineWeight = DeliveryObjectiveDeadline, 0
+	if ids := solve(); len(ids) != 2 || slices.Contains(ids, 1) {
+		t.Fatalf("deadline objective picked %v, want both urgent orders", ids)
+	}
+}
+
+func TestCompareDensityPutsUrgentOrdersFirst(t *testing.T) {
+	defer func(objective string) { DeliveryPlanObjective = objective }(DeliveryPlanObjective)
+	DeliveryPlanObjective = DeliveryObjectiveDeadline
+	soon := time.Now().Add(time.Minute)
+	planner := newDeliveryPlanner("robot", 10)
+	urgent := model.Order{OrderID: 1, Weight: 5, Value: 1, DeliverBefore: &soon}
+	dense := model.Order{OrderID: 2, Weight: 1, Value: 100}
+	if planner.compareDensity(urgent, dense) >= 0 {
+		t.Fatal("urgent orders must come before denser ones in the deadline objective")
+	}
+}
+
+func TestDeliveryPlannerVolumeConstraint(t *testing.T) {
+	orders := []model.Order{
+		{OrderID: 1, Weight: 2, Volume: 6, Value: 10},
+		{OrderID: 2, Weight: 3, Volume: 2, Value: 7},
+		{OrderID: 3, Weight: 3, Volume: 2, Value: 6},
+		{OrderID: 4, Weight: 1, Volume: 9, Value: 100}, // 容積に収まらない
+	}
+	// 重さだけなら 1 と 2 (価値 17) だが、容積 6 では 2 と 3 (価値 13) か 1 のみ (価値 10)
+	plan, err := bestSelectOrdersForDelivery(context.Background(), orders, "robot", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.TotalValue != 13 || plan.TotalWeight != 6 || plan.TotalVolume != 4 || plan.Approximate {
+		t.Fatalf("plan = %+v, want orders 2 and 3", plan)
+	}
+
+	// 容積を考えなければ重さだけで解く
+	plan, _ = bestSelectOrdersForDelivery(context.Background(), orders, "robot", 6, 0)
+	if plan.TotalValue != 117 {
+		t.Fatalf("plan without volume = %+v, want value 117", plan)
+	}
+}
+
+func TestDeliveryPlannerVolumeFallsBackToGreedyOverCells(t *testing.T) {
+	p := newVolumeDeliveryPlanner("robot", deliveryPlanMaxVolumeCells, 3)
+	if p.dp != nil {
+		t.Fatal("dp must not be allocated over the cell limit")
+	}
+	p.add(model.Order{OrderID: 1, Weight: 5, Volume: 2, Value: 4})
+	p.add(model.Order{OrderID: 2, Weight: 5, Volume: 2, Value: 9})
+	p.add(model.Order{OrderID: 3, Weight: 5, Volume: 1, Value: 1})
+	plan := p.plan()
+	if !plan.Approximate || plan.TotalValue != 10 || plan.TotalVolume != 3 {
+		t.Fatalf("plan = %+v, want orders 2 and 3 by density", plan)
+	}
+}
+
+func TestDeliveryCapacityVolume(t *testing.T) {
+	defer func(registry, volume bool) {
+		RobotRegistryEnabled, repository.ProductVolumeEnabled = registry, volume
+	}(RobotRegistryEnabled, repository.ProductVolumeEnabled)
+	RobotRegistryEnabled = true
+	limit := 40
+	robot := &model.Robot{RobotID: "robot", Capacity: 50, State: model.RobotStateIdle, VolumeCapacity: &limit}
+	tests := []struct {
+		name    string
+		enabled bool
+		volume  int
+		want    int
+	}{
+		{"disabled", false, 30, 0},
+		{"registered default", true, 0, 40},
+		{"registered over", true, 80, 40},
+		{"registered under", true, 30, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository.ProductVolumeEnabled = tt.enabled
+			capacity, volumeCapacity := 0, tt.volume
+			if _, err := deliveryCapacity(context.Background(), repository.NewStore(newRobotDB(robot)), "robot", &capacity, &volumeCapacity); err != nil {
+				t.Fatal(err)
+			}
+			if volumeCapacity != tt.want {
+				t.Fatalf("volumeCapacity = %d, want %d", volumeCapacity, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateDeliveryPlanReplaysStoredPlan(t *testing.T) {
+	defer func(enabled bool) { DeliveryPlanIdempotent = enabled }(DeliveryPlanIdempotent)
+
+	// 記録した計画を返す (payload が nil なら記録なし)
+	payload := []byte(`{"robot_id":"robot","total_weight":2,"total_value":8,"orders":[{"order_id":1,"weight":2,"value":8}],"plan_uuid":"u"}`)
+	stored := newReturnOrderDB(&model.Order{OrderID: 9, Weight: 1, Value: 1}, 1)
+	stored.get = func(_ context.Context, dest any, _ string, _ ...any) error {
+		if p, ok := dest.(*[]byte); ok {
+			if payload == nil {
+				return sql.ErrNoRows
+			}
+			*p = payload
+		}
+		return nil
+	}
+	DeliveryPlanIdempotent = true
+	s := NewRobotService(repository.NewStore(stored))
+	plan, err := s.GenerateDeliveryPlan(context.Background(), "robot", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Replayed || plan.PlanUUID != "u" || plan.TotalValue != 8 || len(stored.calls) != 0 {
+		t.Fatalf("plan = %+v, execs = %v, want the stored plan without updates", plan, stored.queries())
+	}
+
+	// 指定した計画がなければ新しく作らない
+	payload = nil
+	if _, err := s.GenerateDeliveryPlan(context.Background(), "robot", model.DeliveryPlanParams{Capacity: 10, PlanUUID: "5f0e4c7e-0000-4000-8000-000000000000"}); !errors.Is(err, ErrDeliveryPlanNotFound) {
+		t.Fatalf("err = %v, want ErrDeliveryPlanNotFound", err)
+	}
+	// 記録がなければ作る
+	plan, err = s.GenerateDeliveryPlan(context.Background(), "robot", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil || plan.Replayed || len(plan.Orders) != 1 {
+		t.Fatalf("plan = %+v, err = %v, want a fresh plan", plan, err)
+	}
+
+	DeliveryPlanIdempotent = false
+	if _, err := s.GenerateDeliveryPlan(context.Background(), "robot", model.DeliveryPlanParams{Capacity: 10, PlanUUID: "5f0e4c7e-0000-4000-8000-000000000000"}); !errors.Is(err, ErrDeliveryPlanNotFound) {
+		t.Fatalf("err = %v, want ErrDeliveryPlanNotFound when plans are not stored", err)
+	}
+}
+
+func TestPreviewDeliveryPlanDoesNotUpdateOrders(t *testing.T) {
+	db := newReturnOrderDB(&model.Order{OrderID: 1, Weight: 2, Value: 5}, 1)
+	s := NewRobotService(repository.NewStore(db))
+	plan, err := s.PreviewDeliveryPlan(context.Background(), "robot", model.DeliveryPlanParams{Capacity: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Preview || len(plan.Orders) != 1 || len(db.calls) != 0 {
+		t.Fatalf("plan = %+v, execs = %v, want a preview without updates", plan, db.queries())
+	}
+	if _, err := s.PreviewDeliveryPlan(context.Background(), "robot", model.DeliveryPlanParams{Capacity: 0}); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest without capacity", err)
+	}
+}
+
+func TestAcceptDeliveryPlan(t *testing.T) {
+	newDB := func() *fakeDB {
+		return newLeaseDB(1, nil, []model.OrderVersion{{OrderID: 10, Quantity: 2}, {OrderID: 11, Quantity: 1}})
+	}
+
+	db := newDB()
+	resp, err := NewRobotService(repository.NewStore(db)).AcceptDeliveryPlan(context.Background(), "robot", 3, []int64{10, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Accepted, []int64{10}) || !reflect.DeepEqual(resp.Reverted, []int64{11}) {
+		t.Fatalf("resp = %+v", resp)
+	}
+	// 引き受けなかった明細を未配送に戻して計画から外し、計画を確認済みにする
+	if len(db.calls) != 3 || !strings.Contains(db.calls[0].query, "LEAST(v.quantity") ||
+		!strings.Contains(db.calls[1].query, "DELETE FROM delivery_plan_items") || !strings.Contains(db.calls[2].query, "acknowledged_at = NOW()") {
+		t.Fatalf("execs = %v", db.queries())
+	}
+
+	db = newDB()
+	if _, err := NewRobotService(repository.NewStore(db)).AcceptDeliveryPlan(context.Background(), "robot", 3, []int64{10, 99}); !errors.Is(err, ErrInvalidRequest) || len(db.calls) != 0 {
+		t.Fatalf("err = %v, execs = %v, want ErrInvalidRequest for an order outside the plan", err, db.queries())
+	}
+
+	if _, err := NewRobotService(repository.NewStore(&fakeDB{get: func(context.Context, any, string, ...any) error { return sql.ErrNoRows }})).AcceptDeliveryPlan(context.Background(), "robot", 3, nil); !errors.Is(err, ErrDeliveryPlanNotFound) {
+		t.Fatalf("err = %v, want ErrDeliveryPlanNotFound", err)
+	}
+}
+
+func TestLimitDeliveryPlanOrdersKeepsHighestScores(t *testing.T) {
+	defer func(limit int) { DeliveryPlanMaxOrders = limit }(DeliveryPlanMaxOrders)
+	DeliveryPlanMaxOrders = 2
+	orders := []model.Order{
+		{OrderID: 1, Weight: 3, Value: 5},
+		{OrderID: 2, Weight: 4, Value: 30},
+		{OrderID: 3, Weight: 2, Value: 10},
+	}
+	plan := model.DeliveryPlan{Orders: orders, TotalWeight: 9, TotalValue: 45}
+	limitDeliveryPlanOrders(&plan)
+
+	ids := lo.Map(plan.Orders, func(o model.Order, _ int) int64 { return o.OrderID })
+	if !reflect.DeepEqual(ids, []int64{2, 3}) || plan.TotalWeight != 6 || plan.TotalValue != 40 || !plan.Approximate {
+		t.Fatalf("plan = %+v (orders %v), want orders 2 and 3", plan, ids)
+	}
+	if orders[0].OrderID != 1 {
+		t.Fatal("the original plan orders must not be reordered")
+	}
+}
+
+func TestWithCapacityMargin(t *testing.T) {
+	defer func(margin int) { DeliveryPlanCapacityMarginPercent = margin }(DeliveryPlanCapacityMarginPercent)
+	DeliveryPlanCapacityMarginPercent = 10
+	for capacity, want := range map[int]int{100: 90, 15: 14, 1: 1, 0: 0} {
+		if got := withCapacityMargin(capacity); got != want {
+			t.Errorf("withCapacityMargin(%d) = %d, want %d", capacity, got, want)
+		}
+	}
+}
+
+func TestPlanDeliveriesRejectsWhenSlotsAreFull(t *testing.T) {
+	s := NewRobotService(repository.NewStore(newReturnOrderDB(nil, 0)))
+	s.planSlots = make(chan struct{}, 1)
+	s.planSlots <- struct{}{}
+
+	_, err := s.planDeliveries(context.Background(), s.store, "robot", model.DeliveryPlanParams{Capacity: 10})
+	if !errors.Is(err, ErrDeliveryPlanBusy) {
+		t.Fatalf("err = %v, want ErrDeliveryPlanBusy", err)
+	}
+
+	<-s.planSlots
+	if _, err := s.planDeliveries(context.Background(), s.store, "robot", model.DeliveryPlanParams{Capacity: 10}); err != nil {
+		t.Fatalf("planDeliveries: %v", err)
+	}
+	if len(s.planSlots) != 0 {
+		t.Fatal("the slot must be released after planning")
+	}
+}
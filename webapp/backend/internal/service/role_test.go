@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestUpdateUserRole(t *testing.T) {
+	var updated []any
+	db := &fakeDB{exec: func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "UPDATE users SET role") {
+			updated = args
+		}
+		return fakeResult{rowsAffected: 1}, nil
+	}}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	if err := s.UpdateUserRole(context.Background(), 5, model.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 2 || updated[0] != model.RoleAdmin || updated[1] != 5 {
+		t.Fatalf("updated %v, want user 5 promoted to admin", updated)
+	}
+
+	if err := s.UpdateUserRole(context.Background(), 5, "root"); !errors.Is(err, ErrInvalidRequest) {
+		t.Fatalf("err = %v, want ErrInvalidRequest for an unknown role", err)
+	}
+
+	db.get = func(context.Context, any, string, ...any) error { return sql.ErrNoRows }
+	if err := s.UpdateUserRole(context.Background(), 6, model.RoleUser); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want ErrUserNotFound", err)
+	}
+}
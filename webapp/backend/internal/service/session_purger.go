@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/internal/repository"
+)
+
+// 期限切れセッションを定期的に削除するバックグラウンドジョブ
+// 1 回の DELETE が長時間ロックを取らないよう batchSize 件ずつ消す
+type SessionPurger struct {
+	store     *repository.Store
+	interval  time.Duration
+	batchSize int
+}
+
+func NewSessionPurger(store *repository.Store, interval time.Duration, batchSize int) *SessionPurger {
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	return &SessionPurger{store: store, interval: interval, batchSize: batchSize}
+}
+
+// ctx がキャンセルされるまで interval ごとに削除を実行する
+func (p *SessionPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.purge(ctx)
+		}
+	}
+}
+
+func (p *SessionPurger) purge(ctx context.Context) {
+	total := 0
+	for {
+		batchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		n, err := p.store.SessionRepo.DeleteExpired(batchCtx, p.batchSize)
+		cancel()
+		if err != nil {
+			log.Printf("[SessionPurger] 期限切れセッションの削除失敗: %v", err)
+			return
+		}
+		total += n
+		if n < p.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("[SessionPurger] 期限切れセッションを %d 件削除", total)
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"backend/internal/repository"
+)
+
+func TestSessionPurgerDeletesInBatches(t *testing.T) {
+	expired := make([]string, 5)
+	for i := range expired {
+		expired[i] = fmt.Sprintf("s%d", i)
+	}
+	var deletes [][]any
+	db := &fakeDB{}
+	db.sel = func(_ context.Context, dest any, _ string, args ...any) error {
+		limit := args[1].(int)
+		*dest.(*[]string) = append([]string(nil), expired[:min(limit, len(expired))]...)
+		return nil
+	}
+	db.exec = func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "DELETE FROM user_sessions") {
+			deletes = append(deletes, args)
+			expired = expired[len(args):]
+		}
+		return fakeResult{rowsAffected: int64(len(args))}, nil
+	}
+
+	p := NewSessionPurger(repository.NewStore(db), 0, 2)
+	p.purge(context.Background())
+
+	if len(expired) != 0 {
+		t.Fatalf("remaining = %v, want all expired sessions deleted", expired)
+	}
+	if len(deletes) != 3 || len(deletes[0]) != 2 || len(deletes[2]) != 1 {
+		t.Fatalf("deletes = %v, want batches of 2, 2, 1", deletes)
+	}
+}
+
+func TestSessionPurgerStopsOnError(t *testing.T) {
+	calls := 0
+	db := &fakeDB{sel: func(context.Context, any, string, ...any) error {
+		calls++
+		return sql.ErrConnDone
+	}}
+	NewSessionPurger(repository.NewStore(db), 0, 2).purge(context.Background())
+	if calls != 1 {
+		t.Fatalf("calls = %d, want the purge to give up after the first error", calls)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+func TestListSessionsMarksCurrent(t *testing.T) {
+	db := &fakeDB{sel: func(_ context.Context, dest any, _ string, _ ...any) error {
+		*dest.(*[]model.UserSession) = []model.UserSession{{ID: 2, SessionUUID: "b"}, {ID: 1, SessionUUID: "a"}}
+		return nil
+	}}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+
+	sessions, err := s.ListSessions(context.Background(), 1, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].Current || !sessions[1].Current {
+		t.Fatalf("sessions = %+v, want only session a marked current", sessions)
+	}
+}
+
+func TestRevokeSessionOfAnotherUser(t *testing.T) {
+	db := &fakeDB{get: func(context.Context, any, string, ...any) error { return sql.ErrNoRows }}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+	if err := s.RevokeSession(context.Background(), 1, 99); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("err = %v, want ErrSessionNotFound", err)
+	}
+}
+
+func TestRevokeSessionEvictsCache(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{get: func(_ context.Context, dest any, _ string, _ ...any) error {
+		*dest.(*string) = "a"
+		return nil
+	}}
+	sessions, _ := repository.NewLRUSessionStore(16, 0)
+	sessions.Set(ctx, "a", 1, time.Now().Add(time.Hour))
+	sessions.Set(ctx, "b", 1, time.Now().Add(time.Hour))
+	s := NewAuthService(repository.NewStore(db, repository.WithSessionStore(sessions)), AuthConfig{})
+
+	if err := s.RevokeSession(ctx, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := sessions.Get(ctx, "a"); ok {
+		t.Fatal("revoked session is still cached")
+	}
+	if _, _, ok := sessions.Get(ctx, "b"); !ok {
+		t.Fatal("other sessions must stay valid")
+	}
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+var (
+	ErrTOTPRequired       = errors.New("totp code required")
+	ErrInvalidTOTP        = errors.New("invalid totp code")
+	ErrTOTPNotEnrolled    = errors.New("totp not enrolled")
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+)
+
+// RFC 6238 のデフォルト (SHA-1, 30 秒, 6 桁)
+const (
+	totpIssuer            = "shark"
+	totpPeriod            = 30
+	totpDigits            = 6
+	totpSkew              = 1 // 前後 1 ステップまで許容する
+	totpRecoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(buf), nil
+}
+
+func totpCode(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func verifyTOTP(secret, code string, now time.Time) bool {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+	counter := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		expected := totpCode(key, uint64(counter+int64(i)))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// xxxxx-xxxxx 形式のリカバリーコード
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// ログインの二段階目の検証
+// TOTP コードかリカバリーコードのどちらかが必要
+func (s *AuthService) verifySecondFactor(ctx context.Context, user *model.User, req model.LoginRequest) error {
+	switch {
+	case req.TOTPCode != "":
+		if !verifyTOTP(user.TOTPSecret.String, req.TOTPCode, time.Now()) {
+			return ErrInvalidTOTP
+		}
+		return nil
+	case req.RecoveryCode != "":
+		codeHash := repository.HashToken(normalizeRecoveryCode(req.RecoveryCode))
+		ok, err := s.store.RecoveryCodeRepo.Consume(ctx, user.UserID, codeHash)
+		if err != nil {
+			log.Printf("[Login] リカバリーコード検証失敗: %v", err)
+			return ErrInternalServer
+		}
+		if !ok {
+			return ErrInvalidTOTP
+		}
+		return nil
+	default:
+		return ErrTOTPRequired
+	}
+}
+
+// TOTP の登録を開始し、認証アプリに読み込ませる secret を返す
+// ConfirmTOTP でコードを確認するまでログインには影響しない
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID int) (*model.TOTPEnrollment, error) {
+	var enrollment model.TOTPEnrollment
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if user.TOTPEnabled {
+			return ErrTOTPAlreadyEnabled
+		}
+
+		secret, err := newTOTPSecret()
+		if err != nil {
+			return err
+		}
+		if err := s.store.UserRepo.SetTOTPSecret(ctx, userID, secret); err != nil {
+			return err
+		}
+
+		label := url.PathEscape(totpIssuer + ":" + user.UserName)
+		q := url.Values{"secret": {secret}, "issuer": {totpIssuer}}
+		enrollment = model.TOTPEnrollment{
+			Secret:     secret,
+			OTPAuthURL: "otpauth://totp/" + label + "?" + q.Encode(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// 認証アプリのコードを確認して TOTP を有効化し、リカバリーコードを発行する
+// リカバリーコードはハッシュのみ保存するので、平文を返すのはこの時だけ
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if user.TOTPEnabled {
+			return ErrTOTPAlreadyEnabled
+		}
+		if !user.TOTPSecret.Valid {
+			return ErrTOTPNotEnrolled
+		}
+		if !verifyTOTP(user.TOTPSecret.String, code, time.Now()) {
+			return ErrInvalidTOTP
+		}
+
+		codeHashes := make([]string, 0, totpRecoveryCodeCount)
+		for range totpRecoveryCodeCount {
+			recoveryCode, err := newRecoveryCode()
+			if err != nil {
+				return err
+			}
+			recoveryCodes = append(recoveryCodes, recoveryCode)
+			codeHashes = append(codeHashes, repository.HashToken(recoveryCode))
+		}
+
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.RecoveryCodeRepo.ReplaceByUserID(ctx, userID, codeHashes); err != nil {
+				return err
+			}
+			return txStore.UserRepo.EnableTOTP(ctx, userID)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// 現在の TOTP コードを確認して二要素認証を無効化する
+func (s *AuthService) DisableTOTP(ctx context.Context, userID int, code string) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		user, err := s.store.UserRepo.FindByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if !user.TOTPEnabled {
+			return ErrTOTPNotEnrolled
+		}
+		if !verifyTOTP(user.TOTPSecret.String, code, time.Now()) {
+			return ErrInvalidTOTP
+		}
+
+		return s.store.ExecTx(ctx, func(txStore *repository.Store) error {
+			if err := txStore.RecoveryCodeRepo.DeleteByUserID(ctx, userID); err != nil {
+				return err
+			}
+			return txStore.UserRepo.DisableTOTP(ctx, userID)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// RFC 6238 Appendix B (SHA-1) の下 6 桁
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, uint64(tt.unix/totpPeriod)); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0)
+
+	if !verifyTOTP(secret, "081804", now) {
+		t.Error("current code must be accepted")
+	}
+	if !verifyTOTP(secret, "081804", now.Add(totpPeriod*time.Second)) {
+		t.Error("code from the previous step must be accepted")
+	}
+	if verifyTOTP(secret, "081804", now.Add(2*totpPeriod*time.Second)) {
+		t.Error("code older than the allowed skew must be rejected")
+	}
+	if verifyTOTP(secret, "81804", now) {
+		t.Error("code with the wrong length must be rejected")
+	}
+	if verifyTOTP("not base32!", "081804", now) {
+		t.Error("invalid secret must be rejected")
+	}
+}
+
+func TestLoginRequiresSecondFactor(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	key, _ := totpEncoding.DecodeString(secret)
+	code := totpCode(key, uint64(time.Now().Unix()/totpPeriod))
+
+	db := newLockoutDB(t, "pw")
+	db.user.TOTPEnabled = true
+	db.user.TOTPSecret = sql.NullString{String: secret, Valid: true}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+	ctx := context.Background()
+
+	if _, err := s.Login(ctx, model.LoginRequest{UserName: "alice", Password: "pw"}); !errors.Is(err, ErrTOTPRequired) {
+		t.Fatalf("err = %v, want ErrTOTPRequired without a code", err)
+	}
+	if _, err := s.Login(ctx, model.LoginRequest{UserName: "alice", Password: "pw", TOTPCode: "000000"}); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("err = %v, want ErrInvalidTOTP for a wrong code", err)
+	}
+	if _, err := s.Login(ctx, model.LoginRequest{UserName: "alice", Password: "pw", TOTPCode: code}); err != nil {
+		t.Fatalf("login with the current code: %v", err)
+	}
+}
+
+func TestLoginWithRecoveryCode(t *testing.T) {
+	db := newLockoutDB(t, "pw")
+	db.user.TOTPEnabled = true
+	unused := map[string]bool{repository.HashToken("abcde-fghij"): true}
+	exec := db.exec
+	db.exec = func(ctx context.Context, query string, args ...any) (sql.Result, error) {
+		if strings.HasPrefix(query, "UPDATE totp_recovery_codes") {
+			hash := args[2].(string)
+			if !unused[hash] {
+				return fakeResult{}, nil
+			}
+			delete(unused, hash)
+			return fakeResult{rowsAffected: 1}, nil
+		}
+		return exec(ctx, query, args...)
+	}
+	s := NewAuthService(repository.NewStore(db), AuthConfig{})
+	ctx := context.Background()
+
+	// 大文字や前後の空白は無視する
+	if _, err := s.Login(ctx, model.LoginRequest{UserName: "alice", Password: "pw", RecoveryCode: " ABCDE-FGHIJ "}); err != nil {
+		t.Fatalf("login with a recovery code: %v", err)
+	}
+	if _, err := s.Login(ctx, model.LoginRequest{UserName: "alice", Password: "pw", RecoveryCode: "abcde-fghij"}); !errors.Is(err, ErrInvalidTOTP) {
+		t.Fatalf("err = %v, want a used recovery code to be rejected", err)
+	}
+}
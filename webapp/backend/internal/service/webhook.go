@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+	"backend/internal/service/utils"
+)
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type WebhookService struct {
+	store *repository.Store
+}
+
+func NewWebhookService(store *repository.Store) *WebhookService {
+	return &WebhookService{store: store}
+}
+
+// Webhook を登録し、署名検証用のシークレットを返す
+// userID が無効値 (Valid: false) の場合は全ユーザーの注文を対象にする (管理者用)
+func (s *WebhookService) Register(ctx context.Context, userID sql.NullInt64, rawURL string) (*model.Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || len(rawURL) > 2048 {
+		return nil, ErrInvalidRequest
+	}
+	if err := validateWebhookHost(ctx, u.Hostname()); err != nil {
+		return nil, ErrInvalidRequest
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	webhook := &model.Webhook{
+		UserID:    userID,
+		URL:       rawURL,
+		Secret:    hex.EncodeToString(buf),
+		CreatedAt: time.Now(),
+	}
+	err = utils.WithTimeout(ctx, func(ctx context.Context) error {
+		id, err := s.store.WebhookRepo.Create(ctx, webhook)
+		webhook.ID = id
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+func (s *WebhookService) List(ctx context.Context, userID sql.NullInt64) ([]model.Webhook, error) {
+	var webhooks []model.Webhook
+	err := utils.WithTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		webhooks, err = s.store.WebhookRepo.List(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (s *WebhookService) Delete(ctx context.Context, userID sql.NullInt64, id int64) error {
+	return utils.WithTimeout(ctx, func(ctx context.Context) error {
+		err := s.store.WebhookRepo.Delete(ctx, userID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWebhookNotFound
+		}
+		return err
+	})
+}
+
+var errWebhookTargetForbidden = errors.New("webhook target address is not allowed")
+
+// 内部ネットワークへのリクエスト (SSRF) を防ぐため、公開アドレス以外への配信は許可しない
+func allowedWebhookIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// 登録時点で解決できるアドレスがすべて公開アドレスであることを確認する
+func validateWebhookHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !allowedWebhookIP(ip) {
+			return errWebhookTargetForbidden
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !allowedWebhookIP(addr.IP) {
+			return errWebhookTargetForbidden
+		}
+	}
+	return nil
+}
+
+// 名前解決後の接続先を検査する net.Dialer の Control
+// 登録後の DNS 変更やリダイレクトで内部アドレスに向けられても接続しない
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !allowedWebhookIP(ip) {
+		return errWebhookTargetForbidden
+	}
+	return nil
+}
+
+// outbox に積まれた Webhook を配信するバックグラウンドジョブ
+// 失敗したものは指数バックオフで maxAttempts 回まで再送する
+type WebhookDispatcher struct {
+	store       *repository.Store
+	client      *http.Client
+	interval    time.Duration
+	maxAttempts int
+	batchSize   int
+}
+
+const (
+	webhookBaseBackoff = 5 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookSendTimeout = 10 * time.Second
+)
+
+func NewWebhookDispatcher(store *repository.Store, interval time.Duration, maxAttempts int) *WebhookDispatcher {
+	if maxAttempts <= 0 {
+		maxAttempts = 10
+	}
+	return &WebhookDispatcher{
+		store:       store,
+		client:      newWebhookClient(),
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		batchSize:   100,
+	}
+}
+
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: webhookDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// プロキシ経由だと接続先の検査がプロキシのアドレスに対して行われてしまう
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: webhookSendTimeout, Transport: transport}
+}
+
+// ctx がキャンセルされるまで interval ごとに配信する
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatch(ctx)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context) {
+	// 確保した分を送り切るまで他の dispatcher に取られないよう、全件タイムアウトする時間だけ確保する
+	lease := webhookSendTimeout * time.Duration(d.batchSize)
+	var deliveries []model.WebhookDelivery
+	err := d.store.ExecTx(ctx, func(txStore *repository.Store) error {
+		var err error
+		deliveries, err = txStore.WebhookRepo.ClaimDue(ctx, d.maxAttempts, d.batchSize, lease)
+		return err
+	})
+	if err != nil {
+		log.Printf("[WebhookDispatcher] 配信対象の取得失敗: %v", err)
+		return
+	}
+	d.deliver(ctx, deliveries)
+}
+
+// 確保した配信を送り、結果を記録する
+func (d *WebhookDispatcher) deliver(ctx context.Context, deliveries []model.WebhookDelivery) {
+	for _, delivery := range deliveries {
+		if err := d.send(ctx, delivery); err != nil {
+			backoff := min(webhookBaseBackoff<<delivery.Attempts, webhookMaxBackoff)
+			if delivery.Attempts+1 >= d.maxAttempts {
+				log.Printf("[WebhookDispatcher] 再送上限に達したため破棄(deliveryID: %d): %v", delivery.ID, err)
+			}
+			if err := d.store.WebhookRepo.MarkFailed(ctx, delivery.ID, time.Now().Add(backoff), truncate(err.Error(), 255)); err != nil {
+				log.Printf("[WebhookDispatcher] 失敗の記録に失敗(deliveryID: %d): %v", delivery.ID, err)
+			}
+			continue
+		}
+		if err := d.store.WebhookRepo.MarkDelivered(ctx, delivery.ID); err != nil {
+			log.Printf("[WebhookDispatcher] 配信済みの記録に失敗(deliveryID: %d): %v", delivery.ID, err)
+		}
+	}
+}
+
+// 受信側は X-Webhook-Signature が HMAC-SHA256(secret, "<timestamp>.<body>") と一致することを確認する
+func (d *WebhookDispatcher) send(ctx context.Context, delivery model.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(delivery.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/model"
+	"backend/internal/repository"
+)
+
+// 配信結果の UPDATE を記録する
+type webhookDB struct {
+	fakeDB
+	delivered []int64
+	failed    map[int64]time.Time
+}
+
+func newWebhookDB() *webhookDB {
+	db := &webhookDB{failed: map[int64]time.Time{}}
+	db.exec = func(_ context.Context, query string, args ...any) (sql.Result, error) {
+		switch {
+		case strings.Contains(query, "delivered_at = ?"):
+			db.delivered = append(db.delivered, args[1].(int64))
+		case strings.Contains(query, "last_error = ?"):
+			db.failed[args[2].(int64)] = args[0].(time.Time)
+		}
+		return fakeResult{rowsAffected: 1}, nil
+	}
+	return db
+}
+
+func TestWebhookDispatcherSignsAndRecordsResults(t *testing.T) {
+	const secret = "s3cret"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(r.Header.Get("X-Webhook-Timestamp") + "."))
+		mac.Write(body)
+		if r.Header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	db := newWebhookDB()
+	d := NewWebhookDispatcher(repository.NewStore(db), time.Minute, 10)
+	d.client = srv.Client()
+
+	start := time.Now()
+	d.deliver(context.Background(), []model.WebhookDelivery{
+		{ID: 1, URL: srv.URL + "/ok", Secret: secret, Payload: []byte(`{"order_id":1}`)},
+		{ID: 2, URL: srv.URL + "/fail", Secret: secret, Payload: []byte(`{"order_id":2}`), Attempts: 2},
+		{ID: 3, URL: srv.URL + "/ok", Secret: "wrong", Payload: []byte(`{"order_id":3}`)},
+	})
+
+	if len(db.delivered) != 1 || db.delivered[0] != 1 {
+		t.Fatalf("delivered = %v, want only delivery 1", db.delivered)
+	}
+	if len(db.failed) != 2 {
+		t.Fatalf("failed = %v, want deliveries 2 and 3", db.failed)
+	}
+	// attempts に応じて指数バックオフする
+	if next := db.failed[2].Sub(start); next < 4*webhookBaseBackoff || next > 5*webhookBaseBackoff {
+		t.Errorf("retry of delivery 2 in %v, want about %v", next, 4*webhookBaseBackoff)
+	}
+	if next := db.failed[3].Sub(start); next < webhookBaseBackoff || next > 2*webhookBaseBackoff {
+		t.Errorf("retry of delivery 3 in %v, want about %v", next, webhookBaseBackoff)
+	}
+}
+
+func TestRegisterWebhookValidatesURL(t *testing.T) {
+	s := NewWebhookService(repository.NewStore(&fakeDB{}))
+	for _, rawURL := range []string{"ftp://example.com/hook", "/relative", "https://"} {
+		if _, err := s.Register(context.Background(), sql.NullInt64{}, rawURL); err != ErrInvalidRequest {
+			t.Errorf("%s: err = %v, want ErrInvalidRequest", rawURL, err)
+		}
+	}
+
+	webhook, err := s.Register(context.Background(), sql.NullInt64{Int64: 1, Valid: true}, "https://93.184.216.34/hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.Secret) != 64 {
+		t.Fatalf("secret = %q, want 32 random bytes in hex", webhook.Secret)
+	}
+}
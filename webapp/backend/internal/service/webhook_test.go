@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestWebhookDialControl(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.0.0.5:80", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+	}
+	for _, tt := range tests {
+		err := webhookDialControl("tcp", tt.address, nil)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.address, err)
+		}
+		if !tt.allowed && !errors.Is(err, errWebhookTargetForbidden) {
+			t.Errorf("%s: err = %v, want errWebhookTargetForbidden", tt.address, err)
+		}
+	}
+}
+
+func TestRegisterRejectsInternalTargets(t *testing.T) {
+	s := &WebhookService{}
+	for _, rawURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+		"ftp://example.com/hook",
+	} {
+		if _, err := s.Register(context.Background(), sql.NullInt64{}, rawURL); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: err = %v, want ErrInvalidRequest", rawURL, err)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+-- 注文IDの採番テーブル
+-- 複数行 INSERT でも挿入した注文IDを正確に返せるよう、アプリ側で ID 範囲を確保してから INSERT する
+CREATE TABLE order_id_sequence (
+    id TINYINT UNSIGNED NOT NULL PRIMARY KEY,
+    next_id BIGINT UNSIGNED NOT NULL
+);
+
+INSERT INTO order_id_sequence (id, next_id)
+SELECT 1, COALESCE(MAX(order_id), 0) + 1 FROM orders;
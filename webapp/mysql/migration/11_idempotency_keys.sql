@@ -0,0 +1,11 @@
+-- 注文作成の Idempotency-Key
+-- 同じキーでの再送には最初の結果 (order_ids) を返す
+CREATE TABLE idempotency_keys (
+    user_id INT UNSIGNED NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    order_ids JSON NULL,
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+-- 完了済みの古い注文の退避先
+-- 配送計画の作成で orders を走査する量を減らすため、バックグラウンドジョブで移動する
+CREATE TABLE orders_archive LIKE orders;
+
+ALTER TABLE orders
+    ALGORITHM = INPLACE,
+    LOCK = NONE,
+    ADD INDEX idx_orders_shipped_status_code_arrived_at (shipped_status_code, arrived_at);
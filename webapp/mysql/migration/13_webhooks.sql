@@ -0,0 +1,26 @@
+-- 注文ステータス変更の Webhook
+-- user_id が NULL のものは管理者が登録した全ユーザー対象の Webhook
+CREATE TABLE webhooks (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NULL,
+    url VARCHAR(2048) NOT NULL,
+    secret CHAR(64) NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_webhooks_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- 配信待ちのイベント (outbox)
+-- ステータス更新と同じトランザクションで積み、ディスパッチャーが配信する
+CREATE TABLE webhook_deliveries (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    webhook_id BIGINT NOT NULL,
+    payload JSON NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    delivered_at DATETIME NULL,
+    last_error VARCHAR(255) NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_webhook_deliveries_pending (delivered_at, next_attempt_at),
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+-- 注文履歴の商品名部分一致検索用 (ORDER_SEARCH_FULLTEXT=true で使用)
+-- 前方一致は既存の idx_products_name_product_id を使う
+ALTER TABLE products
+    ADD FULLTEXT INDEX ftx_products_name (name) WITH PARSER ngram;
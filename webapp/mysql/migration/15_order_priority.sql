@@ -0,0 +1,6 @@
+-- 注文の優先度 (大きいほど優先して配送計画に含める)
+ALTER TABLE orders
+    ADD COLUMN priority TINYINT UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE orders_archive
+    ADD COLUMN priority TINYINT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- 注文ステータス更新の楽観ロック用
+ALTER TABLE orders
+    ADD COLUMN version BIGINT UNSIGNED NOT NULL DEFAULT 0;
+
+ALTER TABLE orders_archive
+    ADD COLUMN version BIGINT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,106 @@
+-- 注文 (1 回の注文操作) と明細 (商品ごとに 1 行、数量つき)
+-- 注文の正を order_items にし、数量分の orders 行はなくす
+-- 配送の進捗は数量で持つ
+--   dispatched_quantity: 配送に出した数 (配送中 + 完了)
+--   completed_quantity:  配送が完了した数
+-- 明細 ID は従来の注文 ID と同じ order_id_sequence で採番する
+
+CREATE TABLE order_headers (
+    order_header_id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_order_headers_user_id (user_id, order_header_id)
+);
+
+CREATE TABLE order_items (
+    order_item_id BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+    order_header_id BIGINT UNSIGNED NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    product_id INT UNSIGNED NOT NULL,
+    quantity INT UNSIGNED NOT NULL,
+    priority TINYINT UNSIGNED NOT NULL DEFAULT 0,
+    dispatched_quantity INT UNSIGNED NOT NULL DEFAULT 0,
+    completed_quantity INT UNSIGNED NOT NULL DEFAULT 0,
+    version BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    arrived_at DATETIME NULL,
+    -- 明細のステータスは最も進んでいない単位に合わせる
+    shipped_status VARCHAR(50) AS (CASE
+        WHEN dispatched_quantity < quantity THEN 'shipping'
+        WHEN completed_quantity < quantity THEN 'delivering'
+        ELSE 'completed'
+    END) STORED,
+    -- completed < delivering < shipping の順番
+    shipped_status_code TINYINT AS (CASE
+        WHEN dispatched_quantity < quantity THEN 2
+        WHEN completed_quantity < quantity THEN 1
+        ELSE 0
+    END) STORED,
+    INDEX idx_order_items_order_header_id (order_header_id),
+    INDEX idx_order_items_user_id_order_item_id (user_id, order_item_id),
+    INDEX idx_order_items_user_id_shipped_status_code (user_id, shipped_status_code, order_item_id),
+    INDEX idx_order_items_user_id_created_at (user_id, created_at),
+    INDEX idx_order_items_shipped_status_code_arrived_at (shipped_status_code, arrived_at)
+);
+
+-- 既存の注文 (orders / orders_archive の 1 行 = 1 個) は、それぞれを数量 1 の明細として移す
+-- 注文 ID をそのまま注文 ID・明細 ID にするので、既存の注文 ID は変わらない
+CREATE TEMPORARY TABLE order_units AS
+SELECT order_id, user_id, product_id, shipped_status, priority, version, created_at, arrived_at FROM orders
+UNION ALL
+SELECT order_id, user_id, product_id, shipped_status, priority, version, created_at, arrived_at FROM orders_archive;
+
+INSERT INTO order_headers (order_header_id, user_id, created_at)
+SELECT order_id, user_id, created_at
+FROM order_units;
+
+INSERT INTO order_items (order_item_id, order_header_id, user_id, product_id, quantity, priority,
+                         dispatched_quantity, completed_quantity, version, created_at, arrived_at)
+SELECT
+    order_id,
+    order_id,
+    user_id,
+    product_id,
+    1,
+    priority,
+    shipped_status <> 'shipping',
+    shipped_status = 'completed',
+    version,
+    created_at,
+    IF(shipped_status = 'completed', arrived_at, NULL)
+FROM order_units;
+
+DROP TEMPORARY TABLE order_units;
+
+DROP TABLE orders_archive;
+DROP TABLE orders;
+
+-- 完了済みの古い明細の退避先
+CREATE TABLE order_items_archive LIKE order_items;
+
+-- 配送計画 (ロボット) 用の互換ビュー
+-- 未配送の数量分だけ明細を展開し、従来の orders と同じく 1 行 = 1 個として返す (order_id は明細 ID)
+CREATE TABLE order_unit_numbers (
+    n INT UNSIGNED NOT NULL PRIMARY KEY
+);
+
+SET SESSION cte_max_recursion_depth = 10000;
+INSERT INTO order_unit_numbers (n)
+WITH RECURSIVE seq (n) AS (
+    SELECT 1
+    UNION ALL
+    SELECT n + 1 FROM seq WHERE n < 10000
+)
+SELECT n FROM seq;
+
+CREATE VIEW shipping_order_units AS
+SELECT
+    i.order_item_id AS order_id,
+    i.priority,
+    i.version,
+    p.weight,
+    p.value
+FROM order_items i
+JOIN order_unit_numbers u ON u.n <= i.quantity - i.dispatched_quantity
+JOIN products p ON p.product_id = i.product_id
+WHERE i.shipped_status_code = 2;
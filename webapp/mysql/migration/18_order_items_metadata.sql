@@ -0,0 +1,6 @@
+-- 注文明細ごとの任意のメタデータ (配達の指示など、JSON で保存する)
+ALTER TABLE order_items
+    ADD COLUMN metadata JSON NULL;
+
+ALTER TABLE order_items_archive
+    ADD COLUMN metadata JSON NULL;
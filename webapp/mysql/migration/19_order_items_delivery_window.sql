@@ -0,0 +1,23 @@
+-- 注文明細ごとの配達希望期間 (どちらも NULL なら指定なし)
+ALTER TABLE order_items
+    ADD COLUMN deliver_after DATETIME NULL,
+    ADD COLUMN deliver_before DATETIME NULL;
+
+ALTER TABLE order_items_archive
+    ADD COLUMN deliver_after DATETIME NULL,
+    ADD COLUMN deliver_before DATETIME NULL;
+
+-- 配送計画で期間を考慮できるよう、配送計画用ビューにも含める
+CREATE OR REPLACE VIEW shipping_order_units AS
+SELECT
+    i.order_item_id AS order_id,
+    i.priority,
+    i.version,
+    p.weight,
+    p.value,
+    i.deliver_after,
+    i.deliver_before
+FROM order_items i
+JOIN order_unit_numbers u ON u.n <= i.quantity - i.dispatched_quantity
+JOIN products p ON p.product_id = i.product_id
+WHERE i.shipped_status_code = 2;
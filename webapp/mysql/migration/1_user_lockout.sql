@@ -0,0 +1,4 @@
+-- ログイン失敗によるアカウントロック用
+ALTER TABLE users
+    ADD COLUMN failed_login_count INT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN locked_until DATETIME NULL;
@@ -0,0 +1,40 @@
+-- 返品 (完了済みの明細をまるごと返品する)
+-- 再配達する場合は代わりの明細を新しく作り、replacement_order_item_id で引けるようにする
+ALTER TABLE order_items
+    ADD COLUMN returned_at DATETIME NULL,
+    ADD COLUMN return_reason VARCHAR(255) NULL,
+    ADD COLUMN replacement_order_item_id BIGINT UNSIGNED NULL;
+
+ALTER TABLE order_items_archive
+    ADD COLUMN returned_at DATETIME NULL,
+    ADD COLUMN return_reason VARCHAR(255) NULL,
+    ADD COLUMN replacement_order_item_id BIGINT UNSIGNED NULL;
+
+-- 返品済みの明細は returned (コードは 3) にする
+ALTER TABLE order_items
+    MODIFY COLUMN shipped_status VARCHAR(50) AS (CASE
+        WHEN returned_at IS NOT NULL THEN 'returned'
+        WHEN dispatched_quantity < quantity THEN 'shipping'
+        WHEN completed_quantity < quantity THEN 'delivering'
+        ELSE 'completed'
+    END) STORED,
+    MODIFY COLUMN shipped_status_code TINYINT AS (CASE
+        WHEN returned_at IS NOT NULL THEN 3
+        WHEN dispatched_quantity < quantity THEN 2
+        WHEN completed_quantity < quantity THEN 1
+        ELSE 0
+    END) STORED;
+
+ALTER TABLE order_items_archive
+    MODIFY COLUMN shipped_status VARCHAR(50) AS (CASE
+        WHEN returned_at IS NOT NULL THEN 'returned'
+        WHEN dispatched_quantity < quantity THEN 'shipping'
+        WHEN completed_quantity < quantity THEN 'delivering'
+        ELSE 'completed'
+    END) STORED,
+    MODIFY COLUMN shipped_status_code TINYINT AS (CASE
+        WHEN returned_at IS NOT NULL THEN 3
+        WHEN dispatched_quantity < quantity THEN 2
+        WHEN completed_quantity < quantity THEN 1
+        ELSE 0
+    END) STORED;
@@ -0,0 +1,4 @@
+-- 商品の在庫数
+-- NULL は在庫を管理しない商品 (無制限に注文できる)。既存の商品はすべて NULL のまま
+ALTER TABLE products
+    ADD COLUMN stock INT NULL;
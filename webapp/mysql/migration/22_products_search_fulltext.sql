@@ -0,0 +1,3 @@
+-- 商品一覧の複数語検索・関連度順用 (PRODUCT_SEARCH_FULLTEXT=true で使用)
+ALTER TABLE products
+    ADD FULLTEXT INDEX ftx_products_name_description (name, description) WITH PARSER ngram;
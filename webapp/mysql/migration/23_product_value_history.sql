@@ -0,0 +1,13 @@
+-- 商品の価格 (value) の変更履歴
+-- 変更した管理者が削除されても履歴は残すため changed_by は NULL 許容
+CREATE TABLE product_value_history (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    product_id INT UNSIGNED NOT NULL,
+    old_value INT UNSIGNED NOT NULL,
+    new_value INT UNSIGNED NOT NULL,
+    changed_by INT UNSIGNED NULL,
+    changed_at DATETIME NOT NULL,
+    INDEX idx_product_value_history_product_id_id (product_id, id),
+    FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE,
+    FOREIGN KEY (changed_by) REFERENCES users(user_id) ON DELETE SET NULL
+);
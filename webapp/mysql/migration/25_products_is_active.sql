@@ -0,0 +1,4 @@
+-- 商品を注文・一覧の対象にするか
+-- FALSE の商品は一覧に出さず新しい注文を受け付けないが、注文済みの明細は配送する
+ALTER TABLE products
+    ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,20 @@
+-- 配送計画のリース
+-- リース期限までに確認 (acknowledged_at) されなかった計画は、注文を未配送に戻して released_at を記録する
+CREATE TABLE delivery_plans (
+    plan_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    robot_id VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    lease_expires_at DATETIME NOT NULL,
+    acknowledged_at DATETIME NULL,
+    released_at DATETIME NULL,
+    INDEX idx_delivery_plans_open_lease (acknowledged_at, released_at, lease_expires_at)
+);
+
+-- 計画で配送中にした明細ごとの個数
+CREATE TABLE delivery_plan_items (
+    plan_id BIGINT UNSIGNED NOT NULL,
+    order_item_id BIGINT UNSIGNED NOT NULL,
+    quantity INT NOT NULL,
+    PRIMARY KEY (plan_id, order_item_id),
+    FOREIGN KEY (plan_id) REFERENCES delivery_plans(plan_id) ON DELETE CASCADE
+);
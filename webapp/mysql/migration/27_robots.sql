@@ -0,0 +1,9 @@
+-- 配送ロボットの登録 (積載量と状態)
+-- state は idle / delivering / offline
+CREATE TABLE robots (
+    robot_id VARCHAR(255) NOT NULL PRIMARY KEY,
+    capacity INT NOT NULL,
+    state VARCHAR(20) NOT NULL DEFAULT 'idle',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL
+);
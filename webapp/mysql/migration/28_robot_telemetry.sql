@@ -0,0 +1,9 @@
+-- 配送ロボットが最後に報告した状態 (ハートビート)
+CREATE TABLE robot_telemetry (
+    robot_id VARCHAR(255) NOT NULL PRIMARY KEY,
+    battery_percent TINYINT UNSIGNED NOT NULL,
+    latitude DOUBLE NOT NULL,
+    longitude DOUBLE NOT NULL,
+    load_weight INT UNSIGNED NOT NULL,
+    reported_at DATETIME NOT NULL
+);
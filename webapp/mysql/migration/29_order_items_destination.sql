@@ -0,0 +1,25 @@
+-- 注文明細ごとの配送先の座標 (どちらも NULL なら指定なし)
+ALTER TABLE order_items
+    ADD COLUMN dest_latitude DOUBLE NULL,
+    ADD COLUMN dest_longitude DOUBLE NULL;
+
+ALTER TABLE order_items_archive
+    ADD COLUMN dest_latitude DOUBLE NULL,
+    ADD COLUMN dest_longitude DOUBLE NULL;
+
+-- 配送計画で巡回順を決められるよう、配送計画用ビューにも含める
+CREATE OR REPLACE VIEW shipping_order_units AS
+SELECT
+    i.order_item_id AS order_id,
+    i.priority,
+    i.version,
+    p.weight,
+    p.value,
+    i.deliver_after,
+    i.deliver_before,
+    i.dest_latitude,
+    i.dest_longitude
+FROM order_items i
+JOIN order_unit_numbers u ON u.n <= i.quantity - i.dispatched_quantity
+JOIN products p ON p.product_id = i.product_id
+WHERE i.shipped_status_code = 2;
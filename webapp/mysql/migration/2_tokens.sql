@@ -0,0 +1,14 @@
+-- サービス間呼び出し用の API トークン（Bearer）
+-- token_hash は生トークンの SHA-256、scopes はカンマ区切り
+CREATE TABLE tokens (
+    token_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL,
+    user_id INT UNSIGNED NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    UNIQUE KEY uk_tokens_token_hash (token_hash),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,24 @@
+-- 商品 1 個あたりの容積と、配送ロボットが積める容積 (NULL なら容積を考えない)
+ALTER TABLE products
+    ADD COLUMN volume INT NOT NULL DEFAULT 0;
+
+ALTER TABLE robots
+    ADD COLUMN volume_capacity INT NULL;
+
+-- 配送計画で重さと容積の両方を考えられるよう、配送計画用ビューにも含める
+CREATE OR REPLACE VIEW shipping_order_units AS
+SELECT
+    i.order_item_id AS order_id,
+    i.priority,
+    i.version,
+    p.weight,
+    p.value,
+    i.deliver_after,
+    i.deliver_before,
+    i.dest_latitude,
+    i.dest_longitude,
+    p.volume
+FROM order_items i
+JOIN order_unit_numbers u ON u.n <= i.quantity - i.dispatched_quantity
+JOIN products p ON p.product_id = i.product_id
+WHERE i.shipped_status_code = 2;
@@ -0,0 +1,7 @@
+-- 配送計画の UUID と、作った計画そのもの (JSON)
+-- 同じ計画を再要求されたら、新しく作らずにこれを返す
+ALTER TABLE delivery_plans
+    ADD COLUMN plan_uuid CHAR(36) NULL,
+    ADD COLUMN payload JSON NULL,
+    ADD UNIQUE INDEX idx_delivery_plans_uuid (plan_uuid),
+    ADD INDEX idx_delivery_plans_robot (robot_id, plan_id);
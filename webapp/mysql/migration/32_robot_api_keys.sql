@@ -0,0 +1,11 @@
+-- ロボットごとの API キー (X-API-KEY)
+-- key_hash は生キーの SHA-256、revoked_at を過ぎたキーは使えない (ローテーション中は未来の時刻になる)
+CREATE TABLE robot_api_keys (
+    key_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    key_hash CHAR(64) NOT NULL,
+    robot_id VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    UNIQUE KEY uk_robot_api_keys_key_hash (key_hash),
+    INDEX idx_robot_api_keys_robot (robot_id)
+);
@@ -0,0 +1,29 @@
+-- 配送エリア (ゾーン)
+-- ユーザーのゾーンは注文明細でゾーンを省略した場合の既定値 (どちらも NULL なら指定なし)
+ALTER TABLE users
+    ADD COLUMN zone VARCHAR(64) NULL;
+
+ALTER TABLE order_items
+    ADD COLUMN zone VARCHAR(64) NULL;
+
+ALTER TABLE order_items_archive
+    ADD COLUMN zone VARCHAR(64) NULL;
+
+-- エリアを担当するロボットがゾーンの注文だけを計画できるよう、配送計画用ビューにも含める
+CREATE OR REPLACE VIEW shipping_order_units AS
+SELECT
+    i.order_item_id AS order_id,
+    i.priority,
+    i.version,
+    p.weight,
+    p.value,
+    i.deliver_after,
+    i.deliver_before,
+    i.dest_latitude,
+    i.dest_longitude,
+    p.volume,
+    i.zone
+FROM order_items i
+JOIN order_unit_numbers u ON u.n <= i.quantity - i.dispatched_quantity
+JOIN products p ON p.product_id = i.product_id
+WHERE i.shipped_status_code = 2;
@@ -0,0 +1,6 @@
+-- 配送計画を作ったときに見積もった到着予定日時 (配送中にした明細のみ、計画ごとに上書きする)
+ALTER TABLE order_items
+    ADD COLUMN estimated_arrival_at DATETIME NULL;
+
+ALTER TABLE order_items_archive
+    ADD COLUMN estimated_arrival_at DATETIME NULL;
@@ -0,0 +1,15 @@
+-- ロボットが報告した配送の失敗
+-- failed_attempts は明細ごとの失敗の回数、上限を超えたら未配送に戻さず review_required_at を記録する (手動で確認する)
+ALTER TABLE order_items
+    ADD COLUMN failed_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN review_required_at DATETIME NULL;
+
+CREATE TABLE delivery_failures (
+    failure_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    order_item_id BIGINT NOT NULL,
+    robot_id VARCHAR(255) NOT NULL,
+    reason VARCHAR(32) NOT NULL,
+    requeued BOOLEAN NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_delivery_failures_order_item (order_item_id)
+);
@@ -0,0 +1,3 @@
+-- 権限管理用
+ALTER TABLE users
+    ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user';
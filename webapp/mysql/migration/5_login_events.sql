@@ -0,0 +1,14 @@
+-- ログイン試行の監査ログ
+-- 存在しないユーザー名での試行も残すため user_id は NULL 許容
+CREATE TABLE login_events (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NULL,
+    user_name VARCHAR(255) NOT NULL,
+    ip VARCHAR(64) NOT NULL,
+    user_agent VARCHAR(512) NOT NULL,
+    success BOOLEAN NOT NULL,
+    failure_reason VARCHAR(64) NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_login_events_user_id_id (user_id, id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
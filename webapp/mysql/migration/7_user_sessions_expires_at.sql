@@ -0,0 +1,5 @@
+-- 期限切れセッションの定期削除用
+ALTER TABLE user_sessions
+    ALGORITHM = INPLACE,
+    LOCK = NONE,
+    ADD INDEX idx_user_sessions_expires_at (expires_at);
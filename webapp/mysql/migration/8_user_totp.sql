@@ -0,0 +1,15 @@
+-- TOTP による二要素認証
+-- totp_secret は登録開始時に保存し、確認コードの検証が通ったら totp_enabled を立てる
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64) NULL,
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- 認証アプリを紛失した場合のリカバリーコード (SHA-256 ハッシュで保存、1 回限り)
+CREATE TABLE totp_recovery_codes (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT UNSIGNED NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    INDEX idx_totp_recovery_codes_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
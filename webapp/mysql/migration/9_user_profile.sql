@@ -0,0 +1,4 @@
+-- プロフィール (表示名と任意の設定値)
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN settings JSON NULL;